@@ -0,0 +1,314 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// User arenas (experimental).
+//
+// An arena, created by runtime/arena.NewArena, allocates objects that
+// are all freed at once by an explicit call to Free, rather than one
+// at a time by the garbage collector.
+//
+// Chunks. An arena allocates from userArenaChunkBytes chunks, each of
+// which is a single large-object span, so to the garbage collector a
+// chunk is one large object. Objects with pointers are allocated
+// upward from the start of the chunk, and the heap bitmap for them is
+// filled in as they are allocated, with the scan bit set on every word
+// up to the end of the last one. Pointer-free objects are allocated
+// downward from the end of the chunk, where the bitmap stays clear.
+// The garbage collector therefore scans just the pointerful prefix of
+// a chunk, and a pointer to any object in the arena keeps the whole
+// chunk alive.
+//
+// Freeing. Free drops the arena's references to its chunks and marks
+// them freed. A freed chunk is made inaccessible with sysFault, so a
+// use after Free faults instead of silently reading or corrupting
+// reused memory, and its span becomes noscan so the garbage collector
+// no longer reads it. This can't be done while the garbage collector
+// might be scanning the chunk, so if Free is called during a cycle, or
+// before the chunk has been swept, the sweeper does it instead. Until
+// then, a use after Free goes undetected.
+//
+// The address space of a faulted chunk can't be reused while anything
+// may still point into it. Once a cycle finds the chunk unmarked, the
+// sweeper maps it back and frees it like any other large object.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+const (
+	// userArenaChunkBytes is the size of an arena chunk.
+	userArenaChunkBytes = 8 << 20
+
+	// userArenaMaxAlloc is the largest allocation an arena makes
+	// from a chunk. Larger ones come from the heap, so that a few
+	// big objects don't waste most of a chunk.
+	userArenaMaxAlloc = userArenaChunkBytes / 4
+)
+
+// Values of mspan.userArena.
+const (
+	userArenaNone    = iota // not an arena chunk
+	userArenaInUse          // belongs to a live arena
+	userArenaFreed          // freed but still accessible
+	userArenaFaulted        // freed and inaccessible
+)
+
+// userArenaRemapped absorbs the accounting done by sysMap when a
+// faulted chunk is mapped back. The chunk's memory never stopped
+// being counted in heap_sys.
+var userArenaRemapped uint64
+
+// A userArena is the runtime side of a runtime/arena.Arena.
+// It is not safe for concurrent use.
+type userArena struct {
+	// chunks holds the base of every chunk, which keeps them
+	// alive until the arena is freed.
+	chunks []unsafe.Pointer
+
+	// active is the chunk being allocated from. Objects with
+	// pointers are allocated upward from lo, and pointer-free
+	// objects downward from hi.
+	active *mspan
+	lo, hi uintptr
+
+	freed bool
+}
+
+// alloc returns n zeroed values of type typ allocated from a.
+func (a *userArena) alloc(typ *_type, n uintptr) unsafe.Pointer {
+	if a.freed {
+		panic(plainError("arena: use after Free"))
+	}
+	size := typ.size * n
+	if size == 0 {
+		return unsafe.Pointer(&zerobase)
+	}
+	if size > userArenaMaxAlloc || typ.kind&kindGCProg != 0 {
+		// Too big for a chunk, or a type whose pointer bitmap
+		// is only available as a program.
+		if n == 1 {
+			return mallocgc(size, typ, true)
+		}
+		return newarray(typ, int(n))
+	}
+
+	align := uintptr(typ.align)
+	if typ.kind&kindNoPointers != 0 {
+		if a.active == nil || a.hi-a.lo < size || (a.hi-size)&^(align-1) < a.lo {
+			a.refill()
+		}
+		a.hi = (a.hi - size) &^ (align - 1)
+		return unsafe.Pointer(a.hi)
+	}
+
+	if align < sys.PtrSize {
+		align = sys.PtrSize
+	}
+	p := round(a.lo, align)
+	if a.active == nil || p+size > a.hi {
+		a.refill()
+		p = a.lo
+	}
+	a.setBits(p, typ, n)
+	a.lo = p + size
+
+	// The bitmap must be visible before the object is, as in
+	// mallocgc.
+	publicationBarrier()
+	return unsafe.Pointer(p)
+}
+
+// setBits fills in the heap bitmap for n values of type typ at p, and
+// for the padding between a.lo and p. Every word gets the scan bit, so
+// the garbage collector scans the chunk up to the new a.lo.
+func (a *userArena) setBits(p uintptr, typ *_type, n uintptr) {
+	// The scan bit of the chunk's second word is the checkmark bit,
+	// and scanobject ignores it. See heapBitsSetType.
+	second := a.active.base() + sys.PtrSize
+
+	h := heapBitsForAddr(a.lo)
+	for q := a.lo; q < p; q += sys.PtrSize {
+		if q != second {
+			*h.bitp |= bitScan << h.shift
+		}
+		h = h.next()
+	}
+	nw := typ.size / sys.PtrSize
+	nptr := typ.ptrdata / sys.PtrSize
+	for i := uintptr(0); i < n; i++ {
+		for j := uintptr(0); j < nw; j++ {
+			var bits uint8
+			if p+j*sys.PtrSize != second {
+				bits = bitScan
+			}
+			if j < nptr && *addb(typ.gcdata, j/8)>>(j%8)&1 != 0 {
+				bits |= bitPointer
+			}
+			*h.bitp |= bits << h.shift
+			h = h.next()
+		}
+		p += typ.size
+	}
+}
+
+// refill replaces a's active chunk with a new one. The rest of the old
+// chunk is wasted.
+func (a *userArena) refill() {
+	x, s := newUserArenaChunk()
+	a.chunks = append(a.chunks, x)
+	a.active = s
+	a.lo = s.base()
+	a.hi = s.base() + userArenaChunkBytes
+}
+
+// newUserArenaChunk allocates a chunk and returns its base and span.
+// The chunk is zeroed and its heap bitmap is clear.
+func newUserArenaChunk() (unsafe.Pointer, *mspan) {
+	if gcBlackenEnabled != 0 {
+		// Charge the chunk against the current G, as mallocgc
+		// does for a large object.
+		assistG := getg()
+		if assistG.m.curg != nil {
+			assistG = assistG.m.curg
+		}
+		assistG.gcAssistBytes -= userArenaChunkBytes
+		if assistG.gcAssistBytes < 0 {
+			gcAssistAlloc(assistG)
+		}
+	}
+
+	mp := acquirem()
+	if mp.mallocing != 0 {
+		throw("malloc deadlock")
+	}
+	mp.mallocing = 1
+
+	var s *mspan
+	systemstack(func() {
+		s = largeAlloc(userArenaChunkBytes, true, false)
+	})
+	s.freeindex = 1
+	s.allocCount = 1
+	atomic.Store(&s.userArena, userArenaInUse)
+	x := unsafe.Pointer(s.base())
+	mp.mcache.local_scan += userArenaChunkBytes
+
+	publicationBarrier()
+	if gcphase != _GCoff {
+		gcmarknewobject(uintptr(x), userArenaChunkBytes, userArenaChunkBytes)
+	}
+	if raceenabled {
+		racemalloc(x, userArenaChunkBytes)
+	}
+	if msanenabled {
+		msanmalloc(x, userArenaChunkBytes)
+	}
+
+	mp.mallocing = 0
+	releasem(mp)
+
+	if t := (gcTrigger{kind: gcTriggerHeap}); t.test() {
+		gcStart(gcBackgroundMode, t)
+	}
+	return x, s
+}
+
+// free frees a's chunks. Objects allocated from the heap because they
+// didn't fit in a chunk are left to the garbage collector.
+func (a *userArena) free() {
+	for _, x := range a.chunks {
+		s := spanOfHeap(uintptr(x))
+		mp := acquirem()
+		if gcphase == _GCoff && atomic.Load(&s.sweepgen) == mheap_.sweepgen {
+			// Nothing can be scanning s until the next cycle,
+			// which can't start while we hold the M.
+			userArenaFault(s)
+		} else {
+			atomic.Store(&s.userArena, userArenaFreed)
+		}
+		releasem(mp)
+	}
+	a.chunks = nil
+	a.active = nil
+	a.lo, a.hi = 0, 0
+	a.freed = true
+}
+
+// userArenaFault makes the freed chunk s inaccessible. The garbage
+// collector must not be able to scan s.
+func userArenaFault(s *mspan) {
+	s.spanclass = makeSpanClass(0, true)
+	sysFault(unsafe.Pointer(s.base()), s.npages<<_PageShift)
+	atomic.Store(&s.userArena, userArenaFaulted)
+}
+
+// userArenaSweep is called by the sweeper for the chunk s, which is in
+// the given state and has nalloc objects (0 or 1) still allocated. It
+// faults a freed chunk that is still reachable, and maps back a
+// faulted chunk that is about to be freed.
+func userArenaSweep(s *mspan, state uint32, nalloc uint16) {
+	if nalloc == 0 {
+		// Nothing points into the chunk, so it is safe to reuse.
+		if state == userArenaFaulted {
+			sysMap(unsafe.Pointer(s.base()), s.npages<<_PageShift, &userArenaRemapped)
+		}
+		atomic.Store(&s.userArena, userArenaNone)
+	} else if state == userArenaFreed {
+		userArenaFault(s)
+	}
+}
+
+//go:linkname arena_newArena runtime/arena.runtime_arena_newArena
+func arena_newArena() unsafe.Pointer {
+	return unsafe.Pointer(new(userArena))
+}
+
+// arena_arenaNew is the implementation of runtime/arena.(*Arena).New.
+// ptr is a nil pointer of type *T.
+//
+//go:linkname arena_arenaNew runtime/arena.runtime_arena_arenaNew
+func arena_arenaNew(arena unsafe.Pointer, ptr interface{}) interface{} {
+	e := efaceOf(&ptr)
+	if e._type == nil || e._type.kind&kindMask != kindPtr {
+		panic(plainError("arena: New of non-pointer type"))
+	}
+	typ := (*ptrtype)(unsafe.Pointer(e._type)).elem
+	e.data = (*userArena)(arena).alloc(typ, 1)
+	return ptr
+}
+
+// arena_arenaMakeSlice is the implementation of
+// runtime/arena.(*Arena).MakeSlice. s is a nil slice of type []T.
+// The slice header is allocated from the arena, too.
+//
+//go:linkname arena_arenaMakeSlice runtime/arena.runtime_arena_arenaMakeSlice
+func arena_arenaMakeSlice(arena unsafe.Pointer, s interface{}, len, cap int) interface{} {
+	e := efaceOf(&s)
+	if e._type == nil || e._type.kind&kindMask != kindSlice {
+		panic(plainError("arena: MakeSlice of non-slice type"))
+	}
+	et := (*slicetype)(unsafe.Pointer(e._type)).elem
+	if len < 0 || uintptr(len) > maxSliceCap(et.size) {
+		panicmakeslicelen()
+	}
+	if cap < len || uintptr(cap) > maxSliceCap(et.size) {
+		panicmakeslicecap()
+	}
+	a := (*userArena)(arena)
+	sl := (*slice)(a.alloc(e._type, 1))
+	sl.array = a.alloc(et, uintptr(cap))
+	sl.len = len
+	sl.cap = cap
+	e.data = unsafe.Pointer(sl)
+	return s
+}
+
+//go:linkname arena_arenaFree runtime/arena.runtime_arena_arenaFree
+func arena_arenaFree(arena unsafe.Pointer) {
+	(*userArena)(arena).free()
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package arena provides memory arenas: groups of objects that are
+allocated together and freed together by an explicit call to Free,
+rather than one at a time by the garbage collector.
+
+This package is experimental. Its API may change or be removed.
+
+Arenas suit code that allocates many short-lived objects with a common
+lifetime, such as the messages decoded while handling one request:
+
+	a := arena.NewArena()
+	defer a.Free()
+	m := a.New((*Message)(nil)).(*Message)
+	m.Fields = a.MakeSlice([]Field(nil), 0, n).([]Field)
+
+The garbage collector still scans arena memory for pointers, so objects
+in an arena may point to objects in the heap, and the reverse.
+
+After Free, the memory of the arena's objects is no longer accessible,
+and using any of them is a fatal fault. Pointers into a freed arena
+must not be kept, and while any remain, the arena's address space is
+not reused. Detection of use after Free is best effort: an access made
+shortly after Free, for example while a garbage collection is in
+progress, may still succeed.
+
+Allocations too large for the arena's memory, and values of some very
+large types, are made from the heap and are freed by the garbage
+collector as usual.
+
+Objects allocated from an arena must not have finalizers.
+*/
+package arena
+
+import (
+	"unsafe"
+)
+
+// An Arena allocates objects that are freed together.
+// An Arena is not safe for concurrent use.
+type Arena struct {
+	a unsafe.Pointer
+}
+
+// Implemented in the runtime.
+func runtime_arena_newArena() unsafe.Pointer
+func runtime_arena_arenaNew(arena unsafe.Pointer, ptr interface{}) interface{}
+func runtime_arena_arenaMakeSlice(arena unsafe.Pointer, s interface{}, len, cap int) interface{}
+func runtime_arena_arenaFree(arena unsafe.Pointer)
+
+// NewArena returns a new, empty arena.
+func NewArena() *Arena {
+	return &Arena{a: runtime_arena_newArena()}
+}
+
+// New allocates a zero value of type T in a and returns a pointer to
+// it. ptr must be a nil pointer of type *T, and the result has type *T.
+func (a *Arena) New(ptr interface{}) interface{} {
+	return runtime_arena_arenaNew(a.arena(), ptr)
+}
+
+// MakeSlice allocates a slice of type []T with the given length and
+// capacity in a. s must be a nil slice of type []T, and the result has
+// type []T.
+func (a *Arena) MakeSlice(s interface{}, len, cap int) interface{} {
+	return runtime_arena_arenaMakeSlice(a.arena(), s, len, cap)
+}
+
+// Free frees every object allocated in a. a must not be used
+// afterward.
+func (a *Arena) Free() {
+	runtime_arena_arenaFree(a.arena())
+	a.a = nil
+}
+
+func (a *Arena) arena() unsafe.Pointer {
+	if a.a == nil {
+		panic("arena: use after Free")
+	}
+	return a.a
+}
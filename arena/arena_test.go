@@ -0,0 +1,124 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package arena_test
+
+import (
+	"runtime"
+	"runtime/arena"
+	"runtime/debug"
+	"testing"
+)
+
+type node struct {
+	val   int
+	name  string
+	next  *node
+	heap  *[4]int
+	bytes []byte
+}
+
+func TestArena(t *testing.T) {
+	a := arena.NewArena()
+	defer a.Free()
+
+	const n = 100000
+	var list *node
+	for i := 0; i < n; i++ {
+		x := a.New((*node)(nil)).(*node)
+		x.val = i
+		x.next = list
+		// Only the arena and the heap objects' own pointers
+		// keep these alive.
+		x.heap = &[4]int{i, i, i, i}
+		x.bytes = a.MakeSlice([]byte(nil), 8, 16).([]byte)
+		x.bytes[0] = byte(i)
+		list = x
+	}
+	runtime.GC()
+	runtime.GC()
+
+	i := n - 1
+	for x := list; x != nil; x = x.next {
+		if x.val != i || x.heap[3] != i || x.bytes[0] != byte(i) || len(x.bytes) != 8 || cap(x.bytes) != 16 {
+			t.Fatalf("node %d corrupted: val=%d heap=%v bytes=%v", i, x.val, x.heap, x.bytes)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("list has %d nodes, want %d", n-1-i, n)
+	}
+}
+
+func TestArenaMakeSlice(t *testing.T) {
+	a := arena.NewArena()
+	defer a.Free()
+
+	s := a.MakeSlice([]*node(nil), 10, 20).([]*node)
+	if len(s) != 10 || cap(s) != 20 {
+		t.Fatalf("len, cap = %d, %d, want 10, 20", len(s), cap(s))
+	}
+	for i := range s {
+		if s[i] != nil {
+			t.Fatalf("s[%d] = %v, want nil", i, s[i])
+		}
+		s[i] = &node{val: i}
+	}
+	// Too big for a chunk, so it comes from the heap.
+	big := a.MakeSlice([]int(nil), 4<<20, 4<<20).([]int)
+	big[len(big)-1] = 1
+	runtime.GC()
+	for i := range s {
+		if s[i].val != i {
+			t.Fatalf("s[%d].val = %d, want %d", i, s[i].val, i)
+		}
+	}
+
+	for _, f := range []func(){
+		func() { a.MakeSlice([]int(nil), -1, 0) },
+		func() { a.MakeSlice([]int(nil), 2, 1) },
+		func() { a.MakeSlice(0, 1, 1) },
+		func() { a.New(node{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("no panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestArenaUseAfterFree(t *testing.T) {
+	a := arena.NewArena()
+	x := a.New((*node)(nil)).(*node)
+	x.val = 1
+	a.Free()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("New after Free did not panic")
+			}
+		}()
+		a.New((*node)(nil))
+	}()
+
+	// Whether the fault is detected right away depends on
+	// whether a collection is running, so finish any that is.
+	runtime.GC()
+	a = arena.NewArena()
+	x = a.New((*node)(nil)).(*node)
+	a.Free()
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if recover() == nil {
+			t.Errorf("read after Free did not fault")
+		}
+	}()
+	t.Logf("read %d", x.val)
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "unsafe"
+
+// cgroupCPU holds the cgroup CPU limit files that apply to the
+// process. cgroupCPUInit finds them once at startup, and
+// cgroupCPULimit reads them whenever the limit may have changed.
+var cgroupCPU struct {
+	files []cgroupCPUFile
+}
+
+// A cgroupCPUFile holds the NUL-terminated paths of the CPU limit
+// files of one cgroup: cpu.max for cgroup v2, or cpu.cfs_quota_us and
+// cpu.cfs_period_us for cgroup v1.
+type cgroupCPUFile struct {
+	max    []byte
+	quota  []byte
+	period []byte
+}
+
+// cgroupCPUInit finds the cgroup CPU limit files that apply to the
+// process.
+func cgroupCPUInit(root string) {
+	cgroupCPU.files = cgroupCPUFind(root)
+}
+
+// cgroupCPUFind returns the cgroup CPU limit files that apply to the
+// process. root is prepended to every path, so tests can use a fake
+// cgroupfs; it is "" otherwise.
+//
+// The process's cgroups are listed in /proc/self/cgroup. The cgroup
+// v1 cpu controller is used if it is mounted, and the cgroup v2
+// hierarchy otherwise, and both are assumed to be mounted in the
+// usual places under /sys/fs/cgroup. Every cgroup from the process's
+// up to the root of the hierarchy may limit it. Without a cgroup
+// namespace, a container may not see its own cgroup by its path, but
+// then the root it sees is that cgroup, so its limit still applies.
+func cgroupCPUFind(root string) []cgroupCPUFile {
+	buf := make([]byte, 8192)
+	n := cgroupReadFile([]byte(root+"/proc/self/cgroup\x00"), buf)
+	if n <= 0 {
+		return nil
+	}
+	var v1, v2 bool
+	var v1mount, v1path, v2path string
+	for data := string(buf[:n]); data != ""; {
+		// hierarchy-ID:controller-list:cgroup-path
+		line := data
+		if i := index(data, "\n"); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = ""
+		}
+		i := index(line, ":")
+		if i < 0 {
+			continue
+		}
+		id, rest := line[:i], line[i+1:]
+		j := index(rest, ":")
+		if j < 0 {
+			continue
+		}
+		controllers, path := rest[:j], rest[j+1:]
+		if id == "0" && controllers == "" {
+			v2, v2path = true, path
+			continue
+		}
+		for list := controllers; list != ""; {
+			c := list
+			if k := index(list, ","); k >= 0 {
+				c, list = list[:k], list[k+1:]
+			} else {
+				list = ""
+			}
+			if c == "cpu" {
+				v1, v1mount, v1path = true, root+"/sys/fs/cgroup/"+controllers, path
+			}
+		}
+	}
+
+	var files []cgroupCPUFile
+	var tmp [64]byte
+	switch {
+	case v1:
+		for _, dir := range cgroupAncestors(v1mount, v1path) {
+			f := cgroupCPUFile{
+				quota:  []byte(dir + "/cpu.cfs_quota_us\x00"),
+				period: []byte(dir + "/cpu.cfs_period_us\x00"),
+			}
+			if cgroupReadFile(f.quota, tmp[:]) > 0 {
+				files = append(files, f)
+			}
+		}
+	case v2:
+		for _, dir := range cgroupAncestors(root+"/sys/fs/cgroup", v2path) {
+			f := cgroupCPUFile{max: []byte(dir + "/cpu.max\x00")}
+			if cgroupReadFile(f.max, tmp[:]) > 0 {
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
+// haveCgroupCPU reports whether cgroups may limit the CPU use of the
+// process, now or later.
+func haveCgroupCPU() bool {
+	return len(cgroupCPU.files) > 0
+}
+
+// cgroupAncestors returns the directories of the cgroup at path in
+// the hierarchy mounted at mount and of all its ancestors.
+func cgroupAncestors(mount, path string) []string {
+	var dirs []string
+	for {
+		for len(path) > 0 && path[len(path)-1] == '/' {
+			path = path[:len(path)-1]
+		}
+		dirs = append(dirs, mount+path)
+		if path == "" {
+			return dirs
+		}
+		i := len(path) - 1
+		for i >= 0 && path[i] != '/' {
+			i--
+		}
+		if i < 0 {
+			return dirs
+		}
+		path = path[:i]
+	}
+}
+
+// cgroupCPULimit returns the smallest CPU limit of the process's
+// cgroups, in CPUs, and whether there is one at all.
+//
+// sysmon calls this, so it must not allocate or have write barriers.
+//
+//go:nowritebarrierrec
+func cgroupCPULimit() (float64, bool) {
+	return cgroupCPUFilesLimit(cgroupCPU.files)
+}
+
+// cgroupCPUFilesLimit returns the smallest CPU limit in files.
+//
+//go:nowritebarrierrec
+func cgroupCPUFilesLimit(files []cgroupCPUFile) (float64, bool) {
+	var buf [64]byte
+	limit, found := 0.0, false
+	for i := range files {
+		f := &files[i]
+		var quota, period int
+		if f.max != nil {
+			// "$MAX $PERIOD", where $MAX is "max" if there
+			// is no limit.
+			s, ok := cgroupReadLine(f.max, buf[:])
+			if !ok {
+				continue
+			}
+			j := index(s, " ")
+			if j < 0 {
+				continue
+			}
+			q, ok1 := atoi(s[:j])
+			p, ok2 := atoi(s[j+1:])
+			if !ok1 || !ok2 {
+				continue
+			}
+			quota, period = q, p
+		} else {
+			// A quota of -1 means there is no limit.
+			s, ok := cgroupReadLine(f.quota, buf[:])
+			if !ok {
+				continue
+			}
+			if quota, ok = atoi(s); !ok {
+				continue
+			}
+			if s, ok = cgroupReadLine(f.period, buf[:]); !ok {
+				continue
+			}
+			if period, ok = atoi(s); !ok {
+				continue
+			}
+		}
+		if quota <= 0 || period <= 0 {
+			continue
+		}
+		if l := float64(quota) / float64(period); !found || l < limit {
+			limit, found = l, true
+		}
+	}
+	return limit, found
+}
+
+// cgroupReadLine reads the first line of the file at the
+// NUL-terminated path into buf and returns it, without allocating.
+//
+//go:nowritebarrierrec
+func cgroupReadLine(path, buf []byte) (string, bool) {
+	n := cgroupReadFile(path, buf)
+	if n <= 0 {
+		return "", false
+	}
+	s := slicebytetostringtmp(buf[:n])
+	if i := index(s, "\n"); i >= 0 {
+		s = s[:i]
+	}
+	return s, true
+}
+
+// cgroupReadFile reads up to len(buf) bytes of the file at the
+// NUL-terminated path into buf. It returns the number of bytes read,
+// or a negative value on error.
+//
+//go:nowritebarrierrec
+func cgroupReadFile(path, buf []byte) int32 {
+	fd := open(&path[0], 0 /* O_RDONLY */, 0)
+	if fd < 0 {
+		return -1
+	}
+	n := int32(0)
+	for n < int32(len(buf)) {
+		r := read(fd, unsafe.Pointer(&buf[n]), int32(len(buf))-n)
+		if r <= 0 {
+			break
+		}
+		n += r
+	}
+	closefd(fd)
+	return n
+}
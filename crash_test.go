@@ -6,6 +6,7 @@ package runtime_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"internal/testenv"
@@ -20,6 +21,7 @@ import (
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 var toRemove []string
@@ -725,3 +727,183 @@ func TestG0StackOverflow(t *testing.T) {
 
 	runtime.G0StackOverflow()
 }
+
+func TestLockOrderInversion(t *testing.T) {
+	output := runTestProg(t, "testprog", "LockOrderInversion", "GODEBUG=lockorder=1")
+	for _, want := range []string{
+		"sync: possible deadlock: inconsistent lock order",
+		"main.lockOrderAB(...)",
+		"main.lockOrderBA(...)",
+		"but these mutexes were previously locked in the opposite order",
+		"OK\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, output)
+		}
+	}
+}
+
+func TestLockOrderDeadlock(t *testing.T) {
+	output := runTestProg(t, "testprog", "LockOrderDeadlock", "GODEBUG=lockorder=1")
+	for _, want := range []string{
+		"sync: deadlock: goroutines are waiting for mutexes held by each other",
+		"main.LockOrderDeadlock.func1(...)",
+		"main.LockOrderDeadlock.func2(...)",
+		"fatal error: all goroutines are asleep - deadlock!",
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, output)
+		}
+	}
+}
+
+// crashReportFrame and crashReportJSON are the parts of a crash report
+// the tests check.
+type crashReportFrame struct {
+	Function string
+	File     string
+	Line     int
+	PC       string
+}
+
+type crashReportJSON struct {
+	Event   string
+	Kind    string
+	Message string
+	Signal  *struct {
+		Name string
+		Addr string
+	}
+	Goroutines []struct {
+		ID         int64
+		Status     string
+		WaitReason string `json:"wait_reason"`
+		Crashed    bool
+		Frames     []crashReportFrame
+		CreatedBy  *crashReportFrame `json:"created_by"`
+	}
+}
+
+// runCrashOutput runs testprog's name with the crash output and crash
+// report set, and returns its standard output and error, the crash
+// output and the crash report, both parsed and as written.
+func runCrashOutput(t *testing.T, name string, env ...string) (output string, crashOutput []byte, report crashReportJSON, data []byte) {
+	dir, err := ioutil.TempDir("", "go-crash-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outFile := filepath.Join(dir, "output")
+	reportFile := filepath.Join(dir, "report")
+
+	env = append(env, "CRASH_OUTPUT="+outFile, "CRASH_REPORT="+reportFile)
+	output = runTestProg(t, "testprog", name, env...)
+	crashOutput, err = ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("bad crash report: %v\n%s", err, data)
+	}
+	if report.Event != "crash" {
+		t.Errorf("got event %q in crash report, want crash", report.Event)
+	}
+	return output, crashOutput, report, data
+}
+
+func TestCrashOutput(t *testing.T) {
+	output, got, report, data := runCrashOutput(t, "CrashOutput", "GOTRACEBACK=all")
+	if !strings.HasPrefix(output, "panic: crash output test\n") {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+
+	// The crash output has a copy of standard error.
+	for _, want := range []string{
+		"panic: crash output test\n",
+		"goroutine 1 [running]:\n",
+		"main.CrashOutput()",
+		"[chan receive]:\n",
+	} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("crash output does not contain %q:\n%s", want, got)
+		}
+	}
+
+	if report.Kind != "panic" || report.Message != "crash output test" || report.Signal != nil {
+		t.Errorf("got kind %q, message %q, signal %v; want panic, crash output test, none", report.Kind, report.Message, report.Signal)
+	}
+	if len(report.Goroutines) < 2 {
+		t.Fatalf("got %d goroutines in crash report, want at least 2:\n%s", len(report.Goroutines), data)
+	}
+	g := report.Goroutines[0]
+	if g.ID != 1 || g.Status != "running" || !g.Crashed {
+		t.Errorf("first goroutine is %d %s crashed=%v; want 1 running crashed=true", g.ID, g.Status, g.Crashed)
+	}
+	found := false
+	for _, f := range g.Frames {
+		if f.Function == "main.CrashOutput" && f.Line > 0 && strings.HasPrefix(f.PC, "0x") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("main.CrashOutput missing from the crashing goroutine's frames:\n%s", data)
+	}
+	found = false
+	for _, g := range report.Goroutines[1:] {
+		if g.Status == "waiting" && g.WaitReason == "chan receive" && g.CreatedBy != nil && g.CreatedBy.Function == "main.CrashOutput" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("blocked goroutine missing from crash report:\n%s", data)
+	}
+}
+
+func TestCrashOutputThrow(t *testing.T) {
+	const msg = "all goroutines are asleep - deadlock!"
+	output, got, report, data := runCrashOutput(t, "CrashOutputThrow")
+	if !strings.Contains(output, "fatal error: "+msg+"\n") {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+	if !strings.Contains(string(got), "fatal error: "+msg+"\n") {
+		t.Errorf("crash output does not contain the fatal error:\n%s", got)
+	}
+	if report.Kind != "fatal error" || report.Message != msg || report.Signal != nil {
+		t.Errorf("got kind %q, message %q, signal %v; want fatal error, %s, none:\n%s", report.Kind, report.Message, report.Signal, msg, data)
+	}
+}
+
+func TestCrashOutputBadUTF8(t *testing.T) {
+	_, _, report, data := runCrashOutput(t, "CrashOutputBadUTF8")
+	if !utf8.Valid(data) {
+		t.Errorf("crash report is not valid UTF-8:\n%q", data)
+	}
+	if want := "crash output \ufffd test"; report.Kind != "panic" || report.Message != want {
+		t.Errorf("got kind %q, message %q; want panic, %q", report.Kind, report.Message, want)
+	}
+}
+
+func TestLockOrderRWInversion(t *testing.T) {
+	output := runTestProg(t, "testprog", "LockOrderRWInversion", "GODEBUG=lockorder=1")
+	for _, want := range []string{
+		"sync: possible deadlock: inconsistent lock order",
+		"main.lockOrderReadAB(...)",
+		"main.LockOrderRWInversion(...)",
+		"OK\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("output does not contain %q:\n%s", want, output)
+		}
+	}
+}
+
+func TestLockOrderFreed(t *testing.T) {
+	output := runTestProg(t, "testprog", "LockOrderFreed", "GODEBUG=lockorder=1")
+	if output != "OK\n" {
+		t.Fatalf("want OK, got:\n%s", output)
+	}
+}
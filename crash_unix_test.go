@@ -281,3 +281,34 @@ func TestSignalDuringExec(t *testing.T) {
 		t.Fatalf("want %s, got %s\n", want, output)
 	}
 }
+
+func TestCrashOutputSegv(t *testing.T) {
+	output, _, report, data := runCrashOutput(t, "CrashOutputSegv")
+	if !strings.Contains(output, "[signal SIGSEGV: segmentation violation") {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+	// The fault becomes a panic, and the report has both.
+	const msg = "runtime error: invalid memory address or nil pointer dereference"
+	if report.Kind != "panic" || report.Message != msg {
+		t.Errorf("got kind %q, message %q; want panic, %s", report.Kind, report.Message, msg)
+	}
+	if s := report.Signal; s == nil || !strings.HasPrefix(s.Name, "SIGSEGV") || s.Addr != "0x0" {
+		t.Errorf("got signal %+v; want SIGSEGV at 0x0:\n%s", s, data)
+	}
+}
+
+func TestCrashOutputSignal(t *testing.T) {
+	output, _, report, data := runCrashOutput(t, "CrashOutputSignal")
+	if !strings.Contains(output, "SIGABRT: abort") {
+		t.Fatalf("unexpected output:\n%s", output)
+	}
+	if report.Kind != "signal" || report.Message != "" {
+		t.Errorf("got kind %q, message %q; want signal and no message", report.Kind, report.Message)
+	}
+	if s := report.Signal; s == nil || !strings.HasPrefix(s.Name, "SIGABRT") {
+		t.Errorf("got signal %+v; want SIGABRT:\n%s", s, data)
+	}
+	if len(report.Goroutines) == 0 || !report.Goroutines[0].Crashed {
+		t.Errorf("crashing goroutine missing from crash report:\n%s", data)
+	}
+}
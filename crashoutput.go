@@ -0,0 +1,282 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Crash output, set by runtime/debug.SetCrashOutput.
+//
+// Everything the runtime prints while it crashes, from the panic
+// message or fatal error to the last goroutine traceback, is copied
+// to the crash output file, in addition to standard error.
+//
+// The crash report file, if set, gets a single JSON object describing
+// the crash, followed by a newline. Like the tracejsonfd objects, it
+// has "event" ("crash") and "time_ns" fields, and fields may be
+// added, but are not renamed or removed:
+//
+//	kind        "panic", "fatal error" or "signal"
+//	message     the panic values or the fatal error message
+//	signal      for a fault or signal: an object with the fields
+//	            "name", "code", "addr" and "pc"
+//	goroutines  an array of goroutines, the crashing one first
+//
+// Each goroutine is an object with the fields:
+//
+//	id                goroutine ID
+//	status            "running", "runnable", "waiting", "syscall", ...
+//	wait_reason       why the goroutine is waiting, if it is
+//	wait_minutes      approximate time the goroutine has been blocked
+//	locked_to_thread  whether the goroutine is locked to its thread
+//	crashed           whether the goroutine crashed the program
+//	frames            the goroutine's stack, innermost frame first,
+//	                  or absent if the stack is not available
+//	created_by        the frame of the go statement that created it
+//	parent_id         ID of the goroutine that executed that statement
+//
+// Each frame is an object with the fields "function", "file", "line"
+// and "pc", an address in hexadecimal. As in the text output, the
+// goroutines listed and the runtime frames shown depend on
+// GOTRACEBACK.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// crashFD and crashReportFD are the file descriptors of the crash
+// output and the crash report, or ^uintptr(0) if they are not set.
+var (
+	crashFD       = ^uintptr(0)
+	crashReportFD = ^uintptr(0)
+)
+
+// crashReport holds the state of the crash report. It is only used by
+// the M that holds paniclk while it first crashes, so needs no lock
+// of its own.
+var crashReport struct {
+	w    jsonWriter
+	msg  [1024]byte // the panic values, as printpanics prints them
+	nmsg int
+	done bool // the report was written, or is being written
+}
+
+//go:linkname setCrashFD runtime/debug.setCrashFD
+func setCrashFD(fd, reportFD uintptr) (oldFD, oldReportFD uintptr) {
+	oldFD = atomic.Loaduintptr(&crashFD)
+	oldReportFD = atomic.Loaduintptr(&crashReportFD)
+	atomic.Storeuintptr(&crashFD, fd)
+	atomic.Storeuintptr(&crashReportFD, reportFD)
+	return
+}
+
+// writeCrash copies b, which was just written to standard error, to
+// the crash output if the program is crashing.
+//
+//go:nosplit
+func writeCrash(b []byte) {
+	gp := getg()
+	if gp != nil && (gp.m.dying > 0 || gp.m.throwing != 0) || gp == nil && atomic.Load(&panicking) > 0 {
+		if fd := atomic.Loaduintptr(&crashFD); fd != ^uintptr(0) {
+			write(fd, unsafe.Pointer(&b[0]), int32(len(b)))
+		}
+	}
+}
+
+// crashReportPanics records the messages of the panics crashing the
+// program for the crash report.
+func crashReportPanics(msgs *_panic) {
+	if atomic.Loaduintptr(&crashReportFD) == ^uintptr(0) {
+		return
+	}
+	// Format the panic values the way printpanics prints them,
+	// without the "panic: " prefix and the final newline.
+	gp := getg()
+	gp.writebuf = crashReport.msg[:0]
+	printpanics(msgs)
+	n := len(gp.writebuf)
+	gp.writebuf = nil
+	msg := crashReport.msg[:n]
+	if len(msg) > len("panic: ") {
+		msg = msg[len("panic: "):]
+	}
+	if len(msg) > 0 && msg[len(msg)-1] == '\n' {
+		msg = msg[:len(msg)-1]
+	}
+	crashReport.nmsg = copy(crashReport.msg[:], msg)
+}
+
+// writeCrashReport writes the crash report, if there is a crash report
+// file. gp crashed the program, and pc, sp and lr are where to start
+// its traceback, as for traceback, or tracebacktrap if trap is set.
+// sig is the signal crashing the program, if any, and msg the message
+// of the fatal error crashing it, if any.
+//
+// It must run on the system stack of the M that holds paniclk.
+func writeCrashReport(gp *g, pc, sp, lr uintptr, trap bool, sig uint32, msg string) {
+	fd := atomic.Loaduintptr(&crashReportFD)
+	if fd == ^uintptr(0) || crashReport.done {
+		return
+	}
+	crashReport.done = true
+	_g_ := getg()
+	w := &crashReport.w
+	w.fd = fd
+	w.begin("crash")
+	switch {
+	case crashReport.nmsg > 0:
+		w.string("kind", "panic")
+		w.string("message", slicebytetostringtmp(crashReport.msg[:crashReport.nmsg]))
+	case sig != 0:
+		w.string("kind", "signal")
+	default:
+		w.string("kind", "fatal error")
+		w.string("message", msg)
+	}
+	if sig == 0 {
+		sig = gp.sig
+	}
+	if sig != 0 {
+		w.objectBegin("signal")
+		w.string("name", signame(sig))
+		w.hex("code", uint64(gp.sigcode0))
+		w.hex("addr", uint64(gp.sigcode1))
+		w.hex("pc", uint64(gp.sigpc))
+		w.objectEnd()
+	}
+
+	level, all, _ := gotraceback()
+	w.arrayBegin("goroutines")
+	if level > 0 {
+		var flags uint
+		if trap {
+			flags = _TraceTrap
+		}
+		crashReportGoroutine(w, gp, pc, sp, lr, flags, true)
+		if gp != _g_.m.curg {
+			all = true
+		}
+		if all {
+			if curg := _g_.m.curg; curg != nil && curg != gp {
+				crashReportGoroutine(w, curg, ^uintptr(0), ^uintptr(0), 0, 0, false)
+			}
+			lock(&allglock)
+			for _, gp1 := range allgs {
+				if gp1 == gp || gp1 == _g_.m.curg || readgstatus(gp1) == _Gdead || isSystemGoroutine(gp1) && level < 2 {
+					continue
+				}
+				crashReportGoroutine(w, gp1, ^uintptr(0), ^uintptr(0), 0, 0, false)
+			}
+			unlock(&allglock)
+		}
+	}
+	w.arrayEnd()
+	w.end()
+}
+
+// crashReportGoroutine appends gp to the goroutines of the crash
+// report. It reads gp's stack the way traceback1 prints it.
+func crashReportGoroutine(w *jsonWriter, gp *g, pc, sp, lr uintptr, flags uint, crashed bool) {
+	_g_ := getg()
+	gpstatus := readgstatus(gp) &^ _Gscan
+	w.objectBegin("")
+	if gp == _g_.m.g0 {
+		// The runtime crashed on the system stack.
+		w.int("id", 0)
+	} else {
+		w.int("id", gp.goid)
+	}
+	status := "???"
+	if gpstatus < uint32(len(gStatusStrings)) {
+		status = gStatusStrings[gpstatus]
+	}
+	w.string("status", status)
+	if gpstatus == _Gwaiting && gp.waitreason != waitReasonZero {
+		w.string("wait_reason", gp.waitreason.String())
+	}
+	if (gpstatus == _Gwaiting || gpstatus == _Gsyscall) && gp.waitsince != 0 {
+		w.int("wait_minutes", (nanotime()-gp.waitsince)/60e9)
+	}
+	w.bool("locked_to_thread", gp.lockedm != 0)
+	w.bool("crashed", crashed)
+
+	// Like tracebackothers, don't read the stack of a goroutine
+	// running on another thread.
+	if crashed || gp.m == _g_.m || gpstatus != _Grunning {
+		if gpstatus == _Gsyscall {
+			pc, sp, lr = gp.syscallpc, gp.syscallsp, 0
+			flags &^= _TraceTrap
+		}
+		w.arrayBegin("frames")
+		n := crashReportFrames(w, gp, pc, sp, lr, flags)
+		if n == 0 {
+			crashReportFrames(w, gp, pc, sp, lr, flags|_TraceRuntimeFrames)
+		}
+		w.arrayEnd()
+	}
+
+	if f := findfunc(gp.gopc); f.valid() && gp.goid != 1 && showframe(f, gp, false, false) {
+		tracepc := gp.gopc
+		if tracepc > f.entry {
+			tracepc -= sys.PCQuantum
+		}
+		file, line := funcline(f, tracepc)
+		w.objectBegin("created_by")
+		crashReportFrame(w, funcname(f), file, line, gp.gopc)
+		w.objectEnd()
+		if gp.parentGoid != 0 {
+			w.int("parent_id", gp.parentGoid)
+		}
+	}
+	w.objectEnd()
+}
+
+// crashReportFrames appends the frames of gp's stack, which starts at
+// pc, sp and lr, to the current array of the crash report, leaving out
+// the frames traceback leaves out. It returns the number of frames it
+// appended.
+func crashReportFrames(w *jsonWriter, gp *g, pc, sp, lr uintptr, flags uint) int {
+	n := 0
+	first := true
+	waspanic := false
+	gentraceback(pc, sp, lr, gp, 0, nil, _TracebackMaxFrames, func(frame *stkframe, unused unsafe.Pointer) bool {
+		f := frame.fn
+		if flags&_TraceRuntimeFrames != 0 || showframe(f, gp, n == 0, false) {
+			tracepc := frame.pc // back up to CALL instruction for funcline.
+			if (!first || flags&_TraceTrap == 0) && frame.pc > f.entry && !waspanic {
+				tracepc--
+			}
+			file, line := funcline(f, tracepc)
+			if inldata := funcdata(f, _FUNCDATA_InlTree); inldata != nil {
+				inltree := (*[1 << 20]inlinedCall)(inldata)
+				ix := pcdatavalue(f, _PCDATA_InlTreeIndex, tracepc, nil)
+				for ix != -1 {
+					w.objectBegin("")
+					crashReportFrame(w, funcnameFromNameoff(f, inltree[ix].func_), file, line, frame.pc)
+					w.objectEnd()
+					file = funcfile(f, inltree[ix].file)
+					line = inltree[ix].line
+					ix = inltree[ix].parent
+				}
+			}
+			w.objectBegin("")
+			crashReportFrame(w, funcname(f), file, line, frame.pc)
+			w.objectEnd()
+			n++
+		}
+		first = false
+		waspanic = f.funcID == funcID_sigpanic
+		return true
+	}, nil, flags)
+	return n
+}
+
+// crashReportFrame appends the fields of a frame to the current
+// object of the crash report.
+func crashReportFrame(w *jsonWriter, function, file string, line int32, pc uintptr) {
+	w.string("function", function)
+	w.string("file", file)
+	w.int("line", int64(line))
+	w.hex("pc", uint64(pc))
+}
@@ -11,7 +11,8 @@ import (
 
 // GOMAXPROCS sets the maximum number of CPUs that can be executing
 // simultaneously and returns the previous setting. If n < 1, it does not
-// change the current setting.
+// change the current setting. Otherwise, GOMAXPROCS stops following
+// changes to the cgroup CPU limit, as described in the package documentation.
 // The number of logical CPUs on the local machine can be queried with NumCPU.
 // This call will go away when the scheduler improves.
 func GOMAXPROCS(n int) int {
@@ -22,7 +23,12 @@ func GOMAXPROCS(n int) int {
 	lock(&sched.lock)
 	ret := int(gomaxprocs)
 	unlock(&sched.lock)
-	if n <= 0 || n == ret {
+	if n <= 0 {
+		return ret
+	}
+	// Stop following the cgroup CPU limit.
+	atomic.Store(&maxprocsUpdate.custom, 1)
+	if n == ret {
 		return ret
 	}
 
@@ -92,6 +92,43 @@ func SetGCPercent(percent int) int {
 	return int(setGCPercent(int32(percent)))
 }
 
+// SetMemoryLimit provides the runtime with a soft memory limit.
+//
+// The runtime undertakes several processes to try to respect this
+// memory limit, including adjustments to the frequency of garbage
+// collections and returning memory to the underlying system more
+// aggressively. This limit will be respected even if GOGC=off (or,
+// if SetGCPercent(-1) is executed).
+//
+// The input limit is provided as bytes, and includes all memory
+// mapped, managed, and not released by the Go runtime: the heap,
+// goroutine stacks, and runtime metadata. Notably, it does not
+// account for space used by the Go binary and memory external to Go,
+// such as memory managed by the underlying system on behalf of the
+// process, or memory managed by non-Go code inside the same process.
+//
+// A zero limit or a limit that's lower than the amount of memory
+// used by the Go runtime may cause the garbage collector to run
+// nearly continuously. However, the application may still make
+// progress: the runtime caps the CPU time the garbage collector may
+// use while enforcing the limit to roughly 50%, letting the heap
+// exceed the limit instead.
+//
+// SetMemoryLimit returns the previously set memory limit. A negative
+// input does not adjust the limit, and allows for retrieval of the
+// currently set memory limit.
+//
+// The initial setting is math.MaxInt64 unless the GOMEMLIMIT
+// environment variable is set, in which case it provides the initial
+// setting. GOMEMLIMIT is a numeric value in bytes with an optional
+// unit suffix. The supported suffixes are B, KiB, MiB, GiB, and TiB.
+// These suffixes represent quantities of bytes as defined by the
+// IEC 80000-13 standard. GOMEMLIMIT=off is equivalent to leaving it
+// unset.
+func SetMemoryLimit(limit int64) int64 {
+	return setMemoryLimit(limit)
+}
+
 // FreeOSMemory forces a garbage collection followed by an
 // attempt to return as much memory to the operating system
 // as possible. (Even if this is not called, the runtime gradually
@@ -114,6 +151,24 @@ func SetMaxStack(bytes int) int {
 	return setMaxStack(bytes)
 }
 
+// SetGoroutineStackLimit sets the stack size limit of the calling
+// goroutine's group to bytes, and returns the previous limit.
+// A goroutine's group is made of the goroutines it starts after
+// the call, the goroutines those start, and so on: each new goroutine
+// inherits the limit of the goroutine that starts it.
+// A limit of 0 or less means no limit, which is the initial setting.
+//
+// Unlike SetMaxStack, exceeding the limit does not crash the program.
+// Instead, the goroutine panics with a runtime.Error, which can be
+// recovered. The stack may grow beyond the limit while the goroutine
+// panics, so that its deferred calls can run. Like SetMaxStack, it
+// only limits future stack growth, and the limit is approximate:
+// stacks grow by doubling, and the runtime does not panic while it
+// is running its own code.
+func SetGoroutineStackLimit(bytes int) int {
+	return setGoroutineStackLimit(bytes)
+}
+
 // SetMaxThreads sets the maximum number of operating system
 // threads that the Go program can use. If it attempts to use more than
 // this many, the program crashes.
@@ -153,7 +208,9 @@ func SetPanicOnFault(enabled bool) bool {
 // connected to a pipe or socket whose other end is in the same Go
 // process; instead, use a temporary file or network socket.
 //
-// The heap dump format is defined at https://golang.org/s/go15heapdump.
+// The heap dump format is described in the documentation of package
+// runtime/heapdump, which reads it. Small objects are only given their
+// types in the dump if the program runs with GODEBUG=heaptypes=1.
 func WriteHeapDump(fd uintptr)
 
 // SetTraceback sets the amount of detail printed by the runtime in
@@ -166,3 +223,19 @@ func WriteHeapDump(fd uintptr)
 // If SetTraceback is called with a level lower than that of the
 // environment variable, the call is ignored.
 func SetTraceback(level string)
+
+// DetectGoroutineLeaks runs a garbage collection that also looks for
+// leaked goroutines and returns the number of new leaks it found.
+//
+// A goroutine is leaked if it is blocked receiving from or sending to
+// a channel, in a select statement, or in sync.Cond.Wait, and nothing
+// but leaked goroutines can reach the channels or Cond it is waiting
+// on. Such a goroutine can never be woken up, and neither its stack
+// nor anything it refers to can be freed.
+//
+// The collection stops the world for the whole mark phase, so it is
+// more disruptive than a regular collection. Leaked goroutines are
+// reported by the "goroutineleak" profile in runtime/pprof.
+func DetectGoroutineLeaks() int {
+	return detectGoroutineLeaks()
+}
@@ -172,6 +172,38 @@ func TestSetGCPercent(t *testing.T) {
 	}
 }
 
+func TestSetMemoryLimit(t *testing.T) {
+	// Test that the limit is being set and returned correctly.
+	old := SetMemoryLimit(-1)
+	defer SetMemoryLimit(old)
+	SetMemoryLimit(1 << 40)
+	if got := SetMemoryLimit(-1); got != 1<<40 {
+		t.Errorf("SetMemoryLimit(1<<40); SetMemoryLimit(-1) = %d, want %d", got, int64(1<<40))
+	}
+
+	// Test that the limit bounds the heap goal even with GC off.
+	defer SetGCPercent(SetGCPercent(-1))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	limit := int64(ms.Sys) + 64<<20
+	SetMemoryLimit(limit)
+	runtime.ReadMemStats(&ms)
+	if int64(ms.NextGC) >= limit {
+		t.Errorf("NextGC = %d MB with limit %d MB, want less than the limit", ms.NextGC>>20, limit>>20)
+	}
+	ngc1 := ms.NumGC
+	// Allocate more garbage than fits under the limit.
+	for i := 0; i < 256<<20; i += 1 << 10 {
+		setGCPercentSink = make([]byte, 1<<10)
+	}
+	setGCPercentSink = nil
+	runtime.ReadMemStats(&ms)
+	if ms.NumGC == ngc1 {
+		t.Errorf("expected GC to run under the memory limit but it did not")
+	}
+}
+
 func abs64(a int64) int64 {
 	if a < 0 {
 		return -a
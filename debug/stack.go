@@ -7,8 +7,10 @@
 package debug
 
 import (
+	"errors"
 	"os"
 	"runtime"
+	"sync"
 )
 
 // PrintStack prints to standard error the stack trace returned by runtime.Stack.
@@ -28,3 +30,73 @@ func Stack() []byte {
 		buf = make([]byte, 2*len(buf))
 	}
 }
+
+// AllStacks returns formatted stack traces of all goroutines, in the
+// same form as runtime.Stack(buf, true), with the calling goroutine
+// first. Unlike runtime.Stack, it does not stop the world: each
+// goroutine is suspended only while its own stack trace is formatted.
+// The traces are therefore not a consistent snapshot; goroutines may
+// run, exit or be created while AllStacks collects them. It suits
+// dumping the goroutines of a live server.
+func AllStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := allStacks(buf)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// CrashOptions provides options for SetCrashOutput.
+type CrashOptions struct {
+	// Report, if not nil, is a file to which the runtime writes a
+	// machine-readable report of the crash: a JSON object with the
+	// panic values or fatal error message, and the ID, status, wait
+	// reason and stack frames of each goroutine traced back. The
+	// format is described in the runtime source, crashoutput.go.
+	Report *os.File
+}
+
+// crashOutput holds the files set by SetCrashOutput, so that they
+// are not closed by their finalizers while the runtime may write to
+// them.
+var crashOutput struct {
+	sync.Mutex
+	f, report *os.File
+}
+
+// SetCrashOutput configures a file to which the runtime also writes
+// what it prints to standard error when the program crashes: the
+// message of an unrecovered panic or fatal error and the goroutine
+// tracebacks that follow, subject to GOTRACEBACK and SetTraceback.
+// If opts.Report is set, the runtime writes a JSON crash report to it
+// as well. A nil f or opts.Report disables the corresponding output.
+//
+// There is only one crash output and one crash report: each call to
+// SetCrashOutput replaces the files of the previous call. The files
+// must stay open until they are replaced; SetCrashOutput returns an
+// error if either of them is already closed.
+//
+// The files are written with direct system calls while the runtime
+// crashes, so they should be regular files or pipes that do not
+// depend on the crashing process to be read.
+func SetCrashOutput(f *os.File, opts CrashOptions) error {
+	fd, reportFD := ^uintptr(0), ^uintptr(0)
+	if f != nil {
+		if fd = f.Fd(); fd == ^uintptr(0) {
+			return errors.New("runtime/debug: crash output file is closed")
+		}
+	}
+	if opts.Report != nil {
+		if reportFD = opts.Report.Fd(); reportFD == ^uintptr(0) {
+			return errors.New("runtime/debug: crash report file is closed")
+		}
+	}
+	crashOutput.Lock()
+	defer crashOutput.Unlock()
+	setCrashFD(fd, reportFD)
+	crashOutput.f, crashOutput.report = f, opts.Report
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	. "runtime/debug"
 	"strings"
 	"testing"
+	"time"
 )
 
 type T int
@@ -63,3 +64,39 @@ func check(t *testing.T, line, has string) {
 		t.Errorf("expected %q in %q", has, line)
 	}
 }
+
+func TestAllStacks(t *testing.T) {
+	c := make(chan int)
+	done := make(chan bool)
+	go func() {
+		stackWaiter(c)
+		done <- true
+	}()
+	defer func() {
+		close(c)
+		<-done
+	}()
+
+	var s string
+	for i := 0; i < 100; i++ {
+		s = string(AllStacks())
+		if strings.Contains(s, "runtime/debug_test.stackWaiter") {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !strings.HasPrefix(s, "goroutine ") || !strings.Contains(s, "runtime/debug_test.TestAllStacks") {
+		t.Fatalf("AllStacks does not start with the calling goroutine:\n%s", s)
+	}
+	if !strings.Contains(s, "[chan receive]:\nruntime/debug_test.stackWaiter") {
+		t.Errorf("AllStacks is missing the blocked goroutine:\n%s", s)
+	}
+	if strings.Contains(s, "(scan)") {
+		t.Errorf("AllStacks shows a goroutine it suspended as being scanned:\n%s", s)
+	}
+}
+
+//go:noinline
+func stackWaiter(c chan int) {
+	<-c
+}
@@ -12,6 +12,11 @@ import (
 func readGCStats(*[]time.Duration)
 func freeOSMemory()
 func setMaxStack(int) int
+func setGoroutineStackLimit(int) int
 func setGCPercent(int32) int32
+func setMemoryLimit(int64) int64
+func detectGoroutineLeaks() int
 func setPanicOnFault(bool) bool
 func setMaxThreads(int) int
+func allStacks([]byte) int
+func setCrashFD(uintptr, uintptr) (uintptr, uintptr)
@@ -56,17 +56,7 @@ func debugCallCheck(pc uintptr) string {
 			return
 		}
 
-		// Look up PC's register map.
-		pcdata := int32(-1)
-		if pc != f.entry {
-			pc--
-			pcdata = pcdatavalue(f, _PCDATA_RegMapIndex, pc, nil)
-		}
-		if pcdata == -1 {
-			pcdata = 0 // in prologue
-		}
-		stkmap := (*stackmap)(funcdata(f, _FUNCDATA_RegPointerMaps))
-		if pcdata == -2 || stkmap == nil {
+		if !atRegMapSafePoint(f, pc) {
 			// Not at a safe point.
 			ret = debugCallUnsafePoint
 			return
@@ -0,0 +1,91 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"reflect"
+	. "runtime"
+	"testing"
+)
+
+func TestReadvarintUnsafe(t *testing.T) {
+	for _, test := range []struct {
+		b    []byte
+		want uint32
+		n    int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x7f, 0xff}, 0x7f, 1},
+		{[]byte{0x80, 0x01}, 0x80, 2},
+		{[]byte{0xac, 0x02, 0x05}, 300, 2},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 0xffffffff, 5},
+	} {
+		v, n := ReadvarintUnsafe(test.b)
+		if v != test.want || n != test.n {
+			t.Errorf("readvarintUnsafe(%x) = %d, %d bytes; want %d, %d bytes", test.b, v, n, test.want, test.n)
+		}
+	}
+}
+
+func TestOpenDeferChainOrder(t *testing.T) {
+	// deferreturn offset 300, maxargsize 16.
+	fd := []byte{0xac, 0x02, 0x10}
+	const entry = 0x1000
+	sps := []uintptr{0x300, 0x100, 0x400, 0x200}
+	chain, pcs := OpenDeferChain(fd, entry, sps)
+	want := []uintptr{0x100, 0x200, 0x300, 0x400}
+	if !reflect.DeepEqual(chain, want) {
+		t.Errorf("defer chain has sps %#x; want %#x", chain, want)
+	}
+	for i, pc := range pcs {
+		if pc != entry+300 {
+			t.Errorf("entry %d resumes at %#x; want %#x", i, pc, entry+300)
+		}
+	}
+}
+
+func TestOpenDeferFrameGoexit(t *testing.T) {
+	var ran []int
+	fns := []func(int){
+		func(i int) { ran = append(ran, i) },
+		func(i int) { ran = append(ran, i) },
+		func(i int) { ran = append(ran, i) },
+	}
+	args := []int{10, 11, 12}
+	// Defer 1 was not reached; the others run, last deferred first.
+	done, bits, recovered := RunOpenDeferFrame(fns, args, 0x5, false)
+	if !done || bits != 0 || recovered {
+		t.Errorf("RunOpenDeferFrame = %v, %#x, %v; want true, 0, false", done, bits, recovered)
+	}
+	if want := []int{12, 10}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("deferred calls ran with %v; want %v", ran, want)
+	}
+}
+
+func TestOpenDeferFramePanic(t *testing.T) {
+	var ran []int
+	var rec interface{}
+	fns := []func(int){
+		func(i int) { ran = append(ran, i) },
+		func(i int) {
+			ran = append(ran, i)
+			rec = recover()
+		},
+		func(i int) { ran = append(ran, i) },
+	}
+	args := []int{10, 11, 12}
+	// Defer 1 recovers, which stops the frame's defers, leaving
+	// defer 0 to run when the frame resumes.
+	done, bits, recovered := RunOpenDeferFrame(fns, args, 0x7, true)
+	if done || bits != 0x1 || !recovered {
+		t.Errorf("RunOpenDeferFrame = %v, %#x, %v; want false, 0x1, true", done, bits, recovered)
+	}
+	if want := []int{12, 11}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("deferred calls ran with %v; want %v", ran, want)
+	}
+	if rec != "RunOpenDeferFrame" {
+		t.Errorf("recover() = %v; want the panic value", rec)
+	}
+}
@@ -9,6 +9,7 @@ const (
 	_EFAULT      = 0xe
 	_EAGAIN      = 0xb
 	_ETIMEDOUT   = 0x91
+	_ETIME       = 0x3e
 	_EWOULDBLOCK = 0xb
 	_EINPROGRESS = 0x96
 
@@ -99,7 +100,8 @@ const (
 	_POLLHUP = 0x10
 	_POLLERR = 0x8
 
-	_PORT_SOURCE_FD = 0x4
+	_PORT_SOURCE_USER = 0x3
+	_PORT_SOURCE_FD   = 0x4
 )
 
 type semt struct {
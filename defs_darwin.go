@@ -105,11 +105,13 @@ const (
 	EV_ADD       = C.EV_ADD
 	EV_DELETE    = C.EV_DELETE
 	EV_CLEAR     = C.EV_CLEAR
+	EV_ONESHOT   = C.EV_ONESHOT
 	EV_RECEIPT   = C.EV_RECEIPT
 	EV_ERROR     = C.EV_ERROR
 	EV_EOF       = C.EV_EOF
 	EVFILT_READ  = C.EVFILT_READ
 	EVFILT_WRITE = C.EVFILT_WRITE
+	EVFILT_TIMER = C.EVFILT_TIMER
 
 	PTHREAD_CREATE_DETACHED = C.PTHREAD_CREATE_DETACHED
 
@@ -83,11 +83,13 @@ const (
 	_EV_ADD       = 0x1
 	_EV_DELETE    = 0x2
 	_EV_CLEAR     = 0x20
+	_EV_ONESHOT   = 0x10
 	_EV_RECEIPT   = 0x40
 	_EV_ERROR     = 0x4000
 	_EV_EOF       = 0x8000
 	_EVFILT_READ  = -0x1
 	_EVFILT_WRITE = -0x2
+	_EVFILT_TIMER = -0x7
 
 	_PTHREAD_CREATE_DETACHED = 0x2
 
@@ -147,7 +149,7 @@ type timespec struct {
 }
 
 //go:nosplit
-func (t *timespec) set_nsec(ns int64) {
+func (t *timespec) setNsec(ns int64) {
 	t.tv_sec = int32(ns / 1000000000)
 	t.tv_nsec = int32(ns % 1000000000)
 }
@@ -81,10 +81,12 @@ const (
 	_EV_ADD       = 0x1
 	_EV_DELETE    = 0x2
 	_EV_CLEAR     = 0x20
+	_EV_ONESHOT   = 0x10
 	_EV_ERROR     = 0x4000
 	_EV_EOF       = 0x8000
 	_EVFILT_READ  = -0x1
 	_EVFILT_WRITE = -0x2
+	_EVFILT_TIMER = -0x7
 )
 
 type rtprio struct {
@@ -178,6 +180,11 @@ func (ts *timespec) set_sec(x int64) {
 	ts.tv_sec = x
 }
 
+func (ts *timespec) setNsec(ns int64) {
+	ts.tv_sec = ns / 1e9
+	ts.tv_nsec = ns % 1e9
+}
+
 type timeval struct {
 	tv_sec  int64
 	tv_usec int64
@@ -77,8 +77,9 @@ const (
 	_ITIMER_VIRTUAL = 0x1
 	_ITIMER_PROF    = 0x2
 
-	_O_RDONLY  = 0x0
-	_O_CLOEXEC = 0x80000
+	_O_RDONLY   = 0x0
+	_O_CLOEXEC  = 0x80000
+	_O_NONBLOCK = 0x800
 
 	_EPOLLIN       = 0x1
 	_EPOLLOUT      = 0x4
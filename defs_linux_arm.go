@@ -71,6 +71,7 @@ const (
 	_ITIMER_VIRTUAL = 0x1
 	_O_RDONLY       = 0
 	_O_CLOEXEC      = 0x80000
+	_O_NONBLOCK     = 0x800
 
 	_EPOLLIN       = 0x1
 	_EPOLLOUT      = 0x4
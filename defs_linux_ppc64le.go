@@ -147,6 +147,7 @@ type epollevent struct {
 const (
 	_O_RDONLY    = 0x0
 	_O_CLOEXEC   = 0x80000
+	_O_NONBLOCK  = 0x800
 	_SA_RESTORER = 0
 )
 
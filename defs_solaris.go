@@ -39,6 +39,7 @@ const (
 	EFAULT      = C.EFAULT
 	EAGAIN      = C.EAGAIN
 	ETIMEDOUT   = C.ETIMEDOUT
+	ETIME       = C.ETIME
 	EWOULDBLOCK = C.EWOULDBLOCK
 	EINPROGRESS = C.EINPROGRESS
 
@@ -128,7 +129,8 @@ const (
 	POLLHUP = C.POLLHUP
 	POLLERR = C.POLLERR
 
-	PORT_SOURCE_FD = C.PORT_SOURCE_FD
+	PORT_SOURCE_USER = C.PORT_SOURCE_USER
+	PORT_SOURCE_FD   = C.PORT_SOURCE_FD
 )
 
 type SemT C.sem_t
@@ -14,3 +14,9 @@ var Mincore = mincore
 func Epollctl(epfd, op, fd int32, ev unsafe.Pointer) int32 {
 	return epollctl(epfd, op, fd, (*epollevent)(ev))
 }
+
+// CgroupCPULimit returns the cgroup CPU limit of the process as
+// found in the fake cgroupfs under root.
+func CgroupCPULimit(root string) (float64, bool) {
+	return cgroupCPUFilesLimit(cgroupCPUFind(root))
+}
@@ -378,8 +378,28 @@ func (rw *RWMutex) Unlock() {
 
 const RuntimeHmapSize = unsafe.Sizeof(hmap{})
 
+// MapBucketsCount returns the number of groups in m.
 func MapBucketsCount(m map[int]int) int {
 	h := *(**hmap)(unsafe.Pointer(&m))
+	if h.flags&hashDirectory == 0 {
+		return 1
+	}
+	n := 0
+	for i := uintptr(0); i < 1<<h.B; {
+		tab := h.tableAt(i)
+		n += int(tab.capacity) / bucketCnt
+		i += 1 << (h.B - tab.localDepth)
+	}
+	return n
+}
+
+// MapDirectorySize returns the number of entries in m's directory,
+// or 0 if m is a small map.
+func MapDirectorySize(m map[int]int) int {
+	h := *(**hmap)(unsafe.Pointer(&m))
+	if h.flags&hashDirectory == 0 {
+		return 0
+	}
 	return 1 << h.B
 }
 
@@ -472,3 +492,94 @@ func stackOverflow(x *byte) {
 	var buf [256]byte
 	stackOverflow(&buf[0])
 }
+
+func ReadvarintUnsafe(b []byte) (uint32, int) {
+	v, p := readvarintUnsafe(unsafe.Pointer(&b[0]))
+	return v, int(uintptr(p) - uintptr(unsafe.Pointer(&b[0])))
+}
+
+// OpenDeferChain adds open-coded defer entries with funcdata fd for
+// frames with the given sps to an empty defer chain, in order, and
+// returns the sps and resume pcs of the chain's entries. The entries
+// are not run.
+func OpenDeferChain(fd []byte, entry uintptr, sps []uintptr) (chainSPs, pcs []uintptr) {
+	chainSPs = make([]uintptr, 0, len(sps))
+	pcs = make([]uintptr, 0, len(sps))
+	gp := getg()
+	saved := gp._defer
+	gp._defer = nil
+	for _, sp := range sps {
+		addOpenDeferEntry(gp, unsafe.Pointer(&fd[0]), 0, 0, sp, entry)
+	}
+	for gp._defer != nil {
+		d := gp._defer
+		if !d.openDefer {
+			panic("not an open-coded defer entry")
+		}
+		chainSPs = append(chainSPs, d.sp)
+		pcs = append(pcs, d.pc)
+		gp._defer = d.link
+		freedefer(d)
+	}
+	gp._defer = saved
+	return
+}
+
+// RunOpenDeferFrame runs, with runOpenDeferFrame, the open-coded defers
+// of a synthetic frame that deferred fns[i](args[i]) for each i whose
+// bit is set in bits. If panicking, they run as for a panic, and
+// otherwise as for Goexit. It returns runOpenDeferFrame's result, the
+// frame's defer bits afterwards and whether a deferred call recovered.
+func RunOpenDeferFrame(fns []func(int), args []int, bits uint8, panicking bool) (done bool, bitsAfter uint8, recovered bool) {
+	frame := new(struct {
+		fns  [8]func(int)
+		args [8]int
+		bits uint8
+	})
+	copy(frame.fns[:], fns)
+	copy(frame.args[:], args)
+	frame.bits = bits
+	varp := uintptr(unsafe.Pointer(frame)) + unsafe.Sizeof(*frame)
+
+	// The funcdata after deferreturn and maxargsize; see
+	// runOpenDeferFrame.
+	var fd []byte
+	varint := func(v uintptr) {
+		for ; v >= 0x80; v >>= 7 {
+			fd = append(fd, byte(v|0x80))
+		}
+		fd = append(fd, byte(v))
+	}
+	varint(varp - uintptr(unsafe.Pointer(&frame.bits)))
+	varint(uintptr(len(fns)))
+	for i := len(fns) - 1; i >= 0; i-- {
+		varint(sys.PtrSize)
+		varint(varp - uintptr(unsafe.Pointer(&frame.fns[i])))
+		varint(1)
+		varint(varp - uintptr(unsafe.Pointer(&frame.args[i])))
+		varint(sys.PtrSize)
+		varint(0)
+	}
+
+	gp := getg()
+	d := newdefer(sys.PtrSize)
+	d.openDefer = true
+	d.varp = varp
+	d.fd = unsafe.Pointer(&fd[0])
+	var p *_panic
+	if panicking {
+		p = &_panic{arg: "RunOpenDeferFrame", link: gp._panic}
+		gp._panic = p
+		d._panic = p
+	}
+	done = runOpenDeferFrame(gp, d)
+	if p != nil {
+		gp._panic = p.link
+		recovered = p.recovered
+	}
+	gp._defer = d.link
+	d.fn = nil
+	d._panic = nil
+	freedefer(d)
+	return done, frame.bits, recovered
+}
@@ -22,12 +22,28 @@ is GOGC=100. Setting GOGC=off disables the garbage collector entirely.
 The runtime/debug package's SetGCPercent function allows changing this
 percentage at run time. See https://golang.org/pkg/runtime/debug/#SetGCPercent.
 
+The GOMEMLIMIT variable sets a soft memory limit for the runtime. This memory
+limit includes the Go heap and all other memory managed by the runtime, and
+excludes external memory sources such as mappings of the binary itself, memory
+managed in other languages, and memory held by the operating system on behalf
+of the Go program. GOMEMLIMIT is a numeric value in bytes with an optional unit
+suffix. The supported suffixes include B, KiB, MiB, GiB, and TiB. The default
+setting is math.MaxInt64, which effectively disables the memory limit.
+The runtime/debug package's SetMemoryLimit function allows changing this limit
+at run time. See https://golang.org/pkg/runtime/debug/#SetMemoryLimit.
+
 The GODEBUG variable controls debugging variables within the runtime.
 It is a comma-separated list of name=val pairs setting these named variables:
 
 	allocfreetrace: setting allocfreetrace=1 causes every allocation to be
 	profiled and a stack trace printed on each object's allocation and free.
 
+	asyncpreemptoff: asyncpreemptoff=1 disables signal-based
+	asynchronous goroutine preemption. This makes some loops
+	non-preemptible for long periods, which may delay GC and
+	goroutine scheduling. Asynchronous preemption is currently
+	only implemented on linux/amd64.
+
 	cgocheck: setting cgocheck=0 disables all checks for packages
 	using cgo to incorrectly pass Go pointers to non-Go code.
 	Setting cgocheck=1 (the default) enables relatively cheap
@@ -45,8 +61,19 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	pass finds a reachable object that was not found by concurrent
 	mark, the garbage collector will panic.
 
+	gcgen: setting gcgen=1 enables an experimental generational mode in which
+	most garbage collection cycles only mark and free the objects allocated
+	since the previous cycle, treating everything that survived it as live.
+	Full cycles still run when the heap has grown enough through such
+	promotions and whenever a collection is forced. Write barriers stay
+	enabled between cycles to track pointers from old objects to new ones,
+	which slows pointer writes. Setting gctrace=2 disables it.
+
 	gcpacertrace: setting gcpacertrace=1 causes the garbage collector to
 	print information about the internal state of the concurrent pacer.
+	The execution tracer records the same decisions as log events with
+	the categories runtime.GCPacerStart, runtime.GCPacerEnd and
+	runtime.GCPacerTrigger.
 
 	gcshrinkstackoff: setting gcshrinkstackoff=1 disables moving goroutines
 	onto smaller stacks. In this mode, a goroutine's stack can only grow.
@@ -84,12 +111,13 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	Setting gctrace to any value > 0 also causes the garbage collector
 	to emit a summary when memory is released back to the system.
 	This process of returning memory to the system is called scavenging.
-	The format of this summary is subject to change.
+	The background scavenger prints a summary each time it reaches
+	its goal and parks. The format of this summary is subject to change.
 	Currently it is:
-		scvg#: # MB released  printed only if non-zero
-		scvg#: inuse: # idle: # sys: # released: # consumed: # (MB)
-	where the fields are as follows:
-		scvg#        the scavenge cycle number, incremented at each scavenge
+		scvg: # KB released
+		scvg: inuse: # idle: # sys: # released: # consumed: # (MB)
+	debug.FreeOSMemory prints the same summary with "scvg-1:" and the
+	amount released in MB. The fields are as follows:
 		inuse: #     MB used or partially used spans
 		idle: #      MB spans pending scavenging
 		sys: #       MB mapped from the system
@@ -100,17 +128,35 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	When set to 0 memory profiling is disabled.  Refer to the description of
 	MemProfileRate for the default value.
 
+	memprofilelifetimes: setting memprofilelifetimes=1 causes the memory profile to
+	record how many garbage collections each sampled object survived before it was
+	freed. See MemProfileRecord.FreeLifetimes.
+
+	heaptypes: setting heaptypes=1 causes the allocator to record the type of every
+	heap object, so that the dumps written by runtime/debug.WriteHeapDump can
+	report it. Without it, only large objects are typed. This reserves as much
+	address space again as the heap uses and costs up to a word of memory per
+	object.
+
 	invalidptr: defaults to invalidptr=1, causing the garbage collector and stack
 	copier to crash the program if an invalid pointer value (for example, 1)
 	is found in a pointer-typed location. Setting invalidptr=0 disables this check.
 	This should only be used as a temporary workaround to diagnose buggy code.
 	The real fix is to not store integers in pointer-typed locations.
 
+	lockorder: setting lockorder=1 causes sync.Mutex and sync.RWMutex to record
+	which goroutines hold each mutex and the order in which mutexes are locked.
+	The runtime prints the stacks involved when two mutexes are locked in
+	inconsistent orders, which can deadlock, and when goroutines block on
+	mutexes held by each other. Setting lockorder=2 also makes these reports
+	fatal. This slows down every Lock, RLock and unlock considerably.
+
 	sbrk: setting sbrk=1 replaces the memory allocator and garbage collector
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
 
-	scavenge: scavenge=1 enables debugging mode of heap scavenger.
+	scavenge: scavenge=1 enables debugging mode of heap scavenger,
+	in which the background scavenger releases memory without pacing.
 
 	scheddetail: setting schedtrace=X and scheddetail=1 causes the scheduler to emit
 	detailed multiline info every X milliseconds, describing state of the scheduler,
@@ -124,6 +170,14 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	report. This also extends the information returned by runtime.Stack. Ancestor's goroutine
 	IDs will refer to the ID of the goroutine at the time of creation; it's possible for this
 	ID to be reused for another goroutine. Setting N to 0 will report no ancestry information.
+	Even then, the "created by" line of a traceback names the goroutine that executed the
+	go statement, at no cost.
+
+	tracejsonfd: setting tracejsonfd=N causes the output of gctrace and schedtrace
+	to be written to file descriptor N instead of standard error, as one JSON object
+	per line. Each object has an "event" field ("gc", "gc.forced", "scvg", "sched",
+	"sched.p", "sched.m" or "sched.g") and a "time_ns" field holding the nanoseconds
+	since program start. The other field names are stable; new fields may be added.
 
 The net and net/http packages also refer to debugging variables in GODEBUG.
 See the documentation for those packages for details.
@@ -134,6 +188,13 @@ that can be blocked in system calls on behalf of Go code; those do not count aga
 the GOMAXPROCS limit. This package's GOMAXPROCS function queries and changes
 the limit.
 
+If the GOMAXPROCS variable is not set, the limit defaults to the number of
+logical CPUs. On Linux, if the process's cgroups limit its CPU bandwidth
+(cpu.max in cgroup v2, or cpu.cfs_quota_us and cpu.cfs_period_us in cgroup v1),
+the default is instead that limit in CPUs, rounded up, if it is lower. The
+runtime checks the cgroup limit periodically and updates the default when it
+changes, until the GOMAXPROCS function changes the limit.
+
 The GOTRACEBACK variable controls the amount of output generated when a Go
 program fails due to an unrecovered panic or an unexpected runtime condition.
 By default, a failure prints a stack trace for the current goroutine,
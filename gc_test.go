@@ -5,11 +5,13 @@
 package runtime_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -28,6 +30,53 @@ func TestGcSys(t *testing.T) {
 	}
 }
 
+func TestGcGenerational(t *testing.T) {
+	if os.Getenv("GOGC") == "off" {
+		t.Skip("skipping test; GOGC=off in environment")
+	}
+	got := runTestProg(t, "testprog", "GCGenerational", "GODEBUG=gcgen=1,gccheckmark=1")
+	want := "OK\n"
+	if got != want {
+		t.Fatalf("expected %q, but got %q", want, got)
+	}
+}
+
+func TestGcTraceJSON(t *testing.T) {
+	if os.Getenv("GOGC") == "off" {
+		t.Skip("skipping test; GOGC=off in environment")
+	}
+	got := runTestProg(t, "testprog", "GCSys", "GODEBUG=gctrace=1,tracejsonfd=2")
+	var ok bool
+	var gcs int
+	for _, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+		if line == "OK" {
+			ok = true
+			continue
+		}
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("bad JSON line %q: %v", line, err)
+		}
+		if _, found := ev["time_ns"]; !found {
+			t.Errorf("line %q has no time_ns field", line)
+		}
+		if ev["event"] == "gc" {
+			gcs++
+			for _, field := range []string{"gc", "heap_start_bytes", "heap_goal_bytes", "procs", "forced"} {
+				if _, found := ev[field]; !found {
+					t.Errorf("gc event %q has no %s field", line, field)
+				}
+			}
+		}
+	}
+	if !ok {
+		t.Errorf("expected OK in output:\n%s", got)
+	}
+	if gcs == 0 {
+		t.Errorf("no gc events in output:\n%s", got)
+	}
+}
+
 func TestGcDeepNesting(t *testing.T) {
 	type T [2][2][2][2][2][2][2][2][2][2]*int
 	a := new(T)
@@ -190,6 +239,35 @@ func TestPeriodicGC(t *testing.T) {
 	}
 }
 
+func TestBackgroundScavenger(t *testing.T) {
+	if runtime.GOARCH == "wasm" {
+		t.Skip("no sysmon on wasm yet")
+	}
+	if os.Getenv("GOGC") == "off" {
+		t.Skip("skipping test; GOGC=off in environment")
+	}
+
+	// Grow the heap well past its steady-state size, then drop
+	// the memory so it's free but still retained.
+	runtime.GC()
+	hugeSink = make([]byte, 256<<20)
+	hugeSink = nil
+	runtime.GC()
+	runtime.GC()
+
+	// The scavenger should release the excess without any help
+	// from FreeOSMemory. Give it some slack on loaded systems.
+	var ms runtime.MemStats
+	for i := 0; i < 200; i++ {
+		runtime.ReadMemStats(&ms)
+		if ms.HeapSys-ms.HeapReleased < 2*ms.NextGC+16<<20 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("scavenger did not release memory: HeapSys=%d MB HeapReleased=%d MB NextGC=%d MB", ms.HeapSys>>20, ms.HeapReleased>>20, ms.NextGC>>20)
+}
+
 func BenchmarkSetTypePtr(b *testing.B) {
 	benchSetType(b, new(*byte))
 }
@@ -6,8 +6,11 @@
 // objects in the heap plus additional info (roots, threads,
 // finalizers, etc.) to a file.
 
-// The format of the dumped file is described at
-// https://golang.org/s/go15heapdump.
+// The format of the dumped file is described in the documentation
+// of package runtime/heapdump, which also reads it. Version 2 of the
+// format extends the version 1 format described at
+// https://golang.org/s/go15heapdump with the type of each object,
+// type layouts, and source positions of stack frames.
 
 package runtime
 
@@ -92,22 +95,52 @@ func flush() {
 	nbuf = 0
 }
 
-// Cache of types that have been serialized already.
-// We use a type's hash field to pick a bucket.
-// Inside a bucket, we keep a list of types that
-// have been serialized so far, most recently used first.
-// Note: when a bucket overflows we may end up
-// serializing a type more than once. That's ok.
-const (
-	typeCacheBuckets = 256
-	typeCacheAssoc   = 4
-)
-
-type typeCacheBucket struct {
-	t [typeCacheAssoc]*_type
+// Set of types that have been serialized already. Types refer to
+// each other, so unlike a cache, the set must be exact to make sure
+// dumping a type terminates. It is an open-addressed hash table
+// allocated with sysAlloc, like tmpbuf.
+var dumpedTypes struct {
+	tab []uintptr
+	n   int
 }
 
-var typecache [typeCacheBuckets]typeCacheBucket
+// typeDumped reports whether t is in dumpedTypes and adds it if not.
+func typeDumped(t *_type) bool {
+	if 2*(dumpedTypes.n+1) > len(dumpedTypes.tab) {
+		old := dumpedTypes.tab
+		n := 2 * len(old)
+		if n == 0 {
+			n = 1024
+		}
+		p := sysAlloc(uintptr(n)*sys.PtrSize, &memstats.other_sys)
+		if p == nil {
+			throw("heapdump: out of memory")
+		}
+		dumpedTypes.tab = (*[1 << 28]uintptr)(p)[:n:n]
+		dumpedTypes.n = 0
+		for _, x := range old {
+			if x != 0 {
+				typeDumped((*_type)(unsafe.Pointer(x)))
+			}
+		}
+		if old != nil {
+			sysFree(unsafe.Pointer(&old[0]), uintptr(len(old))*sys.PtrSize, &memstats.other_sys)
+		}
+	}
+	tab := dumpedTypes.tab
+	x := uintptr(unsafe.Pointer(t))
+	mask := uintptr(len(tab) - 1)
+	for i := uintptr(t.hash) & mask; ; i = (i + 1) & mask {
+		switch tab[i] {
+		case x:
+			return true
+		case 0:
+			tab[i] = x
+			dumpedTypes.n++
+			return false
+		}
+	}
+}
 
 // dump a uint64 in a varint format parseable by encoding/binary
 func dumpint(v uint64) {
@@ -149,36 +182,55 @@ func dumpstr(s string) {
 	dumpmemrange(sp.str, uintptr(sp.len))
 }
 
-// dump information for a type
-func dumptype(t *_type) {
-	if t == nil {
-		return
-	}
+// Types that dumptype still has to dump. Types can nest arbitrarily
+// deeply and the dump runs on the system stack, so rather than
+// recursing, dumptype keeps its own stack of pending types, allocated
+// with sysAlloc like dumpedTypes.
+var pendingTypes struct {
+	stk []uintptr
+	n   int
+}
 
-	// If we've definitely serialized the type before,
-	// no need to do it again.
-	b := &typecache[t.hash&(typeCacheBuckets-1)]
-	if t == b.t[0] {
+// pushType adds t, if not nil, to pendingTypes.
+func pushType(t *_type) {
+	if t == nil {
 		return
 	}
-	for i := 1; i < typeCacheAssoc; i++ {
-		if t == b.t[i] {
-			// Move-to-front
-			for j := i; j > 0; j-- {
-				b.t[j] = b.t[j-1]
-			}
-			b.t[0] = t
-			return
+	if pendingTypes.n == len(pendingTypes.stk) {
+		old := pendingTypes.stk
+		n := 2 * len(old)
+		if n == 0 {
+			n = 256
+		}
+		p := sysAlloc(uintptr(n)*sys.PtrSize, &memstats.other_sys)
+		if p == nil {
+			throw("heapdump: out of memory")
+		}
+		pendingTypes.stk = (*[1 << 28]uintptr)(p)[:n:n]
+		copy(pendingTypes.stk, old)
+		if old != nil {
+			sysFree(unsafe.Pointer(&old[0]), uintptr(len(old))*sys.PtrSize, &memstats.other_sys)
 		}
 	}
+	pendingTypes.stk[pendingTypes.n] = uintptr(unsafe.Pointer(t))
+	pendingTypes.n++
+}
 
-	// Might not have been dumped yet. Dump it and
-	// remember we did so.
-	for j := typeCacheAssoc - 1; j > 0; j-- {
-		b.t[j] = b.t[j-1]
+// dump information for a type, and the types it refers to
+func dumptype(t *_type) {
+	pushType(t)
+	for pendingTypes.n > 0 {
+		pendingTypes.n--
+		t := (*_type)(unsafe.Pointer(pendingTypes.stk[pendingTypes.n]))
+		if !typeDumped(t) {
+			dumponetype(t)
+		}
 	}
-	b.t[0] = t
+}
 
+// dumponetype dumps t and pushes the types it refers to onto
+// pendingTypes.
+func dumponetype(t *_type) {
 	// dump the type
 	dumpint(tagType)
 	dumpint(uint64(uintptr(unsafe.Pointer(t))))
@@ -196,12 +248,56 @@ func dumptype(t *_type) {
 		dwrite(name.str, uintptr(name.len))
 	}
 	dumpbool(t.kind&kindDirectIface == 0 || t.kind&kindNoPointers == 0)
+	dumpint(uint64(t.kind & kindMask))
+	dumpint(uint64(t.ptrdata))
+
+	// dump the layout, then queue the types it refers to. They
+	// are pushed in reverse so that they are dumped in the order
+	// they appear in the layout.
+	var elem, key *_type
+	switch t.kind & kindMask {
+	case kindPtr:
+		elem = (*ptrtype)(unsafe.Pointer(t)).elem
+		dumpint(uint64(uintptr(unsafe.Pointer(elem))))
+	case kindSlice:
+		elem = (*slicetype)(unsafe.Pointer(t)).elem
+		dumpint(uint64(uintptr(unsafe.Pointer(elem))))
+	case kindChan:
+		elem = (*chantype)(unsafe.Pointer(t)).elem
+		dumpint(uint64(uintptr(unsafe.Pointer(elem))))
+	case kindArray:
+		at := (*arraytype)(unsafe.Pointer(t))
+		elem = at.elem
+		dumpint(uint64(uintptr(unsafe.Pointer(elem))))
+		dumpint(uint64(at.len))
+	case kindMap:
+		mt := (*maptype)(unsafe.Pointer(t))
+		key, elem = mt.key, mt.elem
+		dumpint(uint64(uintptr(unsafe.Pointer(key))))
+		dumpint(uint64(uintptr(unsafe.Pointer(elem))))
+	case kindStruct:
+		st := (*structtype)(unsafe.Pointer(t))
+		dumpint(uint64(len(st.fields)))
+		for i := range st.fields {
+			f := &st.fields[i]
+			dumpstr(f.name.name())
+			dumpint(uint64(f.offset()))
+			dumpint(uint64(uintptr(unsafe.Pointer(f.typ))))
+		}
+		for i := len(st.fields) - 1; i >= 0; i-- {
+			pushType(st.fields[i].typ)
+		}
+	}
+	pushType(elem)
+	pushType(key)
 }
 
 // dump an object
-func dumpobj(obj unsafe.Pointer, size uintptr, bv bitvector) {
+func dumpobj(obj unsafe.Pointer, size uintptr, typ *_type, bv bitvector) {
+	dumptype(typ)
 	dumpint(tagObject)
 	dumpint(uint64(uintptr(obj)))
+	dumpint(uint64(uintptr(unsafe.Pointer(typ))))
 	dumpmemrange(obj, size)
 	dumpfields(bv)
 }
@@ -281,6 +377,9 @@ func dumpframe(s *stkframe, arg unsafe.Pointer) bool {
 		name = "unknown function"
 	}
 	dumpstr(name)
+	file, line := funcline(f, pc)
+	dumpstr(file)
+	dumpint(uint64(line))
 
 	// Dump fields in the outargs section
 	if child.args.n >= 0 {
@@ -456,6 +555,10 @@ func dumpobjs() {
 		if s.state != _MSpanInUse {
 			continue
 		}
+		if s.userArena == userArenaFaulted {
+			// A freed arena chunk. Its memory is inaccessible.
+			continue
+		}
 		p := s.base()
 		size := s.elemsize
 		n := (s.npages << _PageShift) / size
@@ -474,7 +577,7 @@ func dumpobjs() {
 				freemark[j] = false
 				continue
 			}
-			dumpobj(unsafe.Pointer(p), size, makeheapobjbv(p, size))
+			dumpobj(unsafe.Pointer(p), size, heapObjectType(p, s), makeheapobjbv(p, size))
 		}
 	}
 }
@@ -632,7 +735,7 @@ func dumpmemprof() {
 	}
 }
 
-var dumphdr = []byte("go1.7 heap dump\n")
+var dumphdr = []byte("go heap dump v2\n")
 
 func mdump() {
 	// make sure we're done sweeping
@@ -641,7 +744,6 @@ func mdump() {
 			s.ensureSwept()
 		}
 	}
-	memclrNoHeapPointers(unsafe.Pointer(&typecache), unsafe.Sizeof(typecache))
 	dwrite(unsafe.Pointer(&dumphdr[0]), uintptr(len(dumphdr)))
 	dumpparams()
 	dumpitabs()
@@ -677,6 +779,16 @@ func writeheapdump_m(fd uintptr) {
 		sysFree(unsafe.Pointer(&tmpbuf[0]), uintptr(len(tmpbuf)), &memstats.other_sys)
 		tmpbuf = nil
 	}
+	if tab := dumpedTypes.tab; tab != nil {
+		sysFree(unsafe.Pointer(&tab[0]), uintptr(len(tab))*sys.PtrSize, &memstats.other_sys)
+		dumpedTypes.tab = nil
+		dumpedTypes.n = 0
+	}
+	if stk := pendingTypes.stk; stk != nil {
+		sysFree(unsafe.Pointer(&stk[0]), uintptr(len(stk))*sys.PtrSize, &memstats.other_sys)
+		pendingTypes.stk = nil
+		pendingTypes.n = 0
+	}
 
 	casgstatus(_g_.m.curg, _Gwaiting, _Grunning)
 }
@@ -718,3 +830,73 @@ func makeheapobjbv(p uintptr, size uintptr) bitvector {
 	}
 	return bitvector{int32(i), &tmpbuf[0]}
 }
+
+// Heap object types.
+//
+// The heap bitmap only records which words of an object are
+// pointers. So that a dump can give each object its Go type, the
+// allocator records the type of each large object in its span. With
+// GODEBUG=heaptypes=1 it also records the type of each small object
+// in its arena's types table, indexed by the object's first word.
+// The table takes as much address space as the arena, but only the
+// pages holding entries for allocated objects are touched. Tiny
+// allocations, which pack several pointer-free objects into one
+// block, are not typed.
+//
+// For an array allocation, the recorded type is the element type.
+
+// heapTypes maps each word of a heap arena to the *_type of the
+// object starting there.
+//
+//go:notinheap
+type heapTypes [heapArenaBytes / sys.PtrSize]uintptr
+
+// allocTypes allocates ha's types table.
+func (ha *heapArena) allocTypes() {
+	p := sysAlloc(unsafe.Sizeof(heapTypes{}), &memstats.other_sys)
+	if p == nil {
+		throw("out of memory allocating heap type table")
+	}
+	ha.types = (*heapTypes)(p)
+}
+
+// heapTypesInit allocates types tables for the arenas created before
+// GODEBUG was parsed. Objects allocated so far remain untyped.
+func heapTypesInit() {
+	for _, l2 := range mheap_.arenas {
+		if l2 == nil {
+			continue
+		}
+		for _, ha := range l2 {
+			if ha != nil && ha.types == nil {
+				ha.allocTypes()
+			}
+		}
+	}
+}
+
+// setHeapType records that the small object at p has type t, or no
+// known type if t is nil.
+//
+//go:nosplit
+func setHeapType(p uintptr, t *_type) {
+	ai := arenaIndex(p)
+	ha := mheap_.arenas[ai.l1()][ai.l2()]
+	if ha.types != nil {
+		ha.types[(p%heapArenaBytes)/sys.PtrSize] = uintptr(unsafe.Pointer(t))
+	}
+}
+
+// heapObjectType returns the recorded type of the object at p in
+// span s, or nil if it is not known.
+func heapObjectType(p uintptr, s *mspan) *_type {
+	if s.spanclass.sizeclass() == 0 {
+		return (*_type)(unsafe.Pointer(s.largeType))
+	}
+	ai := arenaIndex(p)
+	ha := mheap_.arenas[ai.l1()][ai.l2()]
+	if ha.types == nil || s.spanclass == tinySpanClass {
+		return nil
+	}
+	return (*_type)(unsafe.Pointer(ha.types[(p%heapArenaBytes)/sys.PtrSize]))
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package heapdump reads heap dumps written by runtime/debug.WriteHeapDump and
+answers questions about what keeps memory alive.
+
+Read parses a dump into a Dump, which holds every heap object along with its
+type when the runtime recorded one, the goroutines and their stack frames, and
+the roots of the heap: global variables, stack slots and finalizers. Pointers
+between objects form a graph. The dominator tree of that graph says, for each
+object, which single object every path from the roots to it must go through,
+and so which objects would become garbage along with it. Dump.RetainedSize
+reports that amount of memory.
+
+Types
+
+The runtime records the type of every large object. Small objects are only
+typed if the program was run with GODEBUG=heaptypes=1. Tiny allocations,
+which pack several small pointer-free objects into one block, are never typed.
+For an array allocation the recorded type is the element type.
+
+Format
+
+A dump starts with a header line, "go heap dump v2\n". Version 1 dumps, which
+start with "go1.7 heap dump\n", are also understood, but have no types for
+objects or stack frame positions.
+
+The header is followed by a sequence of records. Each record starts with a
+tag. Integers are unsigned varints as in encoding/binary, booleans are
+integers that are 0 or 1, and strings and byte ranges are a length followed by
+that many bytes. A field list is a sequence of (kind, offset) pairs ending with
+kind 0; kind 1 marks a pointer at that offset in the enclosing record's
+contents. The records are:
+
+	0  EOF: end of the dump.
+	1  Object: address, type address (0 if unknown), contents, field list.
+	   (Version 1 has no type address.)
+	2  Other root: description string, pointer.
+	3  Type: address, size, name, whether interface values hold a pointer
+	   to the value rather than the value, and in version 2: kind (as in
+	   reflect.Kind), pointer data size, and then by kind:
+	     pointer, slice, chan: element type address;
+	     array: element type address, length;
+	     map: key type address, element type address;
+	     struct: number of fields, then for each its name, offset and
+	     type address.
+	   A type is written before the first object of that type, but may
+	   refer to types that are written after it.
+	4  Goroutine: g address, stack pointer, goroutine ID, PC of the go
+	   statement that created it, status, whether it is a system
+	   goroutine, unused boolean, time it started waiting, wait reason,
+	   context pointer, m address, top defer record address, top panic
+	   record address. Its stack frames follow, innermost first.
+	5  Stack frame: stack pointer, depth, callee's stack pointer (0 if
+	   none), contents, function entry PC, PC, continuation PC, function
+	   name, in version 2 file name and line number, field list.
+	6  Parameters: whether pointers are big-endian, pointer size, heap
+	   start address, heap end address, GOARCH, GOEXPERIMENT, number of
+	   CPUs.
+	7  Finalizer: object address, funcval address, function PC, argument
+	   type address, object type address.
+	8  Itab: itab address, type address.
+	9  OS thread: m address, thread ID, OS thread ID.
+	10 MemStats: the runtime.MemStats fields Alloc, TotalAlloc, Sys,
+	   Lookups, Mallocs, Frees, HeapAlloc, HeapSys, HeapIdle, HeapInuse,
+	   HeapReleased, HeapObjects, StackInuse, StackSys, MSpanInuse,
+	   MSpanSys, MCacheInuse, MCacheSys, BuckHashSys, GCSys, OtherSys,
+	   NextGC, LastGC and PauseTotalNs, then the 256 PauseNs values and
+	   NumGC.
+	11 Queued finalizer: as Finalizer, for finalizers ready to run.
+	12 Data segment: address, contents, field list.
+	13 BSS segment: address, contents, field list.
+	14 Defer record: address, g address, stack pointer, PC, funcval
+	   address, function PC, next defer record address.
+	15 Panic record: address, g address, argument type address, argument
+	   data, unused integer, next panic record address.
+	16 Heap profile bucket: bucket ID, allocation size, number of frames,
+	   then for each frame its function name, file name and line number,
+	   then the number of allocations and frees.
+	17 Allocation sample: object address, heap profile bucket ID.
+*/
+package heapdump
@@ -0,0 +1,254 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import "sort"
+
+// The dominator tree is computed over a graph with one node per
+// object plus a pseudo-root, node 0, with an edge to every object a
+// root points into. Object i is node i+1.
+
+// A domTree is the dominator tree of a dump's object graph.
+type domTree struct {
+	// idom is the immediate dominator of each node, or -1 for the
+	// pseudo-root and for unreachable objects.
+	idom []int32
+
+	// retained is the total size of the objects each node
+	// dominates, including itself.
+	retained []uint64
+
+	// children lists the nodes each node immediately dominates:
+	// the children of node n are children[start[n]:start[n+1]].
+	start    []int32
+	children []int32
+}
+
+// Reachable reports whether o can be reached from the roots. Objects
+// that cannot are garbage that has not been freed yet.
+func (d *Dump) Reachable(o *Object) bool {
+	return d.dominators().idom[o.index+1] >= 0
+}
+
+// Idom returns the immediate dominator of o: the closest object that
+// every path from the roots to o goes through. It returns nil if
+// there is no such object, because o is reachable directly from more
+// than one root or by independent paths, or if o is not reachable.
+func (d *Dump) Idom(o *Object) *Object {
+	n := d.dominators().idom[o.index+1]
+	if n <= 0 {
+		return nil
+	}
+	return d.Objects[n-1]
+}
+
+// RetainedSize returns the number of bytes that would be freed if o
+// were freed: the sizes of o and of every object it dominates. It
+// returns 0 if o is not reachable.
+func (d *Dump) RetainedSize(o *Object) uint64 {
+	return d.dominators().retained[o.index+1]
+}
+
+// Dominated returns the objects that o immediately dominates, largest
+// retained size first. If o is nil, it returns the objects with no
+// dominator, which together retain the whole reachable heap.
+func (d *Dump) Dominated(o *Object) []*Object {
+	dom := d.dominators()
+	n := int32(0)
+	if o != nil {
+		n = int32(o.index + 1)
+	}
+	var objs []*Object
+	for _, c := range dom.children[dom.start[n]:dom.start[n+1]] {
+		objs = append(objs, d.Objects[c-1])
+	}
+	sort.SliceStable(objs, func(i, j int) bool {
+		return dom.retained[objs[i].index+1] > dom.retained[objs[j].index+1]
+	})
+	return objs
+}
+
+// dominators returns d's dominator tree, computing it the first time.
+func (d *Dump) dominators() *domTree {
+	if d.dom == nil {
+		d.dom = d.computeDominators()
+	}
+	return d.dom
+}
+
+// succs returns the object graph in compressed form: the successors
+// of node n are adj[start[n]:start[n+1]].
+func (d *Dump) succs() (start, adj []int32) {
+	start = make([]int32, len(d.Objects)+2)
+	for _, r := range d.Roots {
+		if r.Object != nil {
+			adj = append(adj, int32(r.Object.index+1))
+		}
+	}
+	start[1] = int32(len(adj))
+	for i, o := range d.Objects {
+		for _, t := range d.Pointers(o) {
+			adj = append(adj, int32(t.index+1))
+		}
+		start[i+2] = int32(len(adj))
+	}
+	return start, adj
+}
+
+// computeDominators computes the dominator tree with the
+// Lengauer-Tarjan algorithm, using path compression but not balancing.
+func (d *Dump) computeDominators() *domTree {
+	n := len(d.Objects) + 1
+	start, adj := d.succs()
+
+	// Predecessors, in the same form as successors.
+	pstart := make([]int32, n+1)
+	for _, w := range adj {
+		pstart[w+1]++
+	}
+	for i := 1; i <= n; i++ {
+		pstart[i] += pstart[i-1]
+	}
+	pred := make([]int32, len(adj))
+	fill := append([]int32(nil), pstart[:n]...)
+	for v := 0; v < n; v++ {
+		for _, w := range adj[start[v]:start[v+1]] {
+			pred[fill[w]] = int32(v)
+			fill[w]++
+		}
+	}
+
+	// semi holds each node's DFS number until it is replaced by its
+	// semidominator's. 0 means not visited. vertex maps DFS numbers
+	// back to nodes.
+	semi := make([]int32, n)
+	vertex := make([]int32, n+1)
+	parent := make([]int32, n)
+	ancestor := make([]int32, n)
+	label := make([]int32, n)
+	idom := make([]int32, n)
+	bucket := make([]int32, n) // first node in each node's bucket
+	next := make([]int32, n)   // next node in the same bucket
+	for i := range idom {
+		ancestor[i] = -1
+		idom[i] = -1
+		bucket[i] = -1
+	}
+
+	// Number the nodes in DFS order from the pseudo-root.
+	num := int32(0)
+	visit := func(v, p int32) {
+		num++
+		semi[v] = num
+		vertex[num] = v
+		label[v] = v
+		parent[v] = p
+	}
+	type item struct{ v, i int32 }
+	visit(0, -1)
+	stack := []item{{0, start[0]}}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.i == start[top.v+1] {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		w := adj[top.i]
+		top.i++
+		if semi[w] == 0 {
+			visit(w, top.v)
+			stack = append(stack, item{w, start[w]})
+		}
+	}
+
+	var path []int32
+	eval := func(v int32) int32 {
+		if ancestor[v] < 0 {
+			return v
+		}
+		// Compress the path from v to the root of its tree in
+		// the forest, starting from the end nearest the root.
+		path = path[:0]
+		for u := v; ancestor[ancestor[u]] >= 0; u = ancestor[u] {
+			path = append(path, u)
+		}
+		for i := len(path) - 1; i >= 0; i-- {
+			u := path[i]
+			a := ancestor[u]
+			if semi[label[a]] < semi[label[u]] {
+				label[u] = label[a]
+			}
+			ancestor[u] = ancestor[a]
+		}
+		return label[v]
+	}
+
+	for i := num; i >= 2; i-- {
+		w := vertex[i]
+		for _, v := range pred[pstart[w]:pstart[w+1]] {
+			if semi[v] == 0 {
+				continue // unreachable
+			}
+			if u := eval(v); semi[u] < semi[w] {
+				semi[w] = semi[u]
+			}
+		}
+		s := vertex[semi[w]]
+		next[w] = bucket[s]
+		bucket[s] = w
+		p := parent[w]
+		ancestor[w] = p
+		for v := bucket[p]; v >= 0; v = next[v] {
+			if u := eval(v); semi[u] < semi[v] {
+				idom[v] = u
+			} else {
+				idom[v] = p
+			}
+		}
+		bucket[p] = -1
+	}
+	for i := int32(2); i <= num; i++ {
+		w := vertex[i]
+		if idom[w] != vertex[semi[w]] {
+			idom[w] = idom[idom[w]]
+		}
+	}
+
+	// A node's dominators come before it in DFS order, so visiting
+	// nodes in reverse DFS order accumulates retained sizes bottom
+	// up.
+	retained := make([]uint64, n)
+	for i := num; i >= 2; i-- {
+		w := vertex[i]
+		retained[w] += d.Objects[w-1].Size()
+		retained[idom[w]] += retained[w]
+	}
+
+	// Build the children lists.
+	cstart := make([]int32, n+1)
+	for w := 1; w < n; w++ {
+		if idom[w] >= 0 {
+			cstart[idom[w]+1]++
+		}
+	}
+	for i := 1; i <= n; i++ {
+		cstart[i] += cstart[i-1]
+	}
+	children := make([]int32, cstart[n])
+	fill = append(fill[:0], cstart[:n]...)
+	for w := 1; w < n; w++ {
+		if p := idom[w]; p >= 0 {
+			children[fill[p]] = int32(w)
+			fill[p]++
+		}
+	}
+
+	return &domTree{
+		idom:     idom,
+		retained: retained,
+		start:    cstart,
+		children: children,
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// graphDump returns a dump of n 64-byte objects in which object i
+// points to the objects in edges[i], and roots point to the objects
+// in roots.
+func graphDump(n int, edges [][]int, roots []int) *Dump {
+	d := &Dump{Params: Params{PtrSize: 8}}
+	addr := func(i int) uint64 { return uint64(0x10000 + 64*i) }
+	for i := 0; i < n; i++ {
+		o := &Object{Addr: addr(i), Data: make([]byte, 64), index: i}
+		for j, e := range edges[i] {
+			off := uint64(8 * j)
+			// Point into the middle of the target sometimes.
+			binary.LittleEndian.PutUint64(o.Data[off:], addr(e)+uint64(8*(j%2)))
+			o.ptrs = append(o.ptrs, off)
+		}
+		d.Objects = append(d.Objects, o)
+	}
+	for _, r := range roots {
+		d.Roots = append(d.Roots, &Root{Kind: RootOther, Target: addr(r), Object: d.Objects[r]})
+	}
+	return d
+}
+
+// naiveIdom computes immediate dominators by removing each node in
+// turn and seeing what becomes unreachable. It returns -1 for
+// unreachable nodes and for nodes dominated only by the pseudo-root,
+// and also which nodes are reachable.
+func naiveIdom(n int, edges [][]int, roots []int) (idom []int, reachable []bool) {
+	reach := func(removed int) []bool {
+		seen := make([]bool, n)
+		var stack []int
+		for _, r := range roots {
+			if r != removed && !seen[r] {
+				seen[r] = true
+				stack = append(stack, r)
+			}
+		}
+		for len(stack) > 0 {
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, w := range edges[v] {
+				if w != removed && !seen[w] {
+					seen[w] = true
+					stack = append(stack, w)
+				}
+			}
+		}
+		return seen
+	}
+	all := reach(-1)
+	// dom[v] lists the nodes that dominate v.
+	dom := make([][]int, n)
+	for u := 0; u < n; u++ {
+		if !all[u] {
+			continue
+		}
+		r := reach(u)
+		for v := 0; v < n; v++ {
+			if v != u && all[v] && !r[v] {
+				dom[v] = append(dom[v], u)
+			}
+		}
+	}
+	idom = make([]int, n)
+	for v := range idom {
+		idom[v] = -1
+		// The immediate dominator is the one dominated by all
+		// the others.
+		for _, u := range dom[v] {
+			if len(dom[u]) == len(dom[v])-1 {
+				idom[v] = u
+			}
+		}
+	}
+	return idom, all
+}
+
+func TestDominators(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for iter := 0; iter < 200; iter++ {
+		n := 1 + r.Intn(40)
+		edges := make([][]int, n)
+		for i := range edges {
+			for j := r.Intn(5); j > 0; j-- {
+				edges[i] = append(edges[i], r.Intn(n))
+			}
+		}
+		var roots []int
+		for j := 1 + r.Intn(3); j > 0; j-- {
+			roots = append(roots, r.Intn(n))
+		}
+
+		d := graphDump(n, edges, roots)
+		want, reachable := naiveIdom(n, edges, roots)
+		for v := 0; v < n; v++ {
+			o := d.Objects[v]
+			got := -1
+			if i := d.Idom(o); i != nil {
+				got = i.index
+			}
+			if got != want[v] {
+				t.Fatalf("graph %v, roots %v: idom of %d is %d, want %d", edges, roots, v, got, want[v])
+			}
+			if d.Reachable(o) != reachable[v] {
+				t.Fatalf("graph %v, roots %v: Reachable(%d) = %v, want %v", edges, roots, v, d.Reachable(o), reachable[v])
+			}
+		}
+
+		// Retained sizes add up to the reachable heap.
+		total := uint64(0)
+		for _, o := range d.Dominated(nil) {
+			total += d.RetainedSize(o)
+		}
+		live := uint64(0)
+		for v, ok := range reachable {
+			if ok {
+				live += d.Objects[v].Size()
+			}
+		}
+		if total != live {
+			t.Fatalf("graph %v, roots %v: retained %d bytes, want %d", edges, roots, total, live)
+		}
+	}
+}
+
+func TestRetainedSize(t *testing.T) {
+	// 0 -> 1 -> 2 -> 3
+	//      1 -> 4 -> 3
+	// 5 -> 4
+	// 6 is garbage.
+	edges := [][]int{{1}, {2, 4}, {3}, {}, {3}, {4}, {0}}
+	d := graphDump(7, edges, []int{0, 5})
+	want := []uint64{3 * 64, 2 * 64, 64, 64, 64, 64, 0}
+	for i, w := range want {
+		if got := d.RetainedSize(d.Objects[i]); got != w {
+			t.Errorf("RetainedSize(%d) = %d, want %d", i, got, w)
+		}
+	}
+	if got := d.Idom(d.Objects[2]); got != d.Objects[1] {
+		t.Errorf("Idom(2) = %v, want object 1", got)
+	}
+	if got := d.Dominated(d.Objects[1]); len(got) != 1 || got[0] != d.Objects[2] {
+		t.Errorf("Dominated(1) = %v, want [object 2]", got)
+	}
+}
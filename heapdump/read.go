@@ -0,0 +1,686 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+const (
+	headerV1 = "go1.7 heap dump\n"
+	headerV2 = "go heap dump v2\n"
+)
+
+// Record tags.
+const (
+	tagEOF             = 0
+	tagObject          = 1
+	tagOtherRoot       = 2
+	tagType            = 3
+	tagGoroutine       = 4
+	tagStackFrame      = 5
+	tagParams          = 6
+	tagFinalizer       = 7
+	tagItab            = 8
+	tagOSThread        = 9
+	tagMemStats        = 10
+	tagQueuedFinalizer = 11
+	tagData            = 12
+	tagBSS             = 13
+	tagDefer           = 14
+	tagPanic           = 15
+	tagMemProf         = 16
+	tagAllocSample     = 17
+)
+
+// Field kinds.
+const (
+	fieldKindEol   = 0
+	fieldKindPtr   = 1
+	fieldKindIface = 2
+	fieldKindEface = 3
+)
+
+// A Dump is a parsed heap dump.
+type Dump struct {
+	// Version is the version of the dump format, 1 or 2.
+	Version int
+
+	Params   Params
+	MemStats runtime.MemStats
+
+	// Objects holds every allocated heap object, sorted by
+	// address.
+	Objects []*Object
+
+	// Types holds the types of objects and the types they refer
+	// to, in the order they appear in the dump.
+	Types []*Type
+
+	Goroutines []*Goroutine
+
+	// Roots holds every pointer from outside the heap that keeps
+	// objects alive.
+	Roots []*Root
+
+	// MemProf holds the heap profile buckets.
+	MemProf []*MemProfRecord
+
+	types map[uint64]*Type
+
+	// Dominator tree, computed on demand by dominators.
+	dom *domTree
+}
+
+// Params describes the process that wrote the dump.
+type Params struct {
+	BigEndian    bool
+	PtrSize      int
+	HeapStart    uint64
+	HeapEnd      uint64
+	GOARCH       string
+	GOEXPERIMENT string
+	NCPU         int
+}
+
+// A Type describes a Go type.
+type Type struct {
+	Addr    uint64 // address of the runtime type descriptor
+	Name    string
+	Size    uint64
+	PtrData uint64 // size of the prefix of the type that can contain pointers
+
+	// IfaceIndir is set if interface values of this type hold a
+	// pointer to the value rather than the value itself.
+	IfaceIndir bool
+
+	// The remaining fields are only set in version 2 dumps.
+	Kind   reflect.Kind
+	Elem   *Type   // element type of a pointer, slice, array, chan or map
+	Key    *Type   // key type of a map
+	Len    uint64  // length of an array
+	Fields []Field // fields of a struct
+}
+
+// A Field is a field of a struct type.
+type Field struct {
+	Name   string
+	Offset uint64
+	Type   *Type
+}
+
+// An Object is a heap object.
+type Object struct {
+	Addr uint64
+	Data []byte
+
+	// Type is the type the object was allocated with, or nil if
+	// it is not known. For an array allocation it is the element
+	// type.
+	Type *Type
+
+	// Alloc is the heap profile bucket of the object's allocation,
+	// if the allocation was sampled.
+	Alloc *MemProfRecord
+
+	ptrs  []uint64 // offsets of pointers in Data
+	index int      // position in Dump.Objects
+}
+
+// Size returns the size of o in bytes, which includes the rounding
+// up done by the allocator.
+func (o *Object) Size() uint64 {
+	return uint64(len(o.Data))
+}
+
+// TypeName returns a description of o's type, or "?" if the type is
+// not known. Arrays are described by their element type and an
+// approximate length.
+func (o *Object) TypeName() string {
+	t := o.Type
+	switch {
+	case t == nil:
+		return "?"
+	case t.Size > 0 && o.Size() >= 2*t.Size:
+		return fmt.Sprintf("[%d]%s", o.Size()/t.Size, t.Name)
+	}
+	return t.Name
+}
+
+// A Goroutine is a goroutine that was not running when the dump was
+// written.
+type Goroutine struct {
+	Addr       uint64 // address of the runtime's g
+	ID         uint64
+	GoPC       uint64 // PC of the go statement that created it
+	Status     uint64
+	System     bool
+	WaitSince  int64
+	WaitReason string
+
+	// Frames holds the goroutine's stack, innermost frame first.
+	Frames []*Frame
+}
+
+// A Frame is a stack frame.
+type Frame struct {
+	SP    uint64
+	Entry uint64 // entry PC of the function
+	PC    uint64
+	Func  string
+	File  string // only set in version 2 dumps
+	Line  int    // only set in version 2 dumps
+	Data  []byte
+
+	ptrs []uint64
+}
+
+// RootKind says what kind of location a Root is.
+type RootKind int
+
+const (
+	RootData            RootKind = iota // an initialized global variable
+	RootBSS                             // a zero-initialized global variable
+	RootStack                           // a stack slot
+	RootFinalizer                       // a registered finalizer
+	RootQueuedFinalizer                 // a finalizer ready to run
+	RootDefer                           // a deferred call
+	RootPanic                           // an in-progress panic
+	RootOther                           // anything else the runtime knows about
+)
+
+var rootKindNames = [...]string{
+	RootData:            "data",
+	RootBSS:             "bss",
+	RootStack:           "stack",
+	RootFinalizer:       "finalizer",
+	RootQueuedFinalizer: "queued finalizer",
+	RootDefer:           "defer",
+	RootPanic:           "panic",
+	RootOther:           "other",
+}
+
+func (k RootKind) String() string {
+	if 0 <= k && int(k) < len(rootKindNames) {
+		return rootKindNames[k]
+	}
+	return fmt.Sprintf("RootKind(%d)", int(k))
+}
+
+// A Root is a pointer from outside the heap.
+type Root struct {
+	Kind RootKind
+
+	// Description is a human-readable description of where the
+	// pointer is.
+	Description string
+
+	// Addr is the address of the pointer itself, if it is in
+	// memory.
+	Addr uint64
+
+	// Target is the pointer and Object is the heap object it
+	// points into, if any.
+	Target uint64
+	Object *Object
+
+	// Goroutine and Frame are the goroutine and frame of a stack
+	// root.
+	Goroutine *Goroutine
+	Frame     *Frame
+}
+
+// A MemProfRecord is a heap profile bucket.
+type MemProfRecord struct {
+	Size   uint64 // size of each allocation
+	Allocs uint64
+	Frees  uint64
+	Stack  []MemProfFrame // innermost frame first
+}
+
+// A MemProfFrame is a frame in a heap profile stack.
+type MemProfFrame struct {
+	Func string
+	File string
+	Line int
+}
+
+// Read reads a heap dump written by runtime/debug.WriteHeapDump.
+func Read(r io.Reader) (*Dump, error) {
+	p := &parser{
+		r: bufio.NewReader(r),
+		d: &Dump{types: make(map[uint64]*Type)},
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.d, nil
+}
+
+type parser struct {
+	r   *bufio.Reader
+	d   *Dump
+	err error
+
+	g       *Goroutine // goroutine the next frames belong to
+	buckets map[uint64]*MemProfRecord
+	samples map[uint64]uint64 // object address to bucket ID
+	refs    []*typeRef        // type addresses to resolve
+}
+
+// A typeRef is a reference to a type that may not have been read yet.
+type typeRef struct {
+	addr uint64
+	dst  **Type
+}
+
+var errMalformed = errors.New("heapdump: malformed dump")
+
+func (p *parser) uvarint() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		p.fail(err)
+	}
+	return v
+}
+
+func (p *parser) bool() bool {
+	return p.uvarint() != 0
+}
+
+func (p *parser) bytes() []byte {
+	n := p.uvarint()
+	if p.err != nil {
+		return nil
+	}
+	if n > 1<<40 {
+		p.fail(errMalformed)
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		p.fail(err)
+	}
+	return b
+}
+
+func (p *parser) string() string {
+	return string(p.bytes())
+}
+
+func (p *parser) fail(err error) {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// fields reads a field list and returns the offsets of the pointers
+// in it. Interfaces count as two pointers.
+func (p *parser) fields() []uint64 {
+	var ptrs []uint64
+	for p.err == nil {
+		kind := p.uvarint()
+		if kind == fieldKindEol {
+			break
+		}
+		off := p.uvarint()
+		switch kind {
+		case fieldKindPtr:
+			ptrs = append(ptrs, off)
+		case fieldKindIface, fieldKindEface:
+			ptrs = append(ptrs, off, off+uint64(p.d.Params.PtrSize))
+		default:
+			p.fail(fmt.Errorf("heapdump: unknown field kind %d", kind))
+		}
+	}
+	return ptrs
+}
+
+// typeRef arranges for *dst to be set to the type at addr once the
+// whole dump has been read.
+func (p *parser) typeRef(addr uint64, dst **Type) {
+	if addr != 0 {
+		p.refs = append(p.refs, &typeRef{addr, dst})
+	}
+}
+
+func (p *parser) parse() error {
+	d := p.d
+	hdr := make([]byte, len(headerV2))
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		return fmt.Errorf("heapdump: reading header: %v", err)
+	}
+	switch string(hdr) {
+	case headerV1:
+		d.Version = 1
+	case headerV2:
+		d.Version = 2
+	default:
+		return fmt.Errorf("heapdump: unknown header %q", hdr)
+	}
+	p.buckets = make(map[uint64]*MemProfRecord)
+	p.samples = make(map[uint64]uint64)
+
+	for p.err == nil {
+		tag := p.uvarint()
+		if p.err != nil {
+			break
+		}
+		if tag == tagEOF {
+			break
+		}
+		p.record(tag)
+	}
+	if p.err != nil {
+		return p.err
+	}
+
+	for _, ref := range p.refs {
+		*ref.dst = d.types[ref.addr]
+	}
+	sort.Slice(d.Objects, func(i, j int) bool {
+		return d.Objects[i].Addr < d.Objects[j].Addr
+	})
+	for i, o := range d.Objects {
+		o.index = i
+	}
+	for addr, id := range p.samples {
+		if o := d.FindObject(addr); o != nil && o.Addr == addr {
+			o.Alloc = p.buckets[id]
+		}
+	}
+	for _, r := range d.Roots {
+		r.Object = d.FindObject(r.Target)
+	}
+	return nil
+}
+
+func (p *parser) record(tag uint64) {
+	d := p.d
+	switch tag {
+	case tagObject:
+		o := &Object{Addr: p.uvarint()}
+		if d.Version >= 2 {
+			p.typeRef(p.uvarint(), &o.Type)
+		}
+		o.Data = p.bytes()
+		o.ptrs = p.fields()
+		d.Objects = append(d.Objects, o)
+
+	case tagOtherRoot:
+		desc := p.string()
+		p.addRoot(RootOther, desc, 0, p.uvarint())
+
+	case tagType:
+		t := &Type{
+			Addr:       p.uvarint(),
+			Size:       p.uvarint(),
+			Name:       p.string(),
+			IfaceIndir: p.bool(),
+		}
+		if d.Version >= 2 {
+			t.Kind = reflect.Kind(p.uvarint())
+			t.PtrData = p.uvarint()
+			switch t.Kind {
+			case reflect.Ptr, reflect.Slice, reflect.Chan:
+				p.typeRef(p.uvarint(), &t.Elem)
+			case reflect.Array:
+				p.typeRef(p.uvarint(), &t.Elem)
+				t.Len = p.uvarint()
+			case reflect.Map:
+				p.typeRef(p.uvarint(), &t.Key)
+				p.typeRef(p.uvarint(), &t.Elem)
+			case reflect.Struct:
+				n := p.uvarint()
+				if n > 1<<20 {
+					p.fail(errMalformed)
+					return
+				}
+				t.Fields = make([]Field, n)
+				for i := range t.Fields {
+					f := &t.Fields[i]
+					f.Name = p.string()
+					f.Offset = p.uvarint()
+					p.typeRef(p.uvarint(), &f.Type)
+				}
+			}
+		}
+		if d.types[t.Addr] == nil {
+			d.types[t.Addr] = t
+			d.Types = append(d.Types, t)
+		}
+
+	case tagGoroutine:
+		g := &Goroutine{Addr: p.uvarint()}
+		p.uvarint() // sp
+		g.ID = p.uvarint()
+		g.GoPC = p.uvarint()
+		g.Status = p.uvarint()
+		g.System = p.bool()
+		p.bool() // isbackground
+		g.WaitSince = int64(p.uvarint())
+		g.WaitReason = p.string()
+		p.uvarint() // ctxt
+		p.uvarint() // m
+		p.uvarint() // defer
+		p.uvarint() // panic
+		d.Goroutines = append(d.Goroutines, g)
+		p.g = g
+
+	case tagStackFrame:
+		f := &Frame{SP: p.uvarint()}
+		p.uvarint() // depth
+		p.uvarint() // child sp
+		f.Data = p.bytes()
+		f.Entry = p.uvarint()
+		f.PC = p.uvarint()
+		p.uvarint() // continuation pc
+		f.Func = p.string()
+		if d.Version >= 2 {
+			f.File = p.string()
+			f.Line = int(p.uvarint())
+		}
+		f.ptrs = p.fields()
+		g := p.g
+		if g == nil {
+			p.fail(errors.New("heapdump: stack frame outside goroutine"))
+			return
+		}
+		g.Frames = append(g.Frames, f)
+		for _, off := range f.ptrs {
+			if v, ok := p.word(f.Data, off); ok {
+				r := p.addRoot(RootStack, fmt.Sprintf("%s frame+%#x", f.Func, off), f.SP+off, v)
+				r.Goroutine = g
+				r.Frame = f
+			}
+		}
+
+	case tagParams:
+		d.Params.BigEndian = p.bool()
+		d.Params.PtrSize = int(p.uvarint())
+		d.Params.HeapStart = p.uvarint()
+		d.Params.HeapEnd = p.uvarint()
+		d.Params.GOARCH = p.string()
+		d.Params.GOEXPERIMENT = p.string()
+		d.Params.NCPU = int(p.uvarint())
+		if d.Params.PtrSize != 4 && d.Params.PtrSize != 8 {
+			p.fail(fmt.Errorf("heapdump: bad pointer size %d", d.Params.PtrSize))
+		}
+
+	case tagFinalizer, tagQueuedFinalizer:
+		obj := p.uvarint()
+		fn := p.uvarint()
+		p.uvarint() // fn.fn
+		p.uvarint() // fint
+		p.uvarint() // ot
+		kind := RootFinalizer
+		if tag == tagQueuedFinalizer {
+			// The object will be passed to the finalizer.
+			kind = RootQueuedFinalizer
+			p.addRoot(kind, "finalizer argument", 0, obj)
+		}
+		p.addRoot(kind, "finalizer function", 0, fn)
+
+	case tagItab:
+		p.uvarint() // itab
+		p.uvarint() // type
+
+	case tagOSThread:
+		p.uvarint() // m
+		p.uvarint() // id
+		p.uvarint() // procid
+
+	case tagMemStats:
+		p.memStats()
+
+	case tagData, tagBSS:
+		addr := p.uvarint()
+		data := p.bytes()
+		kind, name := RootData, "data"
+		if tag == tagBSS {
+			kind, name = RootBSS, "bss"
+		}
+		for _, off := range p.fields() {
+			if v, ok := p.word(data, off); ok {
+				p.addRoot(kind, fmt.Sprintf("%s+%#x", name, off), addr+off, v)
+			}
+		}
+
+	case tagDefer:
+		p.uvarint() // defer record
+		p.uvarint() // g
+		p.uvarint() // sp
+		p.uvarint() // pc
+		fn := p.uvarint()
+		p.uvarint() // fn.fn
+		p.uvarint() // link
+		p.addRoot(RootDefer, "deferred function", 0, fn)
+
+	case tagPanic:
+		p.uvarint() // panic record
+		p.uvarint() // g
+		p.uvarint() // arg type
+		data := p.uvarint()
+		p.uvarint() // unused
+		p.uvarint() // link
+		p.addRoot(RootPanic, "panic argument", 0, data)
+
+	case tagMemProf:
+		id := p.uvarint()
+		b := &MemProfRecord{Size: p.uvarint()}
+		n := p.uvarint()
+		for i := uint64(0); i < n && p.err == nil; i++ {
+			b.Stack = append(b.Stack, MemProfFrame{
+				Func: p.string(),
+				File: p.string(),
+				Line: int(p.uvarint()),
+			})
+		}
+		b.Allocs = p.uvarint()
+		b.Frees = p.uvarint()
+		p.buckets[id] = b
+		d.MemProf = append(d.MemProf, b)
+
+	case tagAllocSample:
+		addr := p.uvarint()
+		p.samples[addr] = p.uvarint()
+
+	default:
+		p.fail(fmt.Errorf("heapdump: unknown record tag %d", tag))
+	}
+}
+
+func (p *parser) addRoot(kind RootKind, desc string, addr, target uint64) *Root {
+	r := &Root{Kind: kind, Description: desc, Addr: addr, Target: target}
+	p.d.Roots = append(p.d.Roots, r)
+	return r
+}
+
+// word returns the pointer-sized word at off in data.
+func (p *parser) word(data []byte, off uint64) (uint64, bool) {
+	v, ok := p.d.word(data, off)
+	if !ok {
+		p.fail(errMalformed)
+	}
+	return v, ok
+}
+
+func (p *parser) memStats() {
+	m := &p.d.MemStats
+	for _, f := range []*uint64{
+		&m.Alloc, &m.TotalAlloc, &m.Sys, &m.Lookups, &m.Mallocs, &m.Frees,
+		&m.HeapAlloc, &m.HeapSys, &m.HeapIdle, &m.HeapInuse, &m.HeapReleased, &m.HeapObjects,
+		&m.StackInuse, &m.StackSys, &m.MSpanInuse, &m.MSpanSys, &m.MCacheInuse, &m.MCacheSys,
+		&m.BuckHashSys, &m.GCSys, &m.OtherSys, &m.NextGC, &m.LastGC, &m.PauseTotalNs,
+	} {
+		*f = p.uvarint()
+	}
+	for i := range m.PauseNs {
+		m.PauseNs[i] = p.uvarint()
+	}
+	m.NumGC = uint32(p.uvarint())
+}
+
+// word returns the pointer-sized word at off in data.
+func (d *Dump) word(data []byte, off uint64) (uint64, bool) {
+	n := uint64(d.Params.PtrSize)
+	if off+n < off || off+n > uint64(len(data)) {
+		return 0, false
+	}
+	b := data[off : off+n]
+	var order binary.ByteOrder = binary.LittleEndian
+	if d.Params.BigEndian {
+		order = binary.BigEndian
+	}
+	if n == 4 {
+		return uint64(order.Uint32(b)), true
+	}
+	return order.Uint64(b), true
+}
+
+// FindObject returns the object that contains addr, or nil if there
+// is none.
+func (d *Dump) FindObject(addr uint64) *Object {
+	i := sort.Search(len(d.Objects), func(i int) bool {
+		return d.Objects[i].Addr > addr
+	})
+	if i == 0 {
+		return nil
+	}
+	o := d.Objects[i-1]
+	if addr-o.Addr >= o.Size() {
+		return nil
+	}
+	return o
+}
+
+// Pointers returns the objects that o points into, in the order of
+// the pointers in o. An object appears once for each pointer to it.
+func (d *Dump) Pointers(o *Object) []*Object {
+	var objs []*Object
+	for _, off := range o.ptrs {
+		v, ok := d.word(o.Data, off)
+		if !ok {
+			continue
+		}
+		if t := d.FindObject(v); t != nil {
+			objs = append(objs, t)
+		}
+	}
+	return objs
+}
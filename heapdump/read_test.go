@@ -0,0 +1,149 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heapdump_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"runtime/heapdump"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// bigNode is large enough to be a large object, which the runtime
+// always records the type of.
+type bigNode struct {
+	next *bigNode
+	pad  [40 << 10]byte
+}
+
+var dumpRoot *bigNode
+
+func writeDump(t *testing.T) *heapdump.Dump {
+	if runtime.GOOS == "nacl" || runtime.GOOS == "js" {
+		t.Skipf("WriteHeapDump is not available on %s.", runtime.GOOS)
+	}
+	f, err := ioutil.TempFile("", "heapdumptest")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	debug.WriteHeapDump(f.Fd())
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	d, err := heapdump.Read(f)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	return d
+}
+
+func TestRead(t *testing.T) {
+	dumpRoot = &bigNode{next: &bigNode{next: &bigNode{}}}
+	defer func() { dumpRoot = nil }()
+
+	d := writeDump(t)
+	if d.Version != 2 {
+		t.Errorf("Version = %d, want 2", d.Version)
+	}
+	if d.Params.PtrSize != int(unsafe.Sizeof(uintptr(0))) {
+		t.Errorf("PtrSize = %d, want %d", d.Params.PtrSize, unsafe.Sizeof(uintptr(0)))
+	}
+	if len(d.Objects) == 0 || d.MemStats.HeapObjects == 0 {
+		t.Fatalf("dump has %d objects, MemStats.HeapObjects = %d", len(d.Objects), d.MemStats.HeapObjects)
+	}
+
+	// Follow the list from the global.
+	var root *heapdump.Root
+	for _, r := range d.Roots {
+		if r.Target == uint64(uintptr(unsafe.Pointer(dumpRoot))) {
+			root = r
+		}
+	}
+	if root == nil || root.Object == nil {
+		t.Fatalf("no root points to dumpRoot")
+	}
+	if root.Kind != heapdump.RootData && root.Kind != heapdump.RootBSS {
+		t.Errorf("root for dumpRoot has kind %v", root.Kind)
+	}
+	objs := []*heapdump.Object{root.Object}
+	for i := 0; i < 2; i++ {
+		next := d.Pointers(objs[i])
+		if len(next) != 1 {
+			t.Fatalf("node %d points to %d objects, want 1", i, len(next))
+		}
+		objs = append(objs, next[0])
+	}
+	size := uint64(unsafe.Sizeof(bigNode{}))
+	for i, o := range objs {
+		if !strings.HasSuffix(o.TypeName(), ".bigNode") {
+			t.Errorf("node %d has type %s, want bigNode", i, o.TypeName())
+		}
+		if i > 0 && d.Idom(o) != objs[i-1] {
+			t.Errorf("node %d is not dominated by node %d", i, i-1)
+		}
+		if want := uint64(3-i) * size; d.RetainedSize(o) < want {
+			t.Errorf("node %d retains %d bytes, want at least %d", i, d.RetainedSize(o), want)
+		}
+	}
+
+	typ := objs[0].Type
+	if typ == nil || typ.Kind != reflect.Struct || len(typ.Fields) != 2 {
+		t.Fatalf("bad bigNode type %+v", typ)
+	}
+	if f := typ.Fields[0]; f.Name != "next" || f.Type == nil || f.Type.Kind != reflect.Ptr || f.Type.Elem != typ {
+		t.Errorf("bad bigNode.next field %+v", f)
+	}
+	if f := typ.Fields[1]; f.Name != "pad" || f.Type == nil || f.Type.Kind != reflect.Array || f.Type.Len != 40<<10 {
+		t.Errorf("bad bigNode.pad field %+v", f)
+	}
+}
+
+func TestReadDeepType(t *testing.T) {
+	// Nest a type deeply enough that dumping it recursively would
+	// overflow a system stack. The array is a large object, so its
+	// type is recorded.
+	const depth = 2000
+	typ := reflect.TypeOf([40 << 10]byte{})
+	for i := 0; i < depth; i++ {
+		typ = reflect.ArrayOf(1, typ)
+	}
+	p := reflect.New(typ).Interface()
+
+	d := writeDump(t)
+	o := d.FindObject(uint64(reflect.ValueOf(p).Pointer()))
+	if o == nil || o.Type == nil {
+		t.Fatalf("no typed object for the nested array")
+	}
+	n := 0
+	for typ := o.Type; typ != nil && typ.Kind == reflect.Array; typ = typ.Elem {
+		n++
+	}
+	if n != depth+1 {
+		t.Errorf("found %d nested array types, want %d", n, depth+1)
+	}
+	runtime.KeepAlive(p)
+}
+
+func TestReadStack(t *testing.T) {
+	d := writeDump(t)
+	for _, g := range d.Goroutines {
+		for _, f := range g.Frames {
+			if strings.HasSuffix(f.Func, ".writeDump") {
+				if !strings.HasSuffix(f.File, "read_test.go") || f.Line == 0 {
+					t.Errorf("writeDump frame at %s:%d", f.File, f.Line)
+				}
+				return
+			}
+		}
+	}
+	t.Errorf("no goroutine is in writeDump")
+}
@@ -0,0 +1,428 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Machine-readable output of GODEBUG=gctrace and schedtrace.
+//
+// With GODEBUG=tracejsonfd=N, the summaries gctrace and schedtrace
+// print are written to file descriptor N as JSON objects instead, one
+// per line. Every object has an "event" field naming the kind of
+// summary and a "time_ns" field with the nanoseconds since the
+// program started; the other fields depend on the event and are
+// documented with the functions that write them. Field names are
+// stable: fields may be added, but are not renamed or removed.
+//
+// Objects are built in a fixed buffer, and written out in parts if
+// they don't fit, so they can be written from anywhere gctrace and
+// schedtrace print, without allocating.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// A jsonWriter builds JSON objects in a fixed buffer and writes them
+// to fd, without allocating.
+type jsonWriter struct {
+	fd    uintptr
+	buf   [4096]byte
+	n     int
+	first bool // no field written yet in the current object or array
+}
+
+var jsonTrace struct {
+	lock mutex
+	w    jsonWriter
+}
+
+// jsonTraceBegin starts an object for event on the tracejsonfd
+// writer, and returns the writer. It locks jsonTrace until the
+// matching jsonTraceEnd.
+func jsonTraceBegin(event string) *jsonWriter {
+	lock(&jsonTrace.lock)
+	w := &jsonTrace.w
+	w.fd = uintptr(debug.tracejsonfd)
+	w.begin(event)
+	return w
+}
+
+// jsonTraceEnd ends the current object and writes it out.
+func jsonTraceEnd() {
+	jsonTrace.w.end()
+	unlock(&jsonTrace.lock)
+}
+
+// begin starts an object for event.
+func (w *jsonWriter) begin(event string) {
+	w.n = 0
+	w.bytes("{")
+	w.first = true
+	w.string("event", event)
+	w.int("time_ns", nanotime()-runtimeInitTime)
+}
+
+// end ends the current object and writes it out.
+func (w *jsonWriter) end() {
+	w.bytes("}\n")
+	w.flush()
+}
+
+// flush writes out the buffer.
+func (w *jsonWriter) flush() {
+	if w.n > 0 {
+		write(w.fd, unsafe.Pointer(&w.buf[0]), int32(w.n))
+		w.n = 0
+	}
+}
+
+// bytes appends s to the buffer verbatim.
+func (w *jsonWriter) bytes(s string) {
+	for len(s) > 0 {
+		if w.n == len(w.buf) {
+			w.flush()
+		}
+		n := copy(w.buf[w.n:], s)
+		w.n += n
+		s = s[n:]
+	}
+}
+
+// key appends the key of the next field, or the separator before the
+// next array element if k is "".
+func (w *jsonWriter) key(k string) {
+	if !w.first {
+		w.bytes(",")
+	}
+	w.first = false
+	if k != "" {
+		w.quote(k)
+		w.bytes(":")
+	}
+}
+
+// quote appends s as a JSON string. Bytes of s that are not valid
+// UTF-8 are replaced by U+FFFD, so that the output stays valid JSON.
+func (w *jsonWriter) quote(s string) {
+	const hexdigits = "0123456789abcdef"
+	w.bytes(`"`)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= runeSelf {
+			r, next := decoderune(s, i)
+			if r == runeError && next == i+1 {
+				w.bytes(s[start:i])
+				w.bytes(`\ufffd`)
+				start = next
+			}
+			i = next - 1
+			continue
+		}
+		if c >= 0x20 && c != '"' && c != '\\' {
+			continue
+		}
+		w.bytes(s[start:i])
+		switch c {
+		case '"':
+			w.bytes(`\"`)
+		case '\\':
+			w.bytes(`\\`)
+		case '\n':
+			w.bytes(`\n`)
+		default:
+			esc := [6]byte{'\\', 'u', '0', '0', hexdigits[c>>4], hexdigits[c&0xf]}
+			w.bytes(slicebytetostringtmp(esc[:]))
+		}
+		start = i + 1
+	}
+	w.bytes(s[start:])
+	w.bytes(`"`)
+}
+
+// int appends a field with an integer value. An empty key appends an
+// array element.
+func (w *jsonWriter) int(key string, v int64) {
+	w.key(key)
+	if v < 0 {
+		w.bytes("-")
+		w.uintValue(uint64(-v))
+		return
+	}
+	w.uintValue(uint64(v))
+}
+
+// uint appends a field with an unsigned integer value.
+func (w *jsonWriter) uint(key string, v uint64) {
+	w.key(key)
+	w.uintValue(v)
+}
+
+func (w *jsonWriter) uintValue(v uint64) {
+	var buf [20]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = byte('0' + v%10)
+		v /= 10
+		if v == 0 {
+			break
+		}
+	}
+	w.bytes(slicebytetostringtmp(buf[i:]))
+}
+
+// hex appends a field with an unsigned integer value as a string of
+// hexadecimal digits prefixed with 0x, since JSON numbers can't hold
+// addresses precisely.
+func (w *jsonWriter) hex(key string, v uint64) {
+	const hexdigits = "0123456789abcdef"
+	var buf [18]byte
+	i := len(buf)
+	for {
+		i--
+		buf[i] = hexdigits[v%16]
+		v /= 16
+		if v == 0 {
+			break
+		}
+	}
+	i--
+	buf[i] = 'x'
+	i--
+	buf[i] = '0'
+	w.key(key)
+	w.quote(slicebytetostringtmp(buf[i:]))
+}
+
+// bool appends a field with a boolean value.
+func (w *jsonWriter) bool(key string, v bool) {
+	w.key(key)
+	if v {
+		w.bytes("true")
+	} else {
+		w.bytes("false")
+	}
+}
+
+// string appends a field with a string value.
+func (w *jsonWriter) string(key, v string) {
+	w.key(key)
+	w.quote(v)
+}
+
+// arrayBegin starts a field with an array value. Elements are
+// appended with an empty key.
+func (w *jsonWriter) arrayBegin(key string) {
+	w.key(key)
+	w.bytes("[")
+	w.first = true
+}
+
+// arrayEnd ends the current array.
+func (w *jsonWriter) arrayEnd() {
+	w.bytes("]")
+	w.first = false
+}
+
+// objectBegin starts a field with an object value, or an array
+// element if key is "".
+func (w *jsonWriter) objectBegin(key string) {
+	w.key(key)
+	w.bytes("{")
+	w.first = true
+}
+
+// objectEnd ends the current object value.
+func (w *jsonWriter) objectEnd() {
+	w.bytes("}")
+	w.first = false
+}
+
+// jsonTraceGC writes the gctrace summary of the GC cycle that just
+// ended, as event "gc". Times are in nanoseconds and sizes in bytes:
+//
+//	gc                  the GC number, incremented at each GC
+//	start_ns            time of the GC start since program start
+//	cpu_percent         percentage of CPU time spent in GC since program start
+//	sweep_term_ns       wall-clock time of STW sweep termination
+//	mark_ns             wall-clock time of concurrent mark and scan
+//	mark_term_ns        wall-clock time of STW mark termination
+//	sweep_term_cpu_ns   CPU time of sweep termination
+//	assist_cpu_ns       CPU time of mark assists
+//	background_cpu_ns   CPU time of dedicated and fractional mark workers
+//	idle_cpu_ns         CPU time of idle mark workers
+//	mark_term_cpu_ns    CPU time of mark termination
+//	heap_start_bytes    heap size at GC start
+//	heap_end_bytes      heap size at GC end
+//	heap_live_bytes     live heap marked
+//	heap_goal_bytes     goal heap size
+//	procs               number of processors used
+//	forced              whether the GC was forced by runtime.GC
+//	minor               whether the GC was a minor generational cycle
+func jsonTraceGC(sweepTermCpu, markTermCpu int64) {
+	w := jsonTraceBegin("gc")
+	w.uint("gc", uint64(memstats.numgc))
+	w.int("start_ns", work.tSweepTerm-runtimeInitTime)
+	w.int("cpu_percent", int64(memstats.gc_cpu_fraction*100))
+	w.int("sweep_term_ns", work.tMark-work.tSweepTerm)
+	w.int("mark_ns", work.tMarkTerm-work.tMark)
+	w.int("mark_term_ns", work.tEnd-work.tMarkTerm)
+	w.int("sweep_term_cpu_ns", sweepTermCpu)
+	w.int("assist_cpu_ns", gcController.assistTime)
+	w.int("background_cpu_ns", gcController.dedicatedMarkTime+gcController.fractionalMarkTime)
+	w.int("idle_cpu_ns", gcController.idleMarkTime)
+	w.int("mark_term_cpu_ns", markTermCpu)
+	w.uint("heap_start_bytes", work.heap0)
+	w.uint("heap_end_bytes", work.heap1)
+	w.uint("heap_live_bytes", work.heap2)
+	w.uint("heap_goal_bytes", work.heapGoal)
+	w.int("procs", int64(work.maxprocs))
+	w.bool("forced", work.userForced)
+	w.bool("minor", gcgen.minor)
+	jsonTraceEnd()
+}
+
+// jsonTraceScavenge writes the gctrace summary of a scavenger pass,
+// as event "scvg". Sizes are in bytes:
+//
+//	released_bytes        memory released by this pass
+//	heap_inuse_bytes      heap in use
+//	heap_idle_bytes       heap idle
+//	heap_sys_bytes        heap obtained from the system
+//	heap_released_bytes   heap released to the system
+//	heap_consumed_bytes   heap obtained from the system and not released
+//
+// background is set for the background scavenger and clear for the
+// periodic or forced scavenges, which are numbered by pass.
+func jsonTraceScavenge(background bool, pass int32, released uintptr) {
+	w := jsonTraceBegin("scvg")
+	w.bool("background", background)
+	if !background {
+		w.int("pass", int64(pass))
+	}
+	w.uint("released_bytes", uint64(released))
+	w.uint("heap_inuse_bytes", memstats.heap_inuse)
+	w.uint("heap_idle_bytes", memstats.heap_idle)
+	w.uint("heap_sys_bytes", memstats.heap_sys)
+	w.uint("heap_released_bytes", memstats.heap_released)
+	w.uint("heap_consumed_bytes", memstats.heap_sys-memstats.heap_released)
+	jsonTraceEnd()
+}
+
+// jsonTraceSched writes the schedtrace summary, as event "sched":
+//
+//	gomaxprocs        current GOMAXPROCS
+//	idle_procs        idle Ps
+//	threads           Ms
+//	spinning_threads  Ms spinning looking for work
+//	idle_threads      idle Ms
+//	runqueue          length of the global run queue
+//	p_runqueues       length of each P's run queue
+//
+// If detailed is set, the object also has the fields gcwaiting,
+// idle_locked_threads, stopwait and sysmonwait, and is followed by
+// one object per P, M and G: events "sched.p", "sched.m" and
+// "sched.g". The caller must hold sched.lock.
+func jsonTraceSched(detailed bool) {
+	w := jsonTraceBegin("sched")
+	w.int("gomaxprocs", int64(gomaxprocs))
+	w.int("idle_procs", int64(sched.npidle))
+	w.int("threads", int64(mcount()))
+	w.int("spinning_threads", int64(sched.nmspinning))
+	w.int("idle_threads", int64(sched.nmidle))
+	w.int("runqueue", int64(sched.runqsize))
+	w.arrayBegin("p_runqueues")
+	for _, _p_ := range allp {
+		h := atomic.Load(&_p_.runqhead)
+		t := atomic.Load(&_p_.runqtail)
+		w.int("", int64(t-h))
+	}
+	w.arrayEnd()
+	if detailed {
+		w.int("gcwaiting", int64(sched.gcwaiting))
+		w.int("idle_locked_threads", int64(sched.nmidlelocked))
+		w.int("stopwait", int64(sched.stopwait))
+		w.int("sysmonwait", int64(sched.sysmonwait))
+	}
+	jsonTraceEnd()
+	if !detailed {
+		return
+	}
+
+	// As in schedtrace, the P's, M's and G's may change
+	// concurrently.
+	for i, _p_ := range allp {
+		mp := _p_.m.ptr()
+		id := int64(-1)
+		if mp != nil {
+			id = mp.id
+		}
+		h := atomic.Load(&_p_.runqhead)
+		t := atomic.Load(&_p_.runqtail)
+		w = jsonTraceBegin("sched.p")
+		w.int("p", int64(i))
+		w.int("status", int64(_p_.status))
+		w.int("schedtick", int64(_p_.schedtick))
+		w.int("syscalltick", int64(_p_.syscalltick))
+		w.int("m", id)
+		w.int("runqueue", int64(t-h))
+		w.int("gfreecnt", int64(_p_.gfreecnt))
+		jsonTraceEnd()
+	}
+
+	for mp := allm; mp != nil; mp = mp.alllink {
+		_p_ := mp.p.ptr()
+		gp := mp.curg
+		lockedg := mp.lockedg.ptr()
+		id1 := int64(-1)
+		if _p_ != nil {
+			id1 = int64(_p_.id)
+		}
+		id2 := int64(-1)
+		if gp != nil {
+			id2 = gp.goid
+		}
+		id3 := int64(-1)
+		if lockedg != nil {
+			id3 = lockedg.goid
+		}
+		w = jsonTraceBegin("sched.m")
+		w.int("m", mp.id)
+		w.int("p", id1)
+		w.int("curg", id2)
+		w.int("mallocing", int64(mp.mallocing))
+		w.int("throwing", int64(mp.throwing))
+		w.string("preemptoff", mp.preemptoff)
+		w.int("locks", int64(mp.locks))
+		w.int("dying", int64(mp.dying))
+		w.bool("spinning", mp.spinning)
+		w.bool("blocked", mp.blocked)
+		w.int("lockedg", id3)
+		jsonTraceEnd()
+	}
+
+	lock(&allglock)
+	for gi := 0; gi < len(allgs); gi++ {
+		gp := allgs[gi]
+		mp := gp.m
+		lockedm := gp.lockedm.ptr()
+		id1 := int64(-1)
+		if mp != nil {
+			id1 = mp.id
+		}
+		id2 := int64(-1)
+		if lockedm != nil {
+			id2 = lockedm.id
+		}
+		w = jsonTraceBegin("sched.g")
+		w.int("g", gp.goid)
+		w.int("status", int64(readgstatus(gp)))
+		w.string("wait_reason", gp.waitreason.String())
+		w.int("m", id1)
+		w.int("lockedm", id2)
+		jsonTraceEnd()
+	}
+	unlock(&allglock)
+}
@@ -230,7 +230,7 @@ func notetsleepg(n *note, ns int64) bool {
 	return ok
 }
 
-func pauseSchedulerUntilCallback() bool {
+func pauseSchedulerUntilCallback(delay int64) bool {
 	return false
 }
 
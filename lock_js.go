@@ -148,12 +148,24 @@ func sleepUntilCallback() {
 // pauseSchedulerUntilCallback gets called from the scheduler and pauses the execution
 // of Go's WebAssembly code util a callback is triggered. Then it checks for note timeouts
 // and resumes goroutines that are waiting for a callback.
-func pauseSchedulerUntilCallback() bool {
-	if waitingForCallback == nil && len(notesWithTimeout) == 0 {
+// If delay is not negative, the next timer is due in delay ns, and a callback
+// is scheduled to resume the execution by then.
+func pauseSchedulerUntilCallback(delay int64) bool {
+	if waitingForCallback == nil && len(notesWithTimeout) == 0 && delay < 0 {
 		return false
 	}
 
-	pause()
+	if delay >= 0 {
+		ms := delay/1000000 + 1 // round up
+		if ms > 1<<31-1 {
+			ms = 1<<31 - 1 // cap to max int32
+		}
+		id := scheduleCallback(ms)
+		pause()
+		clearScheduledCallback(id)
+	} else {
+		pause()
+	}
 	checkTimeouts()
 	if waitingForCallback != nil {
 		goready(waitingForCallback, 1)
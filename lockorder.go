@@ -0,0 +1,525 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Lock-order checking for sync.Mutex and sync.RWMutex.
+//
+// A sync.Mutex has no owner, so two goroutines that lock a pair of
+// mutexes in opposite orders only show up as goroutines blocked in
+// semacquire once they actually deadlock. With GODEBUG=lockorder=1,
+// sync.Mutex and sync.RWMutex report each Lock, RLock, wait and
+// unlock to the runtime, which records which goroutines hold each
+// mutex, where they acquired it, and the order in which mutexes are
+// acquired while others are held.
+//
+// The acquisition order forms a graph with an edge from A to B when
+// some goroutine locked B while holding A. An edge that closes a
+// cycle means the mutexes on the cycle can deadlock, even if they
+// have not yet. When that happens the runtime prints the new edge
+// and the path it reverses, with the stacks at which both ends of
+// every edge were acquired. This is the same idea as the Linux
+// kernel's lockdep. Read locks count the same as write locks: a
+// blocked Lock keeps new readers out, so two goroutines read-locking
+// a pair of RWMutexes in opposite orders can deadlock once writers
+// are waiting on both.
+//
+// Ownership also lets the runtime see actual deadlocks: when a
+// goroutine is about to block on a mutex, it follows the chain of
+// owners and the mutexes they are blocked on, and reports the cycle
+// if it leads back to itself. A mutex held for reading has no single
+// owner, so the chain stops there.
+//
+// Mutexes are identified by address. The node for a mutex in a heap
+// object is attached to the object with a special record and dropped,
+// with its edges, when the object is freed, so a new mutex at the
+// same address starts afresh and memory use follows the number of
+// live mutexes. The rest, in global variables, are never freed. With
+// lockorder=2, reports are fatal.
+
+package runtime
+
+import "unsafe"
+
+const (
+	// lockOrderStackDepth is the number of frames recorded for
+	// each acquisition.
+	lockOrderStackDepth = 16
+
+	// lockOrderMaxPath is the longest path printed in a report.
+	lockOrderMaxPath = 32
+
+	lockOrderTableSize = 1 << 12
+)
+
+var lockOrder struct {
+	lock  mutex
+	table [lockOrderTableSize]*lockOrderNode
+	n     int    // number of nodes in table
+	gen   uint32 // generation of the last path search
+
+	nodeAlloc fixalloc // allocator for lockOrderNode*
+	heldAlloc fixalloc // allocator for lockOrderHeld*
+	edgeAlloc fixalloc // allocator for lockOrderEdge*
+}
+
+// A lockOrderNode is the lock-order state of one sync.Mutex or
+// sync.RWMutex. Nodes are protected by lockOrder.lock.
+//
+//go:notinheap
+type lockOrderNode struct {
+	addr    uintptr        // address of the mutex
+	next    *lockOrderNode // next node in the same table bucket
+	objNext *lockOrderNode // next node in the same heap object
+	edges   *lockOrderEdge // mutexes acquired while holding this one
+	in      *lockOrderEdge // mutexes this one was acquired while holding
+
+	// owner is the goroutine that holds the mutex for writing, if
+	// any. holders lists every acquisition that has not been
+	// released, for reading or writing.
+	owner   guintptr
+	holders *lockOrderHeld
+
+	// State for lockOrderPath.
+	pathGen  uint32
+	pathVia  *lockOrderEdge
+	pathNext *lockOrderNode
+}
+
+// A lockOrderHeld records that a goroutine holds a mutex.
+//
+//go:notinheap
+type lockOrderHeld struct {
+	n        *lockOrderNode
+	gp       guintptr
+	read     bool
+	next     *lockOrderHeld // next mutex gp holds, most recently acquired first
+	nodeNext *lockOrderHeld // next holder of n
+
+	stk [lockOrderStackDepth]uintptr // where gp acquired n
+}
+
+// A lockOrderEdge records that to was locked while from was held.
+//
+//go:notinheap
+type lockOrderEdge struct {
+	from, to *lockOrderNode
+	next     *lockOrderEdge // next edge out of from
+	inNext   *lockOrderEdge // next edge into to
+	goid     int64          // goroutine that first acquired them in this order
+
+	// fromStk and toStk are where from and to were acquired the
+	// first time they were held together.
+	fromStk, toStk [lockOrderStackDepth]uintptr
+}
+
+//go:linkname sync_runtime_lockOrderEnabled sync.runtime_lockOrderEnabled
+func sync_runtime_lockOrderEnabled() bool {
+	if debug.lockorder <= 0 {
+		return false
+	}
+	lock(&lockOrder.lock)
+	if lockOrder.nodeAlloc.size == 0 {
+		lockOrder.nodeAlloc.init(unsafe.Sizeof(lockOrderNode{}), nil, nil, &memstats.other_sys)
+		lockOrder.heldAlloc.init(unsafe.Sizeof(lockOrderHeld{}), nil, nil, &memstats.other_sys)
+		lockOrder.edgeAlloc.init(unsafe.Sizeof(lockOrderEdge{}), nil, nil, &memstats.other_sys)
+	}
+	unlock(&lockOrder.lock)
+	return true
+}
+
+// sync_runtime_lockOrderAcquire is called after the current goroutine
+// has locked the mutex at m, for reading if read is set.
+//
+//go:linkname sync_runtime_lockOrderAcquire sync.runtime_lockOrderAcquire
+func sync_runtime_lockOrderAcquire(m unsafe.Pointer, read bool) {
+	var stk [lockOrderStackDepth]uintptr
+	callers(2, stk[:])
+	gp := getg()
+
+	mp := lockOrderLock(m)
+	gp.syncWait = nil
+	n := lockOrderLookup(uintptr(m), true)
+	for h := gp.syncHeld; h != nil; h = h.next {
+		if h.n != n {
+			lockOrderAddEdge(gp, h, n, &stk)
+		}
+	}
+	h := (*lockOrderHeld)(lockOrder.heldAlloc.alloc())
+	h.n = n
+	h.gp.set(gp)
+	h.read = read
+	h.stk = stk
+	h.next = gp.syncHeld
+	gp.syncHeld = h
+	h.nodeNext = n.holders
+	n.holders = h
+	if !read {
+		n.owner.set(gp)
+	}
+	unlock(&lockOrder.lock)
+	releasem(mp)
+}
+
+// sync_runtime_lockOrderWait is called before the current goroutine
+// blocks waiting for the mutex at m.
+//
+//go:linkname sync_runtime_lockOrderWait sync.runtime_lockOrderWait
+func sync_runtime_lockOrderWait(m unsafe.Pointer) {
+	gp := getg()
+
+	mp := lockOrderLock(m)
+	n := lockOrderLookup(uintptr(m), true)
+	gp.syncWait = n
+	// Follow the owners of the mutexes in the chain. Every
+	// goroutine on it holds a mutex and is waiting for the next,
+	// so if it comes back to gp, none of them can proceed. A
+	// goroutine waits for at most one mutex, so if the chain
+	// is longer than the number of mutexes it has a cycle that
+	// does not include gp, which has already been reported.
+	w := n
+	for i := 0; i <= lockOrder.n; i++ {
+		o := w.owner.ptr()
+		if o == gp {
+			lockOrderReportDeadlock(gp)
+			break
+		}
+		if o == nil || o.syncWait == nil {
+			break
+		}
+		w = o.syncWait
+	}
+	unlock(&lockOrder.lock)
+	releasem(mp)
+}
+
+// sync_runtime_lockOrderRelease is called before the mutex at m is
+// unlocked, for reading if read is set, by any goroutine.
+//
+//go:linkname sync_runtime_lockOrderRelease sync.runtime_lockOrderRelease
+func sync_runtime_lockOrderRelease(m unsafe.Pointer, read bool) {
+	gp := getg()
+
+	lock(&lockOrder.lock)
+	if n := lockOrderLookup(uintptr(m), false); n != nil {
+		// Prefer the calling goroutine's acquisition, but a
+		// mutex may be unlocked by a goroutine other than the
+		// one that locked it.
+		var r *lockOrderHeld
+		for h := n.holders; h != nil; h = h.nodeNext {
+			if h.read == read && (r == nil || h.gp.ptr() == gp) {
+				r = h
+			}
+		}
+		if r != nil {
+			lockOrderDrop(r)
+		}
+	}
+	unlock(&lockOrder.lock)
+}
+
+// lockOrderLock acquires lockOrder.lock to record an acquisition of
+// or a wait for the mutex at addr. It first sweeps the span holding it,
+// if any, since lockOrderLookup may add a special record to it and
+// sweeping the span with lockOrder.lock held could free a tracked
+// mutex and so deadlock. The caller must unlock lockOrder.lock and
+// then releasem the returned m, which keeps the span swept until
+// then.
+func lockOrderLock(addr unsafe.Pointer) *m {
+	mp := acquirem()
+	if s := spanOfHeap(uintptr(addr)); s != nil {
+		s.ensureSwept()
+	}
+	lock(&lockOrder.lock)
+	return mp
+}
+
+// lockOrderGoexit forgets the mutexes gp holds when it exits. They
+// stay locked, but no goroutine owns them.
+func lockOrderGoexit(gp *g) {
+	lock(&lockOrder.lock)
+	for gp.syncHeld != nil {
+		lockOrderDrop(gp.syncHeld)
+	}
+	gp.syncWait = nil
+	unlock(&lockOrder.lock)
+}
+
+// lockOrderDrop removes the acquisition h from its goroutine's and
+// its mutex's lists and frees it.
+//
+// lockOrder.lock must be held.
+func lockOrderDrop(h *lockOrderHeld) {
+	for p := &h.gp.ptr().syncHeld; *p != nil; p = &(*p).next {
+		if *p == h {
+			*p = h.next
+			break
+		}
+	}
+	n := h.n
+	for p := &n.holders; *p != nil; p = &(*p).nodeNext {
+		if *p == h {
+			*p = h.nodeNext
+			break
+		}
+	}
+	if !h.read {
+		n.owner = 0
+	}
+	lockOrder.heldAlloc.free(unsafe.Pointer(h))
+}
+
+// lockOrderLookup returns the node for the mutex at addr. If there
+// is none, it creates one if create is set, and otherwise returns
+// nil. To create a node for a mutex in the heap, the caller must
+// have swept its span; see lockOrderLock.
+//
+// lockOrder.lock must be held.
+func lockOrderLookup(addr uintptr, create bool) *lockOrderNode {
+	b := &lockOrder.table[(addr>>3)%lockOrderTableSize]
+	for n := *b; n != nil; n = n.next {
+		if n.addr == addr {
+			return n
+		}
+	}
+	if !create {
+		return nil
+	}
+	n := (*lockOrderNode)(lockOrder.nodeAlloc.alloc())
+	n.addr = addr
+	n.next = *b
+	*b = n
+	lockOrder.n++
+	lockOrderTrackObject(n)
+	return n
+}
+
+// lockOrderTrackObject arranges for n to be dropped when the heap
+// object holding its mutex is freed. The nodes for all of the
+// mutexes in an object hang off a single special record at the
+// start of the object.
+//
+// lockOrder.lock must be held.
+func lockOrderTrackObject(n *lockOrderNode) {
+	span := spanOfHeap(n.addr)
+	if span == nil {
+		// A global variable, which is never freed.
+		return
+	}
+	p := span.base() + span.objIndex(n.addr)*span.elemsize
+	offset := p - span.base()
+	lock(&span.speciallock)
+	for sp := span.specials; sp != nil; sp = sp.next {
+		if uintptr(sp.offset) == offset && sp.kind == _KindSpecialLockOrder {
+			s := (*speciallockorder)(unsafe.Pointer(sp))
+			n.objNext = s.nodes
+			s.nodes = n
+			unlock(&span.speciallock)
+			return
+		}
+	}
+	unlock(&span.speciallock)
+
+	lock(&mheap_.speciallock)
+	s := (*speciallockorder)(mheap_.speciallockorderalloc.alloc())
+	unlock(&mheap_.speciallock)
+	s.special.kind = _KindSpecialLockOrder
+	s.nodes = n
+	if !addspecial(unsafe.Pointer(p), &s.special) {
+		throw("lockOrderTrackObject: lock-order record already set")
+	}
+}
+
+// lockOrderFree drops the nodes on the objNext list starting at n,
+// whose mutexes are in an object that is being freed, along with all
+// of their edges. Any goroutine still holding one of them forgets it.
+func lockOrderFree(n *lockOrderNode) {
+	lock(&lockOrder.lock)
+	for n != nil {
+		next := n.objNext
+		for n.holders != nil {
+			lockOrderDrop(n.holders)
+		}
+		for e := n.edges; e != nil; {
+			enext := e.next
+			for p := &e.to.in; *p != nil; p = &(*p).inNext {
+				if *p == e {
+					*p = e.inNext
+					break
+				}
+			}
+			lockOrder.edgeAlloc.free(unsafe.Pointer(e))
+			e = enext
+		}
+		for e := n.in; e != nil; {
+			enext := e.inNext
+			for p := &e.from.edges; *p != nil; p = &(*p).next {
+				if *p == e {
+					*p = e.next
+					break
+				}
+			}
+			lockOrder.edgeAlloc.free(unsafe.Pointer(e))
+			e = enext
+		}
+		for p := &lockOrder.table[(n.addr>>3)%lockOrderTableSize]; *p != nil; p = &(*p).next {
+			if *p == n {
+				*p = n.next
+				break
+			}
+		}
+		lockOrder.n--
+		lockOrder.nodeAlloc.free(unsafe.Pointer(n))
+		n = next
+	}
+	unlock(&lockOrder.lock)
+}
+
+// lockOrderAddEdge records that gp locked to at stk while holding
+// the mutex of held. If this is the first time and the reverse order
+// has been seen, it reports the inversion.
+//
+// lockOrder.lock must be held.
+func lockOrderAddEdge(gp *g, held *lockOrderHeld, to *lockOrderNode, stk *[lockOrderStackDepth]uintptr) {
+	from := held.n
+	for e := from.edges; e != nil; e = e.next {
+		if e.to == to {
+			return
+		}
+	}
+	e := (*lockOrderEdge)(lockOrder.edgeAlloc.alloc())
+	e.from = from
+	e.to = to
+	e.goid = gp.goid
+	e.fromStk = held.stk
+	e.toStk = *stk
+	if lockOrderPath(to, from) {
+		lockOrderReportInversion(e)
+	}
+	e.next = from.edges
+	from.edges = e
+	e.inNext = to.in
+	to.in = e
+}
+
+// lockOrderPath reports whether there is a path from from to to in
+// the lock-order graph. If there is, it can be followed backwards
+// from to through the nodes' pathVia edges.
+//
+// It does a breadth-first search so the path is as short as
+// possible.
+//
+// lockOrder.lock must be held.
+func lockOrderPath(from, to *lockOrderNode) bool {
+	lockOrder.gen++
+	gen := lockOrder.gen
+	from.pathGen = gen
+	from.pathVia = nil
+	from.pathNext = nil
+	tail := from
+	for n := from; n != nil; n = n.pathNext {
+		for e := n.edges; e != nil; e = e.next {
+			if e.to.pathGen == gen {
+				continue
+			}
+			e.to.pathGen = gen
+			e.to.pathVia = e
+			if e.to == to {
+				return true
+			}
+			e.to.pathNext = nil
+			tail.pathNext = e.to
+			tail = e.to
+		}
+	}
+	return false
+}
+
+// lockOrderReportInversion prints the edge e, which is about to be
+// added, and the existing path from e.to to e.from found by
+// lockOrderPath.
+//
+// lockOrder.lock must be held.
+func lockOrderReportInversion(e *lockOrderEdge) {
+	var path [lockOrderMaxPath]*lockOrderEdge
+	n := 0
+	for p := e.from.pathVia; p != nil && n < len(path); p = p.from.pathVia {
+		path[n] = p
+		n++
+		if p.from == e.to {
+			break
+		}
+	}
+
+	printlock()
+	print("sync: possible deadlock: inconsistent lock order\n\n")
+	lockOrderPrintEdge(e)
+	print("but these mutexes were previously locked in the opposite order:\n\n")
+	for i := n - 1; i >= 0; i-- {
+		lockOrderPrintEdge(path[i])
+	}
+	if n == len(path) && path[n-1].from != e.to {
+		print("...additional mutexes elided...\n\n")
+	}
+	printunlock()
+	if debug.lockorder > 1 {
+		throw("sync: inconsistent lock order")
+	}
+}
+
+func lockOrderPrintEdge(e *lockOrderEdge) {
+	print("goroutine ", e.goid, " locked mutex ", hex(e.to.addr), " while holding mutex ", hex(e.from.addr), "\n")
+	print("mutex ", hex(e.from.addr), " was locked at:\n")
+	lockOrderPrintStack(e.fromStk[:])
+	print("mutex ", hex(e.to.addr), " was locked at:\n")
+	lockOrderPrintStack(e.toStk[:])
+	print("\n")
+}
+
+// lockOrderReportDeadlock prints the cycle of goroutines waiting for
+// each other's mutexes that starts and ends with gp.
+//
+// lockOrder.lock must be held.
+func lockOrderReportDeadlock(gp *g) {
+	printlock()
+	print("sync: deadlock: goroutines are waiting for mutexes held by each other\n\n")
+	o := gp
+	for {
+		w := o.syncWait
+		print("goroutine ", o.goid, " is waiting for mutex ", hex(w.addr), "\n")
+		o = w.owner.ptr()
+		print("which is held by goroutine ", o.goid, " and was locked at:\n")
+		for h := w.holders; h != nil; h = h.nodeNext {
+			if !h.read {
+				lockOrderPrintStack(h.stk[:])
+				break
+			}
+		}
+		print("\n")
+		if o == gp {
+			break
+		}
+	}
+	printunlock()
+	if debug.lockorder > 1 {
+		throw("sync: deadlock")
+	}
+}
+
+// lockOrderPrintStack prints a stack recorded by callers, in the
+// same format as an ancestor traceback.
+func lockOrderPrintStack(stk []uintptr) {
+	elideWrapper := false
+	for i, pc := range stk {
+		if pc == 0 {
+			break
+		}
+		f := findfunc(pc)
+		if !f.valid() {
+			print("unknown pc ", hex(pc), "\n")
+			continue
+		}
+		if showfuncinfo(f, i == 0, elideWrapper && i != 0) {
+			elideWrapper = printAncestorTracebackFuncInfo(f, pc)
+		}
+	}
+}
@@ -652,6 +652,10 @@ mapped:
 			}
 		}
 
+		if debug.heaptypes != 0 {
+			r.allocTypes()
+		}
+
 		// Store atomically just in case an object from the
 		// new heap arena becomes visible before the heap lock
 		// is released (which shouldn't happen, but there's little downside to this).
@@ -892,6 +896,9 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 			x = unsafe.Pointer(v)
 			(*[2]uint64)(x)[0] = 0
 			(*[2]uint64)(x)[1] = 0
+			if debug.heaptypes != 0 {
+				setHeapType(v, nil)
+			}
 			// See if we need to replace the existing tiny block with the new one
 			// based on amount of remaining free space.
 			if size < c.tinyoffset || c.tiny == 0 {
@@ -917,6 +924,9 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 			if needzero && span.needzero != 0 {
 				memclrNoHeapPointers(unsafe.Pointer(v), size)
 			}
+			if debug.heaptypes != 0 {
+				setHeapType(v, typ)
+			}
 		}
 	} else {
 		var s *mspan
@@ -926,6 +936,7 @@ func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 		})
 		s.freeindex = 1
 		s.allocCount = 1
+		s.largeType = uintptr(unsafe.Pointer(typ))
 		x = unsafe.Pointer(s.base())
 		size = s.elemsize
 	}
@@ -7,282 +7,746 @@ package runtime
 // This file contains the implementation of Go's map type.
 // 这个文件包含了go中map的实现
 //
-// A map is just a hash table.
-// The data is arranged into an array of buckets.
-//  数据是有被安排成桶的数组
-// Each bucket contains up to 8 key/value pairs.
-// 每个桶最多包含了8对key/value
-// The low-order bits of the hash are used to select a bucket.
-// 哈希的低阶位用于查询在这个桶
-// Each bucket contains a few high-order bits of each hash to distinguish the entries within a single bucket.
-// 每个桶包含了hash的高阶位,用于区分单个桶中的条目
-// If more than 8 keys hash to a bucket, we chain on extra buckets.
+// A map is an open-addressed hash table in the style of Abseil's
+// "Swiss tables". Entries are stored in groups of 8 slots. Each group
+// starts with 8 control bytes, one per slot, followed by 8 keys and 8
+// values. The layout is that of the bucket type the compiler builds
+// for each map type, so bmap is still the group type and the control
+// bytes live in bmap.tophash.
+// 数据是被安排成组(group)的,每个组最多包含了8对key/value,
+// 组的开头是8个控制字节,每个槽(slot)一个
 //
-// When the hashtable grows, we allocate a new array of buckets twice as big.
-// 当map在增长的时候,桶满的时候会分配原理的2倍的大小的数组
-// Buckets are incrementally copied from the old bucket array to the new bucket array.
-// 数据的copy是增量式的,不是全部一次性copy
-// Map iterators walk through the array of buckets and return the keys in walk order
-// (bucket #, then overflow chain order, then bucket index).
-// map在遍历桶的时候按照遍历的顺序返回key值(顺序是 bucket, )
-// To maintain iteration semantics, we never move keys within their bucket (if we did, keys might be returned 0 or 2 times).
-// 在遍历的过程中是不会改变顺序的
-// When growing the table, iterators remain iterating through the old table and must check the new table if the bucket
-// they are iterating through has been moved ("evacuated") to the new table.
-// 当map在增长的过程的时候,是遍历老的表,并且,当老的表是空的时候会去检查新的表
-
-// Picking loadFactor: too large and we have lots of overflow buckets, too small and we waste a lot of space.
-// I wrote a simple program to check some stats for different loads:
-// (64-bit, 8 byte keys and values)
-//  loadFactor    %overflow  bytes/entry     hitprobe    missprobe
-//        4.00         2.13        20.77         3.00         4.00
-//        4.50         4.05        17.30         3.25         4.50
-//        5.00         6.85        14.77         3.50         5.00
-//        5.50        10.55        12.94         3.75         5.50
-//        6.00        15.27        11.67         4.00         6.00
-//        6.50        20.90        10.79         4.25         6.50
-//        7.00        27.14        10.15         4.50         7.00
-//        7.50        34.03         9.73         4.75         7.50
-//        8.00        41.10         9.40         5.00         8.00
+// A control byte is either empty, deleted (a tombstone) or full. A
+// full control byte holds the low 7 bits of the key's hash ("h2").
+// Lookups compare h2 against all 8 control bytes of a group at once
+// and only compare keys whose h2 matches, so a lookup typically
+// compares a single key. The comparison works on the control bytes
+// as one 64-bit word, which is the portable form of what a 16-byte
+// SIMD compare does on amd64; the encoding is chosen so that zeroed
+// memory is a group of empty slots.
+// 控制字节保存了hash的低7位,用于区分单个组中的条目,
+// 一次比较一个组的8个控制字节,只有h2相同的时候才去比较key
+//
+// Small maps, of up to 8 entries, are a single group pointed to by
+// hmap.buckets and are searched without probing.
+// 小于等于8个元素的map只有一个组,不需要探测
+//
+// Larger maps have a directory of tables. The top B bits of the hash
+// select an entry in the directory, and the table it points to
+// holds the entry. A table is a power-of-two array of groups probed
+// quadratically, starting at the group chosen by the remaining bits
+// of the hash ("h1"). Because there is always at least one empty
+// slot in the probe sequence, a lookup stops at the first group with
+// an empty slot.
+// 哈希的高B位用于在目录中查询所在的表,剩下的位(h1)决定从表中的哪个组开始探测,
+// 遇到有空槽的组的时候查询就结束了
 //
-// %overflow   = percentage of buckets which have an overflow bucket
-// bytes/entry = overhead bytes used per key/value pair
-// hitprobe    = # of entries to check when looking up a present key 查找当前键要检查的项
-// missprobe   = # of entries to check when looking up an absent key 查找一个不存在的key要检查的条数
+// Tables grow independently. When a table runs out of room, a new
+// table twice the size replaces it and its entries are copied over;
+// growth therefore copies at most maxTableCapacity entries at a time
+// no matter how large the map is. A table at maxTableCapacity is
+// instead split into two tables, each taking the entries for one
+// more bit of the hash, and the directory doubles if needed to tell
+// the two apart. Several directory entries may refer to the same
+// table if it has split fewer times than others.
+// 当表满的时候会分配原来2倍的大小的表,数据的copy是按表进行的,不是整个map一次性copy,
+// 表达到maxTableCapacity之后会分裂成两个表,必要的时候目录的大小翻倍
 //
-// Keep in mind this data is for maximally loaded tables, i.e. just before the table grows.
-// Typical tables will be somewhat less loaded.
+// Tables also shrink independently. When deletions leave a table
+// with fewer than 1/shrinkLoadDen of its slots in use, it is replaced
+// by a smaller copy in the same way it would be by a larger one.
+// When a table and its buddy, the table selected by the same hash
+// bits except the last, together hold fewer than
+// maxTableCapacity/mergeLoadDen entries, they are merged back into
+// one table, and the directory halves once no table needs all B
+// bits to select it. A map that becomes empty goes back to being a
+// small map with no memory allocated.
+// 删除之后表也会单独缩小，两个互为buddy的表元素很少的时候会合并成一个表，
+// 所有的表都不需要全部的B位来选择的时候目录的大小减半
+//
+// A table that has been replaced by growth or shrinking is never
+// modified again.
+// Iterators that were walking it continue to do so, and look up each
+// entry they find in the current map to see whether it has since
+// been deleted or updated. Iterators randomize both the starting
+// table and the starting slot within each table.
+// 当map在增长的过程中,迭代器还是遍历老的表,并且会在当前的map中检查这个key是否还存在,
+// 在遍历的过程中是不会改变顺序的
+
+// Picking loadFactor: a table grows when 7/8 of its slots are in use
+// (full or deleted). With h2 filtering, the cost of a probe is mostly
+// the number of groups visited, and at 7/8 load almost all lookups
+// finish in the first group.
 
 import (
-	"readruntime/internal/atomic"
 	"readruntime/internal/sys"
 	"unsafe"
 )
 
 const (
-	// Maximum number of key/value pairs a bucket can hold.
-	// 一个桶可以容纳的键值对的最大数目
+	// Maximum number of key/value pairs a group can hold.
+	// 一个组可以容纳的键值对的最大数目
 	bucketCntBits = 3
 	bucketCnt     = 1 << bucketCntBits
 
-	// Maximum average load of a bucket that triggers growth is 6.5.
-	// 一个桶的最大平均负载
+	// Maximum load of a table that triggers growth is 7/8.
+	// 一个表的最大平均负载
 	// Represent as loadFactorNum/loadFactDen, to allow integer math.
-	loadFactorNum = 13
-	loadFactorDen = 2
+	loadFactorNum = 7
+	loadFactorDen = 8
+
+	// maxTableCapacity is the maximum number of slots in a table.
+	// Tables that need to grow beyond this split instead, which
+	// bounds the latency of a single growth step.
+	maxTableCapacity = 1024
+
+	// A table shrinks when fewer than 1/shrinkLoadDen of its slots
+	// are full. It is resized so that it is at most half loaded,
+	// which leaves room for growth and for further deletions
+	// before the next resize.
+	shrinkLoadDen = 8
+
+	// A table merges with its buddy when together they hold fewer
+	// than maxTableCapacity/mergeLoadDen entries. This is well
+	// below the load at which a table splits, so a map whose size
+	// hovers around a split does not merge and split repeatedly.
+	mergeLoadDen = 4
 
 	// Maximum key or value size to keep inline (instead of mallocing per element).
+	// 可以内联的最大大小
 	// Must fit in a uint8.
 	// Fast versions cannot handle big values - the cutoff size for
 	// fast versions in cmd/compile/internal/gc/walk.go must be at most this value.
-	// 可以内联的最大大小
 	maxKeySize   = 128
 	maxValueSize = 128
 
 	// data offset should be the size of the bmap struct, but needs to be aligned correctly.
-	// For amd64p32 this means 64-bit alignment even though pointers are 32 bit.
 	// 用于数据对齐
+	// For amd64p32 this means 64-bit alignment even though pointers are 32 bit.
 	dataOffset = unsafe.Offsetof(struct {
 		b bmap
 		v int64
 	}{}.v)
 
-	// Possible tophash values. We reserve a few possibilities for special marks.
-	// Each bucket (including its overflow buckets, if any) will have either all or none of its
-	// entries in the evacuated* states (except during the evacuate() method, which only happens
-	// during map writes and thus no one else can observe the map during that time).
-	empty          = 0 // cell is empty
-	evacuatedEmpty = 1 // cell is empty, bucket is evacuated.
-	evacuatedX     = 2 // key/value is valid.  Entry has been evacuated to first half of larger table.
-	evacuatedY     = 3 // same as above, but evacuated to second half of larger table.
-	minTopHash     = 4 // minimum tophash for a normal filled cell.
+	// Control byte values. A full slot has ctrlFull set and the
+	// key's h2 in the low 7 bits.
+	ctrlEmpty   = 0    // slot is empty
+	ctrlDeleted = 1    // slot is empty, but probing must continue past it
+	ctrlFull    = 0x80 // slot holds a key/value
 
 	// flags
-	iterator    = 1 // there may be an iterator using buckets
-	oldIterator = 2 // there may be an iterator using oldbuckets
-	hashWriting = 4 // a goroutine is writing to the map
-	// 当前map的扩容是和之前的大小一样
-	sameSizeGrow = 8 // the current map growth is to a new map of the same size
-
-	// sentinel bucket ID for iterator checks
-	noCheck = 1<<(8*sys.PtrSize) - 1
+	hashWriting   = 4 // a goroutine is writing to the map
+	hashDirectory = 8 // buckets is a directory of tables rather than a single group
+
+	// entryOffsetBits is the number of low bits of hiter.offsets
+	// that hold the iterator's starting slot within a table.
+	entryOffsetBits = 10
 )
 
 // A header for a Go map.
 type hmap struct {
 	// Note: the format of the hmap is also encoded in cmd/compile/internal/gc/reflect.go.
 	// Make sure this stays in sync with the compiler's definition.
-	count     int // # live cells == size of map.  Must be first (used by len() builtin) 元素个数
-	flags     uint8
-	B         uint8  // log_2 of # of buckets (can hold up to loadFactor * 2^B items)
-	noverflow uint16 // approximate number of overflow buckets; see incrnoverflow for details
-	hash0     uint32 // hash seed 哈希种子
-	// buckets是一个bucket的数组，每一个bucket中存放的是一个bmap，其中买个bmap中可以存8个key-value
-	buckets unsafe.Pointer // array of 2^B Buckets. may be nil if count==0.
-	// 扩容的时候使用，表示的是老的那个buckets
-	oldbuckets unsafe.Pointer // previous bucket array of half the size, non-nil only when growing
-	// 转移桶的计数器
-	nevacuate uintptr // progress counter for evacuation (buckets less than this have been evacuated)
-	// 将包含指针的信息保存起来,使得gc在扫描的时候不会去扫描整个map
-	extra *mapextra // optional fields
-}
-
-// mapextra holds fields that are not present on all maps.
-// 保存了并非在所有的map中都显示的字段，内联并且key和value中不包含指针的情况下使用
-type mapextra struct {
-	// If both key and value do not contain pointers and are inline, then we mark bucket type as containing no pointers.
-	// 如果这个key和value是不包含指针或者内联的,我们标记这个桶是不包含指针的
-	// This avoids scanning such maps.
-	// 避免扫描每一个桶
-	// However, bmap.overflow is a pointer. In order to keep overflow buckets alive,
-	// we store pointers to all overflow buckets in hmap.extra.overflow and hmap.extra.oldoverflow.
-	// overflow and oldoverflow are only used if key and value do not contain pointers.
-	// overflow contains overflow buckets for hmap.buckets.
-	// oldoverflow contains overflow buckets for hmap.oldbuckets.
-	// The indirection allows to store a pointer to the slice in hiter.
-	overflow    *[]*bmap // 指向的是后面的溢出桶
-	oldoverflow *[]*bmap // 扩容的时候使用
-
-	// nextOverflow holds a pointer to a free overflow bucket.
-	// 指向空闲的 overflow bucket的指针
-	nextOverflow *bmap
-}
-
-// A bucket for a Go map.
+	// The compiler only relies on the count, hash0 and buckets
+	// fields; the unnamed fields keep the size it expects.
+	count int // # live cells == size of map.  Must be first (used by len() builtin) 元素个数
+	flags uint8
+	B     uint8 // log_2 of # of directory entries, if flags&hashDirectory != 0 2^B表示目录的大小
+	_     uint16
+	hash0 uint32 // hash seed 哈希种子
+
+	// buckets is the group of a small map, or the first element
+	// of a directory of 2^B *tables. It may be nil if count==0.
+	// buckets是小map的那一个组，或者是有2^B个表的目录
+	buckets unsafe.Pointer
+
+	_ unsafe.Pointer
+
+	// clearSeq is incremented by mapclear. Iterators use it to
+	// tell whether entries they can't look up are still present.
+	// 清空map的计数器，迭代器用来判断map是否被清空过
+	clearSeq uintptr
+
+	_ unsafe.Pointer
+}
+
+// A table is one of the hash tables in a map's directory.
+// 目录中的一个哈希表
+type table struct {
+	used       uint16 // # of full slots 元素个数
+	capacity   uint16 // # of slots; a power of two, at most maxTableCapacity 槽的个数
+	growthLeft uint16 // # of empty slots that may be filled before the table grows 扩容之前还可以使用的空槽的个数
+
+	// localDepth is the number of top bits of the hash that
+	// select this table. The table appears in 2^(B-localDepth)
+	// consecutive directory entries.
+	localDepth uint8
+
+	// index is the first directory entry that refers to this
+	// table, or -1 if the table has been replaced by a grown or
+	// split table.
+	// 在目录中的位置，表被替换掉之后是-1
+	index int
+
+	groups    unsafe.Pointer // array of capacity/bucketCnt groups
+	groupMask uintptr        // capacity/bucketCnt - 1
+}
+
+// A group for a Go map.
 type bmap struct {
-	// tophash generally contains the top byte of the hash value for each key in this bucket.
-	// If tophash[0] < minTopHash, tophash[0] is a bucket evacuation state instead.
-	// 这个就限定了一个桶可容纳的键值对的数量
+	// tophash holds a control byte for each slot in this group.
+	// 这个就限定了一个组可容纳的键值对的数量
 	tophash [bucketCnt]uint8
-	// 实际中每一个bmap中都会有一个overflow指向下一个bmap
 	// Followed by bucketCnt keys and then bucketCnt values.
 	// NOTE: packing all the keys together and then all the values together makes the code a bit more complicated than alternating key/value/key/value/...
 	// but it allows us to eliminate padding which would be needed for, e.g., map[int64]int8.
-	// Followed by an overflow pointer.
+	// Followed by an overflow pointer, which maps no longer use.
 }
 
 // A hash iteration structure.
-// If you modify hiter, also change cmd/compile/internal/gc/reflect.go to indicate the layout of this structure.
 // map的迭代结构
+// If you modify hiter, also change cmd/compile/internal/gc/reflect.go to indicate the layout of this structure.
 type hiter struct {
-	key         unsafe.Pointer // Must be in first position.  Write nil to indicate iteration end (see cmd/internal/gc/range.go).
-	value       unsafe.Pointer // Must be in second position (see cmd/internal/gc/range.go).
-	t           *maptype
-	h           *hmap
-	buckets     unsafe.Pointer // bucket ptr at hash_iter initialization time
-	bptr        *bmap          // current bucket
-	overflow    *[]*bmap       // keeps overflow buckets of hmap.buckets alive
-	oldoverflow *[]*bmap       // keeps overflow buckets of hmap.oldbuckets alive
-	startBucket uintptr        // bucket iteration started at 开始遍历的桶
-	offset      uint8          // intra-bucket offset to start from during iteration (should be big enough to hold bucketCnt-1)
-	wrapped     bool           // already wrapped around from end of bucket array to beginning，遍历结束了
-	B           uint8
-	i           uint8
-	bucket      uintptr
-	checkBucket uintptr
+	key   unsafe.Pointer // Must be in first position.  Write nil to indicate iteration end (see cmd/internal/gc/range.go).
+	value unsafe.Pointer // Must be in second position (see cmd/internal/gc/range.go).
+	t     *maptype
+	h     *hmap
+	tab   *table // current table, or nil
+	group *bmap  // the group of a small map when iteration started
+	_     unsafe.Pointer
+	_     unsafe.Pointer
+
+	clearSeq    uintptr // h.clearSeq when iteration started
+	globalDepth uint8   // number of hash bits in dirIdx; the largest h.B seen so far
+	filter      bool    // skip entries of tab at directory positions already passed
+	entryIdx    uint16  // next slot in the current table or group, before adding the offset
+	dirIdx      int     // current directory entry, before adding the offset; -1 for a small map
+
+	// offsets holds the random starting slot in its low
+	// entryOffsetBits bits and the random starting directory
+	// entry in the rest.
+	// 开始遍历的槽和目录的位置，都是随机的
+	offsets uintptr
 }
 
-// bucketShift returns 1<<b, optimized for code generation.
-func bucketShift(b uint8) uintptr {
-	if sys.GoarchAmd64|sys.GoarchAmd64p32|sys.Goarch386 != 0 {
-		b &= sys.PtrSize*8 - 1 // help x86 archs remove shift overflow checks
-	}
-	return uintptr(1) << b
+// A bitset has the high bit set in each byte that corresponds to
+// a matching slot of a group.
+type bitset uint64
+
+const (
+	bitsetLSB  = 0x0101010101010101
+	bitsetMSB  = 0x8080808080808080
+	bitsetLow7 = 0x7f7f7f7f7f7f7f7f
+)
+
+// first returns the index of the first matching slot in b.
+func (b bitset) first() uintptr {
+	return uintptr(sys.Ctz64(uint64(b))) >> 3
 }
 
-// bucketMask returns 1<<b - 1, optimized for code generation.
-func bucketMask(b uint8) uintptr {
-	return bucketShift(b) - 1
+// removeFirst returns b without its first matching slot.
+func (b bitset) removeFirst() bitset {
+	return b & (b - 1)
 }
 
-// tophash calculates the tophash value for hash.
-// 计算hash的值
-func tophash(hash uintptr) uint8 {
-	top := uint8(hash >> (sys.PtrSize*8 - 8))
-	if top < minTopHash {
-		top += minTopHash
+// zeroBytes returns the bitset of the zero bytes of w.
+func zeroBytes(w uint64) bitset {
+	// For each byte x, (x&0x7f)+0x7f has the high bit set iff
+	// the low 7 bits of x are not all zero. Unlike the usual
+	// (x-0x01)&^x trick this never borrows from the next byte,
+	// so there are no false positives.
+	return bitset(^(((w & bitsetLow7) + bitsetLow7) | w) & bitsetMSB)
+}
+
+// ctrls returns the control bytes of b as a word with slot i in
+// byte i.
+func (b *bmap) ctrls() uint64 {
+	w := *(*uint64)(unsafe.Pointer(&b.tophash))
+	if sys.BigEndian {
+		w = sys.Bswap64(w)
 	}
-	return top
+	return w
 }
 
-//
-func evacuated(b *bmap) bool {
-	h := b.tophash[0]
-	return h > empty && h < minTopHash
+// matchH2 returns the full slots of b whose key has the given h2.
+func (b *bmap) matchH2(top uint8) bitset {
+	return zeroBytes(b.ctrls() ^ (bitsetLSB * uint64(ctrlFull|top)))
 }
 
-func (b *bmap) overflow(t *maptype) *bmap {
-	return *(**bmap)(add(unsafe.Pointer(b), uintptr(t.bucketsize)-sys.PtrSize))
+// matchEmpty returns the empty slots of b.
+func (b *bmap) matchEmpty() bitset {
+	return zeroBytes(b.ctrls())
 }
 
-func (b *bmap) setoverflow(t *maptype, ovf *bmap) {
-	*(**bmap)(add(unsafe.Pointer(b), uintptr(t.bucketsize)-sys.PtrSize)) = ovf
+// matchDeleted returns the deleted slots of b.
+func (b *bmap) matchDeleted() bitset {
+	return zeroBytes(b.ctrls() ^ (bitsetLSB * ctrlDeleted))
+}
+
+// matchFull returns the full slots of b.
+func (b *bmap) matchFull() bitset {
+	return bitset(b.ctrls() & bitsetMSB)
 }
 
 func (b *bmap) keys() unsafe.Pointer {
 	return add(unsafe.Pointer(b), dataOffset)
 }
 
-// incrnoverflow increments h.noverflow.
-// noverflow counts the number of overflow buckets.
-// 计算溢出桶的数量
-// This is used to trigger same-size map growth.
-// See also tooManyOverflowBuckets.
-// To keep hmap small, noverflow is a uint16.
-// When there are few buckets, noverflow is an exact count.
-// 桶的数量少时，溢出是精确的数量，负责是一个大概的数字
-// When there are many buckets, noverflow is an approximate count.
-func (h *hmap) incrnoverflow() {
-	// We trigger same-size map growth if there are as many overflow buckets as buckets.
-	// We need to be able to count to 1<<h.B.
-	if h.B < 16 {
-		h.noverflow++
-		return
+// key returns the key slot i of b. For indirect keys, this is the
+// slot holding the pointer to the key.
+func (b *bmap) key(t *maptype, i uintptr) unsafe.Pointer {
+	return add(unsafe.Pointer(b), dataOffset+i*uintptr(t.keysize))
+}
+
+// value returns the value slot i of b. For indirect values, this
+// is the slot holding the pointer to the value.
+func (b *bmap) value(t *maptype, i uintptr) unsafe.Pointer {
+	return add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+i*uintptr(t.valuesize))
+}
+
+// entry returns the key and value in slot i of b, following
+// indirect keys and values.
+func (b *bmap) entry(t *maptype, i uintptr) (unsafe.Pointer, unsafe.Pointer) {
+	k := b.key(t, i)
+	if t.indirectkey {
+		k = *((*unsafe.Pointer)(k))
+	}
+	v := b.value(t, i)
+	if t.indirectvalue {
+		v = *((*unsafe.Pointer)(v))
 	}
-	// Increment with probability 1/(1<<(h.B-15)).
-	// When we reach 1<<15 - 1, we will have approximately as many overflow buckets as buckets.
-	mask := uint32(1)<<(h.B-15) - 1
-	// Example: if h.B == 18, then mask == 7, and fastrand & 7 == 0 with probability 1/8.
-	if fastrand()&mask == 0 {
-		h.noverflow++
-	}
-}
-
-// 分配一个新的桶
-func (h *hmap) newoverflow(t *maptype, b *bmap) *bmap {
-	var ovf *bmap
-	if h.extra != nil && h.extra.nextOverflow != nil {
-		// We have preallocated overflow buckets available.
-		// See makeBucketArray for more details.
-		ovf = h.extra.nextOverflow
-		if ovf.overflow(t) == nil {
-			// We're not at the end of the preallocated overflow buckets. Bump the pointer.
-			h.extra.nextOverflow = (*bmap)(add(unsafe.Pointer(ovf), uintptr(t.bucketsize)))
+	return k, v
+}
+
+// lookup returns the slot of b that holds key, whose hash has the
+// given h2.
+func (b *bmap) lookup(t *maptype, top uint8, key unsafe.Pointer) (uintptr, bool) {
+	// 只遍历h2相同的槽，h2不相同的就直接跳过
+	for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+		i := match.first()
+		k := b.key(t, i)
+		if t.indirectkey {
+			k = *((*unsafe.Pointer)(k))
+		}
+		if t.key.alg.equal(key, k) { // 比较全部的key是否一致
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// insert stores key in the empty slot i of b and returns the
+// value slot.
+func (b *bmap) insert(t *maptype, i uintptr, top uint8, key unsafe.Pointer) unsafe.Pointer {
+	k := b.key(t, i)
+	v := b.value(t, i)
+	if t.indirectkey {
+		kmem := newobject(t.key)
+		*(*unsafe.Pointer)(k) = kmem
+		k = kmem
+	}
+	if t.indirectvalue {
+		vmem := newobject(t.elem)
+		*(*unsafe.Pointer)(v) = vmem
+	}
+	typedmemmove(t.key, k, key)
+	b.tophash[i&(bucketCnt-1)] = ctrlFull | top // mask i to avoid bounds checks
+	return v
+}
+
+// clearSlot clears the key and value in slot i of b so that the GC
+// does not retain them. It does not change the control byte.
+func (b *bmap) clearSlot(t *maptype, i uintptr) {
+	k := b.key(t, i)
+	// Only clear key if there are pointers in it.
+	// 当键是指针的时候需要将键清除
+	if t.indirectkey {
+		*(*unsafe.Pointer)(k) = nil
+	} else if t.key.kind&kindNoPointers == 0 {
+		memclrHasPointers(k, t.key.size)
+	}
+	v := b.value(t, i)
+	if t.indirectvalue {
+		*(*unsafe.Pointer)(v) = nil
+	} else if t.elem.kind&kindNoPointers == 0 {
+		memclrHasPointers(v, t.elem.size)
+	} else {
+		memclrNoHeapPointers(v, t.elem.size)
+	}
+}
+
+// h1 returns the part of the hash that selects the first group to
+// probe in a table.
+// 取出hash的后面的位，决定从哪个组开始探测
+func h1(hash uintptr) uintptr {
+	return hash >> 7
+}
+
+// h2 returns the part of the hash that is stored in the control
+// byte.
+// 取出hash的低7位，放到控制字节中
+func h2(hash uintptr) uint8 {
+	return uint8(hash & 0x7f)
+}
+
+// A probeSeq is the sequence of groups of a table probed for a
+// hash. It visits each group once before repeating.
+type probeSeq struct {
+	mask   uintptr
+	offset uintptr
+	index  uintptr
+}
+
+func makeProbeSeq(hash, mask uintptr) probeSeq {
+	return probeSeq{mask: mask, offset: h1(hash) & mask}
+}
+
+func (s probeSeq) next() probeSeq {
+	// Triangular numbers visit every group of a power-of-two
+	// table.
+	s.index++
+	s.offset = (s.offset + s.index) & s.mask
+	return s
+}
+
+// newTable returns a table with room for at least capacity slots.
+// 分配一个新的表
+func newTable(t *maptype, capacity uintptr, index int, localDepth uint8) *table {
+	tab := new(table)
+	tab.index = index
+	tab.localDepth = localDepth
+	tab.reset(t, capacity)
+	return tab
+}
+
+// reset gives tab a new, empty array of groups with room for at
+// least capacity slots.
+func (tab *table) reset(t *maptype, capacity uintptr) {
+	c := uintptr(2 * bucketCnt)
+	for c < capacity && c < maxTableCapacity {
+		c <<= 1
+	}
+	ngroups := c / bucketCnt
+	// 给组分配内存
+	tab.groups = newarray(t.bucket, int(ngroups))
+	tab.groupMask = ngroups - 1
+	tab.capacity = uint16(c)
+	tab.used = 0
+	tab.growthLeft = uint16(c * loadFactorNum / loadFactorDen)
+}
+
+// group returns group i of tab.
+func (tab *table) group(t *maptype, i uintptr) *bmap {
+	return (*bmap)(add(tab.groups, i*uintptr(t.bucketsize)))
+}
+
+// remove marks the full slot i of group b in tab as free. The
+// slot's key and value must already have been cleared.
+func (tab *table) remove(b *bmap, i uintptr) {
+	tab.used--
+	if b.matchEmpty() != 0 {
+		// Every probe sequence that reached b stopped here, so
+		// no key depends on this slot having been full.
+		b.tophash[i&(bucketCnt-1)] = ctrlEmpty
+		tab.growthLeft++
+	} else {
+		b.tophash[i&(bucketCnt-1)] = ctrlDeleted
+	}
+}
+
+// uncheckedPut copies the key and value in slot i of b, whose key
+// has the given hash, into tab. tab must not already contain the key
+// and must have room for it. Indirect keys and values are shared
+// with b.
+func (tab *table) uncheckedPut(t *maptype, hash uintptr, b *bmap, i uintptr) {
+	for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+		dst := tab.group(t, seq.offset)
+		match := dst.matchEmpty()
+		if match == 0 {
+			continue
+		}
+		j := match.first()
+		if t.indirectkey {
+			*(*unsafe.Pointer)(dst.key(t, j)) = *(*unsafe.Pointer)(b.key(t, i))
+		} else {
+			typedmemmove(t.key, dst.key(t, j), b.key(t, i))
+		}
+		if t.indirectvalue {
+			*(*unsafe.Pointer)(dst.value(t, j)) = *(*unsafe.Pointer)(b.value(t, i))
 		} else {
-			// This is the last preallocated overflow bucket.
-			// Reset the overflow pointer on this bucket,
-			// which was set to a non-nil sentinel value.
-			ovf.setoverflow(t, nil)
-			h.extra.nextOverflow = nil
+			typedmemmove(t.elem, dst.value(t, j), b.value(t, i))
 		}
+		dst.tophash[j&(bucketCnt-1)] = ctrlFull | h2(hash)
+		tab.used++
+		tab.growthLeft--
+		return
+	}
+}
+
+// clear removes all entries from tab in place.
+func (tab *table) clear(t *maptype) {
+	clearGroups(t, tab.groups, tab.groupMask+1)
+	tab.used = 0
+	tab.growthLeft = uint16(uintptr(tab.capacity) * loadFactorNum / loadFactorDen)
+}
+
+// clearGroups zeroes n groups starting at p.
+func clearGroups(t *maptype, p unsafe.Pointer, n uintptr) {
+	size := n * uintptr(t.bucketsize)
+	if t.bucket.kind&kindNoPointers == 0 {
+		memclrHasPointers(p, size)
 	} else {
-		ovf = (*bmap)(newobject(t.bucket))
+		memclrNoHeapPointers(p, size)
+	}
+}
+
+// rehash makes room in tab, which has no growth left, by replacing
+// it with a larger table, a table of the same size without
+// tombstones, or two tables that split its entries.
+// 扩容的时候，如果不是因为负载因子进行扩容(删除的槽太多)，那么表的大小不变
+func (tab *table) rehash(t *maptype, h *hmap) {
+	capacity := uintptr(tab.capacity)
+	if uintptr(tab.used) <= capacity*loadFactorNum/loadFactorDen/2 {
+		// At least half of the growth budget went to
+		// tombstones. Reclaim them without growing.
+		tab.resize(t, h, capacity)
+		return
+	}
+	if capacity < maxTableCapacity {
+		tab.resize(t, h, 2*capacity)
+		return
+	}
+	tab.split(t, h)
+}
+
+// resize replaces tab in h's directory with a copy that has room
+// for at least capacity slots.
+// 将老的表中的数据复制到新的表中，一次性完成
+func (tab *table) resize(t *maptype, h *hmap, capacity uintptr) {
+	nt := newTable(t, capacity, tab.index, tab.localDepth)
+	nt.putAll(t, h, tab)
+	h.replaceTable(nt)
+	tab.index = -1
+}
+
+// putAll copies all of the entries of old into tab, which must have
+// room for them.
+func (tab *table) putAll(t *maptype, h *hmap, old *table) {
+	for gi := uintptr(0); gi <= old.groupMask; gi++ {
+		b := old.group(t, gi)
+		for full := b.matchFull(); full != 0; full = full.removeFirst() {
+			i := full.first()
+			tab.uncheckedPut(t, h.slotHash(t, b, i), b, i)
+		}
+	}
+}
+
+// shrink releases memory after a deletion from tab. It must be
+// called after h.count and tab.used have been updated.
+func (tab *table) shrink(t *maptype, h *hmap) {
+	if h.count == 0 {
+		// Start over as a small map. Changing clearSeq ends
+		// iterations in progress, since no entry they could
+		// still return is left.
+		h.buckets = nil
+		h.B = 0
+		h.flags &^= hashDirectory
+		h.clearSeq++
+		return
+	}
+	merged := false
+	for tab.localDepth > 0 {
+		buddy := h.buddy(tab)
+		if buddy.localDepth != tab.localDepth || uintptr(tab.used)+uintptr(buddy.used) >= maxTableCapacity/mergeLoadDen {
+			// The buddy has split further, or the two
+			// together are still too full.
+			break
+		}
+		// 和buddy合并，合并之后的表可能还可以和它的buddy合并
+		tab = h.mergeTables(t, tab, buddy)
+		merged = true
+	}
+	if merged {
+		h.shrinkDirectory()
+		return
+	}
+	if tab.capacity > 2*bucketCnt && uintptr(tab.used) < uintptr(tab.capacity)/shrinkLoadDen {
+		tab.resize(t, h, uintptr(tab.used)*2*loadFactorDen/loadFactorNum)
+	}
+}
+
+// split replaces tab in h's directory with two tables, each holding
+// the entries for one value of the next bit of the hash.
+func (tab *table) split(t *maptype, h *hmap) {
+	localDepth := tab.localDepth + 1
+	left := newTable(t, maxTableCapacity, -1, localDepth)
+	right := newTable(t, maxTableCapacity, -1, localDepth)
+	mask := uintptr(1) << (sys.PtrSize*8 - localDepth)
+	for gi := uintptr(0); gi <= tab.groupMask; gi++ {
+		b := tab.group(t, gi)
+		for full := b.matchFull(); full != 0; full = full.removeFirst() {
+			i := full.first()
+			hash := h.slotHash(t, b, i)
+			// left表示的是hash的下一位是0的元素，right表示的是下一位是1的元素
+			if hash&mask == 0 {
+				left.uncheckedPut(t, hash, b, i)
+			} else {
+				right.uncheckedPut(t, hash, b, i)
+			}
+		}
+	}
+	h.installTableSplit(tab, left, right)
+	tab.index = -1
+}
+
+// slotHash returns the hash of the key in slot i of b.
+// 计算hash的值
+func (h *hmap) slotHash(t *maptype, b *bmap, i uintptr) uintptr {
+	k := b.key(t, i)
+	if t.indirectkey {
+		k = *((*unsafe.Pointer)(k))
+	}
+	return t.key.alg.hash(k, uintptr(h.hash0))
+}
+
+// tableAt returns directory entry i.
+func (h *hmap) tableAt(i uintptr) *table {
+	return *(**table)(add(h.buckets, i*sys.PtrSize))
+}
+
+// tableFor returns the table that holds keys with the given hash.
+func (h *hmap) tableFor(hash uintptr) *table {
+	if h.B == 0 {
+		return *(**table)(h.buckets)
 	}
-	h.incrnoverflow()
-	if t.bucket.kind&kindNoPointers != 0 {
-		h.createOverflow()
-		*h.extra.overflow = append(*h.extra.overflow, ovf)
+	return h.tableAt(hash >> (sys.PtrSize*8 - h.B))
+}
+
+// replaceTable points all of the directory entries for nt.index
+// and nt.localDepth at nt.
+func (h *hmap) replaceTable(nt *table) {
+	entries := uintptr(1) << (h.B - nt.localDepth)
+	for i := uintptr(0); i < entries; i++ {
+		*(**table)(add(h.buckets, (uintptr(nt.index)+i)*sys.PtrSize)) = nt
 	}
-	b.setoverflow(t, ovf)
-	return ovf
 }
 
-func (h *hmap) createOverflow() {
-	if h.extra == nil {
-		h.extra = new(mapextra)
+// installTableSplit replaces old in the directory with left and
+// right, growing the directory if old is already selected by all
+// B bits.
+func (h *hmap) installTableSplit(old, left, right *table) {
+	if old.localDepth == h.B {
+		n := uintptr(1) << h.B
+		dir := make([]*table, 2*n)
+		for i := uintptr(0); i < n; i++ {
+			tab := h.tableAt(i)
+			dir[2*i] = tab
+			dir[2*i+1] = tab
+			// A table may appear in several entries; only
+			// update its index at the first.
+			if uintptr(tab.index) == i {
+				tab.index = int(2 * i)
+			}
+		}
+		h.buckets = unsafe.Pointer(&dir[0])
+		h.B++
 	}
-	if h.extra.overflow == nil {
-		h.extra.overflow = new([]*bmap)
+	left.index = old.index
+	h.replaceTable(left)
+	right.index = left.index + 1<<(h.B-right.localDepth)
+	h.replaceTable(right)
+}
+
+// buddy returns the table selected by the same hash bits as tab
+// except the last of its localDepth bits, which must be at least 1.
+func (h *hmap) buddy(tab *table) *table {
+	return h.tableAt(uintptr(tab.index) ^ 1<<(h.B-tab.localDepth))
+}
+
+// mergeTables replaces tab and its buddy in h's directory with a
+// single table holding the entries of both, and returns it.
+// 把两个表合并成一个，和split相反
+func (h *hmap) mergeTables(t *maptype, tab, buddy *table) *table {
+	index := tab.index
+	if buddy.index < index {
+		index = buddy.index
+	}
+	used := uintptr(tab.used) + uintptr(buddy.used)
+	nt := newTable(t, used*2*loadFactorDen/loadFactorNum, index, tab.localDepth-1)
+	nt.putAll(t, h, tab)
+	nt.putAll(t, h, buddy)
+	h.replaceTable(nt)
+	tab.index = -1
+	buddy.index = -1
+	return nt
+}
+
+// shrinkDirectory halves h's directory for as long as no table is
+// selected by all B bits, so that every table still appears in it.
+// 所有表的localDepth都小于B的时候目录减半
+func (h *hmap) shrinkDirectory() {
+	for h.B > 0 {
+		n := uintptr(1) << h.B
+		for i := uintptr(0); i < n; {
+			tab := h.tableAt(i)
+			if tab.localDepth == h.B {
+				return
+			}
+			i += 1 << (h.B - tab.localDepth)
+		}
+		dir := make([]*table, n/2)
+		for i := range dir {
+			tab := h.tableAt(2 * uintptr(i))
+			dir[i] = tab
+			// Every run of entries has even length and
+			// starts at an even index.
+			if tab.index == 2*i {
+				tab.index = i
+			}
+		}
+		h.buckets = unsafe.Pointer(&dir[0])
+		h.B--
+	}
+}
+
+// growToTable converts the full group of a small map into a
+// directory with a single table.
+func (h *hmap) growToTable(t *maptype) {
+	b := (*bmap)(h.buckets)
+	tab := newTable(t, 2*bucketCnt, 0, 0)
+	for full := b.matchFull(); full != 0; full = full.removeFirst() {
+		i := full.first()
+		tab.uncheckedPut(t, h.slotHash(t, b, i), b, i)
+	}
+	dir := make([]*table, 1)
+	dir[0] = tab
+	h.buckets = unsafe.Pointer(&dir[0])
+	h.B = 0
+	h.flags |= hashDirectory
+}
+
+// find returns the group and slot holding key, whose hash is hash.
+// h must not be empty.
+func (h *hmap) find(t *maptype, hash uintptr, key unsafe.Pointer) (*bmap, uintptr, bool) {
+	// 取出hash的低7位
+	top := h2(hash)
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
+		i, ok := b.lookup(t, top, key)
+		return b, i, ok
+	}
+	// 通过hash的高B位得到表
+	tab := h.tableFor(hash)
+	// 循环遍历探测序列中的组
+	for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+		b := tab.group(t, seq.offset)
+		if i, ok := b.lookup(t, top, key); ok {
+			return b, i, true
+		}
+		// 组中有空槽的时候说明key不存在
+		if b.matchEmpty() != 0 {
+			return nil, 0, false
+		}
 	}
 }
 
@@ -307,7 +771,7 @@ func makemap_small() *hmap {
 // If the compiler has determined that the map or the first bucket
 // can be created on the stack, h and/or bucket may be non-nil.
 // If h != nil, the map can be created directly in h.
-// If h.buckets != nil, bucket pointed to can be used as the first bucket.
+// If h.buckets != nil, bucket pointed to can be used as the small map group.
 func makemap(t *maptype, hint int, h *hmap) *hmap {
 	if hint < 0 || hint > int(maxSliceCap(t.bucket.size)) {
 		hint = 0
@@ -319,89 +783,37 @@ func makemap(t *maptype, hint int, h *hmap) *hmap {
 	}
 	h.hash0 = fastrand()
 
-	// find size parameter which will hold the requested # of elements
+	// Small maps allocate their group lazily in mapassign.
+	if hint <= bucketCnt {
+		return h
+	}
+
+	// Size the directory so that hint entries fit without
+	// growing. If hint is large zeroing this memory could take
+	// a while.
+	// 确定初始化的时候目录的大小
+	capacity := uintptr(hint) * loadFactorDen / loadFactorNum
 	B := uint8(0)
-	// 确定初始化的时候buckets的大小
-	for overLoadFactor(hint, B) {
+	for capacity > maxTableCapacity<<B {
 		B++
 	}
-	h.B = B
-
-	// allocate initial hash table
-	// if B == 0, the buckets field is allocated lazily later (in mapassign)
-	// If hint is large zeroing this memory could take a while.
-	if h.B != 0 {
-		var nextOverflow *bmap
-		// 给buckets分配内存
-		h.buckets, nextOverflow = makeBucketArray(t, h.B, nil)
-		if nextOverflow != nil {
-			h.extra = new(mapextra)
-			h.extra.nextOverflow = nextOverflow
-		}
+	n := uintptr(1) << B
+	dir := make([]*table, n)
+	for i := range dir {
+		dir[i] = newTable(t, capacity/n, i, B)
 	}
-
+	h.buckets = unsafe.Pointer(&dir[0])
+	h.B = B
+	h.flags |= hashDirectory
 	return h
 }
 
-// makeBucketArray initializes a backing array for map buckets.
-// 初始化一个backets
-// 1<<b is the minimum number of buckets to allocate.
-// dirtyalloc should either be nil or a bucket array previously allocated by makeBucketArray with the same t and b parameters.
-// If dirtyalloc is nil a new backing array will be alloced and otherwise dirtyalloc will be cleared and reused as backing array.
-func makeBucketArray(t *maptype, b uint8, dirtyalloc unsafe.Pointer) (buckets unsafe.Pointer, nextOverflow *bmap) {
-	base := bucketShift(b)
-	nbuckets := base
-	// For small b, overflow buckets are unlikely.
-	// Avoid the overhead of the calculation.
-	// 如果b小于4的时候就不需要进行计算了，因为不会溢出
-	if b >= 4 {
-		// 这个里面就是检查malloc可分配的内存和需要分配的内存是否一致
-		// Add on the estimated number of overflow buckets
-		// required to insert the median number of elements
-		// used with this value of b.
-		nbuckets += bucketShift(b - 4)
-		sz := t.bucket.size * nbuckets
-		up := roundupsize(sz)
-		if up != sz {
-			nbuckets = up / t.bucket.size
-		}
-	}
-	// 如果这个是新的array就直接分配，如果有老的桶就需要
-	if dirtyalloc == nil {
-		buckets = newarray(t.bucket, int(nbuckets))
-	} else {
-		// dirtyalloc was previously generated by
-		// the above newarray(t.bucket, int(nbuckets))
-		// but may not be empty.
-		buckets = dirtyalloc
-		size := t.bucket.size * nbuckets
-		// 主要是区别是否是指针类型
-		if t.bucket.kind&kindNoPointers == 0 {
-			memclrHasPointers(buckets, size)
-		} else {
-			memclrNoHeapPointers(buckets, size)
-		}
-	}
-	// 如果需要分配的和实际分配的不相等则会分配一下溢出的buckets
-	if base != nbuckets {
-		// We preallocated some overflow buckets.
-		// To keep the overhead of tracking these overflow buckets to a minimum,
-		// we use the convention that if a preallocated overflow bucket's overflow pointer is nil,
-		// then there are more available by bumping the pointer.
-		// We need a safe non-nil pointer for the last overflow bucket; just use buckets.
-		nextOverflow = (*bmap)(add(buckets, base*uintptr(t.bucketsize)))
-		last := (*bmap)(add(buckets, (nbuckets-1)*uintptr(t.bucketsize)))
-		last.setoverflow(t, (*bmap)(buckets))
-	}
-	return buckets, nextOverflow
-}
-
 // mapaccess1 returns a pointer to h[key].  Never returns nil, instead
 // it will return a reference to the zero object for the value type if
 // the key is not in the map.
+// 总是会返回一个值，如果没有就返回初始值
 // NOTE: The returned pointer may keep the whole map live, so don't
 // hold onto it for very long.
-// 总是会返回一个值，如果没有就返回初始值
 func mapaccess1(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	if raceenabled && h != nil {
 		callerpc := getcallerpc()
@@ -418,43 +830,18 @@ func mapaccess1(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	if h.flags&hashWriting != 0 {
 		throw("concurrent map read and map write")
 	}
-	alg := t.key.alg
-	hash := alg.hash(key, uintptr(h.hash0))
-	m := bucketMask(h.B)
-	b := (*bmap)(add(h.buckets, (hash&m)*uintptr(t.bucketsize)))
-	if c := h.oldbuckets; c != nil {
-		if !h.sameSizeGrow() {
-			// There used to be half as many buckets; mask down one more power of two.
-			m >>= 1
-		}
-		oldb := (*bmap)(add(c, (hash&m)*uintptr(t.bucketsize)))
-		if !evacuated(oldb) {
-			b = oldb
-		}
+	// 获取hash值，通过key去获取值
+	b, i, ok := h.find(t, t.key.alg.hash(key, uintptr(h.hash0)), key)
+	if !ok {
+		return unsafe.Pointer(&zeroVal[0])
 	}
-	top := tophash(hash)
-	for ; b != nil; b = b.overflow(t) {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if b.tophash[i] != top {
-				continue
-			}
-			k := add(unsafe.Pointer(b), dataOffset+i*uintptr(t.keysize))
-			if t.indirectkey {
-				k = *((*unsafe.Pointer)(k))
-			}
-			if alg.equal(key, k) {
-				v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+i*uintptr(t.valuesize))
-				if t.indirectvalue {
-					v = *((*unsafe.Pointer)(v))
-				}
-				return v
-			}
-		}
+	v := b.value(t, i)
+	if t.indirectvalue {
+		v = *((*unsafe.Pointer)(v))
 	}
-	return unsafe.Pointer(&zeroVal[0])
+	return v
 }
 
-// 通过key去获取值
 func mapaccess2(t *maptype, h *hmap, key unsafe.Pointer) (unsafe.Pointer, bool) {
 	if raceenabled && h != nil {
 		callerpc := getcallerpc()
@@ -471,47 +858,15 @@ func mapaccess2(t *maptype, h *hmap, key unsafe.Pointer) (unsafe.Pointer, bool)
 	if h.flags&hashWriting != 0 {
 		throw("concurrent map read and map write")
 	}
-	alg := t.key.alg
-	// 获取hash值
-	hash := alg.hash(key, uintptr(h.hash0))
-	m := bucketMask(h.B) //通过b计算要获取hash的后面几位
-	//通过计算得到bucket，
-	b := (*bmap)(unsafe.Pointer(uintptr(h.buckets) + (hash&m)*uintptr(t.bucketsize)))
-	// 当map正在增长的，需要在oldbuckets里查找
-	if c := h.oldbuckets; c != nil {
-		if !h.sameSizeGrow() {
-			// There used to be half as many buckets; mask down one more power of two.
-			m >>= 1
-		}
-		oldb := (*bmap)(unsafe.Pointer(uintptr(c) + (hash&m)*uintptr(t.bucketsize)))
-		if !evacuated(oldb) {
-			b = oldb
-		}
+	b, i, ok := h.find(t, t.key.alg.hash(key, uintptr(h.hash0)), key)
+	if !ok {
+		return unsafe.Pointer(&zeroVal[0]), false
 	}
-	// 取出hash的前八位
-	top := tophash(hash)
-	// 循环遍历bmap
-	for ; b != nil; b = b.overflow(t) {
-		//下面的这个循环是遍历bmap(桶)中的八对key/value中的key值
-		for i := uintptr(0); i < bucketCnt; i++ {
-			// 要是前八位不相同就直接跳过,到下一个bmap(桶)中
-			if b.tophash[i] != top {
-				continue
-			}
-			k := add(unsafe.Pointer(b), dataOffset+i*uintptr(t.keysize))
-			if t.indirectkey {
-				k = *((*unsafe.Pointer)(k))
-			}
-			if alg.equal(key, k) { // 比较全部的hash是否一致
-				v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+i*uintptr(t.valuesize))
-				if t.indirectvalue {
-					v = *((*unsafe.Pointer)(v))
-				}
-				return v, true
-			}
-		}
+	v := b.value(t, i)
+	if t.indirectvalue {
+		v = *((*unsafe.Pointer)(v))
 	}
-	return unsafe.Pointer(&zeroVal[0]), false
+	return v, true
 }
 
 // returns both key and value. Used by map iterator
@@ -520,40 +875,11 @@ func mapaccessK(t *maptype, h *hmap, key unsafe.Pointer) (unsafe.Pointer, unsafe
 	if h == nil || h.count == 0 {
 		return nil, nil
 	}
-	alg := t.key.alg
-	hash := alg.hash(key, uintptr(h.hash0))
-	m := bucketMask(h.B)
-	b := (*bmap)(unsafe.Pointer(uintptr(h.buckets) + (hash&m)*uintptr(t.bucketsize)))
-	if c := h.oldbuckets; c != nil {
-		if !h.sameSizeGrow() {
-			// There used to be half as many buckets; mask down one more power of two.
-			m >>= 1
-		}
-		oldb := (*bmap)(unsafe.Pointer(uintptr(c) + (hash&m)*uintptr(t.bucketsize)))
-		if !evacuated(oldb) {
-			b = oldb
-		}
-	}
-	top := tophash(hash)
-	for ; b != nil; b = b.overflow(t) {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if b.tophash[i] != top {
-				continue
-			}
-			k := add(unsafe.Pointer(b), dataOffset+i*uintptr(t.keysize))
-			if t.indirectkey {
-				k = *((*unsafe.Pointer)(k))
-			}
-			if alg.equal(key, k) {
-				v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+i*uintptr(t.valuesize))
-				if t.indirectvalue {
-					v = *((*unsafe.Pointer)(v))
-				}
-				return k, v
-			}
-		}
+	b, i, ok := h.find(t, t.key.alg.hash(key, uintptr(h.hash0)), key)
+	if !ok {
+		return nil, nil
 	}
-	return nil, nil
+	return b.entry(t, i)
 }
 
 func mapaccess1_fat(t *maptype, h *hmap, key, zero unsafe.Pointer) unsafe.Pointer {
@@ -574,7 +900,7 @@ func mapaccess2_fat(t *maptype, h *hmap, key, zero unsafe.Pointer) (unsafe.Point
 
 // Like mapaccess, but allocates a slot for the key if it is not present in the map.
 // 将数据put进map
-func mapassign(mapType *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
+func mapassign(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 	if h == nil {
 		panic(plainError("assignment to entry in nil map"))
 	}
@@ -582,113 +908,110 @@ func mapassign(mapType *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer {
 		callerpc := getcallerpc()
 		pc := funcPC(mapassign)
 		racewritepc(unsafe.Pointer(h), callerpc, pc)
-		raceReadObjectPC(mapType.key, key, callerpc, pc)
+		raceReadObjectPC(t.key, key, callerpc, pc)
 	}
 	if msanenabled {
-		msanread(key, mapType.key.size)
+		msanread(key, t.key.size)
 	}
 	if h.flags&hashWriting != 0 {
 		throw("concurrent map writes")
 	}
-	alg := mapType.key.alg
+	alg := t.key.alg
 	// 获取key的hash值
 	hash := alg.hash(key, uintptr(h.hash0))
+	top := h2(hash)
 
 	// Set hashWriting after calling alg.hash, since alg.hash may panic,
 	// in which case we have not actually done a write.
-	// 设置标志位，表示当前的map正在写，不是并发安全的存在的意义在哪里
+	// 设置标志位，表示当前的map正在写，不是并发安全的
 	h.flags |= hashWriting
+
 	// 当buckets为nil是重新分配
 	if h.buckets == nil {
-		h.buckets = newobject(mapType.bucket) // newarray(mapType.bucket, 1)
+		h.buckets = newobject(t.bucket) // newarray(t.bucket, 1)
 	}
 
-again:
-	bucket := hash & bucketMask(h.B) // 取到key所对应的桶的位置
-	if h.growing() {                 // 判断map是否正在增长
-		growWork(mapType, h, bucket)
-	}
-	// b表示的是这个key应该存的那个bmap
-	currentBMap := (*bmap)(unsafe.Pointer(uintptr(h.buckets) + bucket*uintptr(mapType.bucketsize)))
-	// 获取hash的前八位
-	top := tophash(hash)
-
-	var inserti *uint8         // 用于保存tophash中的值，即桶中的格子
-	var insertk unsafe.Pointer // 可以放key
-	var val unsafe.Pointer     // 可以放value
-	for {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if currentBMap.tophash[i] != top {
-				//Todo：这个判断是用来做什么？
-				if currentBMap.tophash[i] == empty && inserti == nil {
-					inserti = &currentBMap.tophash[i]
-					insertk = add(unsafe.Pointer(currentBMap), dataOffset+i*uintptr(mapType.keysize))
-					val = add(unsafe.Pointer(currentBMap), dataOffset+bucketCnt*uintptr(mapType.keysize)+i*uintptr(mapType.valuesize))
-				}
-				continue
-			}
-			k := add(unsafe.Pointer(currentBMap), dataOffset+i*uintptr(mapType.keysize))
-			if mapType.indirectkey {
-				k = *((*unsafe.Pointer)(k))
-			}
-			if !alg.equal(key, k) {
-				continue
-			}
+	var val unsafe.Pointer
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
+		if i, ok := b.lookup(t, top, key); ok {
 			// already have a mapping for key. Update it.
 			// 对应的key中有值时就更新这个值
-			if mapType.needkeyupdate {
-				typedmemmove(mapType.key, k, key)
+			if t.needkeyupdate {
+				k := b.key(t, i)
+				if t.indirectkey {
+					k = *((*unsafe.Pointer)(k))
+				}
+				typedmemmove(t.key, k, key)
 			}
-			val = add(unsafe.Pointer(currentBMap), dataOffset+bucketCnt*uintptr(mapType.keysize)+i*uintptr(mapType.valuesize))
+			val = b.value(t, i)
 			goto done
 		}
-		// 当前的bmap中如果没有了溢出桶时就跳出循环，则表示在bmap中没有空位
-		ovf := currentBMap.overflow(mapType)
-		if ovf == nil {
-			break
+		if h.count < bucketCnt {
+			// Small maps never have tombstones, so there is
+			// an empty slot.
+			val = b.insert(t, b.matchEmpty().first(), top, key)
+			h.count++
+			goto done
 		}
-		currentBMap = ovf
+		// 小map满了，转换成有一个表的目录
+		h.growToTable(t)
 	}
 
-	// Did not find mapping for key. Allocate new cell & add entry.
-
-	// If we hit the max load factor or we have too many overflow buckets,
-	// and we're not already in the middle of growing, start growing.
-	// 判断当前的map没有扩容过并且负载因子过大或者有太多的的溢出桶，进行map增长
-	if !h.growing() && (overLoadFactor(h.count+1, h.B) || tooManyOverflowBuckets(h.noverflow, h.B)) {
-		hashGrow(mapType, h)
-		// map增长后再次尝试存放key和value
-		goto again // Growing the table invalidates everything, so try again
-	}
-	//表示所有的
-	if inserti == nil {
-		// all current buckets are full, allocate a new one.
-		newb := h.newoverflow(mapType, currentBMap)
-		inserti = &newb.tophash[0]
-		insertk = add(unsafe.Pointer(newb), dataOffset)
-		val = add(insertk, bucketCnt*uintptr(mapType.keysize))
-	}
+again:
+	{
+		tab := h.tableFor(hash)
+		var delb *bmap
+		var deli uintptr
+		for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+			b := tab.group(t, seq.offset)
+			if i, ok := b.lookup(t, top, key); ok {
+				if t.needkeyupdate {
+					k := b.key(t, i)
+					if t.indirectkey {
+						k = *((*unsafe.Pointer)(k))
+					}
+					typedmemmove(t.key, k, key)
+				}
+				val = b.value(t, i)
+				goto done
+			}
+			match := b.matchEmpty()
+			if match == 0 {
+				// Remember the first tombstone to reuse it
+				// if the key is not in the table.
+				if delb == nil {
+					if del := b.matchDeleted(); del != 0 {
+						delb, deli = b, del.first()
+					}
+				}
+				continue
+			}
 
-	// store new key/value at insert position
-	if mapType.indirectkey {
-		kmem := newobject(mapType.key)
-		*(*unsafe.Pointer)(insertk) = kmem
-		insertk = kmem
-	}
-	if mapType.indirectvalue {
-		vmem := newobject(mapType.elem)
-		*(*unsafe.Pointer)(val) = vmem
+			// Did not find mapping for key. Allocate new cell & add entry.
+			i := match.first()
+			if delb != nil {
+				b, i = delb, deli
+			} else if tab.growthLeft == 0 {
+				// 表中没有可用的空槽了，表增长后再次尝试存放key和value
+				tab.rehash(t, h)
+				goto again // Growing the table invalidates everything, so try again
+			} else {
+				tab.growthLeft--
+			}
+			val = b.insert(t, i, top, key)
+			tab.used++
+			h.count++
+			goto done
+		}
 	}
-	typedmemmove(mapType.key, insertk, key)
-	*inserti = top
-	h.count++
 
 done:
 	if h.flags&hashWriting == 0 {
 		throw("concurrent map writes")
 	}
 	h.flags &^= hashWriting
-	if mapType.indirectvalue {
+	if t.indirectvalue {
 		val = *((*unsafe.Pointer)(val))
 	}
 	return val
@@ -713,55 +1036,33 @@ func mapdelete(t *maptype, h *hmap, key unsafe.Pointer) {
 
 	alg := t.key.alg
 	hash := alg.hash(key, uintptr(h.hash0))
+	top := h2(hash)
 
 	// Set hashWriting after calling alg.hash, since alg.hash may panic,
 	// in which case we have not actually done a write (delete).
 	h.flags |= hashWriting
-	// 取出key的后面的B位
-	bucket := hash & bucketMask(h.B)
-	if h.growing() {
-		growWork(t, h, bucket)
-	}
-	// 取出对应的bmap
-	b := (*bmap)(add(h.buckets, bucket*uintptr(t.bucketsize)))
-	// 取出hash的前八位
-	top := tophash(hash)
-search:
-	for ; b != nil; b = b.overflow(t) {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if b.tophash[i] != top {
-				continue
-			}
-			// 遍历判断key是否相等
-			k := add(unsafe.Pointer(b), dataOffset+i*uintptr(t.keysize))
-			k2 := k
-			if t.indirectkey {
-				k2 = *((*unsafe.Pointer)(k2))
-			}
-			if !alg.equal(key, k2) {
-				continue
-			}
-			// Only clear key if there are pointers in it.
-			// 当键是指针的时候需要将键清除
-			if t.indirectkey {
-				//不包含指针的处理
-				*(*unsafe.Pointer)(k) = nil
-			} else if t.key.kind&kindNoPointers == 0 {
-				// key中包含指针时的处理
-				memclrHasPointers(k, t.key.size)
+
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
+		if i, ok := b.lookup(t, top, key); ok {
+			b.clearSlot(t, i)
+			b.tophash[i&(bucketCnt-1)] = ctrlEmpty
+			h.count--
+		}
+	} else {
+		tab := h.tableFor(hash)
+		for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+			b := tab.group(t, seq.offset)
+			if i, ok := b.lookup(t, top, key); ok {
+				b.clearSlot(t, i)
+				tab.remove(b, i)
+				h.count--
+				tab.shrink(t, h)
+				break
 			}
-			// 获取value
-			v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+i*uintptr(t.valuesize))
-			if t.indirectvalue {
-				*(*unsafe.Pointer)(v) = nil
-			} else if t.elem.kind&kindNoPointers == 0 {
-				memclrHasPointers(v, t.elem.size)
-			} else {
-				memclrNoHeapPointers(v, t.elem.size)
+			if b.matchEmpty() != 0 {
+				break
 			}
-			b.tophash[i] = empty
-			h.count--
-			break search
 		}
 	}
 
@@ -772,10 +1073,10 @@ search:
 }
 
 // mapiterinit initializes the hiter struct used for ranging over maps.
+// 初始化map的迭代器
 // The hiter struct pointed to by 'it' is allocated on the stack
 // by the compilers order pass or on the heap by reflect_mapiterinit.
 // Both need to have zeroed hiter since the struct contains pointers.
-// 初始化map的迭代器
 func mapiterinit(t *maptype, h *hmap, it *hiter) {
 	if raceenabled && h != nil {
 		callerpc := getcallerpc()
@@ -791,41 +1092,30 @@ func mapiterinit(t *maptype, h *hmap, it *hiter) {
 	}
 	it.t = t
 	it.h = h
-
-	// grab snapshot of bucket state
-	it.B = h.B
-	it.buckets = h.buckets
-	if t.bucket.kind&kindNoPointers != 0 {
-		// 主要的作用是为了使得map在扩容的时候，遍历也不受影响
-		// Allocate the current slice and remember pointers to both current and old.
-		// This preserves all relevant overflow buckets alive even if
-		// the table grows and/or overflow buckets are added to the table while we are iterating.
-		h.createOverflow()
-		it.overflow = h.extra.overflow
-		it.oldoverflow = h.extra.oldoverflow
-	}
+	it.clearSeq = h.clearSeq
 
 	// decide where to start
 	// 决定map从哪里开始，使用的是随机的值
 	r := uintptr(fastrand())
-	if h.B > 31-bucketCntBits {
+	if h.flags&hashDirectory != 0 {
 		r += uintptr(fastrand()) << 31
 	}
-	it.startBucket = r & bucketMask(h.B)
-	it.offset = uint8(r >> h.B & (bucketCnt - 1))
+	it.offsets = r
 
-	// iterator state
-	it.bucket = it.startBucket
-	// 记住当前的map有一个iterator了，可以和其他的iterator同时进行
-	// Remember we have an iterator.
-	// Can run concurrently with another mapiterinit().
-	if old := h.flags; old&(iterator|oldIterator) != iterator|oldIterator {
-		atomic.Or8(&h.flags, iterator|oldIterator)
+	if h.flags&hashDirectory == 0 {
+		// Keep the group even if the map grows, so iteration
+		// can continue over the entries it had.
+		// 主要的作用是为了使得map在扩容的时候，遍历也不受影响
+		it.group = (*bmap)(h.buckets)
+		it.dirIdx = -1
+	} else {
+		it.globalDepth = h.B
 	}
-	// 获取下一个
+
 	mapiternext(it)
 }
 
+// 获取下一个
 func mapiternext(it *hiter) {
 	h := it.h
 	if raceenabled {
@@ -836,123 +1126,183 @@ func mapiternext(it *hiter) {
 		throw("concurrent map iteration and map write")
 	}
 	t := it.t
-	bucket := it.bucket
-	b := it.bptr
-	i := it.i
-	checkBucket := it.checkBucket
-	alg := t.key.alg
 
-next:
-	if b == nil {
-		// 说明迭代已经结束了
-		if bucket == it.startBucket && it.wrapped {
-			// end of iteration
-			it.key = nil
-			it.value = nil
-			return
-		}
-		// 表示迭代和增长同时发生
-		if h.growing() && it.B == h.B {
-			// Iterator was started in the middle of a grow, and the grow isn't done yet.
-			// If the bucket we're looking at hasn't been filled in yet (i.e. the old bucket hasn't been evacuated)
-			// then we need to iterate through the old bucket and only return the ones that will be migrated to this bucket.
-			oldbucket := bucket & it.h.oldbucketmask()
-			b = (*bmap)(add(h.oldbuckets, oldbucket*uintptr(t.bucketsize)))
-			if !evacuated(b) {
-				checkBucket = bucket
+	if it.dirIdx < 0 {
+		// Small map.
+		b := it.group
+		for ; it.entryIdx < bucketCnt; it.entryIdx++ {
+			i := (uintptr(it.entryIdx) + it.offsets) & (bucketCnt - 1)
+			if b.tophash[i]&ctrlFull == 0 {
+				continue
+			}
+			var k, v unsafe.Pointer
+			if h.buckets != unsafe.Pointer(b) {
+				// The map has grown out of b since
+				// iteration started.
+				// 表示迭代和增长同时发生
+				k, v = it.grownEntry(b, i)
+				if k == nil {
+					continue
+				}
 			} else {
-				b = (*bmap)(add(it.buckets, bucket*uintptr(t.bucketsize)))
-				checkBucket = noCheck
+				k, v = b.entry(t, i)
 			}
-		} else {
-			b = (*bmap)(add(it.buckets, bucket*uintptr(t.bucketsize)))
-			checkBucket = noCheck
-		}
-		bucket++
-		if bucket == bucketShift(it.B) {
-			bucket = 0
-			it.wrapped = true
+			it.entryIdx++
+			it.key = k
+			it.value = v
+			return
 		}
-		i = 0
+		// end of iteration
+		// 说明迭代已经结束了
+		it.key = nil
+		it.value = nil
+		return
 	}
-	for ; i < bucketCnt; i++ {
-		offi := (i + it.offset) & (bucketCnt - 1)
-		if b.tophash[offi] == empty || b.tophash[offi] == evacuatedEmpty {
-			continue
-		}
-		k := add(unsafe.Pointer(b), dataOffset+uintptr(offi)*uintptr(t.keysize))
-		if t.indirectkey {
-			k = *((*unsafe.Pointer)(k))
-		}
-		v := add(unsafe.Pointer(b), dataOffset+bucketCnt*uintptr(t.keysize)+uintptr(offi)*uintptr(t.valuesize))
-		if checkBucket != noCheck && !h.sameSizeGrow() {
-			// Special case: iterator was started during a grow to a larger size
-			// and the grow is not done yet. We're working on a bucket whose
-			// oldbucket has not been evacuated yet. Or at least, it wasn't
-			// evacuated when we started the bucket. So we're iterating
-			// through the oldbucket, skipping any keys that will go
-			// to the other new bucket (each oldbucket expands to two
-			// buckets during a grow).
-			if t.reflexivekey || alg.equal(k, k) {
-				// If the item in the oldbucket is not destined for
-				// the current new bucket in the iteration, skip it.
-				hash := alg.hash(k, uintptr(h.hash0))
-				if hash&bucketMask(it.B) != checkBucket {
-					continue
+
+	if it.clearSeq != h.clearSeq {
+		// The map has been cleared or has emptied and
+		// dropped its directory. Any entries it has now were
+		// added during iteration, so they need not be
+		// returned.
+		it.key = nil
+		it.value = nil
+		return
+	}
+
+	if it.globalDepth < h.B {
+		// The directory has grown past any size seen so far.
+		// Each entry became 1<<shift entries, so scale the
+		// current position and the offset to match.
+		shift := h.B - it.globalDepth
+		it.dirIdx <<= shift
+		it.offsets = (it.offsets>>entryOffsetBits)<<shift<<entryOffsetBits | it.offsets&(1<<entryOffsetBits-1)
+		it.globalDepth = h.B
+	}
+	// If the directory has shrunk instead, keep counting positions
+	// in it.globalDepth bits, so none of them are lost, and select
+	// the directory entry with their top h.B bits.
+	// 目录变小的时候迭代器的位置不变，用位置的高B位来选择目录中的表
+	shift := it.globalDepth - h.B
+	dirLen := uintptr(1) << it.globalDepth
+	for ; uintptr(it.dirIdx) < dirLen; it.nextTable() {
+		if it.tab == nil {
+			i := (uintptr(it.dirIdx) + it.offsets>>entryOffsetBits) & (dirLen - 1)
+			tab := h.tableAt(i >> shift)
+			start := uintptr(tab.index) << shift
+			run := uintptr(1) << (it.globalDepth - tab.localDepth)
+			if it.dirIdx == 0 {
+				if start != i {
+					// The random starting entry is in the
+					// middle of the run of entries for tab.
+					// Move the offset back to the start of
+					// the run so that the entries after it
+					// are not visited again.
+					it.offsets -= (i - start) << entryOffsetBits
 				}
 			} else {
-				// Hash isn't repeatable if k != k (NaNs).  We need a
-				// repeatable and randomish choice of which direction
-				// to send NaNs during evacuation. We'll use the low
-				// bit of tophash to decide which way NaNs go.
-				// NOTE: this case is why we need two evacuate tophash
-				// values, evacuatedX and evacuatedY, that differ in
-				// their low bit.
-				if checkBucket>>(it.B-1) != uintptr(b.tophash[offi]&1) {
+				// Tables merged since iteration started may
+				// run from before the current position, or
+				// wrap around past the end to entries visited
+				// first. Their entries for those positions
+				// have already been returned.
+				// 合并之后的表可能包含已经遍历过的位置的元素，需要跳过
+				it.filter = start != i || uintptr(it.dirIdx)+run > dirLen
+			}
+			it.tab = tab
+		}
+		// Keep using it.tab even if it has been replaced: it
+		// has all of the entries the replacement started with.
+		tab := it.tab
+		mask := uintptr(tab.capacity) - 1
+		for ; uintptr(it.entryIdx) <= mask; it.entryIdx++ {
+			e := (uintptr(it.entryIdx) + it.offsets) & mask
+			b := tab.group(t, e>>bucketCntBits)
+			i := e & (bucketCnt - 1)
+			if b.tophash[i]&ctrlFull == 0 || it.filter && it.passed(b, i) {
+				continue
+			}
+			var k, v unsafe.Pointer
+			if tab.index < 0 {
+				k, v = it.grownEntry(b, i)
+				if k == nil {
 					continue
 				}
+			} else {
+				k, v = b.entry(t, i)
 			}
-		}
-		if (b.tophash[offi] != evacuatedX && b.tophash[offi] != evacuatedY) ||
-			!(t.reflexivekey || alg.equal(k, k)) {
-			// This is the golden data, we can return it.
-			// OR
-			// key!=key, so the entry can't be deleted or updated, so we can just return it.
-			// That's lucky for us because when key!=key we can't look it up successfully.
+			it.entryIdx++
 			it.key = k
-			if t.indirectvalue {
-				v = *((*unsafe.Pointer)(v))
-			}
 			it.value = v
-		} else {
-			// The hash table has grown since the iterator was started.
-			// The golden data for this key is now somewhere else.
-			// Check the current hash table for the data.
-			// This code handles the case where the key
-			// has been deleted, updated, or deleted and reinserted.
-			// NOTE: we need to regrab the key as it has potentially been
-			// updated to an equal() but not identical key (e.g. +0.0 vs -0.0).
-			rk, rv := mapaccessK(t, h, k)
-			if rk == nil {
-				continue // key has been deleted
-			}
-			it.key = rk
-			it.value = rv
-		}
-		it.bucket = bucket
-		if it.bptr != b { // avoid unnecessary write barrier; see issue 14921
-			it.bptr = b
+			return
 		}
-		it.i = i + 1
-		it.checkBucket = checkBucket
-		return
 	}
-	b = b.overflow(t)
-	i = 0
-	goto next
+	// end of iteration
+	it.key = nil
+	it.value = nil
+}
+
+// nextTable advances it past the rest of the directory entries for
+// it.tab. If it.tab has been split since, this skips the entries
+// for both halves, whose entries it.tab already had. If it.tab has
+// been merged since, this stops at the end of its half.
+func (it *hiter) nextTable() {
+	// Runs of entries are aligned to their length, so the end of
+	// the run follows from the current position alone.
+	i := (uintptr(it.dirIdx) + it.offsets>>entryOffsetBits) & (1<<it.globalDepth - 1)
+	run := uintptr(1) << (it.globalDepth - it.tab.localDepth)
+	it.dirIdx += int(run - i&(run-1))
+	it.tab = nil
+	it.filter = false
+	it.entryIdx = 0
+}
+
+// passed reports whether the key in slot i of b is selected by a
+// directory position it has already passed. Keys that are not equal
+// to themselves have no stable hash and are never reported as
+// passed, so such a key may be returned twice if its table is merged
+// during iteration.
+func (it *hiter) passed(b *bmap, i uintptr) bool {
+	t := it.t
+	k := b.key(t, i)
+	if t.indirectkey {
+		k = *((*unsafe.Pointer)(k))
+	}
+	if !t.reflexivekey && !t.key.alg.equal(k, k) {
+		return false
+	}
+	hash := t.key.alg.hash(k, uintptr(it.h.hash0))
+	pos := (hash>>(sys.PtrSize*8-it.globalDepth) - it.offsets>>entryOffsetBits) & (1<<it.globalDepth - 1)
+	return pos < uintptr(it.dirIdx)
+}
+
+// grownEntry returns the current key and value for the entry in
+// slot i of b, a group the map no longer uses. It returns nil, nil
+// if the entry has since been deleted.
+func (it *hiter) grownEntry(b *bmap, i uintptr) (unsafe.Pointer, unsafe.Pointer) {
+	t := it.t
+	k, v := b.entry(t, i)
+	// The golden data for this key is now somewhere else.
+	// Check the current hash table for the data.
+	// This code handles the case where the key
+	// has been deleted, updated, or deleted and reinserted.
+	// NOTE: we need to regrab the key as it has potentially been
+	// updated to an equal() but not identical key (e.g. +0.0 vs -0.0).
+	if rk, rv := mapaccessK(t, it.h, k); rk != nil {
+		return rk, rv
+	}
+	// key!=key can't be looked up, but it also can't be deleted
+	// or updated, only cleared. Unless the map has been cleared,
+	// the entry is still as it was in b.
+	// 如果相同的key每次算出的hash的值不同(只有在float类型的NaN时存在)，
+	// 这个key是找不到的，在map没有被清空的时候需要将这个key-value返回，不能丢掉
+	if it.clearSeq == it.h.clearSeq && !(t.reflexivekey || t.key.alg.equal(k, k)) {
+		return k, v
+	}
+	return nil, nil
 }
 
 // mapclear deletes all keys from a map.
+// 清空map
 func mapclear(t *maptype, h *hmap) {
 	if raceenabled && h != nil {
 		callerpc := getcallerpc()
@@ -970,26 +1320,20 @@ func mapclear(t *maptype, h *hmap) {
 
 	h.flags |= hashWriting
 
-	h.flags &^= sameSizeGrow
-	h.oldbuckets = nil
-	h.nevacuate = 0
-	h.noverflow = 0
-	h.count = 0
-
-	// Keep the mapextra allocation but clear any extra information.
-	if h.extra != nil {
-		*h.extra = mapextra{}
-	}
-
-	// makeBucketArray clears the memory pointed to by h.buckets
-	// and recovers any overflow buckets by generating them
-	// as if h.buckets was newly alloced.
-	_, nextOverflow := makeBucketArray(t, h.B, h.buckets)
-	if nextOverflow != nil {
-		// If overflow buckets are created then h.extra
-		// will have been allocated during initial bucket creation.
-		h.extra.nextOverflow = nextOverflow
+	// Clear groups in place, keeping their memory for reuse.
+	// Iterators see the empty slots and stop returning entries.
+	if h.flags&hashDirectory == 0 {
+		clearGroups(t, h.buckets, 1)
+	} else {
+		n := uintptr(1) << h.B
+		for i := uintptr(0); i < n; {
+			tab := h.tableAt(i)
+			tab.clear(t)
+			i += uintptr(1) << (h.B - tab.localDepth)
+		}
 	}
+	h.count = 0
+	h.clearSeq++
 
 	if h.flags&hashWriting == 0 {
 		throw("concurrent map writes")
@@ -997,272 +1341,6 @@ func mapclear(t *maptype, h *hmap) {
 	h.flags &^= hashWriting
 }
 
-// 这个方法只是将内存分配了，但是还没有进行数据的移动，数据的移动是渐进式的
-func hashGrow(t *maptype, h *hmap) {
-	// If we've hit the load factor, get bigger.
-	// Otherwise, there are too many overflow buckets,
-	// so keep the same number of buckets and "grow" laterally.
-	// 表示要扩容的大小
-	bigger := uint8(1)
-	// 如果不是因为负载因子进行扩容，那么桶的数量不变
-	if !overLoadFactor(h.count+1, h.B) {
-		bigger = 0
-		h.flags |= sameSizeGrow
-	}
-	oldbuckets := h.buckets
-	newbuckets, nextOverflow := makeBucketArray(t, h.B+bigger, nil)
-	//先对iterator和olditerator的对应为清零，当iterator位为1是将这个值给oldIterator
-	// 因为要把buckets转到oldbuckets中因此标志位也要过去
-	flags := h.flags &^ (iterator | oldIterator)
-	if h.flags&iterator != 0 {
-		flags |= oldIterator
-	}
-	// commit the grow (atomic wrt gc)
-	h.B += bigger
-	h.flags = flags
-	h.oldbuckets = oldbuckets
-	h.buckets = newbuckets
-	h.nevacuate = 0
-	h.noverflow = 0
-	// 将当前的桶赋值给老的桶，因为当前map在增长
-	if h.extra != nil && h.extra.overflow != nil {
-		// Promote current overflow buckets to the old generation.
-		if h.extra.oldoverflow != nil {
-			throw("oldoverflow is not nil")
-		}
-		h.extra.oldoverflow = h.extra.overflow
-		h.extra.overflow = nil
-	}
-	if nextOverflow != nil {
-		if h.extra == nil {
-			h.extra = new(mapextra)
-		}
-		h.extra.nextOverflow = nextOverflow
-	}
-
-	// the actual copying of the hash table data is done incrementally
-	// by growWork() and evacuate().
-}
-
-// overLoadFactor reports whether count items placed in 1<<B buckets is over loadFactor(有效负载).
-func overLoadFactor(count int, B uint8) bool {
-	return count > bucketCnt && uintptr(count) > loadFactorNum*(bucketShift(B)/loadFactorDen)
-}
-
-// tooManyOverflowBuckets reports whether noverflow buckets is too many for a map with 1<<B buckets.
-// Note that most of these overflow buckets must be in sparse use;
-// if use was dense, then we'd have already triggered regular map growth.
-func tooManyOverflowBuckets(noverflow uint16, B uint8) bool {
-	// If the threshold is too low, we do extraneous work.
-	// If the threshold is too high, maps that grow and shrink can hold on to lots of unused memory.
-	// "too many" means (approximately) as many overflow buckets as regular buckets.
-	// See incrnoverflow for more details.
-	if B > 15 {
-		B = 15
-	}
-	// The compiler doesn't see here that B < 16; mask B to generate shorter shift code.
-	return noverflow >= uint16(1)<<(B&15)
-}
-
-// growing reports whether h is growing. The growth may be to the same size or bigger.
-func (h *hmap) growing() bool {
-	return h.oldbuckets != nil
-}
-
-// sameSizeGrow reports whether the current growth is to a map of the same size.
-func (h *hmap) sameSizeGrow() bool {
-	return h.flags&sameSizeGrow != 0
-}
-
-// noldbuckets calculates the number of buckets prior to the current map growth.
-func (h *hmap) noldbuckets() uintptr {
-	oldB := h.B
-	if !h.sameSizeGrow() {
-		oldB--
-	}
-	return bucketShift(oldB)
-}
-
-// oldbucketmask provides a mask that can be applied to calculate n % noldbuckets().
-func (h *hmap) oldbucketmask() uintptr {
-	return h.noldbuckets() - 1
-}
-
-func growWork(t *maptype, h *hmap, bucket uintptr) {
-	// make sure we evacuate the oldbucket corresponding
-	// to the bucket we're about to use
-	evacuate(t, h, bucket&h.oldbucketmask())
-
-	// evacuate one more oldbucket to make progress on growing
-	if h.growing() {
-		evacuate(t, h, h.nevacuate)
-	}
-}
-
-func bucketEvacuated(t *maptype, h *hmap, bucket uintptr) bool {
-	b := (*bmap)(add(h.oldbuckets, bucket*uintptr(t.bucketsize)))
-	return evacuated(b)
-}
-
-// evacDst is an evacuation destination.
-type evacDst struct {
-	b *bmap // current destination bucket 表示目的地桶
-	i int   // key/val index into b  key/value在b中的索引
-	// 分别保存当前的key和value
-	k unsafe.Pointer // pointer to current key storage
-	v unsafe.Pointer // pointer to current value storage
-}
-
-func evacuate(t *maptype, h *hmap, oldbucket uintptr) {
-	oldBMap := (*bmap)(add(h.oldbuckets, oldbucket*uintptr(t.bucketsize)))
-	newbit := h.noldbuckets() // 为了取出最后的一个bmap, 结果是2^B
-	if !evacuated(oldBMap) {
-		// TODO: reuse overflow buckets instead of using new ones, if there is no iterator using the old buckets.  (If !oldIterator.)
-
-		// LowAndHighDst contains the lowDst and y (low and high) evacuation destinations.
-		var LowAndHighDst [2]evacDst
-		// x表示的是低地址的元素
-		lowDst := &LowAndHighDst[0]
-		lowDst.b = (*bmap)(add(h.buckets, oldbucket*uintptr(t.bucketsize)))
-		lowDst.k = add(unsafe.Pointer(lowDst.b), dataOffset)
-		lowDst.v = add(lowDst.k, bucketCnt*uintptr(t.keysize))
-
-		if !h.sameSizeGrow() {
-			// Only calculate highDst pointers if we're growing bigger.
-			// Otherwise GC can see bad pointers.
-			// y表示的高地址的元素
-			highDst := &LowAndHighDst[1]
-			highDst.b = (*bmap)(add(h.buckets, (oldbucket+newbit)*uintptr(t.bucketsize)))
-			highDst.k = add(unsafe.Pointer(highDst.b), dataOffset)
-			highDst.v = add(highDst.k, bucketCnt*uintptr(t.keysize))
-		}
-		// 循环变量bmap
-		for ; oldBMap != nil; oldBMap = oldBMap.overflow(t) {
-			// 取出key和value的值
-			k := add(unsafe.Pointer(oldBMap), dataOffset)
-			v := add(k, bucketCnt*uintptr(t.keysize))
-			// 将bmap格子中的值取出， 即遍历bmap中的tophash的值
-			for i := 0; i < bucketCnt; i, k, v = i+1, add(k, uintptr(t.keysize)), add(v, uintptr(t.valuesize)) {
-				top := oldBMap.tophash[i]
-				if top == empty {
-					oldBMap.tophash[i] = evacuatedEmpty
-					continue
-				}
-				if top < minTopHash {
-					throw("bad map state")
-				}
-				k2 := k
-				if t.indirectkey {
-					k2 = *((*unsafe.Pointer)(k2))
-				}
-				var useY uint8
-				if !h.sameSizeGrow() {
-					// Compute hash to make our evacuation decision
-					// (whether we need to send this key/value to bucket lowDst or bucket y).
-					// 获取新的hash值
-					hash := t.key.alg.hash(k2, uintptr(h.hash0))
-					// 如果相同的key每次算出的hash的值不同， 只有在float类型key时存在
-					// 这个里面说的float类型是IEEE 754这个
-					if h.flags&iterator != 0 && !t.reflexivekey && !t.key.alg.equal(k2, k2) {
-						// If key != key (NaNs), then the hash could be (and probably will be) entirely different from the old hash.
-						// 如果在获取key的hash的时候，出现了相同的key计算出不同的hash
-						// Moreover, it isn't reproducible(不可复制的).
-						// 这个是每次计算都不一样的
-						// Reproducibility(再现) is required in the presence of iterators,
-						// as our evacuation decision must match whatever decision the iterator made.
-						// 当这个key要是想再次被找到就需要对map进行遍历，在转移的时候不能将这个key-value丢掉
-						// Fortunately, we have the freedom to send these keys either way.
-						// 幸运的是我们可以随机的放这些key
-						// Also, tophash is meaningless for these kinds of keys.
-						// tophash对于这些key是没有意义的
-						// We let the low bit of tophash drive the evacuation decision.
-						// 通过tophash的最低位来决定这个key-value应该被放在x区还是Y区（xDst或者yDst）
-						// We recompute a new random tophash for the next level so these keys will get
-						// evenly distributed across all buckets after multiple grows.
-						// 使用新生成的tophash值可以，让这些key分配到多个桶中去
-						useY = top & 1
-						top = tophash(hash)
-					} else {
-						if hash&newbit != 0 {
-							useY = 1
-						}
-					}
-				}
-
-				if evacuatedX+1 != evacuatedY {
-					throw("bad evacuatedN")
-				}
-
-				oldBMap.tophash[i] = evacuatedX + useY // evacuatedX + 1 == evacuatedY
-				// 看将数据具体放到xDst中还是yDst中
-				dst := &LowAndHighDst[useY] // evacuation destination
-				// 如果bmap的格子满了就放到，溢出桶中
-				if dst.i == bucketCnt {
-					dst.b = h.newoverflow(t, dst.b)
-					dst.i = 0
-					dst.k = add(unsafe.Pointer(dst.b), dataOffset)
-					dst.v = add(dst.k, bucketCnt*uintptr(t.keysize))
-				}
-				dst.b.tophash[dst.i&(bucketCnt-1)] = top // mask dst.i as an optimization, to avoid a bounds check
-				// 将key和value复制到新的桶中
-				if t.indirectkey {
-					*(*unsafe.Pointer)(dst.k) = k2 // copy pointer
-				} else {
-					typedmemmove(t.key, dst.k, k) // copy value
-				}
-				if t.indirectvalue {
-					*(*unsafe.Pointer)(dst.v) = *(*unsafe.Pointer)(v)
-				} else {
-					typedmemmove(t.elem, dst.v, v)
-				}
-				dst.i++
-				// These updates might push these pointers past the end of the key or value arrays.
-				// That's ok, as we have the overflow pointer at the end of the bucket to protect against pointing past the end of the bucket.
-				dst.k = add(dst.k, uintptr(t.keysize))
-				dst.v = add(dst.v, uintptr(t.valuesize))
-			}
-		}
-		// Unlink the overflow buckets & clear key/value to help GC.
-		if h.flags&oldIterator == 0 && t.bucket.kind&kindNoPointers == 0 {
-			b := add(h.oldbuckets, oldbucket*uintptr(t.bucketsize))
-			// Preserve oldBMap.tophash because the evacuation
-			// state is maintained there.
-			ptr := add(b, dataOffset)
-			n := uintptr(t.bucketsize) - dataOffset
-			memclrHasPointers(ptr, n)
-		}
-	}
-
-	if oldbucket == h.nevacuate {
-		advanceEvacuationMark(h, t, newbit)
-	}
-}
-
-// 更新迁移的进度
-func advanceEvacuationMark(h *hmap, t *maptype, newbit uintptr) {
-	h.nevacuate++
-	// Experiments suggest that 1024 is overkill by at least an order of magnitude.
-	// Put it in there as a safeguard anyway, to ensure O(1) behavior.
-	stop := h.nevacuate + 1024
-	if stop > newbit {
-		stop = newbit
-	}
-	for h.nevacuate != stop && bucketEvacuated(t, h, h.nevacuate) {
-		h.nevacuate++
-	}
-	if h.nevacuate == newbit { // newbit == # of oldbuckets
-		// Growing is all done. Free old main bucket array.
-		h.oldbuckets = nil
-		// Can discard old overflow buckets as well.
-		// If they are still referenced by an iterator,
-		// then the iterator holds a pointers to the slice.
-		if h.extra != nil {
-			h.extra.oldoverflow = nil
-		}
-		h.flags &^= sameSizeGrow
-	}
-}
-
 func ismapkey(t *_type) bool {
 	return t.alg.hash != nil
 }
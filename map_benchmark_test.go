@@ -370,3 +370,75 @@ func BenchmarkGoMapClear(b *testing.B) {
 		}
 	})
 }
+
+// Lookups in maps of various sizes, for keys that are and aren't
+// present. Large maps don't fit in cache, so these mostly measure
+// how many cache lines a lookup touches.
+func BenchmarkMapLookup(b *testing.B) {
+	for _, size := range []int{6, 64, 1 << 10, 1 << 16, 1 << 20} {
+		b.Run("Int64Hit/"+strconv.Itoa(size), func(b *testing.B) {
+			m := make(map[int64]int64)
+			for i := 0; i < size; i++ {
+				m[int64(i)] = int64(i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = m[int64(i%size)]
+			}
+		})
+		b.Run("Int64Miss/"+strconv.Itoa(size), func(b *testing.B) {
+			m := make(map[int64]int64)
+			for i := 0; i < size; i++ {
+				m[int64(i)] = int64(i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = m[int64(size+i)]
+			}
+		})
+		b.Run("StringHit/"+strconv.Itoa(size), func(b *testing.B) {
+			keys := make([]string, size)
+			m := make(map[string]int)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%d", i)
+				m[keys[i]] = i
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = m[keys[i%size]]
+			}
+		})
+		b.Run("StringMiss/"+strconv.Itoa(size), func(b *testing.B) {
+			keys := make([]string, size)
+			m := make(map[string]int)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%d", i)
+				m[keys[i]] = i
+			}
+			miss := make([]string, size)
+			for i := range miss {
+				miss[i] = fmt.Sprintf("miss%d", i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = m[miss[i%size]]
+			}
+		})
+	}
+}
+
+// Growing a map from empty. With tables growing independently, no
+// single insert copies more than one table's worth of entries.
+func BenchmarkMapGrow(b *testing.B) {
+	for _, size := range []int{1 << 10, 1 << 16, 1 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m := make(map[int64]int64)
+				for j := 0; j < size; j++ {
+					m[int64(j)] = int64(j)
+				}
+			}
+		})
+	}
+}
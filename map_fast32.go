@@ -5,7 +5,6 @@
 package runtime
 
 import (
-	"runtime/internal/sys"
 	"unsafe"
 )
 
@@ -20,33 +19,31 @@ func mapaccess1_fast32(t *maptype, h *hmap, key uint32) unsafe.Pointer {
 	if h.flags&hashWriting != 0 {
 		throw("concurrent map read and map write")
 	}
-	var b *bmap
-	if h.B == 0 {
-		// One-bucket table. No need to hash.
-		b = (*bmap)(h.buckets)
-	} else {
-		hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
-		m := bucketMask(h.B)
-		b = (*bmap)(add(h.buckets, (hash&m)*uintptr(t.bucketsize)))
-		if c := h.oldbuckets; c != nil {
-			if !h.sameSizeGrow() {
-				// There used to be half as many buckets; mask down one more power of two.
-				m >>= 1
-			}
-			oldb := (*bmap)(add(c, (hash&m)*uintptr(t.bucketsize)))
-			if !evacuated(oldb) {
-				b = oldb
+	if h.flags&hashDirectory == 0 {
+		// Small map. No need to hash.
+		b := (*bmap)(h.buckets)
+		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
+			if *(*uint32)(k) == key && b.tophash[i]&ctrlFull != 0 {
+				return add(unsafe.Pointer(b), dataOffset+bucketCnt*4+i*uintptr(t.valuesize))
 			}
 		}
+		return unsafe.Pointer(&zeroVal[0])
 	}
-	for ; b != nil; b = b.overflow(t) {
-		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
-			if *(*uint32)(k) == key && b.tophash[i] != empty {
+	hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
+	top := h2(hash)
+	tab := h.tableFor(hash)
+	for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+		b := tab.group(t, seq.offset)
+		for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+			i := match.first()
+			if *(*uint32)(add(unsafe.Pointer(b), dataOffset+i*4)) == key {
 				return add(unsafe.Pointer(b), dataOffset+bucketCnt*4+i*uintptr(t.valuesize))
 			}
 		}
+		if b.matchEmpty() != 0 {
+			return unsafe.Pointer(&zeroVal[0])
+		}
 	}
-	return unsafe.Pointer(&zeroVal[0])
 }
 
 func mapaccess2_fast32(t *maptype, h *hmap, key uint32) (unsafe.Pointer, bool) {
@@ -60,33 +57,31 @@ func mapaccess2_fast32(t *maptype, h *hmap, key uint32) (unsafe.Pointer, bool) {
 	if h.flags&hashWriting != 0 {
 		throw("concurrent map read and map write")
 	}
-	var b *bmap
-	if h.B == 0 {
-		// One-bucket table. No need to hash.
-		b = (*bmap)(h.buckets)
-	} else {
-		hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
-		m := bucketMask(h.B)
-		b = (*bmap)(add(h.buckets, (hash&m)*uintptr(t.bucketsize)))
-		if c := h.oldbuckets; c != nil {
-			if !h.sameSizeGrow() {
-				// There used to be half as many buckets; mask down one more power of two.
-				m >>= 1
-			}
-			oldb := (*bmap)(add(c, (hash&m)*uintptr(t.bucketsize)))
-			if !evacuated(oldb) {
-				b = oldb
+	if h.flags&hashDirectory == 0 {
+		// Small map. No need to hash.
+		b := (*bmap)(h.buckets)
+		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
+			if *(*uint32)(k) == key && b.tophash[i]&ctrlFull != 0 {
+				return add(unsafe.Pointer(b), dataOffset+bucketCnt*4+i*uintptr(t.valuesize)), true
 			}
 		}
+		return unsafe.Pointer(&zeroVal[0]), false
 	}
-	for ; b != nil; b = b.overflow(t) {
-		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
-			if *(*uint32)(k) == key && b.tophash[i] != empty {
+	hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
+	top := h2(hash)
+	tab := h.tableFor(hash)
+	for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+		b := tab.group(t, seq.offset)
+		for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+			i := match.first()
+			if *(*uint32)(add(unsafe.Pointer(b), dataOffset+i*4)) == key {
 				return add(unsafe.Pointer(b), dataOffset+bucketCnt*4+i*uintptr(t.valuesize)), true
 			}
 		}
+		if b.matchEmpty() != 0 {
+			return unsafe.Pointer(&zeroVal[0]), false
+		}
 	}
-	return unsafe.Pointer(&zeroVal[0]), false
 }
 
 func mapassign_fast32(t *maptype, h *hmap, key uint32) unsafe.Pointer {
@@ -101,6 +96,7 @@ func mapassign_fast32(t *maptype, h *hmap, key uint32) unsafe.Pointer {
 		throw("concurrent map writes")
 	}
 	hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
+	top := h2(hash)
 
 	// Set hashWriting after calling alg.hash for consistency with mapassign.
 	h.flags |= hashWriting
@@ -109,61 +105,67 @@ func mapassign_fast32(t *maptype, h *hmap, key uint32) unsafe.Pointer {
 		h.buckets = newobject(t.bucket) // newarray(t.bucket, 1)
 	}
 
-again:
-	bucket := hash & bucketMask(h.B)
-	if h.growing() {
-		growWork_fast32(t, h, bucket)
-	}
-	b := (*bmap)(unsafe.Pointer(uintptr(h.buckets) + bucket*uintptr(t.bucketsize)))
-
 	var insertb *bmap
 	var inserti uintptr
-	var insertk unsafe.Pointer
 
-	for {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if b.tophash[i] == empty {
-				if insertb == nil {
-					inserti = i
-					insertb = b
-				}
-				continue
-			}
-			k := *((*uint32)(add(unsafe.Pointer(b), dataOffset+i*4)))
-			if k != key {
-				continue
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
+		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
+			if *(*uint32)(k) == key && b.tophash[i]&ctrlFull != 0 {
+				insertb, inserti = b, i
+				goto done
 			}
-			inserti = i
-			insertb = b
-			goto done
 		}
-		ovf := b.overflow(t)
-		if ovf == nil {
-			break
+		if h.count < bucketCnt {
+			insertb, inserti = b, b.matchEmpty().first()
+			goto insert
 		}
-		b = ovf
+		h.growToTable(t)
 	}
 
-	// Did not find mapping for key. Allocate new cell & add entry.
-
-	// If we hit the max load factor or we have too many overflow buckets,
-	// and we're not already in the middle of growing, start growing.
-	if !h.growing() && (overLoadFactor(h.count+1, h.B) || tooManyOverflowBuckets(h.noverflow, h.B)) {
-		hashGrow(t, h)
-		goto again // Growing the table invalidates everything, so try again
-	}
+again:
+	{
+		tab := h.tableFor(hash)
+		var delb *bmap
+		var deli uintptr
+		for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+			b := tab.group(t, seq.offset)
+			for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+				i := match.first()
+				if *(*uint32)(add(unsafe.Pointer(b), dataOffset+i*4)) == key {
+					insertb, inserti = b, i
+					goto done
+				}
+			}
+			match := b.matchEmpty()
+			if match == 0 {
+				if delb == nil {
+					if del := b.matchDeleted(); del != 0 {
+						delb, deli = b, del.first()
+					}
+				}
+				continue
+			}
 
-	if insertb == nil {
-		// all current buckets are full, allocate a new one.
-		insertb = h.newoverflow(t, b)
-		inserti = 0 // not necessary, but avoids needlessly spilling inserti
+			// Did not find mapping for key. Allocate new cell & add entry.
+			insertb, inserti = b, match.first()
+			if delb != nil {
+				insertb, inserti = delb, deli
+			} else if tab.growthLeft == 0 {
+				tab.rehash(t, h)
+				goto again // Growing the table invalidates everything, so try again
+			} else {
+				tab.growthLeft--
+			}
+			tab.used++
+			goto insert
+		}
 	}
-	insertb.tophash[inserti&(bucketCnt-1)] = tophash(hash) // mask inserti to avoid bounds checks
 
-	insertk = add(unsafe.Pointer(insertb), dataOffset+inserti*4)
+insert:
+	insertb.tophash[inserti&(bucketCnt-1)] = ctrlFull | top // mask inserti to avoid bounds checks
 	// store new key at insert position
-	*(*uint32)(insertk) = key
-
+	*(*uint32)(add(unsafe.Pointer(insertb), dataOffset+inserti*4)) = key
 	h.count++
 
 done:
@@ -187,6 +189,7 @@ func mapassign_fast32ptr(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer
 		throw("concurrent map writes")
 	}
 	hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
+	top := h2(hash)
 
 	// Set hashWriting after calling alg.hash for consistency with mapassign.
 	h.flags |= hashWriting
@@ -195,61 +198,67 @@ func mapassign_fast32ptr(t *maptype, h *hmap, key unsafe.Pointer) unsafe.Pointer
 		h.buckets = newobject(t.bucket) // newarray(t.bucket, 1)
 	}
 
-again:
-	bucket := hash & bucketMask(h.B)
-	if h.growing() {
-		growWork_fast32(t, h, bucket)
-	}
-	b := (*bmap)(unsafe.Pointer(uintptr(h.buckets) + bucket*uintptr(t.bucketsize)))
-
 	var insertb *bmap
 	var inserti uintptr
-	var insertk unsafe.Pointer
 
-	for {
-		for i := uintptr(0); i < bucketCnt; i++ {
-			if b.tophash[i] == empty {
-				if insertb == nil {
-					inserti = i
-					insertb = b
-				}
-				continue
-			}
-			k := *((*unsafe.Pointer)(add(unsafe.Pointer(b), dataOffset+i*4)))
-			if k != key {
-				continue
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
+		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
+			if *(*unsafe.Pointer)(k) == key && b.tophash[i]&ctrlFull != 0 {
+				insertb, inserti = b, i
+				goto done
 			}
-			inserti = i
-			insertb = b
-			goto done
 		}
-		ovf := b.overflow(t)
-		if ovf == nil {
-			break
+		if h.count < bucketCnt {
+			insertb, inserti = b, b.matchEmpty().first()
+			goto insert
 		}
-		b = ovf
+		h.growToTable(t)
 	}
 
-	// Did not find mapping for key. Allocate new cell & add entry.
-
-	// If we hit the max load factor or we have too many overflow buckets,
-	// and we're not already in the middle of growing, start growing.
-	if !h.growing() && (overLoadFactor(h.count+1, h.B) || tooManyOverflowBuckets(h.noverflow, h.B)) {
-		hashGrow(t, h)
-		goto again // Growing the table invalidates everything, so try again
-	}
+again:
+	{
+		tab := h.tableFor(hash)
+		var delb *bmap
+		var deli uintptr
+		for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+			b := tab.group(t, seq.offset)
+			for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+				i := match.first()
+				if *(*unsafe.Pointer)(add(unsafe.Pointer(b), dataOffset+i*4)) == key {
+					insertb, inserti = b, i
+					goto done
+				}
+			}
+			match := b.matchEmpty()
+			if match == 0 {
+				if delb == nil {
+					if del := b.matchDeleted(); del != 0 {
+						delb, deli = b, del.first()
+					}
+				}
+				continue
+			}
 
-	if insertb == nil {
-		// all current buckets are full, allocate a new one.
-		insertb = h.newoverflow(t, b)
-		inserti = 0 // not necessary, but avoids needlessly spilling inserti
+			// Did not find mapping for key. Allocate new cell & add entry.
+			insertb, inserti = b, match.first()
+			if delb != nil {
+				insertb, inserti = delb, deli
+			} else if tab.growthLeft == 0 {
+				tab.rehash(t, h)
+				goto again // Growing the table invalidates everything, so try again
+			} else {
+				tab.growthLeft--
+			}
+			tab.used++
+			goto insert
+		}
 	}
-	insertb.tophash[inserti&(bucketCnt-1)] = tophash(hash) // mask inserti to avoid bounds checks
 
-	insertk = add(unsafe.Pointer(insertb), dataOffset+inserti*4)
+insert:
+	insertb.tophash[inserti&(bucketCnt-1)] = ctrlFull | top // mask inserti to avoid bounds checks
 	// store new key at insert position
-	*(*unsafe.Pointer)(insertk) = key
-
+	*(*unsafe.Pointer)(add(unsafe.Pointer(insertb), dataOffset+inserti*4)) = key
 	h.count++
 
 done:
@@ -274,140 +283,46 @@ func mapdelete_fast32(t *maptype, h *hmap, key uint32) {
 	}
 
 	hash := t.key.alg.hash(noescape(unsafe.Pointer(&key)), uintptr(h.hash0))
+	top := h2(hash)
 
 	// Set hashWriting after calling alg.hash for consistency with mapdelete
 	h.flags |= hashWriting
 
-	bucket := hash & bucketMask(h.B)
-	if h.growing() {
-		growWork_fast32(t, h, bucket)
-	}
-	b := (*bmap)(add(h.buckets, bucket*uintptr(t.bucketsize)))
-search:
-	for ; b != nil; b = b.overflow(t) {
+	if h.flags&hashDirectory == 0 {
+		b := (*bmap)(h.buckets)
 		for i, k := uintptr(0), b.keys(); i < bucketCnt; i, k = i+1, add(k, 4) {
-			if key != *(*uint32)(k) || b.tophash[i] == empty {
+			if key != *(*uint32)(k) || b.tophash[i]&ctrlFull == 0 {
 				continue
 			}
-			// Only clear key if there are pointers in it.
-			if t.key.kind&kindNoPointers == 0 {
-				memclrHasPointers(k, t.key.size)
-			}
-			v := add(unsafe.Pointer(b), dataOffset+bucketCnt*4+i*uintptr(t.valuesize))
-			if t.elem.kind&kindNoPointers == 0 {
-				memclrHasPointers(v, t.elem.size)
-			} else {
-				memclrNoHeapPointers(v, t.elem.size)
-			}
-			b.tophash[i] = empty
+			b.clearSlot(t, i)
+			b.tophash[i] = ctrlEmpty
 			h.count--
-			break search
-		}
-	}
-
-	if h.flags&hashWriting == 0 {
-		throw("concurrent map writes")
-	}
-	h.flags &^= hashWriting
-}
-
-func growWork_fast32(t *maptype, h *hmap, bucket uintptr) {
-	// make sure we evacuate the oldbucket corresponding
-	// to the bucket we're about to use
-	evacuate_fast32(t, h, bucket&h.oldbucketmask())
-
-	// evacuate one more oldbucket to make progress on growing
-	if h.growing() {
-		evacuate_fast32(t, h, h.nevacuate)
-	}
-}
-
-func evacuate_fast32(t *maptype, h *hmap, oldbucket uintptr) {
-	b := (*bmap)(add(h.oldbuckets, oldbucket*uintptr(t.bucketsize)))
-	newbit := h.noldbuckets()
-	if !evacuated(b) {
-		// TODO: reuse overflow buckets instead of using new ones, if there
-		// is no iterator using the old buckets.  (If !oldIterator.)
-
-		// xy contains the x and y (low and high) evacuation destinations.
-		var xy [2]evacDst
-		x := &xy[0]
-		x.b = (*bmap)(add(h.buckets, oldbucket*uintptr(t.bucketsize)))
-		x.k = add(unsafe.Pointer(x.b), dataOffset)
-		x.v = add(x.k, bucketCnt*4)
-
-		if !h.sameSizeGrow() {
-			// Only calculate y pointers if we're growing bigger.
-			// Otherwise GC can see bad pointers.
-			y := &xy[1]
-			y.b = (*bmap)(add(h.buckets, (oldbucket+newbit)*uintptr(t.bucketsize)))
-			y.k = add(unsafe.Pointer(y.b), dataOffset)
-			y.v = add(y.k, bucketCnt*4)
+			break
 		}
-
-		for ; b != nil; b = b.overflow(t) {
-			k := add(unsafe.Pointer(b), dataOffset)
-			v := add(k, bucketCnt*4)
-			for i := 0; i < bucketCnt; i, k, v = i+1, add(k, 4), add(v, uintptr(t.valuesize)) {
-				top := b.tophash[i]
-				if top == empty {
-					b.tophash[i] = evacuatedEmpty
+	} else {
+		tab := h.tableFor(hash)
+	search:
+		for seq := makeProbeSeq(hash, tab.groupMask); ; seq = seq.next() {
+			b := tab.group(t, seq.offset)
+			for match := b.matchH2(top); match != 0; match = match.removeFirst() {
+				i := match.first()
+				if key != *(*uint32)(add(unsafe.Pointer(b), dataOffset+i*4)) {
 					continue
 				}
-				if top < minTopHash {
-					throw("bad map state")
-				}
-				var useY uint8
-				if !h.sameSizeGrow() {
-					// Compute hash to make our evacuation decision (whether we need
-					// to send this key/value to bucket x or bucket y).
-					hash := t.key.alg.hash(k, uintptr(h.hash0))
-					if hash&newbit != 0 {
-						useY = 1
-					}
-				}
-
-				b.tophash[i] = evacuatedX + useY // evacuatedX + 1 == evacuatedY, enforced in makemap
-				dst := &xy[useY]                 // evacuation destination
-
-				if dst.i == bucketCnt {
-					dst.b = h.newoverflow(t, dst.b)
-					dst.i = 0
-					dst.k = add(unsafe.Pointer(dst.b), dataOffset)
-					dst.v = add(dst.k, bucketCnt*4)
-				}
-				dst.b.tophash[dst.i&(bucketCnt-1)] = top // mask dst.i as an optimization, to avoid a bounds check
-
-				// Copy key.
-				if sys.PtrSize == 4 && t.key.kind&kindNoPointers == 0 && writeBarrier.enabled {
-					// Write with a write barrier.
-					*(*unsafe.Pointer)(dst.k) = *(*unsafe.Pointer)(k)
-				} else {
-					*(*uint32)(dst.k) = *(*uint32)(k)
-				}
-
-				typedmemmove(t.elem, dst.v, v)
-				dst.i++
-				// These updates might push these pointers past the end of the
-				// key or value arrays.  That's ok, as we have the overflow pointer
-				// at the end of the bucket to protect against pointing past the
-				// end of the bucket.
-				dst.k = add(dst.k, 4)
-				dst.v = add(dst.v, uintptr(t.valuesize))
+				b.clearSlot(t, i)
+				tab.remove(b, i)
+				h.count--
+				tab.shrink(t, h)
+				break search
+			}
+			if b.matchEmpty() != 0 {
+				break
 			}
-		}
-		// Unlink the overflow buckets & clear key/value to help GC.
-		if h.flags&oldIterator == 0 && t.bucket.kind&kindNoPointers == 0 {
-			b := add(h.oldbuckets, oldbucket*uintptr(t.bucketsize))
-			// Preserve b.tophash because the evacuation
-			// state is maintained there.
-			ptr := add(b, dataOffset)
-			n := uintptr(t.bucketsize) - dataOffset
-			memclrHasPointers(ptr, n)
 		}
 	}
 
-	if oldbucket == h.nevacuate {
-		advanceEvacuationMark(h, t, newbit)
+	if h.flags&hashWriting == 0 {
+		throw("concurrent map writes")
 	}
+	h.flags &^= hashWriting
 }
@@ -676,8 +676,8 @@ var mapSink map[int]int
 
 var mapBucketTests = [...]struct {
 	n        int // n is the number of map elements
-	noescape int // number of expected buckets for non-escaping map
-	escape   int // number of expected buckets for escaping map
+	noescape int // number of expected groups for non-escaping map
+	escape   int // number of expected groups for escaping map
 }{
 	{-(1 << 30), 1, 1},
 	{-1, 1, 1},
@@ -686,16 +686,17 @@ var mapBucketTests = [...]struct {
 	{8, 1, 1},
 	{9, 2, 2},
 	{13, 2, 2},
-	{14, 4, 4},
+	{14, 2, 2},
+	{15, 4, 4},
 	{26, 4, 4},
 }
 
 func TestMapBuckets(t *testing.T) {
-	// Test that maps of different sizes have the right number of buckets.
-	// Non-escaping maps with small buckets (like map[int]int) never
-	// have a nil bucket pointer due to starting with preallocated buckets
+	// Test that maps of different sizes have the right number of groups.
+	// Non-escaping maps with small groups (like map[int]int) never
+	// have a nil bucket pointer due to starting with a preallocated group
 	// on the stack. Escaping maps start with a non-nil bucket pointer if
-	// hint size is above bucketCnt and thereby have more than one bucket.
+	// hint size is above bucketCnt and thereby have more than one group.
 	// These tests depend on bucketCnt and loadFactor* in map.go.
 	t.Run("mapliteral", func(t *testing.T) {
 		for _, tt := range mapBucketTests {
@@ -800,6 +801,77 @@ func TestMapBuckets(t *testing.T) {
 
 }
 
+func TestMapShrink(t *testing.T) {
+	// Test that a map gives back most of its groups once most of
+	// its entries are deleted, and all but one once it is empty.
+	m := map[int]int{}
+	const n = 100000
+	for i := 0; i < n; i++ {
+		m[i] = i
+	}
+	peak := runtime.MapBucketsCount(m)
+	if got := runtime.MapDirectorySize(m); got < 64 {
+		t.Fatalf("with %d entries, directory has %d entries, want at least 64", n, got)
+	}
+	for i := 10; i < n; i++ {
+		delete(m, i)
+	}
+	if got := runtime.MapBucketsCount(m); got > 8 {
+		t.Errorf("after deleting all but 10 of %d entries, have %d groups of %d, want at most 8", n, got, peak)
+	}
+	// The tables should all have merged back into one.
+	if got := runtime.MapDirectorySize(m); got != 1 {
+		t.Errorf("after deleting all but 10 of %d entries, directory has %d entries, want 1", n, got)
+	}
+	for i := 0; i < 10; i++ {
+		if m[i] != i {
+			t.Errorf("m[%d] = %d, want %d", i, m[i], i)
+		}
+		delete(m, i)
+	}
+	if got := runtime.MapBucketsCount(m); got != 1 {
+		t.Errorf("after deleting all entries, have %d groups, want 1", got)
+	}
+	for i := 0; i < n; i++ {
+		m[i] = i
+	}
+	if len(m) != n {
+		t.Errorf("len(m) = %d after refilling, want %d", len(m), n)
+	}
+}
+
+func TestMapShrinkIterate(t *testing.T) {
+	// Delete most of a map while ranging over it, so that tables
+	// merge and the directory shrinks under the iterator. Every
+	// entry that is never deleted must still be seen exactly once.
+	m := map[int]int{}
+	const n = 100000
+	for i := 0; i < n; i++ {
+		m[i] = i
+	}
+	seen := make(map[int]int)
+	for k := range m {
+		seen[k]++
+		if len(seen) == 1 {
+			for i := 0; i < n; i++ {
+				if i%1000 != 0 && i != k {
+					delete(m, i)
+				}
+			}
+		}
+	}
+	for k, c := range seen {
+		if c != 1 {
+			t.Errorf("key %d seen %d times", k, c)
+		}
+	}
+	for i := 0; i < n; i += 1000 {
+		if seen[i] != 1 {
+			t.Errorf("key %d seen %d times, want 1", i, seen[i])
+		}
+	}
+}
+
 func benchmarkMapPop(b *testing.B, n int) {
 	m := map[int]int{}
 	for i := 0; i < b.N; i++ {
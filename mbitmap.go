@@ -595,6 +595,15 @@ func bulkBarrierPreWrite(dst, src, size uintptr) {
 	if !writeBarrier.needed {
 		return
 	}
+	if gcgen.remembering {
+		// Only heap cards matter between GC cycles, and
+		// clearing memory can't write a pointer to a young
+		// object.
+		if src != 0 {
+			gcCardMarkRange(dst, size)
+		}
+		return
+	}
 	if s := spanOf(dst); s == nil {
 		// If dst is a global, use the data or BSS bitmaps to
 		// execute write barriers.
@@ -718,6 +727,10 @@ func typeBitsBulkBarrier(typ *_type, dst, src, size uintptr) {
 	if !writeBarrier.needed {
 		return
 	}
+	if gcgen.remembering {
+		gcCardMarkRange(dst, size)
+		return
+	}
 	ptrmask := typ.gcdata
 	buf := &getg().m.p.ptr().wbBuf
 	var bits uint32
@@ -0,0 +1,47 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics_test
+
+import (
+	"io/ioutil"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDescriptionNameFormat(t *testing.T) {
+	for _, d := range metrics.All() {
+		i := strings.Index(d.Name, ":")
+		if !strings.HasPrefix(d.Name, "/") || i < 0 || i == len(d.Name)-1 || strings.Count(d.Name, ":") != 1 {
+			t.Errorf("metric %q has a malformed name", d.Name)
+		}
+		if d.Description == "" {
+			t.Errorf("metric %q has no description", d.Name)
+		}
+		if d.Kind == metrics.KindBad {
+			t.Errorf("metric %q has kind KindBad", d.Name)
+		}
+	}
+}
+
+func TestDescriptionsSorted(t *testing.T) {
+	all := metrics.All()
+	if !sort.SliceIsSorted(all, func(i, j int) bool { return all[i].Name < all[j].Name }) {
+		t.Error("metrics.All is not sorted by name")
+	}
+}
+
+func TestDescriptionDocs(t *testing.T) {
+	doc, err := ioutil.ReadFile("doc.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range metrics.All() {
+		if !strings.Contains(string(doc), "\n\t"+d.Name+"\n") {
+			t.Errorf("metric %q is missing from the package documentation", d.Name)
+		}
+	}
+}
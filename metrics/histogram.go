@@ -0,0 +1,27 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+// Float64Histogram represents a distribution of float64 values.
+type Float64Histogram struct {
+	// Counts contains the weights for each histogram bucket.
+	//
+	// Given N buckets, Count[n] is the weight of the range
+	// [bucket[n], bucket[n+1]), for 0 <= n < N.
+	Counts []uint64
+
+	// Buckets contains the boundaries of the histogram buckets, in
+	// increasing order.
+	//
+	// Buckets[0] is the inclusive lower bound of the minimum bucket
+	// while Buckets[len(Buckets)-1] is the exclusive upper bound of
+	// the maximum bucket. Hence, there are len(Buckets)-1 counts.
+	// Buckets[0] may be -Inf and Buckets[len(Buckets)-1] may be +Inf.
+	//
+	// For a given metric name, the value of Buckets is guaranteed
+	// not to change between calls until program exit. It is shared
+	// by every histogram for that metric and must not be modified.
+	Buckets []float64
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics_test
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+)
+
+func prepareAllMetricsSamples() []metrics.Sample {
+	all := metrics.All()
+	samples := make([]metrics.Sample, len(all))
+	for i := range all {
+		samples[i].Name = all[i].Name
+	}
+	return samples
+}
+
+func TestReadMetrics(t *testing.T) {
+	// Generate some garbage so the counters are non-trivial.
+	for i := 0; i < 1000; i++ {
+		_ = make([]byte, 64<<(i%8))
+	}
+	runtime.GC()
+
+	samples := prepareAllMetricsSamples()
+	metrics.Read(samples)
+
+	for i, d := range metrics.All() {
+		v := samples[i].Value
+		if v.Kind() != d.Kind {
+			t.Errorf("metric %q: got kind %d, want %d", d.Name, v.Kind(), d.Kind)
+			continue
+		}
+		switch v.Kind() {
+		case metrics.KindFloat64:
+			f := v.Float64()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				t.Errorf("metric %q: got %f", d.Name, f)
+			}
+		case metrics.KindFloat64Histogram:
+			h := v.Float64Histogram()
+			if len(h.Buckets) != len(h.Counts)+1 {
+				t.Errorf("metric %q: %d buckets for %d counts", d.Name, len(h.Buckets), len(h.Counts))
+				continue
+			}
+			for j := 1; j < len(h.Buckets); j++ {
+				if h.Buckets[j] <= h.Buckets[j-1] {
+					t.Errorf("metric %q: buckets not increasing at %d: %v", d.Name, j, h.Buckets[j-1:j+1])
+					break
+				}
+			}
+		}
+	}
+
+	get := func(name string) uint64 {
+		for _, s := range samples {
+			if s.Name == name {
+				return s.Value.Uint64()
+			}
+		}
+		t.Fatalf("metric %q not found", name)
+		return 0
+	}
+	if n := get("/gc/cycles/total:gc-cycles"); n < 1 {
+		t.Errorf("got %d GC cycles after runtime.GC, want at least 1", n)
+	}
+	if get("/gc/cycles/forced:gc-cycles") < 1 {
+		t.Error("runtime.GC not counted as a forced GC cycle")
+	}
+	if allocs, frees := get("/gc/heap/allocs:objects"), get("/gc/heap/frees:objects"); allocs < frees {
+		t.Errorf("more objects freed (%d) than allocated (%d)", frees, allocs)
+	}
+	if n := get("/sched/gomaxprocs:threads"); n != uint64(runtime.GOMAXPROCS(-1)) {
+		t.Errorf("got GOMAXPROCS %d, want %d", n, runtime.GOMAXPROCS(-1))
+	}
+
+	// Reading again must reuse the histograms.
+	for i := range samples {
+		if samples[i].Value.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+		h := samples[i].Value.Float64Histogram()
+		metrics.Read(samples)
+		if samples[i].Value.Float64Histogram() != h {
+			t.Errorf("metric %q: histogram not reused by a second Read", samples[i].Name)
+		}
+		break
+	}
+}
+
+func TestReadMetricsUnknown(t *testing.T) {
+	samples := []metrics.Sample{{Name: "/not/a/metric:bytes"}}
+	metrics.Read(samples)
+	if k := samples[0].Value.Kind(); k != metrics.KindBad {
+		t.Errorf("unknown metric has kind %d, want KindBad", k)
+	}
+	metrics.Read(nil)
+}
@@ -189,7 +189,9 @@ func gcinit() {
 	// This will go into computing the initial GC goal.
 	memstats.heap_marked = uint64(float64(heapminimum) / (1 + memstats.triggerRatio))
 
-	// Set gcpercent from the environment. This will also compute and set the GC trigger and goal.
+	// Set the memory limit and gcpercent from the environment.
+	// This will also compute and set the GC trigger and goal.
+	memoryLimit = readGOMEMLIMIT()
 	_ = setGCPercent(readgogc())
 
 	work.startSema = 1
@@ -211,8 +213,10 @@ func readgogc() int32 {
 // just before we're about to start letting user code run.
 // It kicks off the background sweeper goroutine and enables GC.
 func gcenable() {
-	c := make(chan int, 1)
+	c := make(chan int, 2)
 	go bgsweep(c)
+	go bgscavenge(c)
+	<-c
 	<-c
 	memstats.enablegc = true // now that runtime is initialized, GC is okay
 }
@@ -284,7 +288,7 @@ const (
 //go:nosplit
 func setGCPhase(x uint32) {
 	atomic.Store(&gcphase, x)
-	writeBarrier.needed = gcphase == _GCmark || gcphase == _GCmarktermination
+	writeBarrier.needed = gcphase == _GCmark || gcphase == _GCmarktermination || gcgen.remembering
 	writeBarrier.enabled = writeBarrier.needed || writeBarrier.cgo
 }
 
@@ -442,6 +446,9 @@ func (c *gcControllerState) startCycle() {
 	if gcpercent < 0 {
 		memstats.next_gc = ^uint64(0)
 	}
+	if goal := memoryLimitHeapGoal(); goal < memstats.next_gc {
+		memstats.next_gc = goal
+	}
 
 	// Ensure that the heap goal is at least a little larger than
 	// the current live heap size. This may not be the case if GC
@@ -487,6 +494,9 @@ func (c *gcControllerState) startCycle() {
 	// throughout the cycle.
 	c.revise()
 
+	if trace.enabled {
+		traceGCPacerStart(c)
+	}
 	if debug.gcpacertrace > 0 {
 		print("pacer: assist ratio=", c.assistWorkPerByte,
 			" (scan ", memstats.heap_scan>>20, " MB in ",
@@ -602,6 +612,11 @@ func (c *gcControllerState) endCycle() float64 {
 	// difference between this estimate and the GOGC-based goal
 	// heap growth is the error.
 	goalGrowthRatio := float64(gcpercent) / 100
+	if gcpercent < 0 || memstats.next_gc < memstats.heap_marked+memstats.heap_marked*uint64(gcpercent)/100 {
+		// The memory limit set this cycle's goal, so that's
+		// the growth the trigger should have aimed for.
+		goalGrowthRatio = float64(memstats.next_gc)/float64(memstats.heap_marked) - 1
+	}
 	actualGrowthRatio := float64(memstats.heap_live)/float64(memstats.heap_marked) - 1
 	assistDuration := nanotime() - c.markStartTime
 
@@ -618,6 +633,9 @@ func (c *gcControllerState) endCycle() float64 {
 	// damped by the proportional gain.
 	triggerRatio := memstats.triggerRatio + triggerGain*triggerError
 
+	if trace.enabled {
+		traceGCPacerEnd(goalGrowthRatio, actualGrowthRatio, utilization, c.scanWork, triggerRatio)
+	}
 	if debug.gcpacertrace > 0 {
 		// Print controller state in terms of the design
 		// document.
@@ -776,8 +794,9 @@ func pollFractionalWorkerExit() bool {
 // This can be called any time. If GC is the in the middle of a
 // concurrent phase, it will adjust the pacing of that phase.
 //
-// This depends on gcpercent, memstats.heap_marked, and
-// memstats.heap_live. These must be up to date.
+// This depends on gcpercent, memoryLimit, memstats.heap_marked,
+// memstats.heap_live, and the runtime's other memory statistics.
+// These must be up to date.
 //
 // mheap_.lock must be held or the world must be stopped.
 func gcSetTriggerRatio(triggerRatio float64) {
@@ -824,7 +843,6 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			throw("gc_trigger underflow")
 		}
 	}
-	memstats.gc_trigger = trigger
 
 	// Compute the next GC goal, which is when the allocated heap
 	// has grown by GOGC/100 over the heap marked by the last
@@ -839,9 +857,27 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			goal = trigger
 		}
 	}
+
+	// Lower the goal if the memory limit is closer. Like the
+	// GOGC trigger ratio, the trigger is capped at 95% of the way
+	// from heap_marked to the goal so the assist ratio stays
+	// finite. This overrides heapminimum and the sweep distance;
+	// staying under the limit matters more.
+	if limitGoal := memoryLimitHeapGoal(); limitGoal < goal {
+		goal = limitGoal
+		limitTrigger := goal
+		if goal > memstats.heap_marked {
+			limitTrigger = memstats.heap_marked + uint64(float64(goal-memstats.heap_marked)*0.95)
+		}
+		if limitTrigger < trigger {
+			trigger = limitTrigger
+		}
+	}
+	memstats.gc_trigger = trigger
 	memstats.next_gc = goal
 	if trace.enabled {
 		traceNextGC()
+		traceGCPacerTrigger()
 	}
 
 	// Update mark pacing.
@@ -881,6 +917,10 @@ func gcSetTriggerRatio(triggerRatio float64) {
 			atomic.Store64(&mheap_.pagesSweptBasis, pagesSwept)
 		}
 	}
+
+	// The scavenger's goal depends on the heap goal and the
+	// memory limit, so let it re-evaluate.
+	readyForScavenger()
 }
 
 // gcGoalUtilization is the goal CPU utilization for
@@ -969,8 +1009,8 @@ var work struct {
 	helperDrainBlock bool
 
 	// Number of roots of various root types. Set by gcMarkRootPrepare.
-	nFlushCacheRoots                               int
-	nDataRoots, nBSSRoots, nSpanRoots, nStackRoots int
+	nFlushCacheRoots                                           int
+	nDataRoots, nBSSRoots, nSpanRoots, nCardRoots, nStackRoots int
 
 	// markrootDone indicates that roots have been marked at least
 	// once during the current GC cycle. This is checked by root
@@ -1010,6 +1050,10 @@ var work struct {
 	// explicit user call.
 	userForced bool
 
+	// detectLeaks indicates the current GC cycle looks for leaked
+	// goroutines. See mgcleak.go.
+	detectLeaks bool
+
 	// totaltime is the CPU nanoseconds spent in GC since the
 	// program started if debug.gctrace > 0.
 	totaltime int64
@@ -1167,6 +1211,10 @@ type gcTrigger struct {
 	kind gcTriggerKind
 	now  int64  // gcTriggerTime: current time
 	n    uint32 // gcTriggerCycle: cycle number to start
+
+	// detectLeaks requests goroutine leak detection in the
+	// started cycle. It is ignored unless mode is STW.
+	detectLeaks bool
 }
 
 type gcTriggerKind int
@@ -1283,6 +1331,10 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 		}
 	}
 
+	// Leak detection relies on the whole mark phase running with
+	// the world stopped.
+	work.detectLeaks = trigger.detectLeaks && mode != gcBackgroundMode
+
 	// Ok, we're doing it! Stop everybody else
 	semacquire(&worldsema)
 
@@ -1321,6 +1373,8 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 	// reclaimed util the next GC cycle.
 	clearpools()
 
+	gcgenStart()
+
 	work.cycles++
 	if mode == gcBackgroundMode { // Do as much work concurrently as possible
 		gcController.startCycle()
@@ -1368,6 +1422,7 @@ func gcStart(mode gcMode, trigger gcTrigger) {
 			now = startTheWorldWithSema(trace.enabled)
 		})
 		work.pauseNS += now - work.pauseStart
+		memstats.gcPauseDist.record(now - work.pauseStart)
 		work.tMark = now
 	} else {
 		if trace.enabled {
@@ -1557,6 +1612,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 		}
 
 		// marking is complete so we can turn the write barrier off
+		gcgenEnd()
 		setGCPhase(_GCoff)
 		gcSweep(work.mode)
 
@@ -1593,6 +1649,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	}
 
 	// Update GC trigger and pacing for the next cycle.
+	memstats.last_next_gc = memstats.next_gc
 	gcSetTriggerRatio(nextTriggerRatio)
 
 	// Update timing memstats
@@ -1600,6 +1657,7 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	sec, nsec, _ := time_now()
 	unixNow := sec*1e9 + int64(nsec)
 	work.pauseNS += now - work.pauseStart
+	memstats.gcPauseDist.record(now - work.pauseStart)
 	work.tEnd = now
 	atomic.Store64(&memstats.last_gc_unix, uint64(unixNow)) // must be Unix time to make sense to user
 	atomic.Store64(&memstats.last_gc_nanotime, uint64(now)) // monotonic time for us
@@ -1657,7 +1715,9 @@ func gcMarkTermination(nextTriggerRatio float64) {
 	// Print gctrace before dropping worldsema. As soon as we drop
 	// worldsema another cycle could start and smash the stats
 	// we're trying to print.
-	if debug.gctrace > 0 {
+	if debug.gctrace > 0 && debug.tracejsonfd > 0 {
+		jsonTraceGC(sweepTermCpu, markTermCpu)
+	} else if debug.gctrace > 0 {
 		util := int(memstats.gc_cpu_fraction * 100)
 
 		var sbuf [24]byte
@@ -1690,6 +1750,9 @@ func gcMarkTermination(nextTriggerRatio float64) {
 		if work.userForced {
 			print(" (forced)")
 		}
+		if gcgen.minor {
+			print(" (minor)")
+		}
 		print("\n")
 		printunlock()
 	}
@@ -1878,9 +1941,11 @@ func gcBgMarkWorker(_p_ *p) {
 		case gcMarkWorkerDedicatedMode:
 			atomic.Xaddint64(&gcController.dedicatedMarkTime, duration)
 			atomic.Xaddint64(&gcController.dedicatedMarkWorkersNeeded, 1)
+			gcCPULimiter.addGCTime(duration)
 		case gcMarkWorkerFractionalMode:
 			atomic.Xaddint64(&gcController.fractionalMarkTime, duration)
 			atomic.Xaddint64(&_p_.gcFractionalMarkTime, duration)
+			gcCPULimiter.addGCTime(duration)
 		case gcMarkWorkerIdleMode:
 			atomic.Xaddint64(&gcController.idleMarkTime, duration)
 		}
@@ -1948,6 +2013,13 @@ func gcMark(start_time int64) {
 	}
 	work.tstart = start_time
 
+	// Leak detection happens during the first root marking pass,
+	// using the real mark bits.
+	detectLeaks := work.detectLeaks && !work.markrootDone && !useCheckmark
+	if detectLeaks {
+		gcLeakPrepare()
+	}
+
 	// Queue root marking jobs.
 	gcMarkRootPrepare()
 
@@ -1955,7 +2027,7 @@ func gcMark(start_time int64) {
 	work.ndone = 0
 	work.nproc = uint32(gcprocs())
 
-	if work.full == 0 && work.nDataRoots+work.nBSSRoots+work.nSpanRoots+work.nStackRoots == 0 {
+	if work.full == 0 && work.nDataRoots+work.nBSSRoots+work.nSpanRoots+work.nCardRoots+work.nStackRoots == 0 {
 		// There's no work on the work queue and no root jobs
 		// that can produce work, so don't bother entering the
 		// getfull() barrier.
@@ -2006,6 +2078,10 @@ func gcMark(start_time int64) {
 		notesleep(&work.alldone)
 	}
 
+	if detectLeaks {
+		gcFindLeaks(gcw)
+	}
+
 	// Record that at least one root marking pass has completed.
 	work.markrootDone = true
 
@@ -2021,6 +2097,12 @@ func gcMark(start_time int64) {
 		}
 	}
 
+	if gcgen.minor && !useCheckmark {
+		// The old objects were already marked, so this cycle
+		// didn't count them.
+		work.bytesMarked += gcgen.marked
+	}
+
 	cachestats()
 
 	// Update the marked heap stat.
@@ -0,0 +1,294 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Generational collection (experimental, GODEBUG=gcgen=1).
+//
+// In this mode the collector runs two kinds of cycles. A full cycle
+// marks the whole heap as usual. A minor cycle only marks the objects
+// allocated since the previous cycle, which for most programs is
+// where nearly all of the garbage is. Nothing is moved and there are
+// no separate spaces: an object's generation is its mark bit.
+//
+// Sticky mark bits. Normally, sweeping a span replaces its mark bits
+// with cleared ones. When the next cycle may be minor, the sweeper
+// instead copies the new allocation bits into the mark bits, so every
+// object that survived the cycle starts the next one already marked.
+// These are the old objects. A minor cycle never greys them, so it
+// neither scans nor frees them. Objects allocated after the span was
+// swept start out unmarked; these are the young objects.
+//
+// The remembered set. A young object may be reachable only through an
+// old one. To find these, the write barrier stays enabled between
+// cycles, but instead of shading pointers it sets a bit in a card
+// table, heapArena.cards, for the gcCardBytes "card" of heap that
+// holds the slot being written. This needs the slot address, which
+// the write barrier buffer does not record, so between cycles the
+// buffer is set up to flush on every write (see wbBuf.reset) and
+// wbBufFlush marks the card. Bulk barriers mark cards directly.
+// During a minor cycle, the card root jobs (markrootCards) scan the
+// old objects in every dirty card and grey the objects they point to.
+// A full cycle just clears the cards.
+//
+// A minor cycle starts with the old objects marked but doesn't count
+// them in work.bytesMarked, so gcMark adds the bytes marked by the
+// previous cycle, gcgen.marked, back in. The pacer therefore sees the
+// same marked heap size as it would after a full cycle, and the heap
+// goal is unchanged.
+//
+// Policy. A cycle is full if it was forced (for example, by
+// runtime.GC) or if the sweep before it didn't keep the mark bits.
+// Old objects that have died since they were promoted are only freed
+// by a full cycle, so the sweep stops keeping mark bits once minor
+// cycles have promoted more than half of the heap growth GOGC allows
+// over the marked heap of the last full cycle.
+//
+// Costs. Between cycles, every pointer write to the heap takes the
+// write barrier's slow path. In exchange, the mark work of a minor
+// cycle is proportional to the roots, the young objects that survive
+// and the dirty cards, rather than to the whole live heap.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// gcCardBytes is the size of the heap region covered by each bit of
+// the card table. It must divide pageSize.
+const gcCardBytes = 512
+
+var gcgen struct {
+	// minor indicates that the current or most recent cycle is
+	// a minor cycle.
+	minor bool
+
+	// sticky indicates that the sweeper keeps surviving objects
+	// marked, so the next cycle may be minor.
+	sticky bool
+
+	// remembering indicates that the write barrier is enabled
+	// between cycles to maintain the card table. It is only set
+	// while gcphase == _GCoff.
+	remembering bool
+
+	// marked is the number of bytes marked by the last cycle,
+	// including old objects in a minor cycle.
+	marked uint64
+
+	// fullMarked is the number of bytes marked by the last full
+	// cycle. promoted is the number of bytes of young objects
+	// that minor cycles have marked since then.
+	fullMarked uint64
+	promoted   uint64
+}
+
+// gcgenStart decides whether the cycle that is starting is minor.
+//
+// The world must be stopped, the sweep must be finished, and the mark
+// phase must not have started.
+func gcgenStart() {
+	gcgen.minor = gcgen.sticky && !work.userForced && !work.detectLeaks
+	if gcgen.sticky && !gcgen.minor {
+		// The sweep left the survivors of the last cycle
+		// marked. Start this one from cleared mark bits.
+		for _, s := range mheap_.allspans {
+			if s.state == mSpanInUse {
+				s.gcmarkBits = newMarkBits(s.nelems)
+			}
+		}
+	}
+	if gcgen.remembering {
+		// Go back to a normal write barrier.
+		gcgen.remembering = false
+		setGCPhase(_GCoff)
+		for _, p := range allp {
+			p.wbBuf.reset()
+		}
+	}
+}
+
+// gcgenEnd records the results of the cycle that just finished
+// marking and decides whether the next one may be minor.
+//
+// The world must be stopped and sweeping must not have started.
+func gcgenEnd() {
+	if gcgen.minor {
+		memstats.numminorgc++
+		gcgen.promoted += work.heap2 - gcgen.marked
+	} else {
+		gcgen.fullMarked = work.heap2
+		gcgen.promoted = 0
+	}
+	gcgen.marked = work.heap2
+
+	// gctrace=2 runs a second, full mark right after this one,
+	// which requires the sweep to clear the mark bits.
+	gcgen.sticky = debug.gcgen > 0 && gcpercent >= 0 && debug.gctrace <= 1 &&
+		gcgen.promoted <= gcgen.fullMarked/200*uint64(gcpercent)
+	gcgen.remembering = gcgen.sticky
+	if gcgen.remembering {
+		// Make every write barrier flush so wbBufFlush sees
+		// the slot.
+		for _, p := range allp {
+			p.wbBuf.reset()
+		}
+	}
+}
+
+// gcCardByte returns the byte of the card table holding the bit for
+// the card containing p, or nil if p is not in a heap arena.
+//
+//go:nosplit
+func gcCardByte(p uintptr) *uint8 {
+	ri := arenaIndex(p)
+	if arenaL1Bits == 0 {
+		if ri.l2() >= uint(len(mheap_.arenas[0])) {
+			return nil
+		}
+	} else {
+		if ri.l1() >= uint(len(mheap_.arenas)) {
+			return nil
+		}
+	}
+	l2 := mheap_.arenas[ri.l1()]
+	if arenaL1Bits != 0 && l2 == nil {
+		return nil
+	}
+	ha := l2[ri.l2()]
+	if ha == nil {
+		return nil
+	}
+	return &ha.cards[(p%heapArenaBytes)/(gcCardBytes*8)]
+}
+
+// gcCardMark records that a pointer was written to the heap at p.
+//
+// This is part of the write barrier, so it must be nosplit and must
+// not have write barriers.
+//
+//go:nowritebarrierrec
+//go:nosplit
+func gcCardMark(p uintptr) {
+	b := gcCardByte(p)
+	if b == nil {
+		return
+	}
+	mask := uint8(1) << ((p / gcCardBytes) % 8)
+	if *b&mask == 0 {
+		atomic.Or8(b, mask)
+	}
+}
+
+// gcCardMarkRange is like gcCardMark for pointers written to
+// [p, p+size).
+//
+//go:nowritebarrierrec
+//go:nosplit
+func gcCardMarkRange(p, size uintptr) {
+	for c := p &^ (gcCardBytes - 1); c < p+size; c += gcCardBytes {
+		gcCardMark(c)
+	}
+}
+
+// markrootCards scans the dirty cards in one shard of the in-use
+// spans and clears them. In a full cycle it only clears them.
+//
+//go:nowritebarrier
+func markrootCards(gcw *gcWork, shard int) {
+	if work.markrootDone {
+		throw("markrootCards during second markroot")
+	}
+
+	spans := mheap_.sweepSpans[mheap_.sweepgen/2%2].block(shard)
+	for _, s := range spans {
+		if s.state != mSpanInUse {
+			continue
+		}
+		end := s.base() + s.npages*pageSize
+		for p := s.base(); p < end; p += gcCardBytes * 8 {
+			b := gcCardByte(p)
+			bits := *b
+			if bits == 0 {
+				continue
+			}
+			*b = 0
+			if !gcgen.minor || s.spanclass.noscan() {
+				continue
+			}
+			for bits != 0 {
+				i := uintptr(sys.Ctz8(bits))
+				bits &^= 1 << i
+				gcScanCard(s, p+i*gcCardBytes, gcw)
+			}
+		}
+	}
+}
+
+// gcScanCard greys the objects that old objects in the card at c of
+// span s point to.
+//
+//go:nowritebarrier
+func gcScanCard(s *mspan, c uintptr, gcw *gcWork) {
+	if s.spanclass.sizeclass() == 0 {
+		// A large object. Scan just the part of it in the
+		// card, which is always in its pointer prefix or
+		// marked as having no more pointers.
+		objEnd := s.base() + s.elemsize
+		if c >= objEnd || !s.markBitsForIndex(0).isMarked() {
+			return
+		}
+		n := uintptr(gcCardBytes)
+		if c+n > objEnd {
+			n = objEnd - c
+		}
+		gcScanOld(c, n, gcw)
+		return
+	}
+
+	// Scan every old object that overlaps the card. A young
+	// object will be scanned if it's reachable; one that is
+	// already marked just gets scanned twice.
+	end := c + gcCardBytes
+	for i := (c - s.base()) / s.elemsize; i < s.nelems; i++ {
+		p := s.base() + i*s.elemsize
+		if p >= end {
+			break
+		}
+		if s.markBitsForIndex(i).isMarked() {
+			gcScanOld(p, s.elemsize, gcw)
+		}
+	}
+}
+
+// gcScanOld greys the objects pointed to by [b, b+n), which is an old
+// object or, for a large object, one of its cards. It is like
+// scanobject, but old objects are already counted in bytesMarked.
+//
+//go:nowritebarrier
+func gcScanOld(b, n uintptr, gcw *gcWork) {
+	hbits := heapBitsForAddr(b)
+	var i uintptr
+	for i = 0; i < n; i += sys.PtrSize {
+		if i != 0 {
+			hbits = hbits.next()
+		}
+		bits := hbits.bits()
+		// See scanobject for the word 1 exception.
+		if i != 1*sys.PtrSize && bits&bitScan == 0 {
+			break // no more pointers in this object
+		}
+		if bits&bitPointer == 0 {
+			continue // not a pointer
+		}
+		obj := *(*uintptr)(unsafe.Pointer(b + i))
+		if obj != 0 && obj-b >= n {
+			if obj, span, objIndex := findObject(obj, b, i); obj != 0 {
+				greyobject(obj, b, i, span, gcw, objIndex)
+			}
+		}
+	}
+	gcw.scanWork += int64(i)
+}
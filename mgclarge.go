@@ -251,6 +251,22 @@ func scavengetreap(treap *treapNode, now, limit uint64) uintptr {
 		scavengetreap(treap.right, now, limit)
 }
 
+// scavengeTreapChunk releases up to nbytes from the largest span in
+// treap that has pages left to release. It returns the number of
+// bytes released.
+func scavengeTreapChunk(treap *treapNode, nbytes uintptr) uintptr {
+	if treap == nil {
+		return 0
+	}
+	if released := scavengeTreapChunk(treap.right, nbytes); released != 0 {
+		return released
+	}
+	if released := treap.spanKey.scavengeChunk(nbytes); released != 0 {
+		return released
+	}
+	return scavengeTreapChunk(treap.left, nbytes)
+}
+
 // rotateLeft rotates the tree rooted at node x.
 // turning (x a (y b c)) into (y (x a b) c).
 func (root *mTreap) rotateLeft(x *treapNode) {
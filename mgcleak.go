@@ -0,0 +1,238 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Goroutine leak detection.
+//
+// A goroutine blocked on a channel operation or sync.Cond.Wait can
+// only be woken by another goroutine that can reach the channel or
+// Cond. If the only references left are from the blocked goroutine
+// itself, it will never run again, and everything its stack refers
+// to is leaked. checkdead only notices this when every goroutine is
+// blocked.
+//
+// A leak-detecting GC cycle finds such goroutines during marking.
+// It runs the whole mark phase with the world stopped. Before
+// marking, it defers scanning the stacks of goroutines blocked in
+// chanrecv, chansend, selectgo or sync.Cond.Wait, and hides their
+// sudogs' channel pointers from the GC so the channel is not marked
+// just because the blocked g is. Marking then proceeds from all
+// other roots. Whenever marking runs out of work, any deferred
+// goroutine that is waiting on a marked object is reachable: its
+// stack is scanned and marking continues. When no more deferred
+// goroutines become reachable, the remaining ones are leaked.
+//
+// Leaked goroutines are not freed. They are recorded in g.leaked and
+// their stacks are scanned like any other so the heap stays
+// consistent. The "goroutineleak" profile in runtime/pprof reports
+// them with the location of the go statement that created them.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// goroutineLeak records the result of the last leak-detecting GC.
+var goroutineLeak struct {
+	// cycle is the GC cycle that last ran leak detection and
+	// found is the number of leaked goroutines it found. Both are
+	// accessed atomically.
+	cycle uint32
+	found uint32
+}
+
+// detectGoroutineLeaks runs a leak-detecting GC cycle and returns the
+// number of goroutines it found leaked.
+//
+//go:linkname detectGoroutineLeaks runtime/debug.detectGoroutineLeaks
+func detectGoroutineLeaks() int {
+	for {
+		// Like GC, wait for the current cycle's mark phase and
+		// start a fresh one. If another goroutine started it
+		// first, it may not detect leaks, so try again.
+		n := atomic.Load(&work.cycles)
+		gcWaitOnMark(n)
+		gcStart(gcForceMode, gcTrigger{kind: gcTriggerCycle, n: n + 1, detectLeaks: true})
+		gcWaitOnMark(n + 1)
+		if atomic.Load(&goroutineLeak.cycle) == n+1 {
+			return int(atomic.Load(&goroutineLeak.found))
+		}
+	}
+}
+
+// isLeakCandidate reports whether gp is blocked in a way that only a
+// goroutine with a reference to its wait object can undo.
+//
+// The world must be stopped.
+func isLeakCandidate(gp *g) bool {
+	if gp.leaked || readgstatus(gp) != _Gwaiting || isSystemGoroutine(gp) {
+		return false
+	}
+	switch gp.waitreason {
+	case waitReasonChanReceive, waitReasonChanSend, waitReasonSelect,
+		waitReasonSyncCondWait, waitReasonChanReceiveNilChan,
+		waitReasonChanSendNilChan, waitReasonSelectNoCases:
+		return true
+	}
+	return false
+}
+
+// gcLeakPrepare defers the stack scans of all leak candidates and
+// hides their channels from the mark phase.
+//
+// The world must be stopped and marking must not have started.
+//
+//go:nowritebarrier
+func gcLeakPrepare() {
+	for _, gp := range allgs {
+		if !isLeakCandidate(gp) {
+			continue
+		}
+		gp.leakCandidate = true
+		for sg := gp.waiting; sg != nil; sg = sg.waitlink {
+			if sg.c == nil {
+				continue
+			}
+			// Clear c without a write barrier, which would
+			// shade the channel.
+			sg.hiddenc = uintptr(unsafe.Pointer(sg.c))
+			*(*uintptr)(unsafe.Pointer(&sg.c)) = 0
+		}
+	}
+}
+
+// gcFindLeaks scans the stacks of leak candidates that have become
+// reachable until no more do, then marks the rest as leaked and
+// scans them too. It must be called once marking from all other
+// roots is complete.
+//
+// The world must be stopped.
+//
+//go:nowritebarrier
+func gcFindLeaks(gcw *gcWork) {
+	for {
+		progress := false
+		for _, gp := range allgs {
+			if gp.leakCandidate && leakWaitReachable(gp) {
+				gcLeakScan(gp, gcw)
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+		gcDrain(gcw, gcDrainNoBlock)
+	}
+
+	found := uint32(0)
+	for _, gp := range allgs {
+		if gp.leakCandidate {
+			gp.leaked = true
+			found++
+			gcLeakScan(gp, gcw)
+		}
+	}
+	gcDrain(gcw, gcDrainNoBlock)
+	gcw.dispose()
+
+	atomic.Store(&goroutineLeak.found, found)
+	atomic.Store(&goroutineLeak.cycle, work.cycles)
+}
+
+// leakWaitReachable reports whether any object gp is waiting on has
+// been marked.
+func leakWaitReachable(gp *g) bool {
+	if gp.condWait != 0 {
+		return leakObjMarked(gp.condWait)
+	}
+	for sg := gp.waiting; sg != nil; sg = sg.waitlink {
+		if sg.hiddenc != 0 && leakObjMarked(sg.hiddenc) {
+			return true
+		}
+	}
+	return false
+}
+
+// leakObjMarked reports whether the object containing p is marked.
+// Objects outside the heap, such as globals, are always reachable.
+func leakObjMarked(p uintptr) bool {
+	base, span, objIndex := findObject(p, 0, 0)
+	if base == 0 {
+		return true
+	}
+	return span.markBitsForIndex(objIndex).isMarked()
+}
+
+// gcLeakScan restores gp's hidden channels, marks them and scans
+// gp's stack.
+//
+//go:nowritebarrier
+func gcLeakScan(gp *g, gcw *gcWork) {
+	gp.leakCandidate = false
+	for sg := gp.waiting; sg != nil; sg = sg.waitlink {
+		if sg.hiddenc == 0 {
+			continue
+		}
+		c := sg.hiddenc
+		sg.hiddenc = 0
+		*(*uintptr)(unsafe.Pointer(&sg.c)) = c
+		// The sudog may already be black, so the channel
+		// must be shaded explicitly.
+		shade(c)
+	}
+	scang(gp, gcw)
+}
+
+// pprof_goroutineLeakProfile returns the stacks of goroutines found
+// leaked by leak-detecting GC cycles, like GoroutineProfile. Each
+// stack ends with the PC of the go statement that created the
+// goroutine, if there is room.
+//
+//go:linkname pprof_goroutineLeakProfile runtime/pprof.runtime_goroutineLeakProfile
+func pprof_goroutineLeakProfile(p []StackRecord) (n int, ok bool) {
+	isLeaked := func(gp *g) bool {
+		return gp.leaked && readgstatus(gp) == _Gwaiting
+	}
+
+	stopTheWorld("profile")
+
+	for _, gp := range allgs {
+		if isLeaked(gp) {
+			n++
+		}
+	}
+
+	if n <= len(p) {
+		ok = true
+		r := p
+		for _, gp := range allgs {
+			if !isLeaked(gp) {
+				continue
+			}
+			saveg(^uintptr(0), ^uintptr(0), gp, &r[0])
+			stk := r[0].Stack0[:]
+			i := 0
+			for i < len(stk) && stk[i] != 0 {
+				i++
+			}
+			if i < len(stk) {
+				stk[i] = gp.gopc
+				if i+1 < len(stk) {
+					stk[i+1] = 0
+				}
+			}
+			r = r[1:]
+		}
+	}
+
+	startTheWorld()
+
+	return n, ok
+}
+
+//go:linkname pprof_detectGoroutineLeaks runtime/pprof.runtime_detectGoroutineLeaks
+func pprof_detectGoroutineLeaks() int {
+	return detectGoroutineLeaks()
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Soft memory limit.
+//
+// GOGC alone sizes the heap relative to the live heap, so a program
+// whose live heap is half of its container's memory limit will be
+// killed as soon as the heap doubles. The memory limit gives the
+// pacer a second, absolute target: the total amount of memory mapped
+// and in use by the runtime (the heap, goroutine stacks, span
+// structures and other runtime metadata). When the limit is closer
+// than the GOGC goal, the GC goal is lowered so that the heap stays
+// under the limit, and the scavenger returns free pages to the
+// operating system more eagerly.
+//
+// The limit is soft. If the live heap by itself does not fit, the
+// GC would run continuously without freeing anything. To avoid such
+// a death spiral, the GC CPU limiter caps the CPU time the GC may
+// take while the limit is in effect by dropping mutator assists
+// once the GC has used more than gcCPULimiterMaxFraction of the
+// available CPU time for a sustained period. The heap is then
+// allowed to exceed the limit.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
+
+// maxMemoryLimit is the value of memoryLimit when no limit is set.
+const maxMemoryLimit = 1<<63 - 1
+
+// memoryLimit is the soft limit in bytes on the total memory mapped
+// and in use by the runtime. Initialized from $GOMEMLIMIT.
+//
+// Accessed atomically.
+var memoryLimit uint64 = maxMemoryLimit
+
+const (
+	// memoryLimitHeadroomPercent is the fraction of the memory
+	// limit, in percent, the heap goal is kept below the limit.
+	// This absorbs growth in non-heap memory between GC cycles
+	// and the slack in heap_live accounting.
+	memoryLimitHeadroomPercent = 3
+
+	// memoryLimitScavengePercent is the fraction of the memory
+	// limit, in percent, the scavenger keeps mapped memory under.
+	memoryLimitScavengePercent = 95
+
+	// memoryLimitScavengePeriod is how often, in nanoseconds,
+	// sysmon checks whether the scavenger needs to run to stay
+	// under the memory limit.
+	memoryLimitScavengePeriod = 100 * 1000 * 1000 // 100ms
+)
+
+// readGOMEMLIMIT returns the memory limit set by $GOMEMLIMIT, or
+// maxMemoryLimit if it is unset or "off".
+func readGOMEMLIMIT() uint64 {
+	p := gogetenv("GOMEMLIMIT")
+	if p == "" || p == "off" {
+		return maxMemoryLimit
+	}
+	n, ok := parseByteCount(p)
+	if !ok {
+		print("GOMEMLIMIT=", p, "\n")
+		throw("malformed GOMEMLIMIT; see `go doc runtime/debug.SetMemoryLimit`")
+	}
+	return uint64(n)
+}
+
+// parseByteCount parses a non-negative byte count with an optional
+// unit suffix: B, KiB, MiB, GiB or TiB.
+func parseByteCount(s string) (int64, bool) {
+	unit := int64(1)
+	switch {
+	case hassuffix(s, "KiB"):
+		unit, s = 1<<10, s[:len(s)-3]
+	case hassuffix(s, "MiB"):
+		unit, s = 1<<20, s[:len(s)-3]
+	case hassuffix(s, "GiB"):
+		unit, s = 1<<30, s[:len(s)-3]
+	case hassuffix(s, "TiB"):
+		unit, s = 1<<40, s[:len(s)-3]
+	case hassuffix(s, "B"):
+		s = s[:len(s)-1]
+	}
+	if s == "" || s[0] == '-' {
+		return 0, false
+	}
+	n, ok := atoi(s)
+	if !ok || int64(n) > maxMemoryLimit/unit {
+		return 0, false
+	}
+	return int64(n) * unit, true
+}
+
+//go:linkname setMemoryLimit runtime/debug.setMemoryLimit
+func setMemoryLimit(in int64) (out int64) {
+	// Negative values only query the current limit.
+	if in < 0 {
+		return int64(atomic.Load64(&memoryLimit))
+	}
+	lock(&mheap_.lock)
+	out = int64(memoryLimit)
+	atomic.Store64(&memoryLimit, uint64(in))
+	// Update pacing in response to the new limit.
+	gcSetTriggerRatio(memstats.triggerRatio)
+	unlock(&mheap_.lock)
+	return out
+}
+
+// memoryLimitMapped returns the number of bytes of memory mapped and
+// in use by the runtime. This is what the memory limit bounds: the
+// heap minus what has been released to the OS, plus stacks and all
+// other runtime-managed memory.
+//
+// mheap_.lock must be held or the world must be stopped.
+func memoryLimitMapped() uint64 {
+	return memstats.heap_sys - memstats.heap_released +
+		memstats.stacks_inuse + memstats.stacks_sys +
+		memstats.mspan_sys + memstats.mcache_sys +
+		memstats.buckhash_sys + memstats.gc_sys + memstats.other_sys
+}
+
+// memoryLimitHeapGoal returns the heap_live goal implied by the
+// memory limit, or ^uint64(0) if no limit is set.
+//
+// Everything mapped other than in-use heap spans is overhead the
+// heap has to fit around. Free heap spans count as overhead until
+// they are scavenged.
+//
+// mheap_.lock must be held or the world must be stopped.
+func memoryLimitHeapGoal() uint64 {
+	limit := atomic.Load64(&memoryLimit)
+	if limit == maxMemoryLimit {
+		return ^uint64(0)
+	}
+	overhead := memoryLimitMapped() - memstats.heap_inuse
+	headroom := limit / 100 * memoryLimitHeadroomPercent
+	if overhead+headroom >= limit {
+		// The limit can't be met by the heap alone. Collect
+		// as often as the CPU limiter allows.
+		return 0
+	}
+	return limit - overhead - headroom
+}
+
+// memoryLimitNeedsScavenge reports whether the runtime's mapped
+// memory is close enough to the memory limit that the scavenger
+// should run.
+func memoryLimitNeedsScavenge() bool {
+	limit := atomic.Load64(&memoryLimit)
+	if limit == maxMemoryLimit {
+		return false
+	}
+	lock(&mheap_.lock)
+	mapped := memoryLimitMapped()
+	unlock(&mheap_.lock)
+	return mapped > limit/100*memoryLimitScavengePercent
+}
+
+// gcCPULimiterMaxFraction is the maximum fraction of CPU time the GC
+// may use while the memory limit is in effect.
+//
+// The bucket accounting in update relies on this being 0.5: GC time
+// fills the bucket and an equal amount of mutator time drains it.
+const gcCPULimiterMaxFraction = 0.5
+
+// gcCPULimiterCapacity is the size of the limiter's bucket per P, in
+// nanoseconds of CPU time. It is how long the GC may run over its
+// CPU budget before the limiter engages.
+const gcCPULimiterCapacity = 1e9
+
+var gcCPULimiter gcCPULimiterState
+
+// gcCPULimiterState is a leaky bucket that tracks GC CPU time
+// against mutator CPU time.
+type gcCPULimiterState struct {
+	// gcTimePending is GC CPU time in nanoseconds accumulated
+	// since the last update. Accessed atomically.
+	gcTimePending int64
+
+	// enabled is 1 if assists should be skipped to limit GC CPU
+	// usage. Accessed atomically.
+	enabled uint32
+
+	// lock serializes update. It is acquired with a CAS and
+	// update gives up if it's held.
+	lock uint32
+
+	// fill is the current bucket fill in nanoseconds of CPU
+	// time. Protected by lock.
+	fill int64
+
+	// lastUpdate is the nanotime of the last update.
+	// Protected by lock.
+	lastUpdate int64
+}
+
+// addGCTime records d nanoseconds of CPU time spent in the GC by
+// mutator assists or mark workers.
+//
+//go:nosplit
+func (l *gcCPULimiterState) addGCTime(d int64) {
+	atomic.Xaddint64(&l.gcTimePending, d)
+}
+
+// limiting reports whether mutator assists should be skipped to
+// keep GC CPU usage under gcCPULimiterMaxFraction.
+func (l *gcCPULimiterState) limiting() bool {
+	return atomic.Load(&l.enabled) != 0
+}
+
+// update drains GC time accumulated by addGCTime into the bucket and
+// enables or disables the limiter. It is called periodically by
+// sysmon.
+//
+//go:nowritebarrierrec
+func (l *gcCPULimiterState) update(now int64) {
+	if !atomic.Cas(&l.lock, 0, 1) {
+		return
+	}
+	gcTime := atomic.Xchg64((*uint64)(unsafe.Pointer(&l.gcTimePending)), 0)
+	if atomic.Load64(&memoryLimit) == maxMemoryLimit {
+		// The limiter only guards the memory limit. Without
+		// one, GOGC pacing is in charge of GC CPU usage.
+		l.fill = 0
+		atomic.Store(&l.enabled, 0)
+	} else if l.lastUpdate != 0 && now > l.lastUpdate {
+		window := (now - l.lastUpdate) * int64(gomaxprocs)
+		gc := int64(gcTime)
+		if gc > window {
+			gc = window
+		}
+		// With a 50% cap, time in the GC fills the bucket and
+		// an equal amount of time in the mutator drains it.
+		l.fill += gc - (window - gc)
+		capacity := gcCPULimiterCapacity * int64(gomaxprocs)
+		if l.fill < 0 {
+			l.fill = 0
+		} else if l.fill > capacity {
+			l.fill = capacity
+		}
+		enabled := uint32(0)
+		if l.fill == capacity {
+			enabled = 1
+		}
+		atomic.Store(&l.enabled, enabled)
+	}
+	l.lastUpdate = now
+	atomic.Store(&l.lock, 0)
+}
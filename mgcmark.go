@@ -98,6 +98,13 @@ func gcMarkRootPrepare() {
 		// this mark phase.
 		work.nSpanRoots = mheap_.sweepSpans[mheap_.sweepgen/2%2].numBlocks()
 
+		// The card table is scanned, or cleared, once per
+		// cycle, sharded the same way.
+		work.nCardRoots = 0
+		if debug.gcgen > 0 {
+			work.nCardRoots = work.nSpanRoots
+		}
+
 		// On the first markroot, we need to scan all Gs. Gs
 		// may be created after this point, but it's okay that
 		// we ignore them because they begin life without any
@@ -111,6 +118,7 @@ func gcMarkRootPrepare() {
 		// We've already scanned span roots and kept the scan
 		// up-to-date during concurrent mark.
 		work.nSpanRoots = 0
+		work.nCardRoots = 0
 
 		// The hybrid barrier ensures that stacks can't
 		// contain pointers to unmarked objects, so on the
@@ -124,7 +132,7 @@ func gcMarkRootPrepare() {
 	}
 
 	work.markrootNext = 0
-	work.markrootJobs = uint32(fixedRootCount + work.nFlushCacheRoots + work.nDataRoots + work.nBSSRoots + work.nSpanRoots + work.nStackRoots)
+	work.markrootJobs = uint32(fixedRootCount + work.nFlushCacheRoots + work.nDataRoots + work.nBSSRoots + work.nSpanRoots + work.nCardRoots + work.nStackRoots)
 }
 
 // gcMarkRootCheck checks that all roots have been scanned. It is
@@ -148,7 +156,7 @@ func gcMarkRootCheck() {
 	} else {
 		for i := 0; i < work.nStackRoots; i++ {
 			gp = allgs[i]
-			if !gp.gcscandone {
+			if !gp.gcscandone && !gp.leakCandidate {
 				goto fail
 			}
 		}
@@ -182,7 +190,8 @@ func markroot(gcw *gcWork, i uint32) {
 	baseData := baseFlushCache + uint32(work.nFlushCacheRoots)
 	baseBSS := baseData + uint32(work.nDataRoots)
 	baseSpans := baseBSS + uint32(work.nBSSRoots)
-	baseStacks := baseSpans + uint32(work.nSpanRoots)
+	baseCards := baseSpans + uint32(work.nSpanRoots)
+	baseStacks := baseCards + uint32(work.nCardRoots)
 	end := baseStacks + uint32(work.nStackRoots)
 
 	// Note: if you add a case here, please also update heapdump.go:dumproots.
@@ -220,10 +229,14 @@ func markroot(gcw *gcWork, i uint32) {
 			systemstack(markrootFreeGStacks)
 		}
 
-	case baseSpans <= i && i < baseStacks:
+	case baseSpans <= i && i < baseCards:
 		// mark MSpan.specials
 		markrootSpans(gcw, int(i-baseSpans))
 
+	case baseCards <= i && i < baseStacks:
+		// scan the remembered set
+		markrootCards(gcw, int(i-baseCards))
+
 	default:
 		// the rest is scanning goroutine stacks
 		var gp *g
@@ -233,6 +246,13 @@ func markroot(gcw *gcWork, i uint32) {
 			throw("markroot: bad index")
 		}
 
+		if gp.leakCandidate {
+			// A leak-detecting GC scans this stack
+			// later if gp turns out to be reachable.
+			// See gcFindLeaks.
+			break
+		}
+
 		// remember when we've first observed the G blocked
 		// needed only to output in traceback
 		status := readgstatus(gp) // We are not in a scan state
@@ -414,6 +434,12 @@ func gcAssistAlloc(gp *g) {
 	if mp := getg().m; mp.locks > 0 || mp.preemptoff != "" {
 		return
 	}
+	// If the memory limit has kept the GC using more than its
+	// share of CPU, let the mutator run and the heap overshoot
+	// the goal instead.
+	if gcCPULimiter.limiting() {
+		return
+	}
 
 	traced := false
 retry:
@@ -589,6 +615,7 @@ func gcAssistAlloc1(gp *g, scanWork int64) {
 		gp.param = unsafe.Pointer(gp)
 	}
 	duration := nanotime() - startTime
+	gcCPULimiter.addGCTime(duration)
 	_p_ := gp.m.p.ptr()
 	_p_.gcAssistTime += duration
 	if _p_.gcAssistTime > gcAssistTimeSlack {
@@ -779,8 +806,9 @@ func scanstack(gp *g, gcw *gcWork) {
 
 	// Scan the stack.
 	var cache pcvalueCache
+	conservative := false
 	scanframe := func(frame *stkframe, unused unsafe.Pointer) bool {
-		scanframeworker(frame, &cache, gcw)
+		scanframeworker(frame, &cache, gcw, &conservative)
 		return true
 	}
 	gentraceback(^uintptr(0), ^uintptr(0), 0, gp, 0, nil, 0x7fffffff, scanframe, nil, 0)
@@ -789,12 +817,30 @@ func scanstack(gp *g, gcw *gcWork) {
 }
 
 // Scan a stack frame: local variables and function arguments/results.
+// If *conservative is set, the frame was interrupted by an asynchronous
+// preemption; scanframeworker sets it for the frame it scans next.
 //go:nowritebarrier
-func scanframeworker(frame *stkframe, cache *pcvalueCache, gcw *gcWork) {
+func scanframeworker(frame *stkframe, cache *pcvalueCache, gcw *gcWork, conservative *bool) {
 	if _DebugGC > 1 && frame.continpc != 0 {
 		print("scanframe ", funcname(frame.fn), "\n")
 	}
 
+	isAsyncPreempt := frame.fn.valid() && frame.fn.entry == asyncPreemptPC
+	if *conservative || isAsyncPreempt {
+		// asyncPreempt's frame holds the registers of the frame it
+		// interrupted, and the interrupted frame stopped at an
+		// arbitrary instruction rather than at a call site, so
+		// neither has a stack map. Scan both conservatively.
+		if frame.varp != 0 && frame.varp > frame.sp {
+			scanConservative(frame.sp, frame.varp-frame.sp, gcw)
+		}
+		if frame.arglen != 0 {
+			scanConservative(frame.argp, frame.arglen, gcw)
+		}
+		*conservative = isAsyncPreempt
+		return
+	}
+
 	locals, args := getStackMap(frame, cache, false)
 
 	// Scan local variables if stack frame has been allocated.
@@ -809,6 +855,27 @@ func scanframeworker(frame *stkframe, cache *pcvalueCache, gcw *gcWork) {
 	}
 }
 
+// scanConservative scans [b, b+n) conservatively, treating any word
+// that points into an allocated heap object as a pointer to it.
+// Pointers into stacks are ignored, since stack memory is not
+// collected.
+//go:nowritebarrier
+func scanConservative(b, n uintptr, gcw *gcWork) {
+	for i := uintptr(0); i < n; i += sys.PtrSize {
+		val := *(*uintptr)(unsafe.Pointer(b + i))
+		span := spanOfHeap(val)
+		if span == nil {
+			continue
+		}
+		idx := span.objIndex(val)
+		if span.isFree(idx) {
+			continue
+		}
+		obj := span.base() + idx*span.elemsize
+		greyobject(obj, b, i, span, gcw, idx)
+	}
+}
+
 type gcDrainFlags int
 
 const (
@@ -0,0 +1,195 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Scavenging free pages.
+//
+// The background scavenger returns free heap memory to the operating
+// system incrementally. After each GC, it releases free pages a chunk
+// at a time until the retained heap (heap_sys - heap_released) is
+// within retainExtraPercent of the previous cycle's heap goal, then
+// parks until the next GC ends. The heap goal is a good estimate of
+// how much memory the program will need again soon, so this keeps
+// RSS close to it without releasing pages the allocator is about to
+// reuse.
+//
+// The scavenger is paced to use about scavengePercent of a single
+// CPU: after each chunk it sleeps for long enough to make up for the
+// time spent releasing it. When the runtime is close to its memory
+// limit, the goal is lowered to make room under the limit and the
+// scavenger stops sleeping between chunks.
+
+package runtime
+
+import "runtime/internal/atomic"
+
+const (
+	// retainExtraPercent is the amount of memory, as a percent of
+	// the last heap goal, the scavenger retains on top of that
+	// goal. This absorbs fluctuations in the heap size from cycle
+	// to cycle without releasing and refaulting the same pages.
+	retainExtraPercent = 10
+
+	// scavengePercent is the percent of one CPU's time the
+	// scavenger aims to use.
+	scavengePercent = 1
+
+	// scavengeChunkBytes is how much memory the scavenger tries
+	// to release at a time before yielding. It is rounded up to
+	// physPageSize.
+	scavengeChunkBytes = 64 << 10
+)
+
+// State of the background scavenger.
+var scavenge struct {
+	lock   mutex
+	g      *g
+	parked bool
+	timer  *timer
+
+	// sysmonWake is set to 1 to ask sysmon to wake the scavenger
+	// when it is parked. sysmon clears it once it does.
+	// Accessed atomically.
+	sysmonWake uint32
+}
+
+// heapRetained returns the amount of heap memory that is mapped and
+// has not been released to the OS.
+//
+// mheap_.lock must be held or the world must be stopped.
+func heapRetained() uint64 {
+	return memstats.heap_sys - memstats.heap_released
+}
+
+// scavengeGoal returns the retained heap size the scavenger works
+// toward. urgent is true if the goal comes from the memory limit, in
+// which case the scavenger should not pace itself.
+//
+// mheap_.lock must be held or the world must be stopped.
+func scavengeGoal() (goal uint64, urgent bool) {
+	goal = ^uint64(0)
+	if last := memstats.last_next_gc; last != 0 && last != ^uint64(0) {
+		goal = last + last/100*retainExtraPercent
+	}
+	if limit := atomic.Load64(&memoryLimit); limit != maxMemoryLimit {
+		// Fit the retained heap in what the memory limit
+		// leaves after everything else the runtime has mapped.
+		target := limit / 100 * memoryLimitScavengePercent
+		nonHeap := memoryLimitMapped() - heapRetained()
+		limitGoal := uint64(0)
+		if target > nonHeap {
+			limitGoal = target - nonHeap
+		}
+		if limitGoal < goal {
+			goal, urgent = limitGoal, true
+		}
+	}
+	if debug.scavenge > 0 {
+		urgent = true
+	}
+	return goal, urgent
+}
+
+// readyForScavenger asks sysmon to wake the scavenger because its
+// goal may have changed.
+//
+//go:nosplit
+func readyForScavenger() {
+	atomic.Store(&scavenge.sysmonWake, 1)
+}
+
+// wakeScavenger unparks the scavenger if it is parked.
+//
+// This is called by sysmon, so it must not have write barriers. In
+// particular it leaves a pending sleep timer alone; if the timer
+// fires later it only causes a spurious wakeup, and scavengeSleep
+// removes it before reusing it.
+//
+//go:nowritebarrierrec
+func wakeScavenger() {
+	lock(&scavenge.lock)
+	if scavenge.parked {
+		// Tell sysmon it doesn't need to wake us anymore.
+		atomic.Store(&scavenge.sysmonWake, 0)
+		scavenge.parked = false
+		scavenge.g.schedlink = 0
+		injectglist(scavenge.g)
+	}
+	unlock(&scavenge.lock)
+}
+
+// scavengeSleep parks the scavenger for ns nanoseconds, or until it
+// is woken by wakeScavenger.
+func scavengeSleep(ns int64) {
+	lock(&scavenge.lock)
+	deltimer(scavenge.timer)
+	scavenge.timer.when = nanotime() + ns
+	addtimer(scavenge.timer)
+	scavenge.parked = true
+	goparkunlock(&scavenge.lock, waitReasonSleep, traceEvGoSleep, 2)
+}
+
+func bgscavenge(c chan int) {
+	scavenge.g = getg()
+	scavenge.timer = new(timer)
+	scavenge.timer.f = func(_ interface{}, _ uintptr) {
+		wakeScavenger()
+	}
+
+	lock(&scavenge.lock)
+	scavenge.parked = true
+	c <- 1
+	goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+
+	chunk := uintptr(scavengeChunkBytes)
+	if physPageSize > chunk {
+		chunk = physPageSize
+	}
+	released := uintptr(0)
+	for {
+		done, urgent := false, false
+		crit := int64(0)
+		systemstack(func() {
+			lock(&mheap_.lock)
+			var goal uint64
+			goal, urgent = scavengeGoal()
+			retained := heapRetained()
+			if retained <= goal || retained-goal < uint64(physPageSize) {
+				done = true
+			} else {
+				start := nanotime()
+				r := mheap_.scavengeLocked(chunk)
+				crit = nanotime() - start
+				released += r
+				done = r == 0
+			}
+			unlock(&mheap_.lock)
+		})
+
+		if done {
+			lock(&scavenge.lock)
+			if debug.gctrace > 0 && released > 0 && debug.tracejsonfd > 0 {
+				jsonTraceScavenge(true, 0, released)
+			} else if debug.gctrace > 0 && released > 0 {
+				print("scvg: ", released>>10, " KB released\n")
+				print("scvg: inuse: ", memstats.heap_inuse>>20, ", idle: ", memstats.heap_idle>>20, ", sys: ", memstats.heap_sys>>20, ", released: ", memstats.heap_released>>20, ", consumed: ", (memstats.heap_sys-memstats.heap_released)>>20, " (MB)\n")
+			}
+			released = 0
+			scavenge.parked = true
+			goparkunlock(&scavenge.lock, waitReasonGCScavengeWait, traceEvGoBlock, 1)
+			continue
+		}
+
+		if urgent {
+			Gosched()
+			continue
+		}
+		// Sleep long enough that the time spent releasing
+		// memory is scavengePercent of the total.
+		if crit < 1000 {
+			// Don't let a fast madvise turn into a busy loop.
+			crit = 1000
+		}
+		scavengeSleep(crit * (100 - scavengePercent) / scavengePercent)
+	}
+}
@@ -288,6 +288,9 @@ func (s *mspan) sweep(preserve bool) bool {
 
 	// Count the number of free objects in this span.
 	nalloc := uint16(s.countAlloc())
+	if state := atomic.Load(&s.userArena); state != userArenaNone {
+		userArenaSweep(s, state, nalloc)
+	}
 	if spc.sizeclass() == 0 && nalloc == 0 {
 		s.needzero = 1
 		freeToHeap = true
@@ -309,6 +312,11 @@ func (s *mspan) sweep(preserve bool) bool {
 	// get a fresh cleared gcmarkBits in preparation for next GC
 	s.allocBits = s.gcmarkBits
 	s.gcmarkBits = newMarkBits(s.nelems)
+	if gcgen.sticky {
+		// Keep the survivors marked so the next cycle can
+		// treat them as old. See mgcgen.go.
+		memmove(unsafe.Pointer(s.gcmarkBits), unsafe.Pointer(s.allocBits), (s.nelems+7)/8)
+	}
 
 	// Initialize alloc bits cache.
 	s.refillAllocCache(0)
@@ -152,6 +152,7 @@ type mheap struct {
 	treapalloc            fixalloc // allocator for treapNodes* used by large objects
 	specialfinalizeralloc fixalloc // allocator for specialfinalizer*
 	specialprofilealloc   fixalloc // allocator for specialprofile*
+	speciallockorderalloc fixalloc // allocator for speciallockorder*
 	speciallock           mutex    // lock for special record allocators.
 	arenaHintAlloc        fixalloc // allocator for arenaHints
 
@@ -186,6 +187,16 @@ type heapArena struct {
 	// must not be a safe-point between establishing that an
 	// address is live and looking it up in the spans array.
 	spans [pagesPerArena]*mspan
+
+	// types records the type of each small object in this arena
+	// if debug.heaptypes is set. See heapdump.go.
+	types *heapTypes
+
+	// cards is the card table for this arena: one bit per
+	// gcCardBytes of memory that is set when a pointer is written
+	// there between GC cycles. It is only used with
+	// debug.gcgen. See mgcgen.go.
+	cards [heapArenaBytes / (gcCardBytes * 8)]uint8
 }
 
 // arenaHint is a hint for where to grow the heap arenas. See mheap_.arenaHints.
@@ -325,9 +336,12 @@ type mspan struct {
 	elemsize    uintptr    // computed from sizeclass or from npages  class表中块的大小
 	unusedsince int64      // first time spotted by gc in mspanfree state
 	npreleased  uintptr    // number of pages released to the os
+	relscatter  bool       // released pages are not all at the end of the span; see scavengeChunk
 	limit       uintptr    // end of data in span
 	speciallock mutex      // guards specials list
 	specials    *special   // linked list of special records sorted by offset.
+	largeType   uintptr    // *_type of the object in a large object span, if known
+	userArena   uint32     // user arena chunk state (userArenaNone etc.), accessed atomically
 }
 
 func (s *mspan) base() uintptr {
@@ -549,6 +563,7 @@ func (h *mheap) init() {
 	h.cachealloc.init(unsafe.Sizeof(mcache{}), nil, nil, &memstats.mcache_sys)
 	h.specialfinalizeralloc.init(unsafe.Sizeof(specialfinalizer{}), nil, nil, &memstats.other_sys)
 	h.specialprofilealloc.init(unsafe.Sizeof(specialprofile{}), nil, nil, &memstats.other_sys)
+	h.speciallockorderalloc.init(unsafe.Sizeof(speciallockorder{}), nil, nil, &memstats.other_sys)
 	h.arenaHintAlloc.init(unsafe.Sizeof(arenaHint{}), nil, nil, &memstats.other_sys)
 
 	// Don't zero mspan allocations. Background sweeping can
@@ -1009,6 +1024,7 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 		s.unusedsince = nanotime()
 	}
 	s.npreleased = 0
+	s.relscatter = false
 
 	// Coalesce with earlier, later spans.
 	if before := spanOf(s.base() - 1); before != nil && before.state == _MSpanFree {
@@ -1016,6 +1032,9 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 		s.startAddr = before.startAddr
 		s.npages += before.npages
 		s.npreleased = before.npreleased // absorb released pages
+		// before's released pages, if any, are now followed by
+		// s's unreleased ones.
+		s.relscatter = before.npreleased != 0
 		s.needzero |= before.needzero
 		h.setSpan(before.base(), s)
 		// The size is potentially changing so the treap needs to delete adjacent nodes and
@@ -1032,6 +1051,9 @@ func (h *mheap) freeSpanLocked(s *mspan, acctinuse, acctidle bool, unusedsince i
 
 	// Now check to see if next (greater addresses) span is free and can be coalesced.
 	if after := spanOf(s.base() + s.npages*pageSize); after != nil && after.state == _MSpanFree {
+		if after.relscatter || s.npreleased != 0 && after.npreleased != after.npages {
+			s.relscatter = true
+		}
 		s.npages += after.npages
 		s.npreleased += after.npreleased
 		s.needzero |= after.needzero
@@ -1137,6 +1159,91 @@ func scavengelist(list *mSpanList, now, limit uint64) uintptr {
 	return sumreleased
 }
 
+// scavengeChunk releases up to nbytes of s's pages that have not yet
+// been released to the OS, working down from the end of the span. It
+// returns the number of bytes released. Memory is released in
+// physPageSize blocks, so nbytes must be at least physPageSize.
+//
+// This relies on s's released pages being its last s.npreleased pages.
+// Coalescing can leave them anywhere in s, in which case s.relscatter
+// is set and scavengeChunk releases all of s instead, as scavengelist
+// does.
+//
+// s must be free and the heap must be locked.
+func (s *mspan) scavengeChunk(nbytes uintptr) uintptr {
+	if s.relscatter {
+		return s.scavengeAll()
+	}
+	start := s.base()
+	end := start + (s.npages-s.npreleased)<<_PageShift
+	if physPageSize > _PageSize {
+		// Round in, as scavengelist does.
+		start = (start + physPageSize - 1) &^ (physPageSize - 1)
+		end &^= physPageSize - 1
+	}
+	if end <= start {
+		return 0
+	}
+	if end-start > nbytes {
+		start = (end - nbytes + physPageSize - 1) &^ (physPageSize - 1)
+	}
+	released := end - start
+	memstats.heap_released += uint64(released)
+	s.npreleased += released >> _PageShift
+	sysUnused(unsafe.Pointer(start), released)
+	return released
+}
+
+// scavengeAll releases all of s's pages that have not yet been
+// released to the OS and returns the number of bytes released.
+//
+// s must be free and the heap must be locked.
+func (s *mspan) scavengeAll() uintptr {
+	start := s.base()
+	end := start + s.npages<<_PageShift
+	if physPageSize > _PageSize {
+		// Round in, as scavengelist does.
+		start = (start + physPageSize - 1) &^ (physPageSize - 1)
+		end &^= physPageSize - 1
+		if end <= start {
+			return 0
+		}
+	}
+	len := end - start
+	if s.npreleased<<_PageShift >= len {
+		// With large physical pages, earlier chunks may have
+		// released more than len rounds down to.
+		return 0
+	}
+	released := len - s.npreleased<<_PageShift
+	memstats.heap_released += uint64(released)
+	s.npreleased = len >> _PageShift
+	s.relscatter = false
+	sysUnused(unsafe.Pointer(start), len)
+	return released
+}
+
+// scavengeLocked releases up to nbytes of free heap memory to the OS
+// from a single span, preferring the largest free spans. It may release
+// more from a span whose released pages are scattered (see
+// scavengeChunk). It returns the number of bytes released, which is 0
+// only if there is nothing left to release.
+//
+// h must be locked.
+func (h *mheap) scavengeLocked(nbytes uintptr) uintptr {
+	if released := scavengeTreapChunk(h.freelarge.treap, nbytes); released != 0 {
+		return released
+	}
+	for i := len(h.free) - 1; i > 0; i-- {
+		for s := h.free[i].first; s != nil; s = s.next {
+			if released := s.scavengeChunk(nbytes); released != 0 {
+				return released
+			}
+		}
+	}
+	return 0
+}
+
 func (h *mheap) scavenge(k int32, now, limit uint64) {
 	// Disallow malloc or panic while holding the heap lock. We do
 	// this here because this is an non-mallocgc entry-point to
@@ -1152,7 +1259,9 @@ func (h *mheap) scavenge(k int32, now, limit uint64) {
 	unlock(&h.lock)
 	gp.m.mallocing--
 
-	if debug.gctrace > 0 {
+	if debug.gctrace > 0 && debug.tracejsonfd > 0 {
+		jsonTraceScavenge(false, k, sumreleased)
+	} else if debug.gctrace > 0 {
 		if sumreleased > 0 {
 			print("scvg", k, ": ", sumreleased>>20, " MB released\n")
 		}
@@ -1187,6 +1296,8 @@ func (span *mspan) init(base uintptr, npages uintptr) {
 	span.freeindex = 0
 	span.allocBits = nil
 	span.gcmarkBits = nil
+	span.largeType = 0
+	span.userArena = userArenaNone
 }
 
 func (span *mspan) inList() bool {
@@ -1287,6 +1398,7 @@ func (list *mSpanList) takeAll(other *mSpanList) {
 const (
 	_KindSpecialFinalizer = 1
 	_KindSpecialProfile   = 2
+	_KindSpecialLockOrder = 3
 	// Note: The finalizer special must be first because if we're freeing
 	// an object, a finalizer special will cause the freeing operation
 	// to abort, and we want to keep the other special records around
@@ -1461,20 +1573,32 @@ func removefinalizer(p unsafe.Pointer) {
 type specialprofile struct {
 	special special
 	b       *bucket
+	cycle   uint32 // heap profile cycle when the object was allocated
 }
 
-// Set the heap profile bucket associated with addr to b.
-func setprofilebucket(p unsafe.Pointer, b *bucket) {
+// Set the heap profile bucket associated with addr to b, and the heap
+// profile cycle it was allocated in to cycle.
+func setprofilebucket(p unsafe.Pointer, b *bucket, cycle uint32) {
 	lock(&mheap_.speciallock)
 	s := (*specialprofile)(mheap_.specialprofilealloc.alloc())
 	unlock(&mheap_.speciallock)
 	s.special.kind = _KindSpecialProfile
 	s.b = b
+	s.cycle = cycle
 	if !addspecial(p, &s.special) {
 		throw("setprofilebucket: profile already set")
 	}
 }
 
+// The described object contains sync mutexes tracked by lock-order
+// checking.
+//
+//go:notinheap
+type speciallockorder struct {
+	special special
+	nodes   *lockOrderNode // linked by objNext
+}
+
 // Do whatever cleanup needs to be done to deallocate s. It has
 // already been unlinked from the MSpan specials list.
 func freespecial(s *special, p unsafe.Pointer, size uintptr) {
@@ -1487,10 +1611,16 @@ func freespecial(s *special, p unsafe.Pointer, size uintptr) {
 		unlock(&mheap_.speciallock)
 	case _KindSpecialProfile:
 		sp := (*specialprofile)(unsafe.Pointer(s))
-		mProf_Free(sp.b, size)
+		mProf_Free(sp.b, size, sp.cycle)
 		lock(&mheap_.speciallock)
 		mheap_.specialprofilealloc.free(unsafe.Pointer(sp))
 		unlock(&mheap_.speciallock)
+	case _KindSpecialLockOrder:
+		sl := (*speciallockorder)(unsafe.Pointer(s))
+		lockOrderFree(sl.nodes)
+		lock(&mheap_.speciallock)
+		mheap_.speciallockorderalloc.free(unsafe.Pointer(sl))
+		unlock(&mheap_.speciallock)
 	default:
 		throw("bad special kind")
 		panic("not reached")
@@ -9,6 +9,7 @@ package runtime
 
 import (
 	"runtime/internal/atomic"
+	"runtime/internal/sys"
 	"unsafe"
 )
 
@@ -23,6 +24,8 @@ const (
 	memProfile bucketType = 1 + iota
 	blockProfile
 	mutexProfile
+	schedProfile
+	stackGrowthProfile
 
 	// size of bucket hash table
 	buckHashSize = 179999
@@ -114,6 +117,10 @@ type memRecord struct {
 	// C becomes the active cycle and when we've flushed it to
 	// active.
 	future [3]memRecordCycle
+
+	// lifetimes holds the lifetime histograms of the frees, if the
+	// bucket was created with GODEBUG=memprofilelifetimes=1.
+	lifetimes *memLifetimes
 }
 
 // memRecordCycle
@@ -130,8 +137,57 @@ func (a *memRecordCycle) add(b *memRecordCycle) {
 	a.free_bytes += b.free_bytes
 }
 
+// flush accumulates future cycle i of mp into the active profile and
+// clears it for reuse.
+func (mp *memRecord) flush(i uint32) {
+	mpc := &mp.future[i]
+	mp.active.add(mpc)
+	*mpc = memRecordCycle{}
+	if l := mp.lifetimes; l != nil {
+		for j, n := range l.future[i] {
+			l.active[j] += n
+		}
+		l.future[i] = [memLifetimeBuckets]uintptr{}
+	}
+}
+
+// memLifetimes counts the frees of a memRecord by the number of heap
+// profile cycles, that is, garbage collections, the objects survived.
+// active and future are as in memRecord. It is kept apart from the
+// memRecord so that buckets only pay for it with
+// GODEBUG=memprofilelifetimes=1.
+//
+//go:notinheap
+type memLifetimes struct {
+	active [memLifetimeBuckets]uintptr
+	future [3][memLifetimeBuckets]uintptr
+}
+
+// memLifetimeBuckets is the number of buckets in a lifetime histogram.
+// Bucket 0 counts objects that survived no garbage collections, bucket
+// 1 one, bucket 2 two, and bucket i > 2 from 2^(i-2)+1 to 2^(i-1). The
+// last bucket also counts all longer lifetimes.
+const memLifetimeBuckets = 7
+
+// memLifetimeBucket returns the lifetime histogram bucket for an
+// object that survived n garbage collections.
+func memLifetimeBucket(n uint32) int {
+	if n == 0 {
+		return 0
+	}
+	i := 1
+	for n--; n != 0; n >>= 1 {
+		i++
+	}
+	if i >= memLifetimeBuckets {
+		i = memLifetimeBuckets - 1
+	}
+	return i
+}
+
 // A blockRecord is the bucket data for a bucket of type blockProfile,
-// which is used in blocking and mutex profiles.
+// which is used in blocking, mutex, scheduling latency and stack
+// growth profiles.
 type blockRecord struct {
 	count  int64
 	cycles int64
@@ -141,6 +197,8 @@ var (
 	mbuckets  *bucket // memory profile buckets
 	bbuckets  *bucket // blocking profile buckets
 	xbuckets  *bucket // mutex profile buckets
+	sbuckets  *bucket // scheduling latency profile buckets
+	gbuckets  *bucket // stack growth profile buckets
 	buckhash  *[179999]*bucket
 	bucketmem uintptr
 
@@ -166,7 +224,7 @@ func newBucket(typ bucketType, nstk int) *bucket {
 		throw("invalid profile bucket type")
 	case memProfile:
 		size += unsafe.Sizeof(memRecord{})
-	case blockProfile, mutexProfile:
+	case blockProfile, mutexProfile, schedProfile, stackGrowthProfile:
 		size += unsafe.Sizeof(blockRecord{})
 	}
 
@@ -194,7 +252,7 @@ func (b *bucket) mp() *memRecord {
 
 // bp returns the blockRecord associated with the blockProfile bucket b.
 func (b *bucket) bp() *blockRecord {
-	if b.typ != blockProfile && b.typ != mutexProfile {
+	if b.typ != blockProfile && b.typ != mutexProfile && b.typ != schedProfile && b.typ != stackGrowthProfile {
 		throw("bad use of bucket.bp")
 	}
 	data := add(unsafe.Pointer(b), unsafe.Sizeof(*b)+b.nstk*unsafe.Sizeof(uintptr(0)))
@@ -244,11 +302,21 @@ func stkbucket(typ bucketType, size uintptr, stk []uintptr, alloc bool) *bucket
 	b.next = buckhash[i]
 	buckhash[i] = b
 	if typ == memProfile {
+		if debug.memprofilelifetimes != 0 {
+			b.mp().lifetimes = (*memLifetimes)(persistentalloc(unsafe.Sizeof(memLifetimes{}), 0, &memstats.buckhash_sys))
+			bucketmem += unsafe.Sizeof(memLifetimes{})
+		}
 		b.allnext = mbuckets
 		mbuckets = b
 	} else if typ == mutexProfile {
 		b.allnext = xbuckets
 		xbuckets = b
+	} else if typ == schedProfile {
+		b.allnext = sbuckets
+		sbuckets = b
+	} else if typ == stackGrowthProfile {
+		b.allnext = gbuckets
+		gbuckets = b
 	} else {
 		b.allnext = bbuckets
 		bbuckets = b
@@ -309,9 +377,7 @@ func mProf_FlushLocked() {
 
 		// Flush cycle C into the published profile and clear
 		// it for reuse.
-		mpc := &mp.future[c%uint32(len(mp.future))]
-		mp.active.add(mpc)
-		*mpc = memRecordCycle{}
+		mp.flush(c % uint32(len(mp.future)))
 	}
 }
 
@@ -329,9 +395,7 @@ func mProf_PostSweep() {
 	c := mProf.cycle
 	for b := mbuckets; b != nil; b = b.allnext {
 		mp := b.mp()
-		mpc := &mp.future[(c+1)%uint32(len(mp.future))]
-		mp.active.add(mpc)
-		*mpc = memRecordCycle{}
+		mp.flush((c + 1) % uint32(len(mp.future)))
 	}
 	unlock(&proflock)
 }
@@ -354,18 +418,27 @@ func mProf_Malloc(p unsafe.Pointer, size uintptr) {
 	// Since the object must be alive during call to mProf_Malloc,
 	// it's fine to do this non-atomically.
 	systemstack(func() {
-		setprofilebucket(p, b)
+		setprofilebucket(p, b, c)
 	})
 }
 
-// Called when freeing a profiled block.
-func mProf_Free(b *bucket, size uintptr) {
+// Called when freeing a profiled block, which was allocated in heap
+// profile cycle allocCycle.
+func mProf_Free(b *bucket, size uintptr, allocCycle uint32) {
 	lock(&proflock)
 	c := mProf.cycle
 	mp := b.mp()
 	mpc := &mp.future[(c+1)%uint32(len(mp.future))]
 	mpc.frees++
 	mpc.free_bytes += size
+	if l := mp.lifetimes; l != nil {
+		// Frees happen while sweeping, after the mark
+		// termination that found the object dead advanced the
+		// cycle, so an object freed by the first collection
+		// after its allocation survived none.
+		survived := (c + mProfCycleWrap - allocCycle - 1) % mProfCycleWrap
+		l.future[(c+1)%uint32(len(l.future))][memLifetimeBucket(survived)]++
+	}
 	unlock(&proflock)
 }
 
@@ -458,6 +531,94 @@ func mutexevent(cycles int64, skip int) {
 	}
 }
 
+var schedlatencyprofilerate uint64 // in nanoseconds
+
+// SetSchedLatencyProfileRate controls the fraction of scheduling delays
+// that are reported in the scheduling latency profile. A scheduling
+// delay is the time a goroutine spends runnable, waiting in a run queue
+// for a P, before it runs. The profiler aims to sample an average of
+// one delay per rate nanoseconds that goroutines spend waiting.
+//
+// To include every delay in the profile, pass rate = 1.
+// To turn off profiling entirely, pass rate <= 0.
+//
+// While the profile is enabled, the scheduler also measures the total
+// time each goroutine spends runnable, which goroutine tracebacks
+// report. This costs two reads of the clock on every goroutine switch.
+//
+// The execution tracer records every scheduling delay without this
+// profile and without events of its own: a delay is the time from the
+// event that made a goroutine runnable, such as GoUnblock, GoCreate or
+// GoPreempt, to its next GoStart, which trace viewers show as the
+// goroutine's scheduler wait.
+func SetSchedLatencyProfileRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	atomic.Store64(&schedlatencyprofilerate, uint64(rate))
+}
+
+// schedlatencyevent records in the scheduling latency profile that gp,
+// which is about to run, spent wait nanoseconds runnable.
+func schedlatencyevent(gp *g, wait int64) {
+	rate := int64(atomic.Load64(&schedlatencyprofilerate))
+	if rate <= 0 || (rate > wait && int64(fastrand())%rate > wait) {
+		return
+	}
+	var stk [maxStack]uintptr
+	nstk := gcallers(gp, 0, stk[:])
+	lock(&proflock)
+	b := stkbucket(schedProfile, 0, stk[:nstk], true)
+	b.bp().count++
+	b.bp().cycles += wait // in nanoseconds; see SchedLatencyProfile
+	unlock(&proflock)
+}
+
+var stackgrowthprofilerate uint64 // in bytes
+
+// SetStackGrowthProfileRate controls the fraction of goroutine stack
+// growths that are reported in the stack growth profile. The profiler
+// aims to sample an average of one growth per rate bytes by which
+// goroutine stacks grow. A stack doubles in size when it grows.
+//
+// To include every growth in the profile, pass rate = 1.
+// To turn off profiling entirely, pass rate <= 0.
+func SetStackGrowthProfileRate(rate int) {
+	if rate < 0 {
+		rate = 0
+	}
+	atomic.Store64(&stackgrowthprofilerate, uint64(rate))
+}
+
+// stackgrowthevent records in the stack growth profile that gp's stack
+// is about to grow from oldsize to newsize bytes. gp is stopped in
+// newstack, so its stack is the one that needs to grow.
+func stackgrowthevent(gp *g, oldsize, newsize uintptr) {
+	if gp.m.locks != 0 {
+		// gp may hold proflock, or another lock that can't be
+		// held while taking proflock.
+		return
+	}
+	rate := int64(atomic.Load64(&stackgrowthprofilerate))
+	grown := int64(newsize - oldsize)
+	if rate <= 0 || (rate > grown && int64(fastrand())%rate > grown) {
+		return
+	}
+	var stk [maxStack]uintptr
+	nstk := gcallers(gp, 0, stk[:])
+	if nstk > 0 {
+		// gp is stopped at the entry of the function that needs
+		// more stack. Record a PC after the entry, so that, like
+		// the return PCs of its callers, it symbolizes correctly
+		// when backed up by one.
+		stk[0] += sys.PCQuantum
+	}
+	lock(&proflock)
+	b := stkbucket(stackGrowthProfile, newsize, stk[:nstk], true)
+	b.bp().count++
+	unlock(&proflock)
+}
+
 // Go interface to profile data.
 
 // A StackRecord describes a single execution stack.
@@ -498,6 +659,13 @@ type MemProfileRecord struct {
 	AllocBytes, FreeBytes     int64       // number of bytes allocated, freed
 	AllocObjects, FreeObjects int64       // number of objects allocated, freed
 	Stack0                    [32]uintptr // stack trace for this record; ends at first 0 entry
+
+	// FreeLifetimes counts the freed objects by the number of
+	// garbage collections they survived before they were freed:
+	// 0, 1, 2, 3-4, 5-8, 9-16, and more than 16. It is only
+	// recorded if the program runs with GODEBUG=memprofilelifetimes=1,
+	// and is otherwise all zero.
+	FreeLifetimes [7]int64
 }
 
 // InUseBytes returns the number of bytes in use (AllocBytes - FreeBytes).
@@ -565,8 +733,7 @@ func MemProfile(p []MemProfileRecord, inuseZero bool) (n int, ok bool) {
 		for b := mbuckets; b != nil; b = b.allnext {
 			mp := b.mp()
 			for c := range mp.future {
-				mp.active.add(&mp.future[c])
-				mp.future[c] = memRecordCycle{}
+				mp.flush(uint32(c))
 			}
 			if inuseZero || mp.active.alloc_bytes != mp.active.free_bytes {
 				n++
@@ -595,6 +762,12 @@ func record(r *MemProfileRecord, b *bucket) {
 	r.FreeBytes = int64(mp.active.free_bytes)
 	r.AllocObjects = int64(mp.active.allocs)
 	r.FreeObjects = int64(mp.active.frees)
+	r.FreeLifetimes = [len(r.FreeLifetimes)]int64{}
+	if l := mp.lifetimes; l != nil {
+		for i, n := range l.active {
+			r.FreeLifetimes[i] = int64(n)
+		}
+	}
 	if raceenabled {
 		racewriterangepc(unsafe.Pointer(&r.Stack0[0]), unsafe.Sizeof(r.Stack0), getcallerpc(), funcPC(MemProfile))
 	}
@@ -689,6 +862,79 @@ func MutexProfile(p []BlockProfileRecord) (n int, ok bool) {
 	return
 }
 
+// SchedLatencyProfile returns n, the number of records in the current
+// scheduling latency profile. If len(p) >= n, SchedLatencyProfile copies
+// the profile into p and returns n, true. Otherwise, SchedLatencyProfile
+// does not change p, and returns n, false. The stack of each record is
+// where the goroutines were when they became runnable, and its Cycles
+// is the total time they spent waiting to run.
+//
+// Most clients should use the runtime/pprof package
+// instead of calling SchedLatencyProfile directly.
+func SchedLatencyProfile(p []BlockProfileRecord) (n int, ok bool) {
+	// The scheduler records delays in nanoseconds, since converting
+	// them would mean calibrating the clock in the scheduler.
+	cyclesPerNs := float64(tickspersecond()) / 1e9
+	lock(&proflock)
+	for b := sbuckets; b != nil; b = b.allnext {
+		n++
+	}
+	if n <= len(p) {
+		ok = true
+		for b := sbuckets; b != nil; b = b.allnext {
+			bp := b.bp()
+			r := &p[0]
+			r.Count = bp.count
+			r.Cycles = int64(float64(bp.cycles) * cyclesPerNs)
+			i := copy(r.Stack0[:], b.stk())
+			for ; i < len(r.Stack0); i++ {
+				r.Stack0[i] = 0
+			}
+			p = p[1:]
+		}
+	}
+	unlock(&proflock)
+	return
+}
+
+// A StackGrowthRecord describes the sampled goroutine stack growths to
+// a particular size at a particular execution stack.
+type StackGrowthRecord struct {
+	Count int64 // number of sampled growths
+	Size  int64 // stack size after the growths, in bytes
+	StackRecord
+}
+
+// StackGrowthProfile returns n, the number of records in the current
+// stack growth profile. If len(p) >= n, StackGrowthProfile copies the
+// profile into p and returns n, true. Otherwise, StackGrowthProfile
+// does not change p, and returns n, false. The stack of each record
+// is that of the function that needed more stack.
+//
+// Most clients should use the runtime/pprof package
+// instead of calling StackGrowthProfile directly.
+func StackGrowthProfile(p []StackGrowthRecord) (n int, ok bool) {
+	lock(&proflock)
+	for b := gbuckets; b != nil; b = b.allnext {
+		n++
+	}
+	if n <= len(p) {
+		ok = true
+		for b := gbuckets; b != nil; b = b.allnext {
+			r := &p[0]
+			r.Count = b.bp().count
+			r.Size = int64(b.size)
+			i := copy(r.Stack0[:], b.stk())
+			for ; i < len(r.Stack0); i++ {
+				r.Stack0[i] = 0
+			}
+			p = p[1:]
+		}
+	}
+	unlock(&proflock)
+	return
+}
+
 // ThreadCreateProfile returns n, the number of records in the thread creation profile.
 // If len(p) >= n, ThreadCreateProfile copies the profile into p and returns n, true.
 // If len(p) < n, ThreadCreateProfile does not change p and returns n, false.
@@ -718,52 +964,208 @@ func ThreadCreateProfile(p []StackRecord) (n int, ok bool) {
 // Most clients should use the runtime/pprof package instead
 // of calling GoroutineProfile directly.
 func GoroutineProfile(p []StackRecord) (n int, ok bool) {
-	gp := getg()
-
-	isOK := func(gp1 *g) bool {
-		// Checking isSystemGoroutine here makes GoroutineProfile
-		// consistent with both NumGoroutine and Stack.
-		return gp1 != gp && readgstatus(gp1) != _Gdead && !isSystemGoroutine(gp1)
+	records := make([]goroutineProfileRecord, len(p))
+	n, ok = goroutineProfileWithLabels(records, getcallerpc(), getcallersp())
+	if ok {
+		for i := range records[:n] {
+			p[i] = records[i].stack
+		}
 	}
+	return n, ok
+}
 
-	stopTheWorld("profile")
+// A goroutineProfileRecord is one goroutine in the goroutine profile.
+// It must match runtime/pprof.goroutineRecord.
+type goroutineProfileRecord struct {
+	stack  StackRecord
+	labels unsafe.Pointer // the goroutine's profiler labels
+	state  string         // wait reason or status, as in tracebacks
+	wait   int64          // approximate time blocked, in nanoseconds
+	gopc   uintptr        // pc of the go statement that created the goroutine
+	parent int64          // goid of the goroutine that created the goroutine
+}
+
+//go:linkname pprof_goroutineProfileWithLabels runtime/pprof.runtime_goroutineProfileWithLabels
+func pprof_goroutineProfileWithLabels(p unsafe.Pointer, len, cap int) (n int, ok bool) {
+	sl := slice{p, len, cap}
+	records := *(*[]goroutineProfileRecord)(unsafe.Pointer(&sl))
+	return goroutineProfileWithLabels(records, getcallerpc(), getcallersp())
+}
 
+// Values of g.goroutineProfiled.
+const (
+	goroutineProfileAbsent     = iota // not recorded in the profile being collected
+	goroutineProfileInProgress        // being recorded
+	goroutineProfileSatisfied         // recorded, or not part of the profile
+)
+
+// goroutineProfile is the state of the goroutine profile being
+// collected.
+//
+// Collecting a profile stops the world only to count the goroutines
+// and to mark each of them absent from the profile, and again briefly
+// at the end. In between, the profiler records the goroutines one at a
+// time while the world runs. Before a goroutine that is absent from
+// the profile runs again, the scheduler records it itself (see
+// tryRecordGoroutineProfile), so every goroutine's stack is the one it
+// had when the world was first stopped. Goroutines created after that
+// are not part of the profile.
+var goroutineProfile struct {
+	sema    uint32 // serializes collections
+	active  bool   // only changed with the world stopped
+	start   int64  // nanotime when the world was stopped
+	offset  uint32 // next index in records, accessed atomically
+	records []goroutineProfileRecord
+}
+
+// goroutineProfileWithLabels collects the goroutine profile into p.
+// The current goroutine is recorded as of pc and sp.
+func goroutineProfileWithLabels(p []goroutineProfileRecord, pc, sp uintptr) (n int, ok bool) {
+	ourg := getg()
+	semacquire(&goroutineProfile.sema)
+
+	stopTheWorld("profile")
 	n = 1
 	for _, gp1 := range allgs {
-		if isOK(gp1) {
+		if gp1 != ourg && readgstatus(gp1) != _Gdead && !isSystemGoroutine(gp1) {
 			n++
 		}
 	}
+	if n > len(p) {
+		// Not enough room. Don't bother to record anything.
+		startTheWorld()
+		semrelease(&goroutineProfile.sema)
+		return n, false
+	}
 
-	if n <= len(p) {
-		ok = true
-		r := p
+	now := nanotime()
+	systemstack(func() {
+		saveg(pc, sp, ourg, &p[0].stack)
+	})
+	p[0].labels = ourg.labels
+	p[0].state = gStatusStrings[_Grunning]
+	p[0].wait = 0
+	p[0].gopc = ourg.gopc
+	p[0].parent = ourg.parentGoid
+	goroutineProfile.active = true
+	goroutineProfile.start = now
+	goroutineProfile.offset = 1
+	goroutineProfile.records = p
+	for _, gp1 := range allgs {
+		gp1.goroutineProfiled = goroutineProfileAbsent
+	}
+	ourg.goroutineProfiled = goroutineProfileSatisfied
+	startTheWorld()
 
-		// Save current goroutine.
-		sp := getcallersp()
-		pc := getcallerpc()
-		systemstack(func() {
-			saveg(pc, sp, gp, &r[0])
-		})
-		r = r[1:]
-
-		// Save other goroutines.
-		for _, gp1 := range allgs {
-			if isOK(gp1) {
-				if len(r) == 0 {
-					// Should be impossible, but better to return a
-					// truncated profile than to crash the entire process.
-					break
-				}
-				saveg(^uintptr(0), ^uintptr(0), gp1, &r[0])
-				r = r[1:]
-			}
-		}
+	// allgs only grows, and goroutines created from now on are
+	// not part of the profile, so a snapshot of it suffices.
+	lock(&allglock)
+	gs := allgs
+	unlock(&allglock)
+	for _, gp1 := range gs {
+		tryRecordGoroutineProfile(gp1, Gosched)
 	}
 
+	// Stop the world once more so that no goroutine is still
+	// recording itself into p.
+	stopTheWorld("profile cleanup")
+	n = int(goroutineProfile.offset)
+	if n > len(p) {
+		n = len(p)
+	}
+	goroutineProfile.active = false
+	goroutineProfile.offset = 0
+	goroutineProfile.records = nil
 	startTheWorld()
 
-	return n, ok
+	semrelease(&goroutineProfile.sema)
+	return n, true
+}
+
+// tryRecordGoroutineProfile records gp1 in the goroutine profile being
+// collected if it hasn't been yet, or waits, calling yield, until
+// another M has recorded it. gp1 must not be running, and must not
+// start running until this returns.
+func tryRecordGoroutineProfile(gp1 *g, yield func()) {
+	if readgstatus(gp1) == _Gdead {
+		// Dead goroutines aren't in the profile. One that has
+		// been reused since the world was stopped was marked
+		// satisfied by newproc1.
+		return
+	}
+	if isSystemGoroutine(gp1) {
+		atomic.Store(&gp1.goroutineProfiled, goroutineProfileSatisfied)
+		return
+	}
+	for {
+		switch atomic.Load(&gp1.goroutineProfiled) {
+		case goroutineProfileSatisfied:
+			return
+		case goroutineProfileInProgress:
+			yield()
+			continue
+		}
+		if atomic.Cas(&gp1.goroutineProfiled, goroutineProfileAbsent, goroutineProfileInProgress) {
+			break
+		}
+	}
+	systemstack(func() {
+		doRecordGoroutineProfile(gp1)
+	})
+	atomic.Store(&gp1.goroutineProfiled, goroutineProfileSatisfied)
+}
+
+// tryRecordGoroutineProfileWB is tryRecordGoroutineProfile for callers
+// that don't otherwise allow write barriers. gp1 is the calling
+// goroutine, which is returning from a system call and has a P.
+//
+//go:yeswritebarrierrec
+func tryRecordGoroutineProfileWB(gp1 *g) {
+	if getg().m.p.ptr() == nil {
+		throw("no P available, write barriers are forbidden")
+	}
+	tryRecordGoroutineProfile(gp1, osyield)
+}
+
+// doRecordGoroutineProfile writes gp's record in the goroutine profile.
+// It holds gp's scan bit while it does, which keeps the garbage
+// collector from moving gp's stack.
+//
+//go:systemstack
+func doRecordGoroutineProfile(gp *g) {
+	var s uint32
+	for {
+		s = readgstatus(gp) &^ _Gscan
+		if (s == _Grunnable || s == _Gwaiting || s == _Gsyscall) && castogscanstatus(gp, s, s|_Gscan) {
+			break
+		}
+		procyield(10)
+	}
+
+	i := atomic.Xadd(&goroutineProfile.offset, 1) - 1
+	if int(i) < len(goroutineProfile.records) {
+		r := &goroutineProfile.records[i]
+		saveg(^uintptr(0), ^uintptr(0), gp, &r.stack)
+		r.labels = gp.labels
+		r.gopc = gp.gopc
+		r.parent = gp.parentGoid
+		r.wait = 0
+		if s == _Gwaiting && gp.waitreason != waitReasonZero {
+			r.state = gp.waitreason.String()
+		} else {
+			r.state = gStatusStrings[s]
+		}
+		if s == _Gwaiting || s == _Gsyscall {
+			// As in scanstack, the time the profile started
+			// is an upper bound on when gp blocked.
+			if gp.waitsince == 0 {
+				gp.waitsince = goroutineProfile.start
+			}
+			r.wait = goroutineProfile.start - gp.waitsince
+		}
+	}
+
+	casfrom_Gscanstatus(gp, s|_Gscan, s)
 }
 
 func saveg(pc, sp uintptr, gp *g, r *StackRecord) {
@@ -777,6 +1179,8 @@ func saveg(pc, sp uintptr, gp *g, r *StackRecord) {
 // and returns the number of bytes written to buf.
 // If all is true, Stack formats stack traces of all other goroutines
 // into buf after the trace for the current goroutine.
+// Stack stops the world to do so; runtime/debug.AllStacks is an
+// alternative that does not.
 func Stack(buf []byte, all bool) int {
 	if all {
 		stopTheWorld("stack trace")
@@ -811,6 +1215,118 @@ func Stack(buf []byte, all bool) int {
 	return n
 }
 
+//go:linkname debug_allStacks runtime/debug.allStacks
+func debug_allStacks(buf []byte) int {
+	return allStacks(buf, getcallerpc(), getcallersp())
+}
+
+//go:linkname pprof_allStacks runtime/pprof.runtime_allStacks
+func pprof_allStacks(buf []byte) int {
+	return allStacks(buf, getcallerpc(), getcallersp())
+}
+
+// allStacks is like Stack(buf, true), but without stopping the world.
+// The current goroutine is formatted as of pc and sp. Each other
+// goroutine is suspended only while its own stack is formatted, as
+// scang does to scan it, so the traces are not a consistent snapshot:
+// goroutines may run between them, and goroutines created meanwhile
+// are left out.
+func allStacks(buf []byte, pc, sp uintptr) int {
+	if len(buf) == 0 {
+		return 0
+	}
+	gp := getg()
+	n := 0
+	systemstack(func() {
+		g0 := getg()
+		g0.m.traceback = 1
+		g0.writebuf = buf[0:0:len(buf)]
+		goroutineheader(gp)
+		traceback(pc, sp, 0, gp)
+		g0.m.traceback = 0
+		n = len(g0.writebuf)
+		g0.writebuf = nil
+	})
+
+	// allgs only grows, so a snapshot of it has every goroutine
+	// that exists now.
+	lock(&allglock)
+	gs := allgs
+	unlock(&allglock)
+	for _, gp1 := range gs {
+		if n == len(buf) {
+			break
+		}
+		if gp1 == gp || isSystemGoroutine(gp1) {
+			continue
+		}
+		for i := 0; ; i++ {
+			done := false
+			systemstack(func() {
+				done = tracebackSuspended(gp1, buf, &n)
+			})
+			if done {
+				break
+			}
+			// gp1 is running or being scanned. Let it get to a
+			// stopping point.
+			if i < 10 {
+				procyield(10)
+			} else {
+				Gosched()
+			}
+		}
+	}
+	return n
+}
+
+// tracebackSuspended formats gp's stack into buf[*n:] and advances *n
+// if it can suspend gp, holding gp's scan bit while it does so that gp
+// can't run and its stack can't move. If gp is running, it asks gp to
+// stop instead, and the caller must try again. It reports whether it
+// is done with gp.
+//
+//go:systemstack
+func tracebackSuspended(gp *g, buf []byte, n *int) bool {
+	switch s := readgstatus(gp); s {
+	case _Gdead:
+		// Exited since allStacks started.
+		return true
+
+	case _Grunnable, _Gsyscall, _Gwaiting:
+		if !castogscanstatus(gp, s, s|_Gscan) {
+			return false
+		}
+		g0 := getg()
+		g0.m.traceback = 1
+		g0.writebuf = buf[0:*n:len(buf)]
+		print("\n")
+		goroutineheaderStatus(gp, s)
+		traceback(^uintptr(0), ^uintptr(0), 0, gp)
+		g0.m.traceback = 0
+		*n = len(g0.writebuf)
+		g0.writebuf = nil
+		casfrom_Gscanstatus(gp, s|_Gscan, s)
+		return true
+
+	case _Grunning:
+		// Ask gp to yield, as scang does, but without a
+		// self scan. The next attempt finds it runnable,
+		// unless it is rescheduled first.
+		if castogscanstatus(gp, _Grunning, _Gscanrunning) {
+			gp.preempt = true
+			gp.stackguard0 = stackPreempt
+			if mp := gp.m; mp != nil && preemptMSupported && debug.asyncpreemptoff == 0 {
+				preemptM(mp)
+			}
+			casfrom_Gscanstatus(gp, _Gscanrunning, _Grunning)
+		}
+	}
+	// _Gcopystack, or a status with the scan bit held by someone
+	// else.
+	return false
+}
+
 // Tracing of alloc/free/gc.
 
 var tracelock mutex
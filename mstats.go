@@ -93,7 +93,16 @@ type mstats struct {
 	// Statistics below here are not exported to MemStats directly.
 
 	last_gc_nanotime uint64 // last gc (monotonic time)
-	tinyallocs       uint64 // number of tiny allocations that didn't cause actual allocation; not exported to go directly
+	last_next_gc     uint64 // next_gc for the previous GC cycle; the scavenger's goal is based on it
+	numminorgc       uint64 // number of minor GCs; see mgcgen.go
+
+	// gcPauseDist represents the distribution of all GC-related
+	// application pauses in the runtime.
+	//
+	// Each individual pause is counted separately, unlike pause_ns.
+	gcPauseDist timeHistogram
+
+	tinyallocs uint64 // number of tiny allocations that didn't cause actual allocation; not exported to go directly
 
 	// triggerRatio is the heap growth ratio that triggers marking.
 	//
@@ -447,6 +456,14 @@ func init() {
 		println(unsafe.Offsetof(memstats.heap_live))
 		throw("memstats.heap_live not aligned to 8 bytes")
 	}
+	if unsafe.Offsetof(memstats.gcPauseDist)%8 != 0 {
+		println(unsafe.Offsetof(memstats.gcPauseDist))
+		throw("memstats.gcPauseDist not aligned to 8 bytes")
+	}
+	if unsafe.Offsetof(memstats.numminorgc)%8 != 0 {
+		println(unsafe.Offsetof(memstats.numminorgc))
+		throw("memstats.numminorgc not aligned to 8 bytes")
+	}
 }
 
 // ReadMemStats populates m with memory allocator statistics.
@@ -79,7 +79,7 @@ const (
 func (b *wbBuf) reset() {
 	start := uintptr(unsafe.Pointer(&b.buf[0]))
 	b.next = start
-	if gcBlackenPromptly || writeBarrier.cgo {
+	if gcBlackenPromptly || writeBarrier.cgo || gcgen.remembering {
 		// Effectively disable the buffer by forcing a flush
 		// on every barrier.
 		b.end = uintptr(unsafe.Pointer(&b.buf[wbBufEntryPointers]))
@@ -189,6 +189,16 @@ func wbBufFlush(dst *uintptr, src uintptr) {
 		}
 	}
 
+	if gcgen.remembering {
+		// Between GC cycles, the write barrier only maintains
+		// the card table, which needs the slot.
+		getg().m.p.ptr().wbBuf.discard()
+		if dst != nil && src != 0 {
+			gcCardMark(uintptr(unsafe.Pointer(dst)))
+		}
+		return
+	}
+
 	// Switch to the system stack so we don't have to worry about
 	// the untyped stack slots or safe points.
 	systemstack(func() {
@@ -22,6 +22,8 @@ import (
 // An implementation must call the following function to denote that the pd is ready.
 // 使用下面的方法表示 pd已经就绪
 // func netpollready(gpp **g, pd *pollDesc, mode int32)
+// func netpoll(delay int64) *g // poll for ready network connections, blocking for up to delay ns
+// func netpollBreak()          // wake up a thread blocked in netpoll
 
 // pollDesc contains 2 binary semaphores(信号量), rg and wg, to park(存放，寄存) reader and writer
 // goroutines respectively(分别). The semaphore can be in the following states:
@@ -83,15 +85,30 @@ type pollCache struct {
 }
 
 var (
-	netpollInited  uint32
-	pollcache      pollCache
-	netpollWaiters uint32
+	netpollInitLock mutex
+	netpollInited   uint32
+	pollcache       pollCache
+	netpollWaiters  uint32
 )
 
 //go:linkname poll_runtime_pollServerInit internal/poll.runtime_pollServerInit
 func poll_runtime_pollServerInit() {
-	netpollinit()
-	atomic.Store(&netpollInited, 1)
+	netpollGenericInit()
+}
+
+// netpollGenericInit initializes the poller if it has not been
+// initialized yet. Besides the network, timers use the poller to
+// block until the next timer is due, so it may be called either by
+// package internal/poll or by the first addtimer.
+func netpollGenericInit() {
+	if atomic.Load(&netpollInited) == 0 {
+		lock(&netpollInitLock)
+		if netpollInited == 0 {
+			netpollinit()
+			atomic.Store(&netpollInited, 1)
+		}
+		unlock(&netpollInitLock)
+	}
 }
 
 func netpollinited() bool {
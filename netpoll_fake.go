@@ -9,6 +9,14 @@
 
 package runtime
 
+import "runtime/internal/atomic"
+
+var (
+	netpollStubLock mutex
+	netpollNote     note
+	netpollBroken   uint32
+)
+
 func netpollinit() {
 }
 
@@ -27,6 +35,28 @@ func netpollclose(fd uintptr) int32 {
 func netpollarm(pd *pollDesc, mode int) {
 }
 
-func netpoll(block bool) *g {
+func netpollBreak() {
+	if GOARCH == "wasm" {
+		// No threads, so nothing can be blocked in netpoll.
+		return
+	}
+	if atomic.Cas(&netpollBroken, 0, 1) {
+		notewakeup(&netpollNote)
+	}
+}
+
+// netpoll never finds any ready network connections, but the
+// scheduler uses it to wait until the next timer is due.
+// On wasm, findrunnable pauses until a callback instead.
+func netpoll(delay int64) *g {
+	if delay != 0 && GOARCH != "wasm" {
+		// This lock ensures that only one goroutine tries to use
+		// the note. It should normally be completely uncontended.
+		lock(&netpollStubLock)
+		noteclear(&netpollNote)
+		atomic.Store(&netpollBroken, 0)
+		notetsleep(&netpollNote, delay)
+		unlock(&netpollStubLock)
+	}
 	return nil
 }
@@ -57,15 +57,42 @@ func netpollarm(pd *pollDesc, mode int) {
 	throw("runtime: unused")
 }
 
-// Polls for ready network connections.
+// netpollBreak interrupts a kevent.
+//
+// It arms a one-shot timer that expires immediately. Timer events
+// are only used for this, so netpoll treats any of them as a wakeup.
+func netpollBreak() {
+	var ev keventt
+	ev.filter = _EVFILT_TIMER
+	ev.flags = _EV_ADD | _EV_ONESHOT
+	n := kevent(kq, &ev, 1, nil, 0, nil)
+	if n < 0 && n != -_EINTR {
+		println("runtime: netpollBreak kevent failed with", -n)
+		throw("runtime: netpollBreak kevent failed")
+	}
+}
+
+// netpoll checks for ready network connections.
 // Returns list of goroutines that become runnable.
-func netpoll(block bool) *g {
+// delay < 0: blocks indefinitely
+// delay == 0: does not block, just polls
+// delay > 0: block for up to that many nanoseconds
+func netpoll(delay int64) *g {
 	if kq == -1 {
 		return nil
 	}
 	var tp *timespec
 	var ts timespec
-	if !block {
+	if delay < 0 {
+		tp = nil
+	} else if delay == 0 {
+		tp = &ts
+	} else {
+		ts.setNsec(delay)
+		if ts.tv_sec > 1e6 {
+			// Darwin returns EINVAL if the sleep time is too long.
+			ts.tv_sec = 1e6
+		}
 		tp = &ts
 	}
 	var events [64]keventt
@@ -76,6 +103,11 @@ retry:
 			println("runtime: kevent on fd", kq, "failed with", -n)
 			throw("runtime: netpoll failed")
 		}
+		// If a timed sleep was interrupted, just return to
+		// recalculate how long we should sleep now.
+		if delay > 0 {
+			return nil
+		}
 		goto retry
 	}
 	var gp guintptr
@@ -83,6 +115,9 @@ retry:
 		ev := &events[i]
 		var mode int32
 		switch ev.filter {
+		case _EVFILT_TIMER:
+			// Woken up by netpollBreak.
+			continue
 		case _EVFILT_READ:
 			mode += 'r'
 
@@ -105,8 +140,5 @@ retry:
 			netpollready(&gp, (*pollDesc)(unsafe.Pointer(ev.udata)), mode)
 		}
 	}
-	if block && gp == 0 {
-		goto retry
-	}
 	return gp.ptr()
 }
@@ -71,17 +71,20 @@ import "unsafe"
 //go:cgo_import_dynamic libc_port_associate port_associate "libc.so"
 //go:cgo_import_dynamic libc_port_dissociate port_dissociate "libc.so"
 //go:cgo_import_dynamic libc_port_getn port_getn "libc.so"
+//go:cgo_import_dynamic libc_port_send port_send "libc.so"
 
 //go:linkname libc_port_create libc_port_create
 //go:linkname libc_port_associate libc_port_associate
 //go:linkname libc_port_dissociate libc_port_dissociate
 //go:linkname libc_port_getn libc_port_getn
+//go:linkname libc_port_send libc_port_send
 
 var (
 	libc_port_create,
 	libc_port_associate,
 	libc_port_dissociate,
-	libc_port_getn libcFunc
+	libc_port_getn,
+	libc_port_send libcFunc
 )
 
 func errno() int32 {
@@ -108,6 +111,10 @@ func port_getn(port int32, evs *portevent, max uint32, nget *uint32, timeout *ti
 	return int32(sysvicall5(&libc_port_getn, uintptr(port), uintptr(unsafe.Pointer(evs)), uintptr(max), uintptr(unsafe.Pointer(nget)), uintptr(unsafe.Pointer(timeout))))
 }
 
+func port_send(port int32, events int32, user uintptr) int32 {
+	return int32(sysvicall3(&libc_port_send, uintptr(port), uintptr(events), user))
+}
+
 var portfd int32 = -1
 
 func netpollinit() {
@@ -178,34 +185,72 @@ func netpollarm(pd *pollDesc, mode int) {
 	unlock(&pd.lock)
 }
 
-// polls for ready network connections
-// returns list of goroutines that become runnable
-func netpoll(block bool) *g {
+// netpollBreak interrupts a port_getn wait by sending a user event
+// to the port.
+func netpollBreak() {
+	if port_send(portfd, 0, 0) < 0 {
+		if e := errno(); e != _EAGAIN {
+			print("runtime: port_send failed (errno=", e, ")\n")
+			throw("runtime: netpollBreak failed")
+		}
+	}
+}
+
+// netpoll checks for ready network connections.
+// Returns list of goroutines that become runnable.
+// delay < 0: blocks indefinitely
+// delay == 0: does not block, just polls
+// delay > 0: block for up to that many nanoseconds
+func netpoll(delay int64) *g {
 	if portfd == -1 {
 		return nil
 	}
 
 	var wait *timespec
-	var zero timespec
-	if !block {
-		wait = &zero
+	var ts timespec
+	if delay < 0 {
+		wait = nil
+	} else if delay == 0 {
+		wait = &ts
+	} else {
+		ts.tv_sec = delay / 1e9
+		ts.tv_nsec = delay % 1e9
+		if ts.tv_sec > 1e6 {
+			// An arbitrary cap on how long to wait for a timer.
+			ts.tv_sec = 1e6
+		}
+		wait = &ts
 	}
 
 	var events [128]portevent
 retry:
 	var n uint32 = 1
 	if port_getn(portfd, &events[0], uint32(len(events)), &n, wait) < 0 {
-		if e := errno(); e != _EINTR {
+		e := errno()
+		if e != _EINTR && e != _ETIME {
 			print("runtime: port_getn on fd ", portfd, " failed (errno=", e, ")\n")
 			throw("runtime: netpoll failed")
 		}
-		goto retry
+		// port_getn may return some events along with ETIME.
+		// If a timed sleep expired or was interrupted and there
+		// are no events, just return to recalculate how long we
+		// should sleep now.
+		if n == 0 {
+			if delay >= 0 {
+				return nil
+			}
+			goto retry
+		}
 	}
 
 	var gp guintptr
 	for i := 0; i < int(n); i++ {
 		ev := &events[i]
 
+		if ev.portev_source == _PORT_SOURCE_USER {
+			// Woken up by netpollBreak.
+			continue
+		}
 		if ev.portev_events == 0 {
 			continue
 		}
@@ -237,8 +282,5 @@ retry:
 		}
 	}
 
-	if block && gp == 0 {
-		goto retry
-	}
 	return gp.ptr()
 }
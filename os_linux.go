@@ -354,6 +354,21 @@ func sigprocmask(how int32, new, old *sigset) {
 
 func raise(sig uint32)
 func raiseproc(sig uint32)
+func getpid() int
+func tgkill(tgid, tid, sig int)
+
+// preemptMSupported reports whether preemptM is implemented. This
+// requires register maps from the compiler, which only amd64 has.
+const preemptMSupported = GOARCH == "amd64"
+
+// preemptM sends a preemption request to mp. This request may be
+// handled asynchronously and may be coalesced with other requests to
+// the M. When the request is received, if the running G or P are
+// marked for preemption and the goroutine is at an asynchronous
+// safe-point, it will preempt the goroutine.
+func preemptM(mp *m) {
+	tgkill(getpid(), int(mp.procid), sigPreempt)
+}
 
 //go:noescape
 func sched_getaffinity(pid, len uintptr, buf *byte) int32
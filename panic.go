@@ -82,6 +82,14 @@ func panicmem() {
 	panic(memoryError)
 }
 
+var stackLimitError = error(errorString("goroutine stack exceeds its limit"))
+
+// panicstacklimit is called by newstack, in place of a function that
+// needed more stack than the goroutine's group allows.
+func panicstacklimit() {
+	panic(stackLimitError)
+}
+
 func throwinit() {
 	throw("recursive call during initialization - linker skew")
 }
@@ -298,6 +306,10 @@ func freedefer(d *_defer) {
 	d.started = false
 	d.sp = 0
 	d.pc = 0
+	d.openDefer = false
+	d.fd = nil
+	d.varp = 0
+	d.framepc = 0
 	// d._panic and d.fn must be nil already.
 	// If not, we would have called freedeferpanic or freedeferfn above,
 	// both of which throw.
@@ -342,6 +354,17 @@ func deferreturn(arg0 uintptr) {
 	if d.sp != sp {
 		return
 	}
+	if d.openDefer {
+		// A deferred call recovered from a panic and left
+		// the rest of the frame's open-coded defers to run.
+		done := runOpenDeferFrame(gp, d)
+		if !done {
+			throw("unfinished open-coded defers in deferreturn")
+		}
+		gp._defer = d.link
+		freedefer(d)
+		return
+	}
 
 	// Moving arguments around.
 	//
@@ -377,6 +400,7 @@ func Goexit() {
 	// This code is similar to gopanic, see that implementation
 	// for detailed comments.
 	gp := getg()
+	addOneOpenDeferFrame(gp, getcallerpc(), unsafe.Pointer(getcallersp()))
 	for {
 		d := gp._defer
 		if d == nil {
@@ -387,13 +411,24 @@ func Goexit() {
 				d._panic.aborted = true
 				d._panic = nil
 			}
-			d.fn = nil
-			gp._defer = d.link
-			freedefer(d)
-			continue
+			if !d.openDefer {
+				d.fn = nil
+				gp._defer = d.link
+				freedefer(d)
+				continue
+			}
+			// Run the rest of the frame's open-coded defers.
 		}
 		d.started = true
-		reflectcall(nil, unsafe.Pointer(d.fn), deferArgs(d), uint32(d.siz), uint32(d.siz))
+		if d.openDefer {
+			if !runOpenDeferFrame(gp, d) {
+				// Without a panic, nothing can recover.
+				throw("unfinished open-coded defers in Goexit")
+			}
+			addOneOpenDeferFrame(gp, 0, nil)
+		} else {
+			reflectcall(nil, unsafe.Pointer(d.fn), deferArgs(d), uint32(d.siz), uint32(d.siz))
+		}
 		if gp._defer != d {
 			throw("bad defer entry in Goexit")
 		}
@@ -479,6 +514,10 @@ func gopanic(e interface{}) {
 
 	atomic.Xadd(&runningPanicDefers, 1)
 
+	// Find the innermost frame with open-coded defers. Starting
+	// at the caller avoids scanning gopanic's own frame.
+	addOneOpenDeferFrame(gp, getcallerpc(), unsafe.Pointer(getcallersp()))
+
 	for {
 		d := gp._defer
 		if d == nil {
@@ -492,10 +531,15 @@ func gopanic(e interface{}) {
 				d._panic.aborted = true
 			}
 			d._panic = nil
-			d.fn = nil
-			gp._defer = d.link
-			freedefer(d)
-			continue
+			if !d.openDefer {
+				d.fn = nil
+				gp._defer = d.link
+				freedefer(d)
+				continue
+			}
+			// Keep an open-coded defer entry, to run the
+			// frame's other defers. The one that panicked
+			// is already cleared from its defer bits.
 		}
 
 		// Mark defer as started, but keep on list, so that traceback
@@ -508,8 +552,16 @@ func gopanic(e interface{}) {
 		// will find d in the list and will mark d._panic (this panic) aborted.
 		d._panic = (*_panic)(noescape(unsafe.Pointer(&p)))
 
-		p.argp = unsafe.Pointer(getargp(0))
-		reflectcall(nil, unsafe.Pointer(d.fn), deferArgs(d), uint32(d.siz), uint32(d.siz))
+		done := true
+		if d.openDefer {
+			done = runOpenDeferFrame(gp, d)
+			if done && !p.recovered {
+				addOneOpenDeferFrame(gp, 0, nil)
+			}
+		} else {
+			p.argp = unsafe.Pointer(getargp(0))
+			reflectcall(nil, unsafe.Pointer(d.fn), deferArgs(d), uint32(d.siz), uint32(d.siz))
+		}
 		p.argp = nil
 
 		// reflectcall did not panic. Remove d.
@@ -517,18 +569,51 @@ func gopanic(e interface{}) {
 			throw("bad defer entry in panic")
 		}
 		d._panic = nil
-		d.fn = nil
-		gp._defer = d.link
 
 		// trigger shrinkage to test stack copy. See stack_test.go:TestStackPanic
 		//GC()
 
 		pc := d.pc
 		sp := unsafe.Pointer(d.sp) // must be pointer so it gets adjusted during stack copy
-		freedefer(d)
+		if done {
+			d.fn = nil
+			gp._defer = d.link
+			freedefer(d)
+		}
 		if p.recovered {
 			atomic.Xadd(&runningPanicDefers, -1)
 
+			if done {
+				// Remove the entries for open-coded defer
+				// frames not yet started. Those frames run
+				// their defers inline when they return, so
+				// the entries would only get stale.
+				d := gp._defer
+				var prev *_defer
+				for d != nil {
+					if d.openDefer {
+						if d.started {
+							// A panic and recover
+							// within one of the
+							// frame's defers; leave
+							// it and the rest alone.
+							break
+						}
+						if prev == nil {
+							gp._defer = d.link
+						} else {
+							prev.link = d.link
+						}
+						next := d.link
+						freedefer(d)
+						d = next
+					} else {
+						prev = d
+						d = d.link
+					}
+				}
+			}
+
 			gp._panic = p.link
 			// Aborted panics are marked but remain on the g.panic list.
 			// Remove them from the list.
@@ -556,6 +641,196 @@ func gopanic(e interface{}) {
 	*(*int)(nil) = 0      // not reached
 }
 
+// addOneOpenDeferFrame scans the stack for the first frame with
+// open-coded defers that has no entry in the defer chain yet, and adds
+// an entry for it, in the order of the chain. If sp is nil, the scan
+// starts after the frame of the first entry of the chain, which must
+// be an open-coded defer entry; otherwise it starts at the frame with
+// pc and sp.
+func addOneOpenDeferFrame(gp *g, pc uintptr, sp unsafe.Pointer) {
+	var prevDefer *_defer
+	if sp == nil {
+		prevDefer = gp._defer
+		pc = prevDefer.framepc
+		sp = unsafe.Pointer(prevDefer.sp)
+	}
+	var found bool
+	var fd unsafe.Pointer
+	var varp, framepc, framesp, entry uintptr
+	systemstack(func() {
+		gentraceback(pc, uintptr(sp), 0, gp, 0, nil, 0x7fffffff,
+			func(frame *stkframe, unused unsafe.Pointer) bool {
+				if prevDefer != nil && prevDefer.sp == frame.sp {
+					// The frame the scan restarts at.
+					return true
+				}
+				fd1 := funcdata(frame.fn, _FUNCDATA_OpenCodedDeferInfo)
+				if fd1 == nil {
+					return true
+				}
+				for d := gp._defer; d != nil && d.sp <= frame.sp; d = d.link {
+					if d.sp == frame.sp {
+						if !d.openDefer {
+							throw("duplicated defer entry")
+						}
+						return true
+					}
+				}
+				found = true
+				fd, varp, framepc, framesp, entry = fd1, frame.varp, frame.pc, frame.sp, frame.fn.entry
+				return false
+			},
+			nil, 0)
+	})
+	if !found {
+		return
+	}
+	addOpenDeferEntry(gp, fd, varp, framepc, framesp, entry)
+}
+
+// addOpenDeferEntry adds an entry to gp's defer chain for the frame
+// with varp, pc and sp of the function starting at entry, whose
+// _FUNCDATA_OpenCodedDeferInfo is fd. gp must be the current
+// goroutine, as newdefer pushes onto its chain.
+func addOpenDeferEntry(gp *g, fd unsafe.Pointer, varp, framepc, framesp, entry uintptr) {
+	deferreturn, fd := readvarintUnsafe(fd)
+	maxargsize, fd := readvarintUnsafe(fd)
+	// newdefer pushes the new entry; move it to its place in the
+	// chain, which is sorted by sp.
+	d1 := newdefer(int32(maxargsize))
+	gp._defer = d1.link
+	var prev *_defer
+	d := gp._defer
+	for d != nil && d.sp < framesp {
+		prev = d
+		d = d.link
+	}
+	d1.openDefer = true
+	d1._panic = nil
+	// The pc to resume the frame at after one of its defers
+	// recovers: its deferreturn call, which runs the rest of them
+	// and returns.
+	d1.pc = entry + uintptr(deferreturn)
+	d1.varp = varp
+	d1.fd = fd
+	d1.framepc = framepc
+	d1.sp = framesp
+	d1.link = d
+	if prev == nil {
+		gp._defer = d1
+	} else {
+		prev.link = d1
+	}
+}
+
+// readvarintUnsafe reads the uint32 in varint format starting at fd,
+// and returns it and a pointer to the byte following it. It is like
+// readvarint for funcdata, which has no length.
+func readvarintUnsafe(fd unsafe.Pointer) (uint32, unsafe.Pointer) {
+	var r uint32
+	var shift uint
+	for {
+		b := *(*uint8)(fd)
+		fd = add(fd, 1)
+		if b < 0x80 {
+			return r + uint32(b)<<shift, fd
+		}
+		r += uint32(b&0x7F) << shift
+		shift += 7
+		if shift > 28 {
+			throw("bad varint in open-coded defer info")
+		}
+	}
+}
+
+// runOpenDeferFrame runs the open-coded defers of the frame of d that
+// have not run yet, last deferred first. It reports whether it ran
+// them all; it stops early if one of them recovers from the panic
+// running d.
+//
+// d.fd points after the deferreturn and maxargsize fields of the
+// frame's _FUNCDATA_OpenCodedDeferInfo, which continues with varints:
+//
+//	deferBitsOffset  offset below varp of the byte of defer bits
+//	nDefers          number of defers, at most 8
+//
+// and then, for each defer from the last to the first:
+//
+//	argWidth         size of the arguments of the deferred call
+//	closureOffset    offset below varp of the deferred closure
+//	nArgs            number of arguments saved in the frame
+//
+// each followed, for each saved argument, including any receiver:
+//
+//	argOffset        offset below varp of the saved argument
+//	argLen           size of the argument
+//	argCallOffset    offset of the argument in the call's arguments
+//
+// Bit i of the defer bits is set when the frame reaches defer i.
+func runOpenDeferFrame(gp *g, d *_defer) bool {
+	done := true
+	fd := d.fd
+	deferBitsOffset, fd := readvarintUnsafe(fd)
+	nDefers, fd := readvarintUnsafe(fd)
+	deferBitsp := (*uint8)(unsafe.Pointer(d.varp - uintptr(deferBitsOffset)))
+	deferBits := *deferBitsp
+
+	for i := int(nDefers) - 1; i >= 0; i-- {
+		var argWidth, closureOffset, nArgs uint32
+		argWidth, fd = readvarintUnsafe(fd)
+		closureOffset, fd = readvarintUnsafe(fd)
+		nArgs, fd = readvarintUnsafe(fd)
+		if deferBits&(1<<uint(i)) == 0 {
+			for j := uint32(0); j < nArgs; j++ {
+				_, fd = readvarintUnsafe(fd)
+				_, fd = readvarintUnsafe(fd)
+				_, fd = readvarintUnsafe(fd)
+			}
+			continue
+		}
+		closure := *(**funcval)(unsafe.Pointer(d.varp - uintptr(closureOffset)))
+		// Set d.fn first, so that tracebackdefers describes the
+		// arguments while they are copied.
+		d.fn = closure
+		args := deferArgs(d)
+		for j := uint32(0); j < nArgs; j++ {
+			var argOffset, argLen, argCallOffset uint32
+			argOffset, fd = readvarintUnsafe(fd)
+			argLen, fd = readvarintUnsafe(fd)
+			argCallOffset, fd = readvarintUnsafe(fd)
+			memmove(add(args, uintptr(argCallOffset)),
+				unsafe.Pointer(d.varp-uintptr(argOffset)),
+				uintptr(argLen))
+		}
+		// Clear the bit before the call, so that the defer
+		// isn't run again if it panics.
+		deferBits &^= 1 << uint(i)
+		*deferBitsp = deferBits
+		p := d._panic
+		reflectcallSave(p, unsafe.Pointer(closure), args, argWidth)
+		if p != nil && p.aborted {
+			break
+		}
+		d.fn = nil
+		// The arguments are only a copy.
+		memclrNoHeapPointers(args, uintptr(argWidth))
+		if p != nil && p.recovered {
+			done = deferBits == 0
+			break
+		}
+	}
+	return done
+}
+
+// reflectcallSave calls reflectcall, recording in p, if any, the
+// argument pointer that lets the deferred call fn recover from p.
+func reflectcallSave(p *_panic, fn, arg unsafe.Pointer, argsize uint32) {
+	if p != nil {
+		p.argp = unsafe.Pointer(getargp(0))
+	}
+	reflectcall(nil, fn, arg, argsize, argsize)
+}
+
 // getargp returns the location where the caller
 // writes outgoing function call arguments.
 //go:nosplit
@@ -597,14 +872,14 @@ func sync_throw(s string) {
 //go:nosplit
 func throw(s string) {
 	// Everything throw does should be recursively nosplit so it can be called even when it's unsafe to grow the stack.
-	systemstack(func() {
-		print("fatal error: ", s, "\n")
-	})
 	gp := getg()
 	if gp.m.throwing == 0 {
 		gp.m.throwing = 1
 	}
-	fatalthrow()
+	systemstack(func() {
+		print("fatal error: ", s, "\n")
+	})
+	fatalthrow(s)
 	*(*int)(nil) = 0 // not reached
 }
 
@@ -647,10 +922,10 @@ func recovery(gp *g) {
 
 // fatalthrow implements an unrecoverable runtime throw. It freezes the
 // system, prints stack traces starting from its caller, and terminates the
-// process.
+// process. msg is the fatal error's message, for the crash report.
 //
 //go:nosplit
-func fatalthrow() {
+func fatalthrow(msg string) {
 	pc := getcallerpc()
 	sp := getcallersp()
 	gp := getg()
@@ -659,7 +934,7 @@ func fatalthrow() {
 	systemstack(func() {
 		startpanic_m()
 
-		if dopanic_m(gp, pc, sp) {
+		if dopanic_m(gp, pc, sp, msg) {
 			// crash uses a decent amount of nosplit stack and we're already
 			// low on stack in throw, so crash on the system stack (unlike
 			// fatalpanic).
@@ -695,9 +970,10 @@ func fatalpanic(msgs *_panic) {
 			atomic.Xadd(&runningPanicDefers, -1)
 
 			printpanics(msgs)
+			crashReportPanics(msgs)
 		}
 
-		docrash = dopanic_m(gp, pc, sp)
+		docrash = dopanic_m(gp, pc, sp, "")
 	})
 
 	if docrash {
@@ -774,7 +1050,7 @@ func startpanic_m() bool {
 var didothers bool
 var deadlock mutex
 
-func dopanic_m(gp *g, pc, sp uintptr) bool {
+func dopanic_m(gp *g, pc, sp uintptr, msg string) bool {
 	if gp.sig != 0 {
 		signame := signame(gp.sig)
 		if signame != "" {
@@ -804,6 +1080,9 @@ func dopanic_m(gp *g, pc, sp uintptr) bool {
 			tracebackothers(gp)
 		}
 	}
+	if _g_.m.dying == 1 {
+		writeCrashReport(gp, pc, sp, 0, false, 0, msg)
+	}
 	unlock(&paniclk)
 
 	if atomic.Xadd(&panicking, -1) != 0 {
@@ -6,6 +6,11 @@ package pprof
 
 import (
 	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type label struct {
@@ -21,23 +26,69 @@ type LabelSet struct {
 // labelContextKey is the type of contextKeys used for profiler labels.
 type labelContextKey struct{}
 
-func labelValue(ctx context.Context) labelMap {
+func labelValue(ctx context.Context) map[string]string {
 	labels, _ := ctx.Value(labelContextKey{}).(*labelMap)
 	if labels == nil {
-		return labelMap(nil)
+		return nil
 	}
-	return *labels
+	return labels.m
 }
 
 // labelMap is the representation of the label set held in the context type.
 // This is an initial implementation, but it will be replaced with something
 // that admits incremental immutable modification more efficiently.
-type labelMap map[string]string
+type labelMap struct {
+	// cpu points to the CPU time of all goroutines that have run
+	// with a label set equal to this one, in nanoseconds; see
+	// labelCPU. The scheduler adds to it through a goroutine's
+	// labels, so it must be the first field; see
+	// runtime.profLabelHeader. It is accessed atomically.
+	cpu *int64
+	m   map[string]string
+}
+
+// labelCPU maps the canonical form of every label set that has been
+// put in a context, as made by labelSetKey, to the CPU time of the
+// set. Entries are never removed, so that the totals outlive the
+// contexts that carry the label set.
+var labelCPU sync.Map // map[string]*int64
+
+// labelSetCPU returns the CPU time total of the label set m.
+func labelSetCPU(m map[string]string) *int64 {
+	key := labelSetKey(m)
+	if cpu, ok := labelCPU.Load(key); ok {
+		return cpu.(*int64)
+	}
+	cpu, _ := labelCPU.LoadOrStore(key, new(int64))
+	return cpu.(*int64)
+}
+
+// labelSetKey returns a string that is the same for equal label sets
+// and different for different ones: the labels sorted by key, each
+// key and value prefixed by its length.
+func labelSetKey(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b []byte
+	for _, k := range keys {
+		v := m[k]
+		b = strconv.AppendInt(b, int64(len(k)), 10)
+		b = append(b, ':')
+		b = append(b, k...)
+		b = strconv.AppendInt(b, int64(len(v)), 10)
+		b = append(b, ':')
+		b = append(b, v...)
+	}
+	return string(b)
+}
 
 // WithLabels returns a new context.Context with the given labels added.
 // A label overwrites a prior label with the same key.
 func WithLabels(ctx context.Context, labels LabelSet) context.Context {
-	childLabels := make(labelMap)
+	childLabels := make(map[string]string)
 	parentLabels := labelValue(ctx)
 	// TODO(matloob): replace the map implementation with something
 	// more efficient so creating a child context WithLabels doesn't need
@@ -48,7 +99,34 @@ func WithLabels(ctx context.Context, labels LabelSet) context.Context {
 	for _, label := range labels.list {
 		childLabels[label.key] = label.value
 	}
-	return context.WithValue(ctx, labelContextKey{}, &childLabels)
+	return context.WithValue(ctx, labelContextKey{}, &labelMap{cpu: labelSetCPU(childLabels), m: childLabels})
+}
+
+// LabelCPUTime returns the CPU time used by goroutines while their
+// labels were set, by SetGoroutineLabels or Do, or inherited from a
+// goroutine whose labels were, to a label set equal to that of ctx.
+// The total covers every context with an equal label set, however it
+// was made, and is kept for the life of the program.
+//
+// The scheduler measures this time exactly, rather than by sampling,
+// but it does not include time spent in system calls, and it includes
+// time a goroutine has spent running only once the goroutine has
+// stopped running or changed its labels. Goroutines with no labels
+// are not measured, so LabelCPUTime returns 0 for a context without
+// labels.
+func LabelCPUTime(ctx context.Context) time.Duration {
+	labels, _ := ctx.Value(labelContextKey{}).(*labelMap)
+	if labels == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(labels.cpu))
+}
+
+// GoroutineCPUTime returns the CPU time the calling goroutine has used
+// while it had labels, measured in the same way as LabelCPUTime, up to
+// and including the current call.
+func GoroutineCPUTime() time.Duration {
+	return time.Duration(runtime_goroutineCPUTime())
 }
 
 // Labels takes an even number of strings representing key-value pairs
@@ -77,6 +77,7 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"runtime/metrics"
 	"sort"
 	"strings"
 	"sync"
@@ -98,12 +99,15 @@ import (
 //
 // Each Profile has a unique name. A few profiles are predefined:
 //
-//	goroutine    - stack traces of all current goroutines
-//	heap         - a sampling of memory allocations of live objects
-//	allocs       - a sampling of all past memory allocations
-//	threadcreate - stack traces that led to the creation of new OS threads
-//	block        - stack traces that led to blocking on synchronization primitives
-//	mutex        - stack traces of holders of contended mutexes
+//	goroutine     - stack traces of all current goroutines
+//	heap          - a sampling of memory allocations of live objects
+//	allocs        - a sampling of all past memory allocations
+//	threadcreate  - stack traces that led to the creation of new OS threads
+//	block         - stack traces that led to blocking on synchronization primitives
+//	mutex         - stack traces of holders of contended mutexes
+//	goroutineleak - stack traces of goroutines blocked forever on unreachable channels or sync.Conds
+//	schedlatency  - stack traces of goroutines that waited to be scheduled
+//	stackgrowth   - stack traces that led to goroutine stack growth
 //
 // These predefined profiles maintain themselves and panic on an explicit
 // Add or Remove method call.
@@ -120,11 +124,43 @@ import (
 // Pprof's -inuse_space, -inuse_objects, -alloc_space, and -alloc_objects
 // flags select which to display, defaulting to -inuse_space (live objects,
 // scaled by size).
+// If the program runs with GODEBUG=memprofilelifetimes=1, the heap profile
+// also has lifetime_0gc, lifetime_1gc, lifetime_2gc, lifetime_3-4gc,
+// lifetime_5-8gc, lifetime_9-16gc and lifetime_17+gc sample types, which
+// count the freed objects by the number of garbage collections they
+// survived, to tell sites of short-lived garbage from those of
+// long-lived objects.
 //
 // The allocs profile is the same as the heap profile but changes the default
 // pprof display to -alloc_space, the total number of bytes allocated since
 // the program began (including garbage-collected bytes).
 //
+// The goroutine profile labels each stack with the goroutine's profiler
+// labels, its state or wait reason, how long it has been blocked, if at
+// least a minute, the go statement that created it, and the ID of the
+// goroutine that executed that statement. It stops the world only
+// briefly, whatever the number of goroutines: each goroutine is
+// recorded with the stack it had when collection began.
+//
+// Collecting the goroutineleak profile runs a garbage collection that
+// stops the world for its whole mark phase to find leaked goroutines;
+// see runtime/debug.DetectGoroutineLeaks. Each stack ends with the
+// location of the go statement that created the goroutine. Leaked
+// goroutines found by earlier collections remain in the profile.
+//
+// The schedlatency profile records how long goroutines spent runnable,
+// waiting for a P, before they ran again, at the stack where each one
+// became runnable. It is empty unless enabled with
+// runtime.SetSchedLatencyProfileRate. With debug=1, it also includes a
+// histogram of a sample of all scheduling delays. Execution traces
+// carry every delay, as the gap between the event that made a
+// goroutine runnable and its next GoStart event.
+//
+// The stackgrowth profile records the stacks of the functions that
+// needed a goroutine's stack to grow, labeled with the size the stack
+// grew to. It is empty unless enabled with
+// runtime.SetStackGrowthProfileRate.
+//
 // The CPU profile is not available as a Profile. It has a special API,
 // the StartCPUProfile and StopCPUProfile functions, because it streams
 // output to a writer during profiling.
@@ -167,6 +203,12 @@ var allocsProfile = &Profile{
 	write: writeAlloc,
 }
 
+var goroutineLeakProfile = &Profile{
+	name:  "goroutineleak",
+	count: countGoroutineLeak,
+	write: writeGoroutineLeak,
+}
+
 var blockProfile = &Profile{
 	name:  "block",
 	count: countBlock,
@@ -179,17 +221,32 @@ var mutexProfile = &Profile{
 	write: writeMutex,
 }
 
+var schedLatencyProfile = &Profile{
+	name:  "schedlatency",
+	count: countSchedLatency,
+	write: writeSchedLatency,
+}
+
+var stackGrowthProfile = &Profile{
+	name:  "stackgrowth",
+	count: countStackGrowth,
+	write: writeStackGrowth,
+}
+
 func lockProfiles() {
 	profiles.mu.Lock()
 	if profiles.m == nil {
 		// Initial built-in profiles.
 		profiles.m = map[string]*Profile{
-			"goroutine":    goroutineProfile,
-			"threadcreate": threadcreateProfile,
-			"heap":         heapProfile,
-			"allocs":       allocsProfile,
-			"block":        blockProfile,
-			"mutex":        mutexProfile,
+			"goroutine":     goroutineProfile,
+			"threadcreate":  threadcreateProfile,
+			"heap":          heapProfile,
+			"allocs":        allocsProfile,
+			"block":         blockProfile,
+			"mutex":         mutexProfile,
+			"goroutineleak": goroutineLeakProfile,
+			"schedlatency":  schedLatencyProfile,
+			"stackgrowth":   stackGrowthProfile,
 		}
 	}
 }
@@ -319,7 +376,9 @@ func (p *Profile) Remove(value interface{}) {
 // The predefined profiles may assign meaning to other debug values;
 // for example, when printing the "goroutine" profile, debug=2 means to
 // print the goroutine stacks in the same form that a Go program uses
-// when dying due to an unrecovered panic.
+// when dying due to an unrecovered panic. debug=3 prints the same
+// stacks, collected without stopping the world as
+// runtime/debug.AllStacks does, so they are not a consistent snapshot.
 func (p *Profile) WriteTo(w io.Writer, debug int) error {
 	if p.name == "" {
 		panic("pprof: use of zero Profile")
@@ -364,6 +423,21 @@ type countProfile interface {
 	Stack(i int) []uintptr
 }
 
+// A labeledCountProfile is a countProfile whose traces also carry
+// labels. Traces are grouped by stack trace and labels together.
+type labeledCountProfile interface {
+	countProfile
+	Labels(i int) []sampleLabel
+}
+
+// A sampleLabel is a label of a sample in a labeledCountProfile.
+// It has either a string or a numeric value.
+type sampleLabel struct {
+	key string
+	str string
+	num int64
+}
+
 // printCountCycleProfile outputs block profile records (for block or mutex profiles)
 // as the pprof-proto format output. Translations from cycle count to time duration
 // are done because The proto expects count and time (nanoseconds) instead of count
@@ -406,20 +480,34 @@ func printCountCycleProfile(w io.Writer, countName, cycleName string, scaler fun
 func printCountProfile(w io.Writer, debug int, name string, p countProfile) error {
 	// Build count of each stack.
 	var buf bytes.Buffer
-	key := func(stk []uintptr) string {
+	key := func(stk []uintptr, labels []sampleLabel) string {
 		buf.Reset()
 		fmt.Fprintf(&buf, "@")
 		for _, pc := range stk {
 			fmt.Fprintf(&buf, " %#x", pc)
 		}
+		if labels != nil {
+			buf.WriteString("\n# labels: ")
+			printSampleLabels(&buf, labels)
+		}
 		return buf.String()
 	}
+	lp, _ := p.(labeledCountProfile)
 	count := map[string]int{}
 	index := map[string]int{}
 	var keys []string
+	var labels [][]sampleLabel
 	n := p.Len()
+	if lp != nil {
+		labels = make([][]sampleLabel, n)
+	}
 	for i := 0; i < n; i++ {
-		k := key(p.Stack(i))
+		var l []sampleLabel
+		if lp != nil {
+			l = lp.Labels(i)
+			labels[i] = l
+		}
+		k := key(p.Stack(i), l)
 		if count[k] == 0 {
 			index[k] = i
 			keys = append(keys, k)
@@ -460,12 +548,36 @@ func printCountProfile(w io.Writer, debug int, name string, p countProfile) erro
 			}
 			locs = append(locs, l)
 		}
-		b.pbSample(values, locs, nil)
+		var pbLabels func()
+		if lp != nil {
+			pbLabels = func() {
+				for _, l := range labels[index[k]] {
+					b.pbLabel(tagSample_Label, l.key, l.str, l.num)
+				}
+			}
+		}
+		b.pbSample(values, locs, pbLabels)
 	}
 	b.build()
 	return nil
 }
 
+// printSampleLabels prints labels in the form {"k1":"v1", "k2":2}.
+func printSampleLabels(w io.Writer, labels []sampleLabel) {
+	fmt.Fprintf(w, "{")
+	for i, l := range labels {
+		if i > 0 {
+			fmt.Fprintf(w, ", ")
+		}
+		if l.str != "" || l.num == 0 {
+			fmt.Fprintf(w, "%q:%q", l.key, l.str)
+		} else {
+			fmt.Fprintf(w, "%q:%d", l.key, l.num)
+		}
+	}
+	fmt.Fprintf(w, "}")
+}
+
 // keysByCount sorts keys with higher counts first, breaking ties by key string order.
 type keysByCount struct {
 	keys  []string
@@ -663,19 +775,131 @@ func countGoroutine() int {
 
 // writeGoroutine writes the current runtime GoroutineProfile to w.
 func writeGoroutine(w io.Writer, debug int) error {
+	if debug >= 3 {
+		// Don't stop the world, which would freeze a live
+		// server for as long as formatting every stack takes.
+		return writeGoroutineStacks(w, runtime_allStacks)
+	}
 	if debug >= 2 {
-		return writeGoroutineStacks(w)
+		return writeGoroutineStacks(w, func(buf []byte) int {
+			return runtime.Stack(buf, true)
+		})
 	}
-	return writeRuntimeProfile(w, debug, "goroutine", runtime.GoroutineProfile)
+	// As in writeRuntimeProfile, allocate a few extra records in
+	// case goroutines are created between the calls.
+	var p []goroutineRecord
+	n, ok := runtime_goroutineProfileWithLabels(nil, 0, 0)
+	for {
+		p = make([]goroutineRecord, n+10)
+		n, ok = runtime_goroutineProfileWithLabels(unsafe.Pointer(&p[0]), len(p), cap(p))
+		if ok {
+			p = p[0:n]
+			break
+		}
+		// Profile grew; try again.
+	}
+
+	return printCountProfile(w, debug, "goroutine", &goroutineProfileRecords{records: p})
 }
 
-func writeGoroutineStacks(w io.Writer) error {
+// A goroutineRecord is one goroutine in the goroutine profile.
+// It must match runtime.goroutineProfileRecord.
+type goroutineRecord struct {
+	stack  runtime.StackRecord
+	labels unsafe.Pointer // *labelMap
+	state  string         // wait reason or status, as in tracebacks
+	wait   int64          // approximate time blocked, in nanoseconds
+	gopc   uintptr        // pc of the go statement that created the goroutine
+	parent int64          // ID of the goroutine that created the goroutine
+}
+
+// goroutineProfileRecords is the goroutine profile as a
+// labeledCountProfile. Besides a goroutine's own labels, its samples
+// are labeled with:
+//
+//	go.state        - the wait reason or status, as in tracebacks
+//	go.wait_minutes - how long it has been blocked, if at least a minute
+//	go.created_by   - the function and line of the go statement that created it
+//	go.parent_goid  - the ID of the goroutine that executed that go statement
+//
+// The wait is rounded down to minutes, as in tracebacks, so that
+// goroutines blocked at the same place still share a sample. The
+// goroutines' own IDs are left out for the same reason, but the
+// parent's ID is kept: goroutines started by one fan-out and blocked
+// at the same place share a sample, which tells how many there are.
+type goroutineProfileRecords struct {
+	records []goroutineRecord
+	created map[uintptr]string // creation sites by gopc
+}
+
+func (p *goroutineProfileRecords) Len() int              { return len(p.records) }
+func (p *goroutineProfileRecords) Stack(i int) []uintptr { return p.records[i].stack.Stack() }
+
+func (p *goroutineProfileRecords) Labels(i int) []sampleLabel {
+	r := &p.records[i]
+	var labels []sampleLabel
+	if r.labels != nil {
+		m := (*labelMap)(r.labels).m
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			labels = append(labels, sampleLabel{key: k, str: m[k]})
+		}
+	}
+	labels = append(labels, sampleLabel{key: "go.state", str: r.state})
+	if min := r.wait / int64(time.Minute); min >= 1 {
+		labels = append(labels, sampleLabel{key: "go.wait_minutes", num: min})
+	}
+	if r.gopc != 0 {
+		labels = append(labels, sampleLabel{key: "go.created_by", str: p.createdBy(r.gopc)})
+	}
+	if r.parent != 0 {
+		labels = append(labels, sampleLabel{key: "go.parent_goid", num: r.parent})
+	}
+	return labels
+}
+
+// createdBy returns the function, file and line of the go statement
+// at gopc.
+func (p *goroutineProfileRecords) createdBy(gopc uintptr) string {
+	if s, ok := p.created[gopc]; ok {
+		return s
+	}
+	// gopc is a return PC, like the PCs of a stack.
+	frame, _ := runtime.CallersFrames([]uintptr{gopc}).Next()
+	s := fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+	if p.created == nil {
+		p.created = make(map[uintptr]string)
+	}
+	p.created[gopc] = s
+	return s
+}
+
+// countGoroutineLeak returns the number of goroutines found leaked so far.
+func countGoroutineLeak() int {
+	n, _ := runtime_goroutineLeakProfile(nil)
+	return n
+}
+
+// writeGoroutineLeak looks for leaked goroutines and writes their
+// stacks to w.
+func writeGoroutineLeak(w io.Writer, debug int) error {
+	runtime_detectGoroutineLeaks()
+	return writeRuntimeProfile(w, debug, "goroutineleak", runtime_goroutineLeakProfile)
+}
+
+// writeGoroutineStacks writes the stacks of all goroutines, as
+// formatted by stacks, to w.
+func writeGoroutineStacks(w io.Writer, stacks func(buf []byte) int) error {
 	// We don't know how big the buffer needs to be to collect
 	// all the goroutines. Start with 1 MB and try a few times, doubling each time.
 	// Give up and use a truncated trace if 64 MB is not enough.
 	buf := make([]byte, 1<<20)
 	for i := 0; ; i++ {
-		n := runtime.Stack(buf, true)
+		n := stacks(buf)
 		if n < len(buf) {
 			buf = buf[:n]
 			break
@@ -921,4 +1145,143 @@ func scaleMutexProfile(cnt int64, ns float64) (int64, float64) {
 	return cnt * int64(period), ns * float64(period)
 }
 
+// countSchedLatency returns the number of records in the scheduling
+// latency profile.
+func countSchedLatency() int {
+	n, _ := runtime.SchedLatencyProfile(nil)
+	return n
+}
+
+// writeSchedLatency writes the current scheduling latency profile to w.
+func writeSchedLatency(w io.Writer, debug int) error {
+	var p []runtime.BlockProfileRecord
+	n, ok := runtime.SchedLatencyProfile(nil)
+	for {
+		p = make([]runtime.BlockProfileRecord, n+50)
+		n, ok = runtime.SchedLatencyProfile(p)
+		if ok {
+			p = p[:n]
+			break
+		}
+	}
+
+	sort.Slice(p, func(i, j int) bool { return p[i].Cycles > p[j].Cycles })
+
+	if debug <= 0 {
+		return printCountCycleProfile(w, "delays", "delay", scaleBlockProfile, p)
+	}
+
+	b := bufio.NewWriter(w)
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	w = tw
+
+	fmt.Fprintf(w, "--- schedlatency:\n")
+	fmt.Fprintf(w, "cycles/second=%v\n", runtime_cyclesPerSecond())
+	for i := range p {
+		r := &p[i]
+		fmt.Fprintf(w, "%v %v @", r.Cycles, r.Count)
+		for _, pc := range r.Stack() {
+			fmt.Fprintf(w, " %#x", pc)
+		}
+		fmt.Fprint(w, "\n")
+		printStackRecord(w, r.Stack(), true)
+	}
+
+	// The scheduler keeps a histogram of a sample of all delays,
+	// whether or not the profile is enabled.
+	s := []metrics.Sample{{Name: "/sched/latencies:seconds"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() == metrics.KindFloat64Histogram {
+		h := s[0].Value.Float64Histogram()
+		fmt.Fprintf(w, "\n# scheduling latency histogram (seconds)\n")
+		for i, c := range h.Counts {
+			if c != 0 {
+				fmt.Fprintf(w, "# [%g, %g)\t%d\n", h.Buckets[i], h.Buckets[i+1], c)
+			}
+		}
+	}
+
+	if tw != nil {
+		tw.Flush()
+	}
+	return b.Flush()
+}
+
+// countStackGrowth returns the number of records in the stack growth
+// profile.
+func countStackGrowth() int {
+	n, _ := runtime.StackGrowthProfile(nil)
+	return n
+}
+
+// writeStackGrowth writes the current stack growth profile to w.
+func writeStackGrowth(w io.Writer, debug int) error {
+	var p []runtime.StackGrowthRecord
+	n, ok := runtime.StackGrowthProfile(nil)
+	for {
+		p = make([]runtime.StackGrowthRecord, n+50)
+		n, ok = runtime.StackGrowthProfile(p)
+		if ok {
+			p = p[:n]
+			break
+		}
+	}
+
+	sort.Slice(p, func(i, j int) bool { return p[i].Count > p[j].Count })
+
+	if debug <= 0 {
+		return printStackGrowthProfile(w, p)
+	}
+
+	b := bufio.NewWriter(w)
+	w = b
+	tw := tabwriter.NewWriter(w, 1, 8, 1, '\t', 0)
+	w = tw
+
+	fmt.Fprintf(w, "--- stackgrowth:\n")
+	for i := range p {
+		r := &p[i]
+		fmt.Fprintf(w, "%v %v @", r.Count, r.Size)
+		for _, pc := range r.Stack() {
+			fmt.Fprintf(w, " %#x", pc)
+		}
+		fmt.Fprint(w, "\n")
+		printStackRecord(w, r.Stack(), false)
+	}
+
+	if tw != nil {
+		tw.Flush()
+	}
+	return b.Flush()
+}
+
+// printStackGrowthProfile outputs the stack growth profile in
+// protobuf form, labeling each sample with the size the stack grew to.
+func printStackGrowthProfile(w io.Writer, records []runtime.StackGrowthRecord) error {
+	b := newProfileBuilder(w)
+	b.pbValueType(tagProfile_PeriodType, "growths", "count")
+	b.pb.int64Opt(tagProfile_Period, 1)
+	b.pbValueType(tagProfile_SampleType, "growths", "count")
+
+	values := []int64{0}
+	var locs []uint64
+	for _, r := range records {
+		values[0] = r.Count
+		locs = locs[:0]
+		for _, addr := range r.Stack() {
+			l := b.locForPC(addr)
+			if l == 0 { // runtime.goexit
+				continue
+			}
+			locs = append(locs, l)
+		}
+		size := r.Size
+		b.pbSample(values, locs, func() {
+			b.pbLabel(tagSample_Label, "bytes", "", size)
+		})
+	}
+	b.build()
+	return nil
+}
+
 func runtime_cyclesPerSecond() int64
@@ -19,6 +19,7 @@ import (
 	"regexp"
 	"runtime"
 	"runtime/pprof/internal/profile"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -735,6 +736,129 @@ func TestMutexProfile(t *testing.T) {
 	})
 }
 
+// schedLatencyWaiter blocks on c and then spins, so that the goroutines
+// it runs in wait for each other to be scheduled.
+func schedLatencyWaiter(c chan int, done *sync.WaitGroup) {
+	<-c
+	for start := time.Now(); time.Since(start) < time.Millisecond; {
+	}
+	done.Done()
+}
+
+func TestSchedLatencyProfile(t *testing.T) {
+	runtime.SetSchedLatencyProfileRate(1)
+	defer runtime.SetSchedLatencyProfileRate(0)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	c := make(chan int)
+	var done sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		done.Add(1)
+		go schedLatencyWaiter(c, &done)
+	}
+	// Let them all block, then make them runnable at once.
+	time.Sleep(10 * time.Millisecond)
+	close(c)
+	done.Wait()
+
+	t.Run("debug=1", func(t *testing.T) {
+		var w bytes.Buffer
+		Lookup("schedlatency").WriteTo(&w, 1)
+		prof := w.String()
+		if !strings.HasPrefix(prof, "--- schedlatency:\ncycles/second=") {
+			t.Errorf("Bad profile header:\n%v", prof)
+		}
+		if !strings.Contains(prof, "# scheduling latency histogram") {
+			t.Errorf("profile has no histogram:\n%v", prof)
+		}
+	})
+	t.Run("proto", func(t *testing.T) {
+		var w bytes.Buffer
+		Lookup("schedlatency").WriteTo(&w, 0)
+		p, err := profile.Parse(&w)
+		if err != nil {
+			t.Fatalf("failed to parse profile: %v", err)
+		}
+		if err := p.CheckValid(); err != nil {
+			t.Fatalf("invalid profile: %v", err)
+		}
+		// The waiters were blocked in a channel receive when they
+		// became runnable.
+		found := false
+		for _, stk := range stacks(p) {
+			for i, f := range stk {
+				if f == "runtime/pprof.schedLatencyWaiter" && i > 0 && stk[i-1] == "runtime.chanrecv1" {
+					found = true
+				}
+			}
+		}
+		if !found {
+			t.Errorf("No stack entry for schedLatencyWaiter in channel receive in\n%s", p)
+		}
+	})
+}
+
+// stackGrowthRecurse uses n frames of stack, to make the stack of the
+// goroutine running it grow.
+func stackGrowthRecurse(n int) int {
+	var buf [128]byte
+	if n == 0 {
+		return len(buf)
+	}
+	return stackGrowthRecurse(n-1) + int(buf[n%len(buf)])
+}
+
+func TestStackGrowthProfile(t *testing.T) {
+	runtime.SetStackGrowthProfileRate(1)
+	defer runtime.SetStackGrowthProfileRate(0)
+
+	done := make(chan bool)
+	go func() {
+		stackGrowthRecurse(1000)
+		done <- true
+	}()
+	<-done
+
+	t.Run("debug=1", func(t *testing.T) {
+		var w bytes.Buffer
+		Lookup("stackgrowth").WriteTo(&w, 1)
+		prof := w.String()
+		if !strings.HasPrefix(prof, "--- stackgrowth:\n") {
+			t.Errorf("Bad profile header:\n%v", prof)
+		}
+		if !strings.Contains(prof, "runtime/pprof.stackGrowthRecurse") {
+			t.Errorf("profile has no stackGrowthRecurse:\n%v", prof)
+		}
+	})
+	t.Run("proto", func(t *testing.T) {
+		var w bytes.Buffer
+		Lookup("stackgrowth").WriteTo(&w, 0)
+		p, err := profile.Parse(&w)
+		if err != nil {
+			t.Fatalf("failed to parse profile: %v", err)
+		}
+		if err := p.CheckValid(); err != nil {
+			t.Fatalf("invalid profile: %v", err)
+		}
+		found := false
+		for _, s := range p.Sample {
+			for _, loc := range s.Location {
+				for _, l := range loc.Line {
+					if l.Function.Name == "runtime/pprof.stackGrowthRecurse" {
+						found = true
+					}
+				}
+			}
+			if got := s.NumLabel["bytes"]; len(got) != 1 || got[0] <= 0 {
+				t.Errorf("sample has bytes label %v, want one positive size", got)
+			}
+		}
+		if !found {
+			t.Errorf("No stack entry for stackGrowthRecurse in\n%s", p)
+		}
+	})
+}
+
 func func1(c chan int) { <-c }
 func func2(c chan int) { <-c }
 func func3(c chan int) { <-c }
@@ -792,6 +916,36 @@ func TestGoroutineCounts(t *testing.T) {
 	time.Sleep(10 * time.Millisecond) // let goroutines exit
 }
 
+func TestGoroutineStacks(t *testing.T) {
+	c := make(chan int)
+	done := make(chan bool)
+	go func() {
+		func1(c)
+		done <- true
+	}()
+	defer func() {
+		close(c)
+		<-done
+	}()
+	// Let the goroutine block on c.
+	for i := 0; i < 100 && !strings.Contains(allStacks(), "chan receive"); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// debug=2 stops the world and debug=3 doesn't, but both print
+	// every goroutine the way a dying program does.
+	for _, debug := range []int{2, 3} {
+		var w bytes.Buffer
+		if err := Lookup("goroutine").WriteTo(&w, debug); err != nil {
+			t.Fatalf("debug=%d: %v", debug, err)
+		}
+		stacks := w.String()
+		if !strings.HasPrefix(stacks, "goroutine ") || !strings.Contains(stacks, "runtime/pprof.func1(") {
+			t.Errorf("debug=%d: goroutine stacks lack the blocked goroutine:\n%s", debug, stacks)
+		}
+	}
+}
+
 func containsInOrder(s string, all ...string) bool {
 	for _, t := range all {
 		i := strings.Index(s, t)
@@ -803,6 +957,71 @@ func containsInOrder(s string, all ...string) bool {
 	return true
 }
 
+func TestGoroutineProfileLabels(t *testing.T) {
+	c := make(chan int)
+	done := make(chan bool)
+	Do(context.Background(), Labels("label", "value"), func(context.Context) {
+		go func() {
+			func1(c)
+			done <- true
+		}()
+	})
+	defer func() {
+		close(c)
+		<-done
+	}()
+	// Let the goroutine block on c.
+	for i := 0; i < 100 && !strings.Contains(allStacks(), "chan receive"); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	var w bytes.Buffer
+	Lookup("goroutine").WriteTo(&w, 0)
+	p, err := profile.Parse(&w)
+	if err != nil {
+		t.Fatalf("error parsing protobuf profile: %v", err)
+	}
+	for _, s := range p.Sample {
+		if len(s.Label["label"]) == 0 {
+			continue
+		}
+		if got := s.Label["label"]; len(got) != 1 || got[0] != "value" {
+			t.Errorf("label = %q, want [value]", got)
+		}
+		if got := s.Label["go.state"]; len(got) != 1 || got[0] != "chan receive" {
+			t.Errorf("go.state = %q, want [chan receive]", got)
+		}
+		if got := s.Label["go.created_by"]; len(got) != 1 || !strings.Contains(got[0], "TestGoroutineProfileLabels") {
+			t.Errorf("go.created_by = %q, want the test function", got)
+		}
+		if got, want := s.NumLabel["go.parent_goid"], curGoid(); len(got) != 1 || got[0] != want {
+			t.Errorf("go.parent_goid = %v, want [%d]", got, want)
+		}
+		return
+	}
+	t.Errorf("no sample with label=value in profile:\n%v", p)
+}
+
+// curGoid returns the ID of the calling goroutine, from its stack trace.
+func curGoid() int64 {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	f := strings.Fields(string(buf))
+	if len(f) < 2 || f[0] != "goroutine" {
+		panic("bad stack trace: " + string(buf))
+	}
+	id, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func allStacks() string {
+	buf := make([]byte, 1<<20)
+	return string(buf[:runtime.Stack(buf, true)])
+}
+
 func containsCounts(prof *profile.Profile, counts []int64) bool {
 	m := make(map[int64]int)
 	for _, c := range counts {
@@ -823,6 +1042,51 @@ func containsCounts(prof *profile.Profile, counts []int64) bool {
 	return true
 }
 
+func leakChanRecv() {
+	c := make(chan int)
+	<-c
+}
+
+func leakSelect(c chan int) {
+	select {
+	case <-c:
+	case c <- 1:
+	}
+}
+
+func TestGoroutineLeakProfile(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// Goroutines blocked on channels only they can reach leak.
+	for i := 0; i < 3; i++ {
+		go leakChanRecv()
+	}
+	go leakSelect(make(chan int))
+
+	// A goroutine blocked on a channel the test holds does not.
+	c := make(chan int)
+	go func2(c)
+	defer close(c)
+
+	for j := 0; j < 5; j++ {
+		runtime.Gosched()
+	}
+
+	var w bytes.Buffer
+	Lookup("goroutineleak").WriteTo(&w, 1)
+	prof := w.String()
+
+	if !containsInOrder(prof, "\n3 @ ", "leakChanRecv", "TestGoroutineLeakProfile") {
+		t.Errorf("leaked channel receives missing from goroutineleak profile:\n%s", prof)
+	}
+	if !strings.Contains(prof, "leakSelect") {
+		t.Errorf("leaked select missing from goroutineleak profile:\n%s", prof)
+	}
+	if strings.Contains(prof, "func2") {
+		t.Errorf("reachable goroutine in goroutineleak profile:\n%s", prof)
+	}
+}
+
 var emptyCallStackTestRun int64
 
 // Issue 18836.
@@ -396,7 +396,7 @@ func (b *profileBuilder) build() {
 		var labels func()
 		if e.tag != nil {
 			labels = func() {
-				for k, v := range *(*labelMap)(e.tag) {
+				for k, v := range (*labelMap)(e.tag).m {
 					b.pbLabel(tagSample_Label, k, v, 0)
 				}
 			}
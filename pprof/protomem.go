@@ -20,6 +20,20 @@ func writeHeapProto(w io.Writer, p []runtime.MemProfileRecord, rate int64, defau
 	b.pbValueType(tagProfile_SampleType, "alloc_space", "bytes")
 	b.pbValueType(tagProfile_SampleType, "inuse_objects", "count")
 	b.pbValueType(tagProfile_SampleType, "inuse_space", "bytes")
+	// Lifetimes are only recorded with GODEBUG=memprofilelifetimes=1.
+	lifetimes := false
+	for i := range p {
+		for _, n := range p[i].FreeLifetimes {
+			if n != 0 {
+				lifetimes = true
+			}
+		}
+	}
+	if lifetimes {
+		for _, t := range lifetimeSampleTypes {
+			b.pbValueType(tagProfile_SampleType, t, "count")
+		}
+	}
 	if defaultSampleType != "" {
 		b.pb.int64Opt(tagProfile_DefaultSampleType, b.stringIndex(defaultSampleType))
 	}
@@ -59,6 +73,19 @@ func writeHeapProto(w io.Writer, p []runtime.MemProfileRecord, rate int64, defau
 		if values[0] > 0 {
 			blockSize = values[1] / values[0]
 		}
+		if lifetimes {
+			// Scale the counts as if they were objects of the
+			// record's average size.
+			var size int64
+			if r.AllocObjects > 0 {
+				size = r.AllocBytes / r.AllocObjects
+			}
+			values = values[:4]
+			for _, n := range r.FreeLifetimes {
+				n, _ = scaleHeapSample(n, n*size, rate)
+				values = append(values, n)
+			}
+		}
 		b.pbSample(values, locs, func() {
 			if blockSize != 0 {
 				b.pbLabel(tagSample_Label, "bytes", "", blockSize)
@@ -69,6 +96,19 @@ func writeHeapProto(w io.Writer, p []runtime.MemProfileRecord, rate int64, defau
 	return nil
 }
 
+// lifetimeSampleTypes are the sample types of the buckets of
+// runtime.MemProfileRecord.FreeLifetimes: freed objects that survived
+// the given number of garbage collections.
+var lifetimeSampleTypes = [len(runtime.MemProfileRecord{}.FreeLifetimes)]string{
+	"lifetime_0gc",
+	"lifetime_1gc",
+	"lifetime_2gc",
+	"lifetime_3-4gc",
+	"lifetime_5-8gc",
+	"lifetime_9-16gc",
+	"lifetime_17+gc",
+}
+
 // scaleHeapSample adjusts the data from a heap Sample to
 // account for its probability of appearing in the collected
 // data. heap profiles are a sampling of the memory allocations
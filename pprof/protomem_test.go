@@ -82,3 +82,52 @@ func TestConvertMemProfile(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertMemProfileLifetimes(t *testing.T) {
+	addr1, addr2, map1, map2 := testPCs(t)
+	a1, a2 := uintptr(addr1)+1, uintptr(addr2)+1
+	rate := int64(512 * 1024)
+	rec := []runtime.MemProfileRecord{
+		{AllocBytes: 4096, FreeBytes: 1024, AllocObjects: 4, FreeObjects: 1, Stack0: [32]uintptr{a1, a2}, FreeLifetimes: [7]int64{1}},
+		{AllocBytes: 512 * 1024, FreeBytes: 512 * 1024, AllocObjects: 1, FreeObjects: 1, Stack0: [32]uintptr{a2 + 1, a2 + 2}, FreeLifetimes: [7]int64{0, 0, 0, 1}},
+	}
+
+	periodType := &profile.ValueType{Type: "space", Unit: "bytes"}
+	sampleType := []*profile.ValueType{
+		{Type: "alloc_objects", Unit: "count"},
+		{Type: "alloc_space", Unit: "bytes"},
+		{Type: "inuse_objects", Unit: "count"},
+		{Type: "inuse_space", Unit: "bytes"},
+	}
+	for _, typ := range lifetimeSampleTypes {
+		sampleType = append(sampleType, &profile.ValueType{Type: typ, Unit: "count"})
+	}
+	samples := []*profile.Sample{
+		{
+			Value: []int64{2050, 2099200, 1537, 1574400, 512, 0, 0, 0, 0, 0, 0},
+			Location: []*profile.Location{
+				{ID: 1, Mapping: map1, Address: addr1},
+				{ID: 2, Mapping: map2, Address: addr2},
+			},
+			NumLabel: map[string][]int64{"bytes": {1024}},
+		},
+		{
+			Value: []int64{1, 829411, 0, 0, 0, 0, 0, 1, 0, 0, 0},
+			Location: []*profile.Location{
+				{ID: 3, Mapping: map2, Address: addr2 + 1},
+				{ID: 4, Mapping: map2, Address: addr2 + 2},
+			},
+			NumLabel: map[string][]int64{"bytes": {829411}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeHeapProto(&buf, rec, rate, ""); err != nil {
+		t.Fatalf("writing profile: %v", err)
+	}
+	p, err := profile.Parse(&buf)
+	if err != nil {
+		t.Fatalf("profile.Parse: %v", err)
+	}
+	checkProfile(t, p, rate, periodType, sampleType, samples, "")
+}
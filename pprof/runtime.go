@@ -6,6 +6,7 @@ package pprof
 
 import (
 	"context"
+	"runtime"
 	"unsafe"
 )
 
@@ -15,6 +16,21 @@ func runtime_setProfLabel(labels unsafe.Pointer)
 // runtime_getProfLabel is defined in runtime/proflabel.go.
 func runtime_getProfLabel() unsafe.Pointer
 
+// runtime_goroutineCPUTime is defined in runtime/proflabel.go.
+func runtime_goroutineCPUTime() int64
+
+// runtime_detectGoroutineLeaks is defined in runtime/mgcleak.go.
+func runtime_detectGoroutineLeaks() int
+
+// runtime_goroutineLeakProfile is defined in runtime/mgcleak.go.
+func runtime_goroutineLeakProfile(p []runtime.StackRecord) (n int, ok bool)
+
+// runtime_goroutineProfileWithLabels is defined in runtime/mprof.go.
+func runtime_goroutineProfileWithLabels(p unsafe.Pointer, len, cap int) (n int, ok bool)
+
+// runtime_allStacks is defined in runtime/mprof.go.
+func runtime_allStacks(buf []byte) int
+
 // SetGoroutineLabels sets the current goroutine's labels to match ctx.
 // This is a lower-level API than Do, which should be used instead when possible.
 func SetGoroutineLabels(ctx context.Context) {
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSetGoroutineLabels(t *testing.T) {
@@ -92,5 +93,55 @@ func getProfLabel() map[string]string {
 	if l == nil {
 		return map[string]string{}
 	}
-	return *l
+	return l.m
+}
+
+func TestLabelCPUTime(t *testing.T) {
+	const spin = 50 * time.Millisecond
+	var ctx context.Context
+	var goroutineCPU time.Duration
+	start := time.Now()
+	before := GoroutineCPUTime()
+	Do(context.Background(), Labels("tenant", "TestLabelCPUTime"), func(c context.Context) {
+		ctx = c
+		for start := time.Now(); time.Since(start) < spin; {
+		}
+		goroutineCPU = GoroutineCPUTime() - before
+	})
+	elapsed := time.Since(start)
+
+	// GoroutineCPUTime includes the current run.
+	if goroutineCPU < spin/2 || goroutineCPU > elapsed {
+		t.Errorf("GoroutineCPUTime grew by %v, want between %v and %v", goroutineCPU, spin/2, elapsed)
+	}
+	// Do restored the labels, which charged the time to ctx's.
+	got := LabelCPUTime(ctx)
+	if got < spin/2 || got > elapsed {
+		t.Errorf("LabelCPUTime = %v, want between %v and %v", got, spin/2, elapsed)
+	}
+	// An equal label set from another context shares the total,
+	// and time charged through it counts for ctx too.
+	ctx2 := WithLabels(context.Background(), Labels("tenant", "TestLabelCPUTime"))
+	if got2 := LabelCPUTime(ctx2); got2 != got {
+		t.Errorf("LabelCPUTime of an equal label set = %v, want %v", got2, got)
+	}
+	done := make(chan bool)
+	go func() {
+		Do(context.Background(), Labels("tenant", "TestLabelCPUTime"), func(context.Context) {
+			for start := time.Now(); time.Since(start) < spin; {
+			}
+		})
+		done <- true
+	}()
+	<-done
+	if got2 := LabelCPUTime(ctx); got2 < got+spin/2 {
+		t.Errorf("LabelCPUTime after another goroutine ran with equal labels = %v, want at least %v", got2, got+spin/2)
+	}
+	ctx3 := WithLabels(context.Background(), Labels("tenant", "TestLabelCPUTime", "other", ""))
+	if got3 := LabelCPUTime(ctx3); got3 != 0 {
+		t.Errorf("LabelCPUTime of a different label set = %v, want 0", got3)
+	}
+	if got := LabelCPUTime(context.Background()); got != 0 {
+		t.Errorf("LabelCPUTime without labels = %v, want 0", got)
+	}
 }
@@ -0,0 +1,187 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Goroutine preemption
+//
+// A goroutine can be preempted at any safe-point. Currently, there
+// are two kinds of safe-points:
+//
+// 1. A synchronous safe-point occurs when a goroutine calls a
+// function with a stack bound check. preemptone sets
+// gp.stackguard0 to stackPreempt, which makes the next check fail
+// and enter newstack, which yields or scans the stack for scang.
+//
+// 2. An asynchronous safe-point occurs at any instruction in user
+// code where the compiler recorded a register map (see
+// _PCDATA_RegMapIndex and _FUNCDATA_RegPointerMaps). These are the
+// same maps debugger call injection relies on: at such an instruction
+// the stack and the registers can be scanned and adjusted precisely.
+//
+// Tight loops without calls never reach a synchronous safe-point, so
+// preemptone additionally asks the M running the goroutine to stop it
+// asynchronously. On Unix this is done by sending sigPreempt to the
+// thread. The signal handler checks that the goroutine is at an
+// asynchronous safe-point and, if so, injects a call to asyncPreempt.
+// asyncPreempt spills all registers in register map order, exactly
+// like debugCallV1, and then calls into the scheduler.
+//
+// The interrupted frame did not stop at a call site, so its stack map
+// does not describe it. The garbage collector scans it and the
+// asyncPreempt frame holding its registers conservatively, and the
+// stack of a goroutine stopped at an asynchronous safe point is never
+// shrunk, since conservatively scanned frames can't be adjusted.
+//
+// Asynchronous preemption can be disabled with GODEBUG=asyncpreemptoff=1.
+
+package runtime
+
+import "runtime/internal/sys"
+
+// asyncPreempt saves all user registers and calls asyncPreempt2.
+//
+// asyncPreempt is injected by the preemption signal handler and never
+// called directly. It is implemented in assembly.
+func asyncPreempt()
+
+// asyncPreemptPC is the entry PC of asyncPreempt. The garbage
+// collector uses it to recognize the frames it must scan
+// conservatively.
+var asyncPreemptPC uintptr
+
+// asyncPreemptStack is the bytes of stack space required to inject an
+// asyncPreempt call.
+var asyncPreemptStack = ^uintptr(0)
+
+func init() {
+	asyncPreemptPC = funcPC(asyncPreempt)
+
+	f := findfunc(asyncPreemptPC)
+	total := funcMaxSPDelta(f)
+	f = findfunc(funcPC(asyncPreempt2))
+	total += funcMaxSPDelta(f)
+	// Add some overhead for return PCs, etc.
+	asyncPreemptStack = uintptr(total) + 8*sys.PtrSize
+	if asyncPreemptStack > _StackLimit {
+		// asyncPreempt and asyncPreempt2 are nosplit, so
+		// together they must fit in the nosplit limit.
+		print("runtime: asyncPreemptStack=", asyncPreemptStack, "\n")
+		throw("async stack too large")
+	}
+}
+
+// asyncPreempt2 is called by asyncPreempt on the goroutine's stack
+// after all registers have been saved.
+//
+//go:nosplit
+func asyncPreempt2() {
+	gp := getg()
+	gp.asyncSafePoint = true
+	mcall(asyncPreemptM)
+	gp.asyncSafePoint = false
+}
+
+// asyncPreemptM handles an asynchronous preemption of gp on g0. It
+// mirrors the preemption path in newstack: if scang asked gp to scan
+// itself, it does so and resumes gp; otherwise gp yields as if it
+// called Gosched.
+func asyncPreemptM(gp *g) {
+	if gp.preemptscan {
+		casgstatus(gp, _Grunning, _Gwaiting)
+		preemptscanSelf(gp)
+		// This clears gcscanvalid.
+		casgstatus(gp, _Gwaiting, _Grunning)
+		gp.stackguard0 = gp.stack.lo + _StackGuard
+		gogo(&gp.sched) // never return
+	}
+	gopreempt_m(gp) // never return
+}
+
+// wantAsyncPreempt returns whether an asynchronous preemption is
+// queued for gp.
+func wantAsyncPreempt(gp *g) bool {
+	return gp.preempt && readgstatus(gp)&^_Gscan == _Grunning
+}
+
+// canPreemptM reports whether mp is in a state that is safe to preempt.
+// It must only be called on an M that owns a P.
+//
+// It is nosplit because it has nosplit callers.
+//
+//go:nosplit
+func canPreemptM(mp *m) bool {
+	return mp.locks == 0 && mp.mallocing == 0 && mp.preemptoff == "" && mp.p.ptr().status == _Prunning
+}
+
+// isAsyncSafePoint reports whether gp, stopped at instruction pc with
+// stack pointer sp, is at an asynchronous safe point. This indicates
+// that:
+//
+// 1. gp's stack and registers can be scanned and adjusted precisely.
+//
+// 2. gp has enough stack space to inject the asyncPreempt call.
+//
+// 3. It's generally safe to interact with the runtime, even if we're
+// in a signal handler stopped here. For example, there are no runtime
+// locks held, so acquiring a runtime lock won't self-deadlock.
+//
+// It is called from the signal handler, so it must not allocate.
+func isAsyncSafePoint(gp *g, pc, sp uintptr) bool {
+	mp := gp.m
+
+	// Only user Gs can have safe points. We check this first
+	// because it's extremely common that we'll catch mp in the
+	// scheduler processing this G preemption.
+	if mp.curg != gp {
+		return false
+	}
+
+	// Check M state.
+	if mp.p == 0 || !canPreemptM(mp) {
+		return false
+	}
+
+	// Check stack space. Fast syscalls (nanotime) and racecall
+	// switch to the g0 stack without switching g, so also check
+	// that we're on gp's stack at all.
+	if sp < gp.stack.lo || sp > gp.stack.hi || sp-gp.stack.lo < asyncPreemptStack {
+		return false
+	}
+
+	f := findfunc(pc)
+	if !f.valid() {
+		// Not Go code.
+		return false
+	}
+	if name := funcname(f); hasprefix(name, "runtime.") || hasprefix(name, "runtime/internal/") || hasprefix(name, "reflect.") {
+		// For now we never async preempt the runtime or
+		// anything closely tied to the runtime. Known issues
+		// include: various points in the scheduler ("don't
+		// preempt between here and here"), much of the defer
+		// implementation (untyped info on stack), bulk write
+		// barriers (write barrier check) and
+		// reflect.{makeFuncStub,methodValueCall}.
+		return false
+	}
+	return atRegMapSafePoint(f, pc)
+}
+
+// atRegMapSafePoint reports whether the compiler recorded a register
+// map for the state of f just before the instruction at pc. If so, a
+// goroutine stopped at pc can be treated as if it had called a
+// function from pc: its stack and registers can be scanned precisely.
+// Write barrier sequences and other unsafe points have no register
+// map.
+func atRegMapSafePoint(f funcInfo, pc uintptr) bool {
+	// Look up PC's register map.
+	pcdata := int32(-1)
+	if pc != f.entry {
+		pc--
+		pcdata = pcdatavalue(f, _PCDATA_RegMapIndex, pc, nil)
+	}
+	if pcdata == -1 {
+		pcdata = 0 // in prologue
+	}
+	stkmap := (*stackmap)(funcdata(f, _FUNCDATA_RegPointerMaps))
+	return pcdata != -2 && stkmap != nil
+}
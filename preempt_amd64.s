@@ -0,0 +1,85 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// asyncPreempt is injected by the preemption signal handler as if the
+// interrupted instruction had called it. See preempt.go.
+//
+// The general purpose registers are saved in GC register map order
+// (see ssa.registersAMD64) at the same frame offsets as debugCallV1.
+// This lets the stack walker scan and adjust this frame using the
+// register map of the interrupted instruction. Flags and the X
+// registers cannot hold pointers and are saved below them.
+TEXT runtime·asyncPreempt(SB),NOSPLIT,$384-0
+	MOVQ	R15, r15-(14*8+8)(SP)
+	MOVQ	R14, r14-(13*8+8)(SP)
+	MOVQ	R13, r13-(12*8+8)(SP)
+	MOVQ	R12, r12-(11*8+8)(SP)
+	MOVQ	R11, r11-(10*8+8)(SP)
+	MOVQ	R10, r10-(9*8+8)(SP)
+	MOVQ	R9, r9-(8*8+8)(SP)
+	MOVQ	R8, r8-(7*8+8)(SP)
+	MOVQ	DI, di-(6*8+8)(SP)
+	MOVQ	SI, si-(5*8+8)(SP)
+	MOVQ	BP, bp-(4*8+8)(SP)
+	MOVQ	BX, bx-(3*8+8)(SP)
+	MOVQ	DX, dx-(2*8+8)(SP)
+	MOVQ	CX, cx-(1*8+8)(SP)
+	MOVQ	AX, ax-(0*8+8)(SP)
+	PUSHFQ
+	POPQ	AX
+	MOVQ	AX, flags-128(SP)
+	MOVUPS	X0, x0-144(SP)
+	MOVUPS	X1, x1-160(SP)
+	MOVUPS	X2, x2-176(SP)
+	MOVUPS	X3, x3-192(SP)
+	MOVUPS	X4, x4-208(SP)
+	MOVUPS	X5, x5-224(SP)
+	MOVUPS	X6, x6-240(SP)
+	MOVUPS	X7, x7-256(SP)
+	MOVUPS	X8, x8-272(SP)
+	MOVUPS	X9, x9-288(SP)
+	MOVUPS	X10, x10-304(SP)
+	MOVUPS	X11, x11-320(SP)
+	MOVUPS	X12, x12-336(SP)
+	MOVUPS	X13, x13-352(SP)
+	MOVUPS	X14, x14-368(SP)
+	MOVUPS	X15, x15-384(SP)
+	CALL	runtime·asyncPreempt2(SB)
+	MOVUPS	x15-384(SP), X15
+	MOVUPS	x14-368(SP), X14
+	MOVUPS	x13-352(SP), X13
+	MOVUPS	x12-336(SP), X12
+	MOVUPS	x11-320(SP), X11
+	MOVUPS	x10-304(SP), X10
+	MOVUPS	x9-288(SP), X9
+	MOVUPS	x8-272(SP), X8
+	MOVUPS	x7-256(SP), X7
+	MOVUPS	x6-240(SP), X6
+	MOVUPS	x5-224(SP), X5
+	MOVUPS	x4-208(SP), X4
+	MOVUPS	x3-192(SP), X3
+	MOVUPS	x2-176(SP), X2
+	MOVUPS	x1-160(SP), X1
+	MOVUPS	x0-144(SP), X0
+	MOVQ	flags-128(SP), AX
+	PUSHQ	AX
+	POPFQ
+	MOVQ	ax-(0*8+8)(SP), AX
+	MOVQ	cx-(1*8+8)(SP), CX
+	MOVQ	dx-(2*8+8)(SP), DX
+	MOVQ	bx-(3*8+8)(SP), BX
+	MOVQ	bp-(4*8+8)(SP), BP
+	MOVQ	si-(5*8+8)(SP), SI
+	MOVQ	di-(6*8+8)(SP), DI
+	MOVQ	r8-(7*8+8)(SP), R8
+	MOVQ	r9-(8*8+8)(SP), R9
+	MOVQ	r10-(9*8+8)(SP), R10
+	MOVQ	r11-(10*8+8)(SP), R11
+	MOVQ	r12-(11*8+8)(SP), R12
+	MOVQ	r13-(12*8+8)(SP), R13
+	MOVQ	r14-(13*8+8)(SP), R14
+	MOVQ	r15-(14*8+8)(SP), R15
+	RET
@@ -0,0 +1,13 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !amd64
+
+#include "textflag.h"
+
+// Asynchronous preemption is only supported on amd64, which is the
+// only architecture with register maps. preemptM never asks for it
+// elsewhere, so this is never called.
+TEXT runtime·asyncPreempt(SB),NOSPLIT|NOFRAME,$0-0
+	UNDEF
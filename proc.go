@@ -241,6 +241,14 @@ func init() {
 	go forcegchelper()
 }
 
+// start GOMAXPROCS updater goroutine, if the cgroup CPU limit may
+// change
+func init() {
+	if haveCgroupCPU() && maxprocsUpdate.custom == 0 {
+		go maxprocsUpdater()
+	}
+}
+
 func forcegchelper() {
 	forcegc.g = getg()
 	for {
@@ -251,7 +259,10 @@ func forcegchelper() {
 		atomic.Store(&forcegc.idle, 1)
 		goparkunlock(&forcegc.lock, waitReasonForceGGIdle, traceEvGoBlock, 1)
 		// this goroutine is explicitly resumed by sysmon
-		if debug.gctrace > 0 {
+		if debug.gctrace > 0 && debug.tracejsonfd > 0 {
+			jsonTraceBegin("gc.forced")
+			jsonTraceEnd()
+		} else if debug.gctrace > 0 {
 			println("GC forced")
 		}
 		// Time-triggered, fully concurrent.
@@ -259,6 +270,87 @@ func forcegchelper() {
 	}
 }
 
+// maxprocsCheckPeriod is how often, in nanoseconds, sysmon checks
+// whether the cgroup CPU limit has changed.
+const maxprocsCheckPeriod = 1e9
+
+// maxprocsUpdate is the state for following changes to the cgroup CPU
+// limit in GOMAXPROCS.
+var maxprocsUpdate struct {
+	lock  mutex
+	g     *g
+	idle  uint32
+	procs int32 // GOMAXPROCS to set, found by sysmon
+
+	// custom is set to 1 once GOMAXPROCS has been set by the
+	// environment variable or the GOMAXPROCS function. From then
+	// on it is left alone. Accessed atomically.
+	custom uint32
+}
+
+// defaultGOMAXPROCS returns the GOMAXPROCS to use unless the user sets
+// it: the number of CPUs, or fewer if the process's cgroups limit its
+// CPU use, to the limit rounded up.
+//
+//go:nowritebarrierrec
+func defaultGOMAXPROCS() int32 {
+	procs := ncpu
+	if limit, ok := cgroupCPULimit(); ok {
+		n := int32(limit)
+		if float64(n) < limit {
+			n++
+		}
+		if n < 1 {
+			n = 1
+		}
+		if n < procs {
+			procs = n
+		}
+	}
+	return procs
+}
+
+// maxprocsCheck wakes the GOMAXPROCS updater if the default
+// GOMAXPROCS no longer matches GOMAXPROCS.
+//
+// This is called by sysmon, so it must not have write barriers.
+//
+//go:nowritebarrierrec
+func maxprocsCheck() {
+	if maxprocsUpdate.g == nil || atomic.Load(&maxprocsUpdate.custom) != 0 {
+		return
+	}
+	procs := defaultGOMAXPROCS()
+	if procs == gomaxprocs {
+		return
+	}
+	lock(&maxprocsUpdate.lock)
+	if maxprocsUpdate.idle != 0 {
+		maxprocsUpdate.idle = 0
+		maxprocsUpdate.procs = procs
+		maxprocsUpdate.g.schedlink = 0
+		injectglist(maxprocsUpdate.g)
+	}
+	unlock(&maxprocsUpdate.lock)
+}
+
+func maxprocsUpdater() {
+	maxprocsUpdate.g = getg()
+	for {
+		lock(&maxprocsUpdate.lock)
+		atomic.Store(&maxprocsUpdate.idle, 1)
+		goparkunlock(&maxprocsUpdate.lock, waitReasonMaxprocsIdle, traceEvGoBlock, 1)
+		// this goroutine is explicitly resumed by sysmon
+		stopTheWorld("GOMAXPROCS")
+		// The user may have set GOMAXPROCS since sysmon looked.
+		if atomic.Load(&maxprocsUpdate.custom) == 0 {
+			// newprocs will be processed by startTheWorld
+			newprocs = maxprocsUpdate.procs
+		}
+		startTheWorld()
+	}
+}
+
 //go:nosplit
 
 // Gosched yields the processor, allowing other goroutines to run. It does not
@@ -560,9 +652,11 @@ func schedinit() {
 	gcinit()
 
 	sched.lastpoll = uint64(nanotime())
-	procs := ncpu
+	cgroupCPUInit("")
+	procs := defaultGOMAXPROCS()
 	if n, ok := atoi32(gogetenv("GOMAXPROCS")); ok && n > 0 {
 		procs = n
+		maxprocsUpdate.custom = 1
 	}
 	if procresize(procs) != nil {
 		throw("unknown runnable goroutine during bootstrap")
@@ -883,8 +977,69 @@ func casgstatus(gp *g, oldval, newval uint32) {
 	if newval == _Grunning {
 		gp.gcscanvalid = false
 	}
+
+	// Measure the time goroutines with profiler labels spend
+	// running, for runtime/pprof.LabelCPUTime and GoroutineCPUTime.
+	if oldval == _Grunning && gp.cpuStamp != 0 {
+		profLabelAccount(gp, nanotime())
+	}
+	if newval == _Grunning && gp.labels != nil {
+		gp.cpuStamp = nanotime()
+	}
+
+	if oldval == _Grunning {
+		// Track every gTrackingPeriod time a goroutine transitions out of running,
+		// or every time if schedTrackAll.
+		if gp.trackingSeq%gTrackingPeriod == 0 || schedTrackAll() {
+			gp.tracking = true
+		}
+		gp.trackingSeq++
+	}
+	if gp.tracking {
+		if oldval == _Grunnable {
+			// We transitioned out of runnable, so measure how much
+			// time we spent in this state and add it to
+			// runnableTime.
+			now := nanotime()
+			gp.runnableTime += now - gp.runnableStamp
+			gp.runnableStamp = 0
+		}
+		if newval == _Grunnable {
+			// We just transitioned into runnable, so record what
+			// time that happened.
+			now := nanotime()
+			gp.runnableStamp = now
+		} else if newval == _Grunning {
+			// We're transitioning into running, so turn off
+			// tracking and record how much time we spent in
+			// runnable.
+			gp.tracking = false
+			sched.timeToRun.record(gp.runnableTime)
+			if schedTrackAll() {
+				gp.runnableWait += gp.runnableTime
+				gp.lastRunnable = gp.runnableTime
+			}
+			gp.runnableTime = 0
+		}
+	}
 }
 
+// schedTrackAll reports whether every transition of every goroutine
+// should be tracked, because the scheduling latency profile wants
+// each goroutine's time spent runnable. The execution tracer needs
+// no help: runnable time is the gap between a goroutine's
+// GoUnblock or GoCreate event and its next GoStart.
+//
+//go:nosplit
+func schedTrackAll() bool {
+	return atomic.Load64(&schedlatencyprofilerate) != 0
+}
+
+// gTrackingPeriod is the number of transitions out of _Grunning between
+// latency tracking runs for a goroutine. Sampling keeps the cost of
+// calling nanotime off most scheduling transitions.
+const gTrackingPeriod = 8
+
 // casgstatus(gp, oldstatus, Gcopystack), assuming oldstatus is Gwaiting or Grunnable.
 // Returns old status. Cannot call casgstatus directly, because we are racing with an
 // async wakeup that might come in from netpoll. If we see Gwaiting from the readgstatus,
@@ -917,6 +1072,7 @@ func scang(gp *g, gcw *gcWork) {
 	// See https://golang.org/cl/21503 for justification of the yield delay.
 	const yieldDelay = 10 * 1000
 	var nextYield int64
+	var nextPreemptM int64
 
 	// Endeavor to get gcscandone set to true,
 	// either by doing the stack scan ourselves or by coercing gp to scan itself.
@@ -967,12 +1123,25 @@ loop:
 
 			// Ask for preemption and self scan.
 			if castogscanstatus(gp, _Grunning, _Gscanrunning) {
+				var asyncM *m
 				if !gp.gcscandone {
 					gp.preemptscan = true
 					gp.preempt = true
 					gp.stackguard0 = stackPreempt
+					asyncM = gp.m
 				}
 				casfrom_Gscanstatus(gp, _Gscanrunning, _Grunning)
+
+				// Interrupt loops without calls. gp may decline
+				// the request in newstack and have it reissued on
+				// every spin, so send the signal at most once per
+				// yield period rather than on every attempt.
+				if asyncM != nil && preemptMSupported && debug.asyncpreemptoff == 0 {
+					if now := nanotime(); now >= nextPreemptM {
+						nextPreemptM = now + yieldDelay/2
+						preemptM(asyncM)
+					}
+				}
 			}
 		}
 
@@ -1149,7 +1318,7 @@ func startTheWorldWithSema(emitTraceEvent bool) int64 {
 
 	_g_.m.locks++ // disable preemption because it can be holding p in a local var
 	if netpollinited() {
-		gp := netpoll(false) // non-blocking
+		gp := netpoll(0) // non-blocking
 		injectglist(gp)
 	}
 	add := needaddgcproc()
@@ -2235,7 +2404,19 @@ func gcstopm() {
 func execute(gp *g, inheritTime bool) {
 	_g_ := getg()
 
+	if goroutineProfile.active {
+		// Make sure that gp has had its stack written out to the goroutine
+		// profile, exactly as it was when the goroutine profiler first
+		// stopped the world.
+		tryRecordGoroutineProfile(gp, osyield)
+	}
+
 	casgstatus(gp, _Grunnable, _Grunning)
+	wait := gp.lastRunnable
+	if wait > 0 {
+		gp.lastRunnable = 0
+		schedlatencyevent(gp, wait)
+	}
 	gp.waitsince = 0
 	gp.preempt = false
 	gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -2281,6 +2462,9 @@ top:
 	if _p_.runSafePointFn != 0 {
 		runSafePointFn()
 	}
+
+	now, pollUntil, _ := checkTimers(_p_, 0)
+
 	if fingwait && fingwake {
 		if gp := wakefing(); gp != nil {
 			ready(gp, 0, true)
@@ -2313,7 +2497,7 @@ top:
 	// not set lastpoll yet), this thread will do blocking netpoll below
 	// anyway.
 	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Load64(&sched.lastpoll) != 0 {
-		if gp := netpoll(false); gp != nil { // non-blocking
+		if gp := netpoll(0); gp != nil { // non-blocking
 			// netpoll returns list of goroutines linked by schedlink.
 			injectglist(gp.schedlink.ptr())
 			casgstatus(gp, _Gwaiting, _Grunnable)
@@ -2324,6 +2508,38 @@ top:
 		}
 	}
 
+	// Run the timers of other P's that won't run them themselves.
+	// This is the only place where we lock the timers of a
+	// different P. A P that is running and not being preempted
+	// is assumed to handle its own timers, which avoids lock
+	// contention; idle P's have nobody else to run theirs.
+	ranTimer := false
+	for enum := stealOrder.start(fastrand()); !enum.done(); enum.next() {
+		p2 := allp[enum.position()]
+		if p2 == _p_ || !shouldStealTimers(p2) {
+			continue
+		}
+		tnow, w, ran := checkTimers(p2, now)
+		now = tnow
+		if w != 0 && (pollUntil == 0 || w < pollUntil) {
+			pollUntil = w
+		}
+		if ran {
+			// Running the timers may have made an arbitrary
+			// number of G's ready and added them to this P's
+			// local run queue.
+			ranTimer = true
+		}
+	}
+	if ranTimer {
+		if gp, inheritTime := runqget(_p_); gp != nil {
+			return gp, inheritTime
+		}
+		// The timer functions may have done something else,
+		// like starting a goroutine on another P.
+		goto top
+	}
+
 	// Steal work from other P's.
 	procs := uint32(gomaxprocs)
 	if atomic.Load(&sched.npidle) == procs-1 {
@@ -2369,10 +2585,17 @@ stop:
 		return gp, false
 	}
 
+	delta := int64(-1)
+	if pollUntil != 0 {
+		// checkTimers ensures that pollUntil > now.
+		delta = pollUntil - now
+	}
+
 	// wasm only:
-	// Check if a goroutine is waiting for a callback from the WebAssembly host.
+	// Check if a goroutine is waiting for a callback from the WebAssembly host
+	// or a timer is pending.
 	// If yes, pause the execution util a callback was triggered.
-	if pauseSchedulerUntilCallback() {
+	if pauseSchedulerUntilCallback(delta) {
 		// A callback was triggered and caused at least one goroutine to wake up.
 		goto top
 	}
@@ -2459,22 +2682,36 @@ stop:
 		}
 	}
 
-	// poll network
-	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
+	// Poll network until next timer.
+	if netpollinited() && (atomic.Load(&netpollWaiters) > 0 || pollUntil != 0) && atomic.Xchg64(&sched.lastpoll, 0) != 0 {
+		atomic.Store64(&sched.pollUntil, uint64(pollUntil))
 		if _g_.m.p != 0 {
 			throw("findrunnable: netpoll with p")
 		}
 		if _g_.m.spinning {
 			throw("findrunnable: netpoll with spinning")
 		}
-		gp := netpoll(true) // block util new work is available
+		if faketime != 0 {
+			// When using fake time, just poll.
+			delta = 0
+		}
+		gp := netpoll(delta) // block util new work is available
+		atomic.Store64(&sched.pollUntil, 0)
 		atomic.Store64(&sched.lastpoll, uint64(nanotime()))
-		if gp != nil {
-			lock(&sched.lock)
-			_p_ = pidleget()
-			unlock(&sched.lock)
-			if _p_ != nil {
-				acquirep(_p_)
+		if faketime != 0 && gp == nil {
+			// Using fake time and nothing is ready; stop M.
+			// When all M's stop, checkdead will jump time forward.
+			stopm()
+			goto top
+		}
+		lock(&sched.lock)
+		_p_ = pidleget()
+		unlock(&sched.lock)
+		if _p_ == nil {
+			injectglist(gp)
+		} else {
+			acquirep(_p_)
+			if gp != nil {
 				injectglist(gp.schedlink.ptr())
 				casgstatus(gp, _Gwaiting, _Grunnable)
 				if trace.enabled {
@@ -2482,13 +2719,44 @@ stop:
 				}
 				return gp, false
 			}
-			injectglist(gp)
+			// Woken up by a timer or netpollBreak.
+			if wasSpinning {
+				_g_.m.spinning = true
+				atomic.Xadd(&sched.nmspinning, 1)
+			}
+			goto top
+		}
+	} else if pollUntil != 0 && netpollinited() {
+		// Another M is polling. Make sure it wakes up in time
+		// for our timers.
+		pollerPollUntil := int64(atomic.Load64(&sched.pollUntil))
+		if pollerPollUntil == 0 || pollerPollUntil > pollUntil {
+			netpollBreak()
 		}
 	}
 	stopm()
 	goto top
 }
 
+// shouldStealTimers reports whether we should try running the timers
+// of p2. We don't steal timers from a running P that is not marked
+// for preemption, on the assumption that it will run its own timers.
+// This reduces contention on the timers lock.
+func shouldStealTimers(p2 *p) bool {
+	if p2.status != _Prunning {
+		return true
+	}
+	mp := p2.m.ptr()
+	if mp == nil || mp.locks > 0 {
+		return false
+	}
+	gp := mp.curg
+	if gp == nil || gp.atomicstatus != _Grunning || !gp.preempt {
+		return false
+	}
+	return true
+}
+
 // pollWork returns true if there is non-background work this P could
 // be doing. This is a fairly lightweight check to be used for
 // background work loops, like idle GC. It checks a subset of the
@@ -2502,7 +2770,7 @@ func pollWork() bool {
 		return true
 	}
 	if netpollinited() && atomic.Load(&netpollWaiters) > 0 && sched.lastpoll != 0 {
-		if gp := netpoll(false); gp != nil {
+		if gp := netpoll(0); gp != nil {
 			injectglist(gp)
 			return true
 		}
@@ -2578,10 +2846,13 @@ top:
 		gcstopm()
 		goto top
 	}
-	if _g_.m.p.ptr().runSafePointFn != 0 {
+	pp := _g_.m.p.ptr()
+	if pp.runSafePointFn != 0 {
 		runSafePointFn()
 	}
 
+	checkTimers(pp, 0)
+
 	var gp *g
 	var inheritTime bool
 	if trace.enabled || trace.shutdown {
@@ -2751,6 +3022,10 @@ func goexit0(gp *g) {
 	gp.param = nil
 	gp.labels = nil
 	gp.timer = nil
+	gp.leaked = false
+	if gp.syncHeld != nil || gp.syncWait != nil {
+		lockOrderGoexit(gp)
+	}
 
 	if gcBlackenEnabled != 0 && gp.gcAssistBytes > 0 {
 		// Flush assist credit to the global pool. This gives
@@ -3022,6 +3297,14 @@ func exitsyscall() {
 				systemstack(traceGoStart)
 			}
 		}
+		if goroutineProfile.active {
+			// Make sure that gp has had its stack written out to the goroutine
+			// profile, exactly as it was when the goroutine profiler first
+			// stopped the world.
+			systemstack(func() {
+				tryRecordGoroutineProfileWB(_g_)
+			})
+		}
 		// There's a cpu for us, so we can run.
 		_g_.m.p.ptr().syscalltick++
 		// We need to cas the status and scan before resuming...
@@ -3382,6 +3665,8 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 	newg.sched.g = guintptr(unsafe.Pointer(newg))
 	gostartcallfn(&newg.sched, fn)
 	newg.gopc = callerpc
+	newg.parentGoid = callergp.goid
+	newg.stackLimit = callergp.stackLimit
 	newg.ancestors = saveAncestors(callergp)
 	newg.startpc = fn.fn
 	if _g_.m.curg != nil {
@@ -3391,6 +3676,19 @@ func newproc1(fn *funcval, argp *uint8, narg int32, callergp *g, callerpc uintpt
 		atomic.Xadd(&sched.ngsys, +1)
 	}
 	newg.gcscanvalid = false
+	// Track the initial transition to runnable for a sample of goroutines.
+	newg.trackingSeq = uint8(fastrand())
+	if newg.trackingSeq%gTrackingPeriod == 0 || schedTrackAll() {
+		newg.tracking = true
+	}
+	newg.runnableWait = 0
+	newg.lastRunnable = 0
+	newg.cpuTime = 0
+	if goroutineProfile.active {
+		// A goroutine created after the profiler stopped the world is
+		// not part of the profile.
+		newg.goroutineProfiled = goroutineProfileSatisfied
+	}
 	casgstatus(newg, _Gdead, _Grunnable)
 
 	if _p_.goidcache == _p_.goidcacheend {
@@ -3444,9 +3742,10 @@ func saveAncestors(callergp *g) *[]ancestorInfo {
 	ipcs := make([]uintptr, npcs)
 	copy(ipcs, pcs[:])
 	ancestors[0] = ancestorInfo{
-		pcs:  ipcs,
-		goid: callergp.goid,
-		gopc: callergp.gopc,
+		pcs:        ipcs,
+		goid:       callergp.goid,
+		gopc:       callergp.gopc,
+		parentGoid: callergp.parentGoid,
 	}
 
 	ancestorsp := new([]ancestorInfo)
@@ -4034,6 +4333,9 @@ func procresize(nprocs int32) *p {
 				pp.racectx = raceproccreate()
 			}
 		}
+		if raceenabled && pp.timerRaceCtx == 0 {
+			pp.timerRaceCtx = racegostart(funcPC(runtimer) + sys.PCQuantum)
+		}
 	}
 
 	// free unused P's
@@ -4057,6 +4359,10 @@ func procresize(nprocs int32) *p {
 			globrunqputhead(p.runnext.ptr())
 			p.runnext = 0
 		}
+		// move all timers to p[0], which always survives
+		if len(p.timers) > 0 {
+			moveTimers(allp[0], p)
+		}
 		// if there's a background worker, make it runnable and put
 		// it on the global queue so it can clean itself up
 		if gp := p.gcBgMarkWorker.ptr(); gp != nil {
@@ -4280,23 +4586,28 @@ func checkdead() {
 	}
 
 	// Maybe jump time forward for playground.
-	gp := timejump()
-	if gp != nil {
-		casgstatus(gp, _Gwaiting, _Grunnable)
-		globrunqput(gp)
-		_p_ := pidleget()
-		if _p_ == nil {
-			throw("checkdead: no p for timer")
-		}
-		mp := mget()
-		if mp == nil {
-			// There should always be a free M since
-			// nothing is running.
-			throw("checkdead: no m for timer")
+	if faketime != 0 {
+		when, _p_ := timeSleepUntil()
+		if _p_ != nil {
+			faketime = when
+			// Wake an M on _p_ so it runs the timer.
+			for pp := &sched.pidle; *pp != 0; pp = &(*pp).ptr().link {
+				if (*pp).ptr() == _p_ {
+					*pp = _p_.link
+					atomic.Xadd(&sched.npidle, -1)
+					break
+				}
+			}
+			mp := mget()
+			if mp == nil {
+				// There should always be a free M since
+				// nothing is running.
+				throw("checkdead: no m for timer")
+			}
+			mp.nextp.set(_p_)
+			notewakeup(&mp.park)
+			return
 		}
-		mp.nextp.set(_p_)
-		notewakeup(&mp.park)
-		return
 	}
 
 	getg().m.throwing = -1 // do not dump full stacks
@@ -4319,18 +4630,13 @@ func sysmon() {
 	checkdead()
 	unlock(&sched.lock)
 
-	// If a heap span goes unused for 5 minutes after a garbage collection,
-	// we hand it back to the operating system.
-	scavengelimit := int64(5 * 60 * 1e9)
-
 	if debug.scavenge > 0 {
 		// Scavenge-a-lot for testing.
 		forcegcperiod = 10 * 1e6
-		scavengelimit = 20 * 1e6
 	}
 
-	lastscavenge := nanotime()
-	nscavenge := 0
+	lastlimitcheck := nanotime()
+	lastmaxprocscheck := lastlimitcheck
 
 	lasttrace := int64(0)
 	idle := 0 // how many cycles in succession we had not wokeup somebody
@@ -4345,35 +4651,34 @@ func sysmon() {
 			delay = 10 * 1000
 		}
 		usleep(delay)
+		now := nanotime()
+		next, _ := timeSleepUntil()
 		if debug.schedtrace <= 0 && (sched.gcwaiting != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs)) {
 			lock(&sched.lock)
 			if atomic.Load(&sched.gcwaiting) != 0 || atomic.Load(&sched.npidle) == uint32(gomaxprocs) {
-				atomic.Store(&sched.sysmonwait, 1)
-				unlock(&sched.lock)
-				// Make wake-up period small enough
-				// for the sampling to be correct.
-				maxsleep := forcegcperiod / 2
-				if scavengelimit < forcegcperiod {
-					maxsleep = scavengelimit / 2
-				}
-				shouldRelax := true
-				if osRelaxMinNS > 0 {
-					next := timeSleepUntil()
-					now := nanotime()
-					if next-now < osRelaxMinNS {
-						shouldRelax = false
+				if next > now {
+					atomic.Store(&sched.sysmonwait, 1)
+					unlock(&sched.lock)
+					// Make wake-up period small enough
+					// for the sampling to be correct.
+					sleep := forcegcperiod / 2
+					if next-now < sleep {
+						sleep = next - now
 					}
+					shouldRelax := sleep >= osRelaxMinNS
+					if shouldRelax {
+						osRelax(true)
+					}
+					notetsleep(&sched.sysmonnote, sleep)
+					if shouldRelax {
+						osRelax(false)
+					}
+					now = nanotime()
+					next, _ = timeSleepUntil()
+					lock(&sched.lock)
+					atomic.Store(&sched.sysmonwait, 0)
+					noteclear(&sched.sysmonnote)
 				}
-				if shouldRelax {
-					osRelax(true)
-				}
-				notetsleep(&sched.sysmonnote, maxsleep)
-				if shouldRelax {
-					osRelax(false)
-				}
-				lock(&sched.lock)
-				atomic.Store(&sched.sysmonwait, 0)
-				noteclear(&sched.sysmonnote)
 				idle = 0
 				delay = 20
 			}
@@ -4385,10 +4690,9 @@ func sysmon() {
 		}
 		// poll network if not polled for more than 10ms
 		lastpoll := int64(atomic.Load64(&sched.lastpoll))
-		now := nanotime()
 		if netpollinited() && lastpoll != 0 && lastpoll+10*1000*1000 < now {
 			atomic.Cas64(&sched.lastpoll, uint64(lastpoll), uint64(now))
-			gp := netpoll(false) // non-blocking - returns list of goroutines
+			gp := netpoll(0) // non-blocking - returns list of goroutines
 			if gp != nil {
 				// Need to decrement number of idle locked M's
 				// (pretending that one more is running) before injectglist.
@@ -4402,6 +4706,12 @@ func sysmon() {
 				incidlelocked(1)
 			}
 		}
+		if next < now {
+			// There are timers that should have already run,
+			// perhaps because there is an unpreemptible P.
+			// Try to start an M to run them.
+			startm(nil, false)
+		}
 		// retake P's blocked in syscalls
 		// and preempt long running G's
 		if retake(now) != 0 {
@@ -4417,11 +4727,23 @@ func sysmon() {
 			injectglist(forcegc.g)
 			unlock(&forcegc.lock)
 		}
-		// scavenge heap once in a while
-		if lastscavenge+scavengelimit/2 < now {
-			mheap_.scavenge(int32(nscavenge), uint64(now), uint64(scavengelimit))
-			lastscavenge = now
-			nscavenge++
+		// near the memory limit, have the scavenger release memory
+		// even if no GC has finished
+		if lastlimitcheck+memoryLimitScavengePeriod < now {
+			if memoryLimitNeedsScavenge() {
+				readyForScavenger()
+			}
+			lastlimitcheck = now
+		}
+		// wake the scavenger if its goal may have changed
+		if atomic.Load(&scavenge.sysmonWake) != 0 {
+			wakeScavenger()
+		}
+		gcCPULimiter.update(now)
+		// follow changes to the cgroup CPU limit
+		if lastmaxprocscheck+maxprocsCheckPeriod < now {
+			maxprocsCheck()
+			lastmaxprocscheck = now
 		}
 		if debug.schedtrace > 0 && lasttrace+int64(debug.schedtrace)*1000000 <= now {
 			lasttrace = now
@@ -4530,7 +4852,8 @@ func preemptall() bool {
 // This function is purely best-effort. It can incorrectly fail to inform the
 // goroutine. It can send inform the wrong goroutine. Even if it informs the
 // correct goroutine, that goroutine might ignore the request if it is
-// simultaneously executing newstack.
+// simultaneously executing newstack or is not at an asynchronous
+// safe-point when the preemption signal arrives.
 // No lock needs to be held.
 // Returns true if preemption request was issued.
 // The actual preemption will happen at some point in the future
@@ -4553,6 +4876,13 @@ func preemptone(_p_ *p) bool {
 	// Setting gp->stackguard0 to StackPreempt folds
 	// preemption into the normal stack overflow check.
 	gp.stackguard0 = stackPreempt
+
+	// Request an async preemption of this G in case it is
+	// running a loop without calls.
+	if preemptMSupported && debug.asyncpreemptoff == 0 {
+		preemptM(mp)
+	}
+
 	return true
 }
 
@@ -4565,6 +4895,11 @@ func schedtrace(detailed bool) {
 	}
 
 	lock(&sched.lock)
+	if debug.tracejsonfd > 0 {
+		jsonTraceSched(detailed)
+		unlock(&sched.lock)
+		return
+	}
 	print("SCHED ", (now-starttime)/1e6, "ms: gomaxprocs=", gomaxprocs, " idleprocs=", sched.npidle, " threads=", mcount(), " spinningthreads=", sched.nmspinning, " idlethreads=", sched.nmidle, " runqueue=", sched.runqsize)
 	if detailed {
 		print(" gcwaiting=", sched.gcwaiting, " nmidlelocked=", sched.nmidlelocked, " stopwait=", sched.stopwait, " sysmonwait=", sched.sysmonwait, "\n")
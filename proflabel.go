@@ -4,10 +4,35 @@
 
 package runtime
 
-import "unsafe"
+import (
+	"runtime/internal/atomic"
+	"unsafe"
+)
 
 var labelSync uintptr
 
+// A profLabelHeader is the start of the label set that g.labels points
+// to, which is otherwise opaque to the runtime. The scheduler adds to
+// *cpuNanos the time goroutines spend running with the label set.
+// Equal label sets share cpuNanos; see runtime/pprof.labelMap.
+type profLabelHeader struct {
+	cpuNanos *int64 // accessed atomically
+}
+
+// profLabelAccount charges the time since gp.cpuStamp to gp and to
+// gp's labels and clears gp.cpuStamp. Time spent in system calls or
+// waiting to run is not charged, since gp is not running then.
+//
+//go:nosplit
+func profLabelAccount(gp *g, now int64) {
+	d := now - gp.cpuStamp
+	gp.cpuTime += d
+	if h := (*profLabelHeader)(gp.labels); h != nil {
+		atomic.Xadd64((*uint64)(unsafe.Pointer(h.cpuNanos)), d)
+	}
+	gp.cpuStamp = 0
+}
+
 //go:linkname runtime_setProfLabel runtime/pprof.runtime_setProfLabel
 func runtime_setProfLabel(labels unsafe.Pointer) {
 	// Introduce race edge for read-back via profile.
@@ -31,7 +56,25 @@ func runtime_setProfLabel(labels unsafe.Pointer) {
 	if raceenabled {
 		racereleasemerge(unsafe.Pointer(&labelSync))
 	}
-	getg().labels = labels
+	gp := getg()
+	now := nanotime()
+	if gp.cpuStamp != 0 {
+		profLabelAccount(gp, now)
+	}
+	gp.labels = labels
+	if labels != nil {
+		gp.cpuStamp = now
+	}
+}
+
+//go:linkname runtime_goroutineCPUTime runtime/pprof.runtime_goroutineCPUTime
+func runtime_goroutineCPUTime() int64 {
+	gp := getg()
+	t := gp.cpuTime
+	if gp.cpuStamp != 0 {
+		t += nanotime() - gp.cpuStamp
+	}
+	return t
 }
 
 //go:linkname runtime_getProfLabel runtime/pprof.runtime_getProfLabel
@@ -13,6 +13,17 @@ func setMaxStack(in int) (out int) {
 	return out
 }
 
+//go:linkname setGoroutineStackLimit runtime/debug.setGoroutineStackLimit
+func setGoroutineStackLimit(in int) (out int) {
+	_g_ := getg()
+	out = int(_g_.stackLimit)
+	if in <= 0 {
+		in = 0
+	}
+	_g_.stackLimit = uintptr(in)
+	return out
+}
+
 //go:linkname setPanicOnFault runtime/debug.setPanicOnFault
 func setPanicOnFault(new bool) (old bool) {
 	_g_ := getg()
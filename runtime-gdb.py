@@ -158,34 +158,38 @@ class MapTypePrinter:
 	def children(self):
 		B = self.val['B']
 		buckets = self.val['buckets']
-		oldbuckets = self.val['oldbuckets']
 		flags = self.val['flags']
-		inttype = self.val['hash0'].type
 		cnt = 0
-		for bucket in xrange(2 ** int(B)):
-			bp = buckets + bucket
-			if oldbuckets:
-				oldbucket = bucket & (2 ** (B - 1) - 1)
-				oldbp = oldbuckets + oldbucket
-				oldb = oldbp.dereference()
-				if (oldb['overflow'].cast(inttype) & 1) == 0:  # old bucket not evacuated yet
-					if bucket >= 2 ** (B - 1):
-						continue    # already did old bucket
-					bp = oldbp
-			while bp:
-				b = bp.dereference()
-				for i in xrange(8):
-					if b['tophash'][i] != 0:
-						k = b['keys'][i]
-						v = b['values'][i]
-						if flags & 1:
-							k = k.dereference()
-						if flags & 2:
-							v = v.dereference()
-						yield str(cnt), k
-						yield str(cnt + 1), v
-						cnt += 2
-				bp = b['overflow']
+		if not buckets:
+			return
+		if flags & 8 == 0:
+			# small map: a single group
+			groups = [buckets]
+		else:
+			# directory of tables
+			groups = []
+			tabptr = gdb.lookup_type('runtime.table').pointer()
+			dirp = buckets.cast(tabptr.pointer())
+			i = 0
+			while i < 2 ** int(B):
+				tab = dirp[i].dereference()
+				gp = tab['groups'].cast(buckets.type)
+				for g in xrange(int(tab['groupMask']) + 1):
+					groups.append(gp + g)
+				i += 2 ** (int(B) - int(tab['localDepth']))
+		for bp in groups:
+			b = bp.dereference()
+			for i in xrange(8):
+				if b['tophash'][i] & 0x80:
+					k = b['keys'][i]
+					v = b['values'][i]
+					if flags & 1:
+						k = k.dereference()
+					if flags & 2:
+						v = v.dereference()
+					yield str(cnt), k
+					yield str(cnt + 1), v
+					cnt += 2
 
 
 class ChanTypePrinter:
@@ -314,39 +314,51 @@ type dbgVar struct {
 // existing int var for that value, which may
 // already have an initial value.
 var debug struct {
-	allocfreetrace     int32
-	cgocheck           int32
-	efence             int32
-	gccheckmark        int32
-	gcpacertrace       int32
-	gcshrinkstackoff   int32
-	gcrescanstacks     int32
-	gcstoptheworld     int32
-	gctrace            int32
-	invalidptr         int32
-	sbrk               int32
-	scavenge           int32
-	scheddetail        int32
-	schedtrace         int32
-	tracebackancestors int32
+	allocfreetrace      int32
+	asyncpreemptoff     int32
+	cgocheck            int32
+	efence              int32
+	gccheckmark         int32
+	gcgen               int32
+	gcpacertrace        int32
+	gcshrinkstackoff    int32
+	gcrescanstacks      int32
+	gcstoptheworld      int32
+	gctrace             int32
+	heaptypes           int32
+	invalidptr          int32
+	lockorder           int32
+	memprofilelifetimes int32
+	sbrk                int32
+	scavenge            int32
+	scheddetail         int32
+	schedtrace          int32
+	tracebackancestors  int32
+	tracejsonfd         int32
 }
 
 var dbgvars = []dbgVar{
 	{"allocfreetrace", &debug.allocfreetrace},
+	{"asyncpreemptoff", &debug.asyncpreemptoff},
 	{"cgocheck", &debug.cgocheck},
 	{"efence", &debug.efence},
 	{"gccheckmark", &debug.gccheckmark},
+	{"gcgen", &debug.gcgen},
 	{"gcpacertrace", &debug.gcpacertrace},
 	{"gcshrinkstackoff", &debug.gcshrinkstackoff},
 	{"gcrescanstacks", &debug.gcrescanstacks},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"heaptypes", &debug.heaptypes},
 	{"invalidptr", &debug.invalidptr},
+	{"lockorder", &debug.lockorder},
+	{"memprofilelifetimes", &debug.memprofilelifetimes},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
 	{"scheddetail", &debug.scheddetail},
 	{"schedtrace", &debug.schedtrace},
 	{"tracebackancestors", &debug.tracebackancestors},
+	{"tracejsonfd", &debug.tracejsonfd},
 }
 
 func parsedebugvars() {
@@ -388,6 +400,10 @@ func parsedebugvars() {
 
 	setTraceback(gogetenv("GOTRACEBACK"))
 	traceback_env = traceback_cache
+
+	if debug.heaptypes != 0 {
+		heapTypesInit()
+	}
 }
 
 //go:linkname setTraceback runtime/debug.SetTraceback
@@ -314,6 +314,11 @@ type sudog struct {
 	waitlink    *sudog // g.waiting list or semaRoot
 	waittail    *sudog // semaRoot
 	c           *hchan // channel
+
+	// hiddenc holds c as a uintptr while a leak-detecting GC
+	// hides it from the mark phase. It is zero at all other
+	// times. See mgcleak.go.
+	hiddenc uintptr
 }
 
 type libcall struct {
@@ -371,6 +376,7 @@ type g struct {
 	preempt        bool       // preemption signal, duplicates stackguard0 = stackpreempt
 	paniconfault   bool       // panic (instead of crash) on unexpected fault address
 	preemptscan    bool       // preempted g does scan for gc
+	asyncSafePoint bool       // g is stopped at an asynchronous safe point
 	gcscandone     bool       // g has scanned stack; protected by _Gscan bit in status
 	gcscanvalid    bool       // false at start of gc cycle, true if G has not run since last scan; TODO: remove?
 	throwsplit     bool       // must not split stack
@@ -386,15 +392,42 @@ type g struct {
 	sigcode1       uintptr
 	sigpc          uintptr
 	gopc           uintptr         // pc of go statement that created this goroutine
+	parentGoid     int64           // goid of the goroutine that created this goroutine
+	stackLimit     uintptr         // stack size limit of this goroutine's group, or 0 (see debug.SetGoroutineStackLimit)
 	ancestors      *[]ancestorInfo // ancestor information goroutine(s) that created this goroutine (only used if debug.tracebackancestors)
 	startpc        uintptr         // pc of goroutine function
 	racectx        uintptr
 	waiting        *sudog         // sudog structures this g is waiting on (that have a valid elem ptr); in lock order
+	condWait       uintptr        // notifyList this g is blocked on in sync.Cond.Wait; a uintptr so the GC ignores it
+	syncHeld       *lockOrderHeld // sync mutexes held, most recent first (only used if debug.lockorder)
+	syncWait       *lockOrderNode // sync mutex this g is blocked on (only used if debug.lockorder)
 	cgoCtxt        []uintptr      // cgo traceback context
 	labels         unsafe.Pointer // profiler labels
 	timer          *timer         // cached timer for time.Sleep
 	selectDone     uint32         // are we participating in a select and did someone win the race?
 
+	// Goroutine leak detection state; see mgcleak.go. Only
+	// modified with the world stopped.
+	leakCandidate bool // stack scan deferred until the g's wait objects are marked
+	leaked        bool // found blocked forever by a leak-detecting GC
+
+	// Per-G tracking state for scheduler latency metrics.
+	tracking      bool  // whether we're tracking this G for sched latency statistics
+	trackingSeq   uint8 // used to decide whether to track this G
+	runnableStamp int64 // timestamp of when the G last became runnable, only used when tracking
+	runnableTime  int64 // the amount of time spent runnable, cleared when running, only used when tracking
+	runnableWait  int64 // total time spent runnable in transitions measured by schedTrackAll
+	lastRunnable  int64 // runnableTime of the latest such transition to running, cleared by execute
+
+	goroutineProfiled uint32 // state in the goroutine profile being collected; see goroutineProfile
+
+	// cpuStamp is the time the G started running with its current
+	// labels, or 0 if it has no labels or isn't running. cpuTime is
+	// the total time it has spent running with labels. See
+	// profLabelAccount.
+	cpuStamp int64
+	cpuTime  int64
+
 	// Per-G GC state
 
 	// gcAssistBytes is this G's GC assist credit in terms of
@@ -478,6 +511,11 @@ type m struct {
 }
 
 type p struct {
+	// The when field of the first entry on the timer heap, or 0
+	// if the heap is empty. Accessed atomically; keep at top to
+	// ensure alignment on 32-bit systems.
+	timer0When uint64
+
 	lock mutex
 
 	id          int32
@@ -531,6 +569,17 @@ type p struct {
 
 	palloc persistentAlloc // per-P to avoid mutex
 
+	// Lock for timers. We normally access the timers while running
+	// on this P, but the scheduler can also do it from a different P.
+	timersLock mutex
+
+	// Actions to take at some time. This is used to implement the
+	// standard library's time package. Must hold timersLock to access.
+	timers []*timer
+
+	// Race context used while executing timer functions.
+	timerRaceCtx uintptr
+
 	// Per-P GC state
 	gcAssistTime         int64 // Nanoseconds in assistAlloc
 	gcFractionalMarkTime int64 // Nanoseconds in fractional mark worker
@@ -558,8 +607,19 @@ type p struct {
 
 type schedt struct {
 	// accessed atomically. keep at top to ensure alignment on 32-bit systems.
-	goidgen  uint64
-	lastpoll uint64
+	goidgen   uint64
+	lastpoll  uint64 // time of last network poll, 0 if currently polling
+	pollUntil uint64 // time to which current poll is sleeping
+
+	// timeToRun is a distribution of scheduling latencies, defined
+	// as the sum of time a G spends in the _Grunnable state before
+	// it transitions to _Grunning. Only a sample of transitions is
+	// recorded; see gTrackingPeriod.
+	timeToRun timeHistogram
+
+	// totalMutexWaitTime is the sum of time goroutines have spent
+	// blocked acquiring a sync.Mutex. Accessed atomically.
+	totalMutexWaitTime int64
 
 	lock mutex
 
@@ -703,14 +763,29 @@ func extendRandom(r []byte, n int) {
 
 // A _defer holds an entry on the list of deferred calls.
 // If you add a field here, add code to clear it in freedefer.
+//
+// Functions with open-coded defers run them inline on return and keep
+// no entries for them. Only a panic or Goexit adds an entry for each
+// such frame, with openDefer set, to run the frame's remaining
+// defers; see addOneOpenDeferFrame.
 type _defer struct {
-	siz     int32
+	siz     int32 // includes both arguments and results
 	started bool
 	sp      uintptr // sp at time of defer
 	pc      uintptr
 	fn      *funcval
 	_panic  *_panic // panic that is running defer
 	link    *_defer
+
+	// If openDefer is true, the entry is for a frame with
+	// open-coded defers. fd is the frame's function's
+	// _FUNCDATA_OpenCodedDeferInfo, varp its varp and framepc its
+	// pc, so the stack scan for further such frames can resume
+	// after it.
+	openDefer bool
+	fd        unsafe.Pointer
+	varp      uintptr
+	framepc   uintptr
 }
 
 // A _panic holds information about an active panic.
@@ -748,9 +823,10 @@ type stkframe struct {
 
 // ancestorInfo records details of where a goroutine was started.
 type ancestorInfo struct {
-	pcs  []uintptr // pcs from the stack of this goroutine
-	goid int64     // goroutine id of this goroutine; original goroutine possibly dead
-	gopc uintptr   // pc of go statement that created this goroutine
+	pcs        []uintptr // pcs from the stack of this goroutine
+	goid       int64     // goroutine id of this goroutine; original goroutine possibly dead
+	gopc       uintptr   // pc of go statement that created this goroutine
+	parentGoid int64     // goroutine id of the goroutine that created this goroutine
 }
 
 const (
@@ -787,10 +863,11 @@ const (
 	waitReasonSemacquire                              // "semacquire"
 	waitReasonSleep                                   // "sleep"
 	waitReasonSyncCondWait                            // "sync.Cond.Wait"
-	waitReasonTimerGoroutineIdle                      // "timer goroutine (idle)"
 	waitReasonTraceReaderBlocked                      // "trace reader (blocked)"
 	waitReasonWaitForGCCycle                          // "wait for GC cycle"
 	waitReasonGCWorkerIdle                            // "GC worker (idle)"
+	waitReasonGCScavengeWait                          // "GC scavenge wait"
+	waitReasonMaxprocsIdle                            // "GOMAXPROCS updater (idle)"
 )
 
 var waitReasonStrings = [...]string{
@@ -814,10 +891,11 @@ var waitReasonStrings = [...]string{
 	waitReasonSemacquire:            "semacquire",
 	waitReasonSleep:                 "sleep",
 	waitReasonSyncCondWait:          "sync.Cond.Wait",
-	waitReasonTimerGoroutineIdle:    "timer goroutine (idle)",
 	waitReasonTraceReaderBlocked:    "trace reader (blocked)",
 	waitReasonWaitForGCCycle:        "wait for GC cycle",
 	waitReasonGCWorkerIdle:          "GC worker (idle)",
+	waitReasonGCScavengeWait:        "GC scavenge wait",
+	waitReasonMaxprocsIdle:          "GOMAXPROCS updater (idle)",
 }
 
 func (w waitReason) String() string {
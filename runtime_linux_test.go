@@ -5,6 +5,9 @@
 package runtime_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	. "runtime"
 	"syscall"
 	"testing"
@@ -61,3 +64,75 @@ func TestEpollctlErrorSign(t *testing.T) {
 		t.Errorf("epollctl = %v, want %v", v, -EBADF)
 	}
 }
+
+func TestCgroupCPULimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		limit float64
+		ok    bool
+	}{
+		{
+			name: "v2 nested",
+			files: map[string]string{
+				"proc/self/cgroup":          "0::/a/b\n",
+				"sys/fs/cgroup/cpu.max":     "max 100000\n",
+				"sys/fs/cgroup/a/cpu.max":   "150000 100000\n",
+				"sys/fs/cgroup/a/b/cpu.max": "max 100000\n",
+			},
+			limit: 1.5,
+			ok:    true,
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"proc/self/cgroup":      "0::/\n",
+				"sys/fs/cgroup/cpu.max": "max 100000\n",
+			},
+		},
+		{
+			name: "v1 without namespace",
+			files: map[string]string{
+				"proc/self/cgroup":                            "12:cpu,cpuacct:/docker/x\n3:memory:/docker/x\n0::/system.slice\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "200000\n",
+				"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+			},
+			limit: 2,
+			ok:    true,
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"proc/self/cgroup":                    "4:cpu:/\n",
+				"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  "-1\n",
+				"sys/fs/cgroup/cpu/cpu.cfs_period_us": "100000\n",
+			},
+		},
+		{
+			name:  "no cgroups",
+			files: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "cgroupfs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			for name, data := range tt.files {
+				path := filepath.Join(root, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			limit, ok := CgroupCPULimit(root)
+			if limit != tt.limit || ok != tt.ok {
+				t.Errorf("CgroupCPULimit = %v, %v; want %v, %v", limit, ok, tt.limit, tt.ok)
+			}
+		})
+	}
+}
@@ -128,6 +128,12 @@ func semacquire1(addr *uint32, lifo bool, profile semaProfileFlags) {
 		}
 		s.acquiretime = t0
 	}
+	// Account for time spent blocked on sync.Mutex (including
+	// the writer lock inside sync.RWMutex) for runtime/metrics.
+	waitStart := int64(0)
+	if profile&semaMutexProfile != 0 {
+		waitStart = nanotime()
+	}
 	for {
 		lock(&root.lock)
 		// Add ourselves to nwait to disable "easy case" in semrelease.
@@ -149,6 +155,9 @@ func semacquire1(addr *uint32, lifo bool, profile semaProfileFlags) {
 	if s.releasetime > 0 {
 		blockevent(s.releasetime-t0, 3)
 	}
+	if waitStart != 0 {
+		atomic.Xaddint64(&sched.totalMutexWaitTime, nanotime()-waitStart)
+	}
 	releaseSudog(s)
 }
 
@@ -507,7 +516,10 @@ func notifyListWait(l *notifyList, t uint32) {
 		l.tail.next = s
 	}
 	l.tail = s
+	// Record what we're waiting on for the goroutine leak detector.
+	s.g.condWait = uintptr(unsafe.Pointer(l))
 	goparkunlock(&l.lock, waitReasonSyncCondWait, traceEvGoBlockCond, 3)
+	getg().condWait = 0
 	if t0 != 0 {
 		blockevent(s.releasetime-t0, 2)
 	}
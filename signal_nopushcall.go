@@ -0,0 +1,15 @@
+// Copyright 2019 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !amd64,!amd64p32
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runtime
+
+// pushCall is only implemented where asynchronous preemption is
+// supported. doSigPreempt is never called on other architectures
+// because preemptMSupported is false.
+func (c *sigctxt) pushCall(targetPC uintptr) {
+	throw("pushCall not implemented")
+}
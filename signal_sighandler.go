@@ -127,6 +127,9 @@ func sighandler(sig uint32, info *siginfo, ctxt unsafe.Pointer, gp *g) {
 		}
 		dumpregs(c)
 	}
+	if crashing == 0 {
+		writeCrashReport(gp, c.sigpc(), c.sigsp(), c.siglr(), true, sig, "")
+	}
 
 	if docrash {
 		crashing++
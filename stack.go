@@ -692,6 +692,7 @@ func adjustdefers(gp *g, adjinfo *adjustinfo) {
 		adjustpointer(adjinfo, unsafe.Pointer(&d.fn))
 		adjustpointer(adjinfo, unsafe.Pointer(&d.sp))
 		adjustpointer(adjinfo, unsafe.Pointer(&d._panic))
+		adjustpointer(adjinfo, unsafe.Pointer(&d.varp))
 	}
 }
 
@@ -932,7 +933,7 @@ func newstack() {
 	// it needs a lock held by the goroutine), that small preemption turns
 	// into a real deadlock.
 	if preempt {
-		if thisg.m.locks != 0 || thisg.m.mallocing != 0 || thisg.m.preemptoff != "" || thisg.m.p.ptr().status != _Prunning {
+		if !canPreemptM(thisg.m) {
 			// Let the goroutine keep running for now.
 			// gp->preempt is set, so it will be preempted next time.
 			gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -969,26 +970,7 @@ func newstack() {
 		// Synchronize with scang.
 		casgstatus(gp, _Grunning, _Gwaiting)
 		if gp.preemptscan {
-			for !castogscanstatus(gp, _Gwaiting, _Gscanwaiting) {
-				// Likely to be racing with the GC as
-				// it sees a _Gwaiting and does the
-				// stack scan. If so, gcworkdone will
-				// be set and gcphasework will simply
-				// return.
-			}
-			if !gp.gcscandone {
-				// gcw is safe because we're on the
-				// system stack.
-				gcw := &gp.m.p.ptr().gcw
-				scanstack(gp, gcw)
-				if gcBlackenPromptly {
-					gcw.dispose()
-				}
-				gp.gcscandone = true
-			}
-			gp.preemptscan = false
-			gp.preempt = false
-			casfrom_Gscanstatus(gp, _Gscanwaiting, _Gwaiting)
+			preemptscanSelf(gp)
 			// This clears gcscanvalid.
 			casgstatus(gp, _Gwaiting, _Grunning)
 			gp.stackguard0 = gp.stack.lo + _StackGuard
@@ -1007,6 +989,9 @@ func newstack() {
 		print("runtime: goroutine stack exceeds ", maxstacksize, "-byte limit\n")
 		throw("stack overflow")
 	}
+	limited := gp.stackLimit != 0 && newsize > gp.stackLimit && canstacklimitpanic(gp)
+
+	stackgrowthevent(gp, oldsize, newsize)
 
 	// The goroutine must be executing in order to call newstack,
 	// so it must be Grunning (or Gscanrunning).
@@ -1018,10 +1003,32 @@ func newstack() {
 	if stackDebug >= 1 {
 		print("stack grow done\n")
 	}
+	if limited {
+		// Make gp panic instead of running the function that
+		// needs more stack. The function has not started yet,
+		// so gp can run panicstacklimit as if the function's
+		// caller had called it instead. The stack still grew,
+		// to leave room for the panic and the deferred calls
+		// it runs.
+		gp.sched.pc = funcPC(panicstacklimit)
+	}
 	casgstatus(gp, _Gcopystack, _Grunning)
 	gogo(&gp.sched)
 }
 
+// canstacklimitpanic reports whether gp, which is in newstack, can
+// panic because its stack exceeds the limit of its goroutine group.
+// Once gp panics, its deferred calls may grow its stack beyond the
+// limit. Runtime functions never panic for the limit; the goroutine
+// panics at its next stack growth in other code instead.
+func canstacklimitpanic(gp *g) bool {
+	if gp._panic != nil || !canpanic(gp) {
+		return false
+	}
+	f := findfunc(gp.sched.pc)
+	return f.valid() && !hasprefix(funcname(f), "runtime.")
+}
+
 //go:nosplit
 func nilfunc() {
 	*(*uint8)(nil) = 0
@@ -1093,6 +1100,12 @@ func shrinkstack(gp *g) {
 	if gp.syscallsp != 0 {
 		return
 	}
+	// We can't copy the stack if gp is stopped at an asynchronous
+	// safe point. The interrupted frame was scanned conservatively
+	// and has no stack map to adjust its pointers with.
+	if gp.asyncSafePoint {
+		return
+	}
 	if sys.GoosWindows != 0 && gp.m != nil && gp.m.libcallsp != 0 {
 		return
 	}
@@ -1241,6 +1254,32 @@ func getStackMap(frame *stkframe, cache *pcvalueCache, debug bool) (locals, args
 	return
 }
 
+// preemptscanSelf scans gp's stack on behalf of scang. gp must be
+// stopped in _Gwaiting on its own M and must have been asked to scan
+// itself by scang. This must run on the system stack.
+func preemptscanSelf(gp *g) {
+	for !castogscanstatus(gp, _Gwaiting, _Gscanwaiting) {
+		// Likely to be racing with the GC as
+		// it sees a _Gwaiting and does the
+		// stack scan. If so, gcworkdone will
+		// be set and gcphasework will simply
+		// return.
+	}
+	if !gp.gcscandone {
+		// gcw is safe because we're on the
+		// system stack.
+		gcw := &gp.m.p.ptr().gcw
+		scanstack(gp, gcw)
+		if gcBlackenPromptly {
+			gcw.dispose()
+		}
+		gp.gcscandone = true
+	}
+	gp.preemptscan = false
+	gp.preempt = false
+	casfrom_Gscanstatus(gp, _Gscanwaiting, _Gwaiting)
+}
+
 //go:nosplit
 func morestackc() {
 	throw("attempt to execute system stack code on user stack")
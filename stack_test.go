@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"regexp"
 	. "runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
@@ -784,3 +785,74 @@ func TestTracebackAncestors(t *testing.T) {
 		}
 	}
 }
+
+func TestTracebackCreatedByGoroutine(t *testing.T) {
+	buf := make([]byte, 64)
+	buf = buf[:Stack(buf, false)]
+	var parent int
+	if _, err := fmt.Sscanf(string(buf), "goroutine %d ", &parent); err != nil {
+		t.Fatalf("bad stack trace %q: %v", buf, err)
+	}
+
+	c := make(chan []byte)
+	go func() {
+		buf := make([]byte, 4096)
+		c <- buf[:Stack(buf, false)]
+	}()
+	stk := string(<-c)
+	want := fmt.Sprintf("created by runtime_test.TestTracebackCreatedByGoroutine in goroutine %d\n", parent)
+	if !strings.Contains(stk, want) {
+		t.Errorf("traceback does not contain %q:\n%s", want, stk)
+	}
+}
+
+// stackLimitRecurse uses about n KB of stack.
+func stackLimitRecurse(n int) int {
+	var buf [1024]byte
+	if n == 0 {
+		return len(buf)
+	}
+	return stackLimitRecurse(n-1) + int(buf[n%len(buf)])
+}
+
+// stackLimitRecover runs stackLimitRecurse(n) and returns what it
+// panicked with, if anything.
+func stackLimitRecover(n int) (err interface{}) {
+	defer func() {
+		err = recover()
+	}()
+	stackLimitRecurse(n)
+	return nil
+}
+
+func TestGoroutineStackLimit(t *testing.T) {
+	c := make(chan interface{})
+	go func() {
+		debug.SetGoroutineStackLimit(64 << 10)
+		// Stay within the limit.
+		if err := stackLimitRecover(16); err != nil {
+			c <- fmt.Errorf("unexpected panic within the limit: %v", err)
+			return
+		}
+		// The limit is inherited by goroutines started afterwards.
+		go func() {
+			c <- stackLimitRecover(10000)
+		}()
+		c <- stackLimitRecover(10000)
+	}()
+	for i := 0; i < 2; i++ {
+		err := <-c
+		rerr, ok := err.(Error)
+		if !ok {
+			t.Fatalf("got %v, want a runtime.Error", err)
+		}
+		if want := "goroutine stack exceeds its limit"; !strings.Contains(rerr.Error(), want) {
+			t.Errorf("got %q, want it to contain %q", rerr.Error(), want)
+		}
+	}
+
+	// The limit does not apply to other goroutines.
+	if err := stackLimitRecover(1000); err != nil {
+		t.Errorf("unexpected panic without a limit: %v", err)
+	}
+}
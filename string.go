@@ -347,6 +347,10 @@ func hasprefix(s, t string) bool {
 	return len(s) >= len(t) && s[:len(t)] == t
 }
 
+func hassuffix(s, t string) bool {
+	return len(s) >= len(t) && s[len(s)-len(t):] == t
+}
+
 const (
 	maxUint = ^uint(0)
 	maxInt  = int(maxUint >> 1)
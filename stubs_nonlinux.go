@@ -10,3 +10,26 @@ package runtime
 func sbrk0() uintptr {
 	return 0
 }
+
+// preemptMSupported reports whether preemptM is implemented.
+// Asynchronous preemption is not yet supported here.
+const preemptMSupported = false
+
+func preemptM(mp *m) {
+	// Not currently supported.
+}
+
+// cgroupCPUInit finds the cgroup CPU limit files of the process.
+// There are no cgroups here.
+func cgroupCPUInit(root string) {}
+
+// haveCgroupCPU reports whether cgroups may limit the CPU use of the
+// process.
+func haveCgroupCPU() bool {
+	return false
+}
+
+// cgroupCPULimit returns the cgroup CPU limit of the process.
+func cgroupCPULimit() (float64, bool) {
+	return 0, false
+}
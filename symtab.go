@@ -341,14 +341,15 @@ func (f *Func) funcInfo() funcInfo {
 //
 // See funcdata.h and ../cmd/internal/objabi/funcdata.go.
 const (
-	_PCDATA_StackMapIndex       = 0
-	_PCDATA_InlTreeIndex        = 1
-	_PCDATA_RegMapIndex         = 2
-	_FUNCDATA_ArgsPointerMaps   = 0
-	_FUNCDATA_LocalsPointerMaps = 1
-	_FUNCDATA_InlTree           = 2
-	_FUNCDATA_RegPointerMaps    = 3
-	_ArgsSizeUnknown            = -0x80000000
+	_PCDATA_StackMapIndex        = 0
+	_PCDATA_InlTreeIndex         = 1
+	_PCDATA_RegMapIndex          = 2
+	_FUNCDATA_ArgsPointerMaps    = 0
+	_FUNCDATA_LocalsPointerMaps  = 1
+	_FUNCDATA_InlTree            = 2
+	_FUNCDATA_RegPointerMaps     = 3
+	_FUNCDATA_OpenCodedDeferInfo = 4
+	_ArgsSizeUnknown             = -0x80000000
 )
 
 // A FuncID identifies particular functions that need to be treated
@@ -847,6 +848,25 @@ func funcspdelta(f funcInfo, targetpc uintptr, cache *pcvalueCache) int32 {
 	return x
 }
 
+// funcMaxSPDelta returns the maximum spdelta at any point in f.
+func funcMaxSPDelta(f funcInfo) int32 {
+	datap := f.datap
+	p := datap.pclntable[f.pcsp:]
+	pc := f.entry
+	val := int32(-1)
+	max := int32(0)
+	for {
+		var ok bool
+		p, ok = step(p, &pc, &val, pc == f.entry)
+		if !ok {
+			return max
+		}
+		if val > max {
+			max = val
+		}
+	}
+}
+
 func pcdatavalue(f funcInfo, table int32, targetpc uintptr, cache *pcvalueCache) int32 {
 	if table < 0 || table >= f.npcdata {
 		return -1
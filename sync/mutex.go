@@ -79,6 +79,9 @@ func (m *Mutex) Lock() {
 		if race.Enabled {
 			race.Acquire(unsafe.Pointer(m))
 		}
+		if lockOrderEnabled {
+			runtime_lockOrderAcquire(unsafe.Pointer(m), false)
+		}
 		return
 	}
 
@@ -133,6 +136,9 @@ func (m *Mutex) Lock() {
 			if waitStartTime == 0 {
 				waitStartTime = runtime_nanotime()
 			}
+			if lockOrderEnabled {
+				runtime_lockOrderWait(unsafe.Pointer(m))
+			}
 			runtime_SemacquireMutex(&m.sema, queueLifo)
 			starving = starving || runtime_nanotime()-waitStartTime > starvationThresholdNs
 			old = m.state
@@ -166,6 +172,9 @@ func (m *Mutex) Lock() {
 	if race.Enabled {
 		race.Acquire(unsafe.Pointer(m))
 	}
+	if lockOrderEnabled {
+		runtime_lockOrderAcquire(unsafe.Pointer(m), false)
+	}
 }
 
 // Unlock unlocks m.
@@ -179,6 +188,9 @@ func (m *Mutex) Unlock() {
 		_ = m.state
 		race.Release(unsafe.Pointer(m))
 	}
+	if lockOrderEnabled {
+		runtime_lockOrderRelease(unsafe.Pointer(m), false)
+	}
 
 	// Fast path: drop lock bit.
 	new := atomic.AddInt32(&m.state, -mutexLocked)
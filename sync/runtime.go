@@ -60,3 +60,21 @@ func runtime_canSpin(i int) bool
 func runtime_doSpin()
 
 func runtime_nanotime() int64
+
+// Lock-order checking runtime support; see runtime/lockorder.go.
+// lockOrderEnabled is set if GODEBUG=lockorder is.
+var lockOrderEnabled = runtime_lockOrderEnabled()
+
+func runtime_lockOrderEnabled() bool
+
+// runtime_lockOrderAcquire records that the calling goroutine locked m,
+// for reading if read is set.
+func runtime_lockOrderAcquire(m unsafe.Pointer, read bool)
+
+// runtime_lockOrderWait records that the calling goroutine is about
+// to block waiting for m.
+func runtime_lockOrderWait(m unsafe.Pointer)
+
+// runtime_lockOrderRelease records that m is about to be unlocked,
+// for reading if read is set.
+func runtime_lockOrderRelease(m unsafe.Pointer, read bool)
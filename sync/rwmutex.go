@@ -47,12 +47,18 @@ func (rw *RWMutex) RLock() {
 	}
 	if atomic.AddInt32(&rw.readerCount, 1) < 0 {
 		// A writer is pending, wait for it.
+		if lockOrderEnabled {
+			runtime_lockOrderWait(unsafe.Pointer(rw))
+		}
 		runtime_SemacquireMutex(&rw.readerSem, false)
 	}
 	if race.Enabled {
 		race.Enable()
 		race.Acquire(unsafe.Pointer(&rw.readerSem))
 	}
+	if lockOrderEnabled {
+		runtime_lockOrderAcquire(unsafe.Pointer(rw), true)
+	}
 }
 
 // RUnlock undoes a single RLock call;
@@ -65,6 +71,9 @@ func (rw *RWMutex) RUnlock() {
 		race.ReleaseMerge(unsafe.Pointer(&rw.writerSem))
 		race.Disable()
 	}
+	if lockOrderEnabled {
+		runtime_lockOrderRelease(unsafe.Pointer(rw), true)
+	}
 	if r := atomic.AddInt32(&rw.readerCount, -1); r < 0 {
 		if r+1 == 0 || r+1 == -rwmutexMaxReaders {
 			race.Enable()
@@ -90,6 +99,9 @@ func (rw *RWMutex) Lock() {
 		race.Disable()
 	}
 	// First, resolve competition with other writers.
+	// With lock-order checking, this also records rw,
+	// which is at the same address as rw.w, as locked
+	// for writing.
 	rw.w.Lock()
 	// Announce to readers there is a pending writer.
 	r := atomic.AddInt32(&rw.readerCount, -rwmutexMaxReaders) + rwmutexMaxReaders
@@ -0,0 +1,62 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+func init() {
+	register("CrashOutput", CrashOutput)
+	register("CrashOutputThrow", CrashOutputThrow)
+	register("CrashOutputBadUTF8", CrashOutputBadUTF8)
+}
+
+// setCrashOutput sets the crash output and crash report to the files
+// named by $CRASH_OUTPUT and $CRASH_REPORT.
+func setCrashOutput() {
+	f, err := os.Create(os.Getenv("CRASH_OUTPUT"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	report, err := os.Create(os.Getenv("CRASH_REPORT"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := debug.SetCrashOutput(f, debug.CrashOptions{Report: report}); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// CrashOutput panics with the crash output and crash report set.
+func CrashOutput() {
+	setCrashOutput()
+	c := make(chan int)
+	started := make(chan bool)
+	go func() {
+		started <- true
+		<-c
+	}()
+	<-started
+	panic("crash output test")
+}
+
+// CrashOutputThrow deadlocks with the crash output and crash report
+// set, which the runtime reports as a fatal error rather than a panic.
+func CrashOutputThrow() {
+	setCrashOutput()
+	select {}
+}
+
+// CrashOutputBadUTF8 panics with a message that is not valid UTF-8.
+func CrashOutputBadUTF8() {
+	setCrashOutput()
+	panic("crash output \xff test")
+}
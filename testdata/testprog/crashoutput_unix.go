@@ -0,0 +1,35 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !windows,!plan9,!nacl
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+func init() {
+	register("CrashOutputSegv", CrashOutputSegv)
+	register("CrashOutputSignal", CrashOutputSignal)
+}
+
+var crashOutputNil *int
+
+// CrashOutputSegv dereferences a nil pointer with the crash output and
+// crash report set. The SIGSEGV becomes a panic.
+func CrashOutputSegv() {
+	setCrashOutput()
+	*crashOutputNil = 1
+}
+
+// CrashOutputSignal sends itself a SIGABRT with the crash output and
+// crash report set, which crashes the program from the signal handler.
+func CrashOutputSignal() {
+	setCrashOutput()
+	syscall.Kill(syscall.Getpid(), syscall.SIGABRT)
+	// As in SignalExitStatus, give the signal time to arrive.
+	time.Sleep(time.Second)
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"runtime/metrics"
 	"sync/atomic"
 	"time"
 )
@@ -17,6 +18,7 @@ func init() {
 	register("GCFairness", GCFairness)
 	register("GCFairness2", GCFairness2)
 	register("GCSys", GCSys)
+	register("GCGenerational", GCGenerational)
 }
 
 func GCSys() {
@@ -121,3 +123,89 @@ func GCFairness2() {
 	}
 	fmt.Println("OK")
 }
+
+type genNode struct {
+	leaf *genLeaf
+	pad  [7]uintptr
+}
+
+type genLeaf struct {
+	id   int
+	self *genLeaf
+	buf  []byte
+}
+
+func newGenLeaf(id int) *genLeaf {
+	l := &genLeaf{id: id, buf: make([]byte, 64)}
+	l.self = l
+	for i := range l.buf {
+		l.buf[i] = byte(id)
+	}
+	return l
+}
+
+func (l *genLeaf) ok() bool {
+	if l == nil {
+		return true
+	}
+	if l.self != l || len(l.buf) != 64 {
+		return false
+	}
+	for _, b := range l.buf {
+		if b != byte(l.id) {
+			return false
+		}
+	}
+	return true
+}
+
+var genSink []byte
+
+// GCGenerational stores new objects in old ones while minor GC cycles
+// run. It is meant to run with GODEBUG=gcgen=1,gccheckmark=1, so a
+// young object that a minor cycle misses makes the checkmark pass
+// throw.
+func GCGenerational() {
+	nodes := make([]*genNode, 1000)
+	for i := range nodes {
+		nodes[i] = new(genNode)
+	}
+	big := make([]*genLeaf, 1<<14) // a large object
+	runtime.GC()                   // make nodes and big old
+
+	for iter := 0; iter < 5000; iter++ {
+		for j := 0; j < 16; j++ {
+			genSink = make([]byte, 1024)
+		}
+		// Pointer writes.
+		nodes[iter%len(nodes)].leaf = newGenLeaf(iter)
+		big[iter*7%len(big)] = newGenLeaf(iter)
+		if iter%50 == 0 {
+			// Bulk writes.
+			tmp := []*genLeaf{newGenLeaf(iter), newGenLeaf(iter + 1), newGenLeaf(iter + 2)}
+			copy(big[iter%(len(big)-len(tmp)):], tmp)
+			*nodes[(iter+1)%len(nodes)] = genNode{leaf: newGenLeaf(iter)}
+		}
+	}
+
+	for _, n := range nodes {
+		if !n.leaf.ok() {
+			fmt.Println("corrupt leaf in old object")
+			return
+		}
+	}
+	for _, l := range big {
+		if !l.ok() {
+			fmt.Println("corrupt leaf in old large object")
+			return
+		}
+	}
+
+	s := []metrics.Sample{{Name: "/gc/cycles/minor:gc-cycles"}}
+	metrics.Read(s)
+	if s[0].Value.Uint64() == 0 {
+		fmt.Println("no minor GC cycles")
+		return
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// These are run with GODEBUG=lockorder=1.
+
+func init() {
+	register("LockOrderInversion", LockOrderInversion)
+	register("LockOrderDeadlock", LockOrderDeadlock)
+	register("LockOrderRWInversion", LockOrderRWInversion)
+	register("LockOrderFreed", LockOrderFreed)
+}
+
+func LockOrderInversion() {
+	var a, b sync.Mutex
+	done := make(chan bool)
+	go func() {
+		lockOrderAB(&a, &b)
+		done <- true
+	}()
+	<-done
+	// Never deadlocks, since the goroutine above is gone,
+	// but the order is the opposite of lockOrderAB's.
+	lockOrderBA(&a, &b)
+	fmt.Println("OK")
+}
+
+func lockOrderAB(a, b *sync.Mutex) {
+	a.Lock()
+	b.Lock()
+	b.Unlock()
+	a.Unlock()
+}
+
+func lockOrderBA(a, b *sync.Mutex) {
+	b.Lock()
+	a.Lock()
+	a.Unlock()
+	b.Unlock()
+}
+
+func LockOrderDeadlock() {
+	var a, b sync.Mutex
+	var wg sync.WaitGroup
+	locked := make(chan bool)
+	wg.Add(2)
+	go func() {
+		a.Lock()
+		locked <- true
+		<-locked
+		b.Lock()
+		wg.Done()
+	}()
+	go func() {
+		<-locked
+		b.Lock()
+		locked <- true
+		a.Lock()
+		wg.Done()
+	}()
+	wg.Wait()
+}
+
+func LockOrderRWInversion() {
+	var a, b sync.RWMutex
+	done := make(chan bool)
+	go func() {
+		lockOrderReadAB(&a, &b)
+		done <- true
+	}()
+	<-done
+	b.Lock()
+	a.RLock()
+	a.RUnlock()
+	b.Unlock()
+	fmt.Println("OK")
+}
+
+func lockOrderReadAB(a, b *sync.RWMutex) {
+	a.RLock()
+	b.Lock()
+	b.Unlock()
+	a.RUnlock()
+}
+
+func LockOrderFreed() {
+	// Lock pairs of mutexes in alternating orders. Each pair is
+	// freed before the next is allocated, so the new mutexes
+	// often reuse the old ones' addresses, but they are
+	// different mutexes and the orders do not conflict.
+	for i := 0; i < 100; i++ {
+		a, b := new(sync.Mutex), new(sync.Mutex)
+		if i%2 == 0 {
+			a, b = b, a
+		}
+		a.Lock()
+		b.Lock()
+		b.Unlock()
+		a.Unlock()
+		runtime.GC()
+	}
+	fmt.Println("OK")
+}
@@ -7,7 +7,7 @@
 package runtime
 
 import (
-	"runtime/internal/sys"
+	"runtime/internal/atomic"
 	"unsafe"
 )
 
@@ -17,11 +17,14 @@ import (
 // If this struct changes, adjust ../syscall/net_nacl.go:/runtimeTimer.
 // 定时器的结构体
 type timer struct {
-	tb *timersBucket // the bucket the timer lives in  定时器桶
-	i  int           // heap index  堆的索引
+	// If this timer is on a heap, which P's heap it is on.
+	// puintptr rather than *p to match uintptr in the versions
+	// of this struct defined in other packages.
+	pp puintptr
+	i  int // heap index  堆的索引
 
 	// Timer wakes up at when, and then at when+period, ... (period > 0 only)
-	// each time calling f(arg, now) in the timer goroutine, so f must be
+	// each time calling f(arg, now) in the scheduler, so f must be
 	// a well-behaved function and not block.
 	when   int64                      // 表示当前的时间
 	period int64                      // 表示周期时间
@@ -30,48 +33,21 @@ type timer struct {
 	seq    uintptr
 }
 
-// timersLen is the length of timers array.
+// Timers are kept in a 4-heap on each P, ordered by when, and
+// protected by the P's timersLock. There are no timer goroutines:
+// timer functions are run by the scheduler on the system stack,
+// either by the M that owns the P (see checkTimers in schedule and
+// findrunnable) or, if that P is idle or being preempted, by an M
+// looking for work on another P. So f must be a well-behaved
+// function that does not block.
 //
-// Ideally(理想), this would be set to GOMAXPROCS(P的数量), but that would require
-// dynamic reallocation 理想和实际的使用是有区别的
-//
-// The current value is a compromise(折中，妥协) between memory usage and performance(性能)
-// that should cover the majority of GOMAXPROCS values used in the wild.
-const timersLen = 64
-
-// timers contains "per-P" timer heaps.
-//
-// Timers are queued(排队) into timersBucket associated(关联) with the current(当前) P,
-// so each P may work with its own timers independently(独立得) of other P instances(实例).
-//
-// Each timersBucket may be associated with multiple(多个，多种) P if GOMAXPROCS > timersLen.
-var timers [timersLen]struct {
-	timersBucket
-
-	// The padding should eliminate false sharing
-	// between timersBucket values.
-	pad [sys.CacheLineSize - unsafe.Sizeof(timersBucket{})%sys.CacheLineSize]byte
-}
+// When there is nothing else to do, an M sleeps in netpoll until
+// the earliest timer that it is responsible for. Adding an earlier
+// timer wakes it with netpollBreak. sysmon starts an M if a timer
+// is overdue, which happens if the P holding it is not scheduling.
 
-// 分配桶
-func (t *timer) assignBucket() *timersBucket {
-	// 获取当前g得m，p得id
-	id := uint8(getg().m.p.ptr().id) % timersLen
-	t.tb = &timers[id].timersBucket
-	return t.tb
-}
-
-//go:notinheap
-type timersBucket struct {
-	lock         mutex
-	gp           *g
-	created      bool // 是否创建
-	sleeping     bool // 是否正在由睡眠得
-	rescheduling bool
-	sleepUntil   int64 // 睡眠到什么时候
-	waitnote     note
-	t            []*timer
-}
+// maxWhen is the maximum value for timer's when field.
+const maxWhen = 1<<63 - 1
 
 //
 // nacl fake time support - time in nanoseconds since 1970
@@ -99,15 +75,22 @@ func timeSleep(ns int64) {
 	*t = timer{}
 	// 保存再什么时间进行睡眠
 	t.when = nanotime() + ns
+	if t.when < 0 { // check for overflow.
+		t.when = maxWhen
+	}
 	t.f = goroutineReady
 	t.arg = gp
-	tb := t.assignBucket()
-	lock(&tb.lock)
-	if !tb.addtimerLocked(t) {
-		unlock(&tb.lock)
+	// No need to wake the netpoller: this P is about to look for
+	// work and will see the new timer.
+	mp := acquirem()
+	pp := mp.p.ptr()
+	lock(&pp.timersLock)
+	releasem(mp)
+	if !doaddtimer(pp, t) {
+		unlock(&pp.timersLock)
 		badTimer()
 	}
-	goparkunlock(&tb.lock, waitReasonSleep, traceEvGoSleep, 2)
+	goparkunlock(&pp.timersLock, waitReasonSleep, traceEvGoSleep, 2)
 }
 
 // startTimer adds t to the timer heap.
@@ -134,232 +117,315 @@ func goroutineReady(arg interface{}, seq uintptr) {
 	goready(arg.(*g), 0)
 }
 
+// addtimer adds a timer to the current P's heap.
+// This should only be called with a newly created timer.
+// That avoids the risk of changing the when field of a timer in some P's heap,
+// which could cause the heap to become unsorted.
 func addtimer(t *timer) {
-	tb := t.assignBucket()
-	lock(&tb.lock)
-	ok := tb.addtimerLocked(t)
-	unlock(&tb.lock)
+	// when must never be negative(负数); otherwise runtimer will overflow(溢出)
+	// during its delta calculation and never expire(终止) other runtime timers.
+	if t.when < 0 {
+		t.when = maxWhen
+	}
+	when := t.when
+
+	// Pin the M so that the P cannot be destroyed by procresize
+	// before we lock its timers.
+	mp := acquirem()
+	pp := mp.p.ptr()
+	lock(&pp.timersLock)
+	ok := doaddtimer(pp, t)
+	unlock(&pp.timersLock)
+	releasem(mp)
 	if !ok {
 		badTimer()
 	}
+
+	wakeNetPoller(when)
 }
 
-// Add a timer to the heap and start or kick timerproc(回调函数)
-// if the new timer is earlier than any of the others.
-// Timers are locked.
+// doaddtimer adds t to pp's heap.
+// The caller must have locked the timers for pp.
 // Returns whether all is well:
 // false if the data structure is corrupt due to(由于) user-level(用户级得) races(竞争).
 // 正常情况下会返回true，如果由于竞争导致数据被破坏则会返回false
-func (tb *timersBucket) addtimerLocked(t *timer) bool {
-	// when must never be negative(负数); otherwise timerproc will overflow(溢出) during
-	// its delta calculation and never expire(终止) other runtime timers.
-	if t.when < 0 {
-		t.when = 1<<63 - 1
+func doaddtimer(pp *p, t *timer) bool {
+	// Timers rely on the network poller, so make sure the poller
+	// has started.
+	if !netpollinited() {
+		netpollGenericInit()
 	}
+
+	t.pp.set(pp)
 	// 保存当前定时器堆中数组得长度
-	t.i = len(tb.t)
+	i := len(pp.timers)
+	t.i = i
 	// 将t加入到堆中
-	tb.t = append(tb.t, t)
-	if !siftupTimer(tb.t, t.i) {
+	pp.timers = append(pp.timers, t)
+	if !siftupTimer(pp.timers, i) {
 		return false
 	}
-	if t.i == 0 {
-		// siftup moved to top: new earliest deadline.
-		if tb.sleeping {
-			tb.sleeping = false
-			notewakeup(&tb.waitnote)
-		}
-		if tb.rescheduling {
-			tb.rescheduling = false
-			goready(tb.gp, 0)
-		}
-	}
-	if !tb.created {
-		tb.created = true
-		go timerproc(tb)
+	if t == pp.timers[0] {
+		atomic.Store64(&pp.timer0When, uint64(t.when))
 	}
 	return true
 }
 
-// Delete timer t from the heap.
-// Do not need to update the timerproc: if it wakes up early, no big deal.
+// deltimer removes t from the heap of whichever P it is on.
+// Reports whether the timer was removed before it was run.
 func deltimer(t *timer) bool {
-	if t.tb == nil {
-		// t.tb can be nil if the user created a timer
-		// directly, without invoking startTimer e.g
-		//    time.Ticker{C: c}
-		// In this case, return early without any deletion.
-		// See Issue 21874.
-		return false
-	}
-
-	tb := t.tb
+	for {
+		pp := t.pp.ptr()
+		if pp == nil {
+			// t.pp can be nil if the user created a timer
+			// directly, without invoking startTimer e.g
+			//    time.Ticker{C: c}
+			// or if the timer has already run or been deleted.
+			// In this case, return early without any deletion.
+			// See Issue 21874.
+			return false
+		}
 
-	lock(&tb.lock)
-	// t may not be registered anymore and may have
-	// a bogus i (typically 0, if generated by Go).
-	// Verify it before proceeding.
-	i := t.i
-	last := len(tb.t) - 1
-	// 判断t是否再堆中，如果再将t删掉
-	if i < 0 || i > last || tb.t[i] != t {
-		unlock(&tb.lock)
-		return false
+		lock(&pp.timersLock)
+		if t.pp.ptr() != pp {
+			// The timer ran, was deleted, or was moved to
+			// another P while we were waiting for the lock.
+			unlock(&pp.timersLock)
+			continue
+		}
+		// t may not be registered anymore and may have
+		// a bogus i (typically 0, if generated by Go).
+		// Verify it before proceeding.
+		i := t.i
+		if i < 0 || i >= len(pp.timers) || pp.timers[i] != t {
+			unlock(&pp.timersLock)
+			return false
+		}
+		ok := dodeltimer(pp, i)
+		unlock(&pp.timersLock)
+		if !ok {
+			badTimer()
+		}
+		return true
 	}
+}
+
+// dodeltimer removes timer i from pp's heap.
+// The caller must have locked the timers for pp.
+// Returns false if the heap is corrupt.
+func dodeltimer(pp *p, i int) bool {
+	t := pp.timers[i]
+	t.pp = 0
+	t.i = -1 // mark as removed
+	last := len(pp.timers) - 1
 	if i != last {
-		tb.t[i] = tb.t[last]
-		tb.t[i].i = i
+		pp.timers[i] = pp.timers[last]
+		pp.timers[i].i = i
 	}
-	tb.t[last] = nil
-	tb.t = tb.t[:last]
+	pp.timers[last] = nil
+	pp.timers = pp.timers[:last]
 	ok := true
 	if i != last {
-		if !siftupTimer(tb.t, i) {
+		// Moving to i may have moved the last timer to a new parent,
+		// so sift up to preserve the heap guarantee.
+		if !siftupTimer(pp.timers, i) {
 			ok = false
 		}
-		if !siftdownTimer(tb.t, i) {
+		if !siftdownTimer(pp.timers, i) {
 			ok = false
 		}
 	}
-	unlock(&tb.lock)
-	if !ok {
-		badTimer()
+	if i == 0 {
+		updateTimer0When(pp)
 	}
-	return true
+	return ok
 }
 
-// Timerproc runs the time-driven events.
-// It sleeps util the next event in the tb heap.
-// If addtimer inserts a new earlier event, it wakes timerproc early.
-func timerproc(tb *timersBucket) {
-	tb.gp = getg()
-	for {
-		lock(&tb.lock)
-		tb.sleeping = false
-		now := nanotime()
-		delta := int64(-1)
-		for {
-			if len(tb.t) == 0 {
-				delta = -1
-				break
-			}
-			t := tb.t[0]
-			delta = t.when - now
-			if delta > 0 {
-				break
-			}
-			ok := true
-			if t.period > 0 {
-				// leave in heap but adjust next time to fire
-				t.when += t.period * (1 + -delta/t.period)
-				if !siftdownTimer(tb.t, 0) {
-					ok = false
-				}
-			} else {
-				// remove from heap
-				last := len(tb.t) - 1
-				if last > 0 {
-					tb.t[0] = tb.t[last]
-					tb.t[0].i = 0
-				}
-				tb.t[last] = nil
-				tb.t = tb.t[:last]
-				if last > 0 {
-					if !siftdownTimer(tb.t, 0) {
-						ok = false
-					}
-				}
-				t.i = -1 // mark as removed
-			}
-			f := t.f
-			arg := t.arg
-			seq := t.seq
-			unlock(&tb.lock)
-			if !ok {
-				badTimer()
-			}
-			if raceenabled {
-				raceacquire(unsafe.Pointer(t))
-			}
-			f(arg, seq)
-			lock(&tb.lock)
-		}
-		if delta < 0 || faketime > 0 {
-			// No timers left - put goroutine to sleep.
-			tb.rescheduling = true
-			goparkunlock(&tb.lock, waitReasonTimerGoroutineIdle, traceEvGoBlock, 1)
-			continue
-		}
-		// At least one timer pending. Sleep util then.
-		tb.sleeping = true
-		tb.sleepUntil = now + delta
-		noteclear(&tb.waitnote)
-		unlock(&tb.lock)
-		notetsleepg(&tb.waitnote, delta)
+// updateTimer0When sets pp.timer0When from the first timer on pp's heap.
+// The caller must have locked the timers for pp.
+func updateTimer0When(pp *p) {
+	if len(pp.timers) == 0 {
+		atomic.Store64(&pp.timer0When, 0)
+	} else {
+		atomic.Store64(&pp.timer0When, uint64(pp.timers[0].when))
 	}
 }
 
-func timejump() *g {
-	if faketime == 0 {
-		return nil
+// moveTimers moves all of src's timers to dst. It is used by
+// procresize when src is being destroyed. The world must be stopped.
+func moveTimers(dst, src *p) {
+	if len(src.timers) == 0 {
+		return
+	}
+	// The world is stopped, but we acquire the timers locks anyway
+	// in case deltimer is racing with us. This is the only place
+	// that holds the timersLock of more than one P; since the
+	// world is stopped, nothing else can, so there are no deadlock
+	// concerns.
+	lock(&dst.timersLock)
+	lock(&src.timersLock)
+	for i, t := range src.timers {
+		src.timers[i] = nil
+		if !doaddtimer(dst, t) {
+			badTimer()
+		}
 	}
+	src.timers = src.timers[:0]
+	atomic.Store64(&src.timer0When, 0)
+	unlock(&src.timersLock)
+	unlock(&dst.timersLock)
+}
 
-	for i := range timers {
-		lock(&timers[i].lock)
+// checkTimers runs any timers for the P that are ready.
+// If now is not 0 it is the current time.
+// It returns the current time or 0 if it is not known,
+// and the time when the next timer should run or 0 if there is no next timer,
+// and reports whether it ran any timers.
+// We pass now in and out to avoid extra calls of nanotime.
+func checkTimers(pp *p, now int64) (rnow, pollUntil int64, ran bool) {
+	// If the first timer on the heap is not yet ready to run,
+	// then there is nothing to do.
+	next := int64(atomic.Load64(&pp.timer0When))
+	if next == 0 {
+		return now, 0, false
+	}
+	if now == 0 {
+		now = nanotime()
+	}
+	if now < next {
+		return now, next, false
 	}
-	gp := timejumpLocked()
-	for i := range timers {
-		unlock(&timers[i].lock)
+
+	lock(&pp.timersLock)
+	for len(pp.timers) > 0 {
+		if tw := runtimer(pp, now); tw != 0 {
+			if tw > 0 {
+				pollUntil = tw
+			}
+			break
+		}
+		ran = true
 	}
+	unlock(&pp.timersLock)
 
-	return gp
+	return now, pollUntil, ran
 }
 
-func timejumpLocked() *g {
-	// Determine a timer bucket with minimum when.
-	var minT *timer
-	for i := range timers {
-		tb := &timers[i]
-		if !tb.created || len(tb.t) == 0 {
-			continue
+// runtimer examines the first timer in pp's heap. If it is ready based
+// on now, it runs the timer and removes or updates it.
+// Returns 0 if it ran a timer, -1 if there are no more timers, or the time
+// when the first timer should run.
+// The caller must have locked the timers for pp.
+// If a timer is run, this will temporarily unlock the timers.
+func runtimer(pp *p, now int64) int64 {
+	if len(pp.timers) == 0 {
+		return -1
+	}
+	t := pp.timers[0]
+	if t.when > now {
+		// Not ready to run.
+		return t.when
+	}
+	runOneTimer(pp, t, now)
+	return 0
+}
+
+// runOneTimer runs a single timer.
+// The caller must have locked the timers for pp.
+// This will temporarily unlock the timers while running the timer function.
+func runOneTimer(pp *p, t *timer, now int64) {
+	if raceenabled {
+		ppcur := getg().m.p.ptr()
+		raceacquirectx(ppcur.timerRaceCtx, unsafe.Pointer(t))
+	}
+
+	f := t.f
+	arg := t.arg
+	seq := t.seq
+
+	ok := true
+	if t.period > 0 {
+		// Leave in heap but adjust next time to fire.
+		delta := t.when - now
+		t.when += t.period * (1 + -delta/t.period)
+		if t.when < 0 { // check for overflow.
+			t.when = maxWhen
+		}
+		if !siftdownTimer(pp.timers, 0) {
+			ok = false
+		}
+		updateTimer0When(pp)
+	} else {
+		// Remove from heap.
+		if !dodeltimer(pp, 0) {
+			ok = false
 		}
-		t := tb.t[0]
-		if minT == nil || t.when < minT.when {
-			minT = t
+	}
+
+	if raceenabled {
+		// Temporarily use the current P's racectx for g0.
+		gp := getg()
+		if gp.racectx != 0 {
+			throw("runOneTimer: unexpected racectx")
 		}
+		gp.racectx = gp.m.p.ptr().timerRaceCtx
 	}
-	if minT == nil || minT.when <= faketime {
-		return nil
+
+	unlock(&pp.timersLock)
+
+	if !ok {
+		badTimer()
 	}
+	f(arg, seq)
 
-	faketime = minT.when
-	tb := minT.tb
-	if !tb.rescheduling {
-		return nil
+	lock(&pp.timersLock)
+
+	if raceenabled {
+		gp := getg()
+		gp.racectx = 0
 	}
-	tb.rescheduling = false
-	return tb.gp
 }
 
-func timeSleepUntil() int64 {
-	next := int64(1<<63 - 1)
+// wakeNetPoller wakes up the thread sleeping in the network poller
+// if it isn't going to wake up before the when argument.
+func wakeNetPoller(when int64) {
+	if atomic.Load64(&sched.lastpoll) == 0 {
+		// In findrunnable we ensure that when polling the pollUntil
+		// field is either zero or the time to which the current
+		// poll is expected to run. This can have a spurious wakeup
+		// but should never miss a wakeup.
+		pollerPollUntil := int64(atomic.Load64(&sched.pollUntil))
+		if pollerPollUntil == 0 || pollerPollUntil > when {
+			netpollBreak()
+		}
+	}
+}
 
-	// Determine minimum sleepUntil across all the timer buckets.
-	//
-	// The function can not return a precise answer,
-	// as another timer may pop in as soon as timers have been unlocked.
-	// So lock the timers one by one instead of all at once.
-	for i := range timers {
-		tb := &timers[i]
+// timeSleepUntil returns the time when the next timer should fire,
+// and the P that holds the timer heap that that timer is on.
+// This is only called by sysmon and checkdead.
+func timeSleepUntil() (int64, *p) {
+	next := int64(maxWhen)
+	var pret *p
+
+	// Prevent allp slice changes. This is like retake.
+	lock(&allpLock)
+	for _, pp := range allp {
+		if pp == nil {
+			// This can happen if procresize has grown
+			// allp but not yet created new Ps.
+			continue
+		}
 
-		lock(&tb.lock)
-		if tb.sleeping && tb.sleepUntil < next {
-			next = tb.sleepUntil
+		w := int64(atomic.Load64(&pp.timer0When))
+		if w != 0 && w < next {
+			next = w
+			pret = pp
 		}
-		unlock(&tb.lock)
 	}
+	unlock(&allpLock)
 
-	return next
+	return next, pret
 }
 
 // Heap maintenance algorithms. 堆得维护算法
@@ -369,9 +435,8 @@ func timeSleepUntil() int64 {
 // We don't want to panic here,
 // because it will cause the program to crash with a mysterious(神秘得)
 // "panic holding locks" message. Instead, we panic while not holding a lock.
-// The races can occur despite the bucket locks because assignBucket
-// itself is called without locks, so racy calls can cause a timer to
-// change buckets while executing these functions.
+// The races can occur despite the timers locks because racy calls
+// can add a timer to a heap while it is already on another one.
 
 func siftupTimer(t []*timer, i int) bool {
 	if i >= len(t) {
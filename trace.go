@@ -54,7 +54,7 @@ const (
 	traceEvGoInSyscall       = 32 // denotes that goroutine is in syscall when tracing starts [timestamp, goroutine id]
 	traceEvHeapAlloc         = 33 // memstats.heap_live change [timestamp, heap_alloc]
 	traceEvNextGC            = 34 // memstats.next_gc change [timestamp, next_gc]
-	traceEvTimerGoroutine    = 35 // denotes timer goroutine [timer goroutine id]
+	traceEvTimerGoroutine    = 35 // not currently used; previously denoted timer goroutine [timer goroutine ID]
 	traceEvFutileWakeup      = 36 // denotes that the previous wakeup of this goroutine was futile [timestamp]
 	traceEvString            = 37 // string dictionary entry [ID, length, string]
 	traceEvGoStartLocal      = 38 // goroutine starts running on the same P as the last event [timestamp, goroutine id]
@@ -74,6 +74,11 @@ const (
 	// That means, the max event type value is 63.
 )
 
+// traceHeader starts every trace, whether read with ReadTrace or
+// written by the flight recorder. Adding an event type means changing
+// the version here and in internal/trace.
+const traceHeader = "go 1.11 trace\x00\x00\x00"
+
 const (
 	// Timestamps in trace are cputicks/traceTickDiv.
 	// This makes absolute values of timestamp diffs smaller,
@@ -138,6 +143,8 @@ var trace struct {
 
 	// markWorkerLabels maps gcMarkWorkerMode to string ID.
 	markWorkerLabels [len(gcMarkWorkerModeStrings)]uint64
+	// logCategories maps traceLog* to string ID.
+	logCategories [len(traceLogCategories)]uint64
 
 	bufLock mutex       // protects buf
 	buf     traceBufPtr // global trace buffer, used when running without a p
@@ -180,6 +187,12 @@ func traceBufPtrOf(b *traceBuf) traceBufPtr {
 // Most clients should use the runtime/trace package or the testing package's
 // -test.trace flag instead of calling StartTrace directly.
 func StartTrace() error {
+	return traceStart(false)
+}
+
+// traceStart implements StartTrace. If flight is set, it starts the
+// flight recorder instead; see traceflight.go.
+func traceStart(flight bool) error {
 	// Stop the world, so that we can take a consistent snapshot
 	// of all goroutines at the beginning of the trace.
 	stopTheWorld("start tracing")
@@ -207,6 +220,23 @@ func StartTrace() error {
 	_g_ := getg()
 	_g_.m.startingtrace = true
 
+	// string to id mapping
+	//  0 : reserved for an empty string
+	//  remaining: other strings registered by traceString
+	trace.stringSeq = 0
+	trace.strings = make(map[string]uint64)
+
+	// Register runtime goroutine labels and log categories. The
+	// categories are needed by traceGoParent below.
+	_, pid, bufp := traceAcquireBuffer()
+	for i, label := range gcMarkWorkerModeStrings[:] {
+		trace.markWorkerLabels[i], bufp = traceString(bufp, pid, label)
+	}
+	for i, category := range traceLogCategories[:] {
+		trace.logCategories[i], bufp = traceString(bufp, pid, category)
+	}
+	traceReleaseBuffer(pid)
+
 	// Obtain current stack ID to use in all traceEvGoCreate events below.
 	mp := acquirem()
 	stkBuf := make([]uintptr, traceStackSize)
@@ -221,6 +251,7 @@ func StartTrace() error {
 			// +PCQuantum because traceFrameForPC expects return PCs and subtracts PCQuantum.
 			id := trace.stackTab.put([]uintptr{gp.startpc + sys.PCQuantum})
 			traceEvent(traceEvGoCreate, -1, uint64(gp.goid), uint64(id), stackID)
+			traceGoParent(gp)
 		}
 		if status == _Gwaiting {
 			// traceEvGoWaiting is implied to have seq=1.
@@ -245,22 +276,14 @@ func StartTrace() error {
 	trace.headerWritten = false
 	trace.footerWritten = false
 
-	// string to id mapping
-	//  0 : reserved for an empty string
-	//  remaining: other strings registered by traceString
-	trace.stringSeq = 0
-	trace.strings = make(map[string]uint64)
-
 	trace.seqGC = 0
 	_g_.m.startingtrace = false
 	trace.enabled = true
-
-	// Register runtime goroutine labels.
-	_, pid, bufp := traceAcquireBuffer()
-	for i, label := range gcMarkWorkerModeStrings[:] {
-		trace.markWorkerLabels[i], bufp = traceString(bufp, pid, label)
+	if flight {
+		traceFlight.enabled = true
+		traceFlight.bytes = 0
+		traceFlight.cur = &traceGen{ticksStart: trace.ticksStart, timeStart: trace.timeStart}
 	}
-	traceReleaseBuffer(pid)
 
 	unlock(&trace.bufLock)
 
@@ -278,7 +301,8 @@ func StopTrace() {
 	// See the comment in StartTrace.
 	lock(&trace.bufLock)
 
-	if !trace.enabled {
+	// The flight recorder is stopped by traceFlightStop.
+	if !trace.enabled || traceFlight.enabled {
 		unlock(&trace.bufLock)
 		startTheWorld()
 		return
@@ -376,6 +400,12 @@ func ReadTrace() []byte {
 		println("runtime: ReadTrace called from multiple goroutines simultaneously")
 		return nil
 	}
+	if traceFlight.enabled {
+		// The flight recorder keeps the trace to itself.
+		trace.lockOwner = nil
+		unlock(&trace.lock)
+		return nil
+	}
 	// Recycle the old buffer.
 	if buf := trace.reading; buf != 0 {
 		buf.ptr().link = trace.empty
@@ -387,7 +417,7 @@ func ReadTrace() []byte {
 		trace.headerWritten = true
 		trace.lockOwner = nil
 		unlock(&trace.lock)
-		return []byte("go 1.11 trace\x00\x00\x00")
+		return []byte(traceHeader)
 	}
 	// Wait for new data.
 	if trace.fullHead == 0 && !trace.shutdown {
@@ -413,13 +443,6 @@ func ReadTrace() []byte {
 		var data []byte
 		data = append(data, traceEvFrequency|0<<traceArgCountShift)
 		data = traceAppend(data, uint64(freq))
-		for i := range timers {
-			tb := &timers[i]
-			if tb.gp != nil {
-				data = append(data, traceEvTimerGoroutine|0<<traceArgCountShift)
-				data = traceAppend(data, uint64(tb.gp.goid))
-			}
-		}
 		// This will emit a bunch of full buffers, we will pick them up
 		// on the next iteration.
 		trace.stackTab.dump()
@@ -483,6 +506,9 @@ func traceFullQueue(buf traceBufPtr) {
 		trace.fullTail.ptr().link = buf
 	}
 	trace.fullTail = buf
+	if traceFlight.enabled {
+		traceFlight.bytes += uintptr(buf.ptr().pos)
+	}
 }
 
 // traceFullDequeue dequeues from queue of full buffers.
@@ -1050,6 +1076,21 @@ func traceGoCreate(newg *g, pc uintptr) {
 	traceEvent(traceEvGoCreate, 2, uint64(newg.goid), uint64(id))
 }
 
+// traceGoParent records the goroutine that created gp and where, for
+// a gp that exists when tracing starts. gp's GoCreate event is then
+// emitted by the goroutine starting the trace, not by its creator.
+func traceGoParent(gp *g) {
+	if gp.parentGoid == 0 {
+		return
+	}
+	// gopc is a return PC, as traceFrameForPC expects.
+	id := trace.stackTab.put([]uintptr{gp.gopc})
+	var b traceLogBuf
+	b.uint("goid", uint64(gp.goid))
+	b.uint("parent", uint64(gp.parentGoid))
+	traceLog(traceLogGoParent, uint64(id), b.bytes())
+}
+
 func traceGoStart() {
 	_g_ := getg().m.curg
 	_p_ := _g_.m.p
@@ -1145,6 +1186,122 @@ func traceNextGC() {
 	}
 }
 
+// traceGCPacerStart records the pacer's plan for the cycle computed
+// by gcControllerState.startCycle.
+func traceGCPacerStart(c *gcControllerState) {
+	var b traceLogBuf
+	b.uint("heap_live", memstats.heap_live)
+	b.uint("heap_goal", memstats.next_gc)
+	b.uint("heap_scan", memstats.heap_scan)
+	b.ratio("assist_work_per_byte", c.assistWorkPerByte)
+	b.uint("dedicated_workers", uint64(c.dedicatedMarkWorkersNeeded))
+	b.ratio("fractional_goal", c.fractionalUtilizationGoal)
+	traceLog(traceLogGCPacerStart, 0, b.bytes())
+}
+
+// traceGCPacerEnd records how the cycle went against the pacer's
+// plan, as computed by gcControllerState.endCycle.
+func traceGCPacerEnd(goalGrowth, actualGrowth, utilization float64, scanWork int64, triggerRatio float64) {
+	var b traceLogBuf
+	b.uint("heap_marked", memstats.heap_marked)
+	b.ratio("goal_growth", goalGrowth)
+	b.ratio("actual_growth", actualGrowth)
+	b.ratio("utilization", utilization)
+	b.uint("scan_work", uint64(scanWork))
+	b.ratio("trigger_ratio", triggerRatio)
+	traceLog(traceLogGCPacerEnd, 0, b.bytes())
+}
+
+// traceGCPacerTrigger records the trigger set by gcSetTriggerRatio.
+// It is called with the heap locked, so it must not allocate.
+func traceGCPacerTrigger() {
+	trigger := memstats.gc_trigger
+	if trigger == ^uint64(0) {
+		// Heap-based triggering is disabled.
+		trigger = 0
+	}
+	var b traceLogBuf
+	b.ratio("trigger_ratio", memstats.triggerRatio)
+	b.uint("trigger", trigger)
+	traceLog(traceLogGCPacerTrigger, 0, b.bytes())
+}
+
+// Categories of the log events the runtime emits itself. These are
+// ordinary traceEvUserLog events in the background task, so that the
+// trace format doesn't change; their categories are registered when
+// tracing starts, because some are emitted where traceString can't
+// allocate.
+const (
+	traceLogGCPacerStart = iota
+	traceLogGCPacerEnd
+	traceLogGCPacerTrigger
+	traceLogGoParent
+)
+
+var traceLogCategories = [...]string{
+	traceLogGCPacerStart:   "runtime.GCPacerStart",
+	traceLogGCPacerEnd:     "runtime.GCPacerEnd",
+	traceLogGCPacerTrigger: "runtime.GCPacerTrigger",
+	traceLogGoParent:       "runtime.GoParent",
+}
+
+// traceLog emits a traceEvUserLog event in the background task with
+// category traceLogCategories[category], stack stackID and value msg.
+func traceLog(category int, stackID uint64, msg []byte) {
+	// Same as in traceEvent.
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return
+	}
+	extraSpace := traceBytesPerNumber + len(msg)
+	traceEventLocked(extraSpace, mp, pid, bufp, traceEvUserLog, -1, 0, trace.logCategories[category], stackID)
+	buf := (*bufp).ptr()
+	buf.varint(uint64(len(msg)))
+	buf.pos += copy(buf.arr[buf.pos:], msg)
+	traceReleaseBuffer(pid)
+}
+
+// traceLogBuf formats the value of a runtime log event as
+// space-separated key=value pairs without allocating.
+type traceLogBuf struct {
+	n   int
+	buf [256]byte
+}
+
+func (b *traceLogBuf) bytes() []byte {
+	return b.buf[:b.n]
+}
+
+func (b *traceLogBuf) key(key string) {
+	if b.n > 0 {
+		b.buf[b.n] = ' '
+		b.n++
+	}
+	b.n += copy(b.buf[b.n:], key)
+	b.buf[b.n] = '='
+	b.n++
+}
+
+func (b *traceLogBuf) uint(key string, v uint64) {
+	b.key(key)
+	var tmp [20]byte
+	b.n += copy(b.buf[b.n:], itoaDiv(tmp[:], v, 0))
+}
+
+// ratio formats f with six decimal places.
+func (b *traceLogBuf) ratio(key string, f float64) {
+	b.key(key)
+	ppm := int64(f * 1e6)
+	if ppm < 0 {
+		b.buf[b.n] = '-'
+		b.n++
+		ppm = -ppm
+	}
+	var tmp [21]byte
+	b.n += copy(b.buf[b.n:], itoaDiv(tmp[:], uint64(ppm), 6))
+}
+
 // To access runtime functions from runtime/trace.
 // See runtime/trace/annotation.go
 
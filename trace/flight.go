@@ -0,0 +1,158 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+	_ "unsafe"
+)
+
+// A FlightRecorder records a trace of the recent past of the program
+// in memory. It keeps at least the last period of trace, unless that
+// would exceed its size, and can write a snapshot of it at any time,
+// for instance after something unexpected happened.
+//
+// While a FlightRecorder is recording, tracing is enabled, so Start
+// fails and IsEnabled reports true.
+type FlightRecorder struct {
+	mu     sync.Mutex
+	period time.Duration
+	size   int
+	done   chan struct{} // closed to stop the advancing goroutine; nil when not recording
+	exited chan struct{} // closed when the advancing goroutine returns
+}
+
+// NewFlightRecorder returns a FlightRecorder that keeps about the
+// last ten seconds, and at most about ten megabytes, of trace.
+func NewFlightRecorder() *FlightRecorder {
+	return &FlightRecorder{
+		period: 10 * time.Second,
+		size:   10 << 20,
+	}
+}
+
+// SetPeriod sets how much recent trace the flight recorder keeps.
+// The flight recorder may keep up to about one and a half times as
+// much. SetPeriod has no effect while the flight recorder is recording.
+func (r *FlightRecorder) SetPeriod(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil && d > 0 {
+		r.period = d
+	}
+}
+
+// SetSize sets the approximate maximum number of bytes of trace the
+// flight recorder keeps. It takes precedence over the period.
+// SetSize has no effect while the flight recorder is recording.
+func (r *FlightRecorder) SetSize(bytes int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil && bytes > 0 {
+		r.size = bytes
+	}
+}
+
+// Start starts recording. It returns an error if tracing is already
+// enabled, by Start or by another FlightRecorder.
+func (r *FlightRecorder) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done != nil {
+		return errors.New("trace: flight recorder already recording")
+	}
+
+	tracing.Lock()
+	defer tracing.Unlock()
+	if err := flightStart(int64(r.period), uintptr(r.size)); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&tracing.enabled, 1)
+
+	r.done = make(chan struct{})
+	r.exited = make(chan struct{})
+	go r.advance(r.period/8, r.done, r.exited)
+	return nil
+}
+
+// advance periodically gives the runtime the opportunity to start a
+// new part of the trace and drop the oldest, until done is closed.
+func (r *FlightRecorder) advance(every time.Duration, done, exited chan struct{}) {
+	defer close(exited)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			flightAdvance(false)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Stop stops recording and discards the recorded trace. It returns an
+// error if the flight recorder isn't recording.
+func (r *FlightRecorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		return errors.New("trace: flight recorder not recording")
+	}
+	close(r.done)
+	<-r.exited
+	r.done, r.exited = nil, nil
+
+	tracing.Lock()
+	defer tracing.Unlock()
+	atomic.StoreInt32(&tracing.enabled, 0)
+	flightStop()
+	return nil
+}
+
+// Enabled reports whether the flight recorder is recording.
+func (r *FlightRecorder) Enabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done != nil
+}
+
+// WriteTo writes a snapshot of the recorded trace to w, in the format
+// written by Start. Recording continues meanwhile. WriteTo returns the
+// number of bytes written and the first error encountered, if any.
+// It returns an error if the flight recorder isn't recording.
+func (r *FlightRecorder) WriteTo(w io.Writer) (n int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done == nil {
+		return 0, errors.New("trace: flight recorder not recording")
+	}
+	err = flightWrite(func(data []byte) error {
+		m, err := w.Write(data)
+		n += int64(m)
+		return err
+	})
+	return n, err
+}
+
+//
+// Function bodies are defined in runtime/traceflight.go
+//
+
+// starts tracing in flight recorder mode.
+func flightStart(period int64, size uintptr) error
+
+// starts a new generation of the trace, if due or if force is set.
+func flightAdvance(force bool)
+
+// calls write with successive parts of a snapshot of the trace.
+func flightWrite(write func([]byte) error) error
+
+// stops tracing in flight recorder mode.
+func flightStop()
@@ -16,6 +16,7 @@ import (
 	"runtime"
 	. "runtime/trace"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -138,6 +139,140 @@ func TestTrace(t *testing.T) {
 	}
 }
 
+func TestTraceGCPacer(t *testing.T) {
+	if IsEnabled() {
+		t.Skip("skipping because -test.trace is set")
+	}
+	buf := new(bytes.Buffer)
+	if err := Start(buf); err != nil {
+		t.Fatalf("failed to start tracing: %v", err)
+	}
+	runtime.GC()
+	Stop()
+	saveTrace(t, buf, "TestTraceGCPacer")
+	events, _ := parseTrace(t, buf)
+	logs := make(map[string]string)
+	for _, ev := range events {
+		if ev.Type == trace.EvUserLog && strings.HasPrefix(ev.SArgs[0], "runtime.") {
+			logs[ev.SArgs[0]] = ev.SArgs[1]
+		}
+	}
+	for _, category := range []string{"runtime.GCPacerStart", "runtime.GCPacerEnd", "runtime.GCPacerTrigger"} {
+		msg, ok := logs[category]
+		if !ok {
+			t.Errorf("no %s log in trace", category)
+			continue
+		}
+		if !strings.Contains(msg, "trigger_ratio=") && !strings.Contains(msg, "heap_goal=") {
+			t.Errorf("%s log has unexpected value %q", category, msg)
+		}
+	}
+}
+
+func TestTraceGoParent(t *testing.T) {
+	if IsEnabled() {
+		t.Skip("skipping because -test.trace is set")
+	}
+	done := make(chan bool)
+	go func() {
+		<-done
+	}()
+	defer close(done)
+	buf := new(bytes.Buffer)
+	if err := Start(buf); err != nil {
+		t.Fatalf("failed to start tracing: %v", err)
+	}
+	Stop()
+	saveTrace(t, buf, "TestTraceGoParent")
+	events, _ := parseTrace(t, buf)
+	if !hasGoParentLog(events, "runtime/trace_test.TestTraceGoParent") {
+		t.Errorf("no runtime.GoParent log for the goroutine created by TestTraceGoParent")
+	}
+}
+
+// hasGoParentLog reports whether events contain a runtime.GoParent log
+// for a goroutine created by fn.
+func hasGoParentLog(events []*trace.Event, fn string) bool {
+	for _, ev := range events {
+		if ev.Type != trace.EvUserLog || ev.SArgs[0] != "runtime.GoParent" || !strings.Contains(ev.SArgs[1], "parent=") {
+			continue
+		}
+		for _, f := range ev.Stk {
+			if f.Fn == fn {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFlightRecorder(t *testing.T) {
+	if IsEnabled() {
+		t.Skip("skipping because -test.trace is set")
+	}
+	fr := NewFlightRecorder()
+	fr.SetPeriod(100 * time.Millisecond)
+	if err := fr.Start(); err != nil {
+		t.Fatalf("failed to start flight recorder: %v", err)
+	}
+	defer fr.Stop()
+	if err := Start(new(bytes.Buffer)); err == nil {
+		Stop()
+		t.Fatalf("succeeded to start tracing while flight recording")
+	}
+
+	// Keep goroutines blocking, running and collecting garbage over
+	// several generations of the trace.
+	done := make(chan bool)
+	c := make(chan int)
+	go func() {
+		for {
+			select {
+			case c <- 1:
+			case <-done:
+				close(c)
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(300 * time.Millisecond)
+	for i := 0; time.Now().Before(deadline); i++ {
+		<-c
+		if i%100 == 0 {
+			runtime.GC()
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+
+	for i := 0; i < 2; i++ {
+		buf := new(bytes.Buffer)
+		if _, err := fr.WriteTo(buf); err != nil {
+			t.Fatalf("failed to write flight recording: %v", err)
+		}
+		saveTrace(t, buf, "TestFlightRecorder")
+		events, _ := parseTrace(t, buf)
+		if len(events) == 0 {
+			t.Fatalf("flight recording is empty")
+		}
+		// The snapshot starts with a generation's prologue, which
+		// records the parent of the goroutine started above.
+		if !hasGoParentLog(events, "runtime/trace_test.TestFlightRecorder") {
+			t.Errorf("no runtime.GoParent log for the goroutine created by TestFlightRecorder")
+		}
+	}
+
+	if err := fr.Stop(); err != nil {
+		t.Fatalf("failed to stop flight recorder: %v", err)
+	}
+	if _, err := fr.WriteTo(new(bytes.Buffer)); err == nil {
+		t.Fatalf("succeeded to write flight recording after stop")
+	}
+	if err := fr.Stop(); err == nil {
+		t.Fatalf("succeeded to stop flight recorder twice")
+	}
+}
+
 func parseTrace(t *testing.T, r io.Reader) ([]*trace.Event, map[uint64]*trace.GDesc) {
 	res, err := trace.Parse(r, "")
 	if err == trace.ErrTimeOrder {
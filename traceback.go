@@ -660,12 +660,18 @@ func printcreatedby(gp *g) {
 	pc := gp.gopc
 	f := findfunc(pc)
 	if f.valid() && showframe(f, gp, false, false) && gp.goid != 1 {
-		printcreatedby1(f, pc)
+		printcreatedby1(f, pc, gp.parentGoid)
 	}
 }
 
-func printcreatedby1(f funcInfo, pc uintptr) {
-	print("created by ", funcname(f), "\n")
+// printcreatedby1 prints the go statement at pc, which goroutine goid
+// executed, or the runtime if goid is 0.
+func printcreatedby1(f funcInfo, pc uintptr, goid int64) {
+	print("created by ", funcname(f))
+	if goid != 0 {
+		print(" in goroutine ", goid)
+	}
+	print("\n")
 	tracepc := pc // back up to CALL instruction for funcline.
 	if pc > f.entry {
 		tracepc -= sys.PCQuantum
@@ -759,7 +765,7 @@ func printAncestorTraceback(ancestor ancestorInfo) {
 	// Show what created goroutine, except main goroutine (goid 1).
 	f := findfunc(ancestor.gopc)
 	if f.valid() && showfuncinfo(f, false, false) && ancestor.goid != 1 {
-		printcreatedby1(f, ancestor.gopc)
+		printcreatedby1(f, ancestor.gopc, ancestor.parentGoid)
 	}
 }
 
@@ -881,8 +887,13 @@ var gStatusStrings = [...]string{
 }
 
 func goroutineheader(gp *g) {
-	gpstatus := readgstatus(gp)
+	goroutineheaderStatus(gp, readgstatus(gp))
+}
 
+// goroutineheaderStatus is goroutineheader for gp with status
+// gpstatus. It lets a caller that holds gp's scan bit print the status
+// it found.
+func goroutineheaderStatus(gp *g, gpstatus uint32) {
 	isScan := gpstatus&_Gscan != 0
 	gpstatus &^= _Gscan // drop the scan bit
 
@@ -914,6 +925,9 @@ func goroutineheader(gp *g) {
 	if gp.lockedm != 0 {
 		print(", locked to thread")
 	}
+	if ms := gp.runnableWait / 1e6; ms >= 1 {
+		print(", ", ms, " ms runnable")
+	}
 	print("]:\n")
 }
 
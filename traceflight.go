@@ -0,0 +1,530 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Flight recorder mode of the execution tracer.
+//
+// In flight recorder mode, started by runtime/trace.FlightRecorder,
+// the tracer keeps the trace in memory instead of handing it to
+// ReadTrace, and drops the oldest part of it to bound its age and
+// size. A snapshot of what is kept can be written out at any time as a
+// self-contained trace.
+//
+// Generations. The trace is divided into generations. traceFlightAdvance
+// ends the current generation with the world stopped: it takes every
+// P's buffer and all full buffers for the generation, and records a
+// prologue for the next one in buffers of its own. The prologue holds
+// the events StartTrace emits to describe each goroutine, so a trace
+// can start at any generation. Generations are dropped from the
+// oldest, and only the prologue of the oldest one kept is ever written.
+//
+// The events themselves don't restart at a generation: goroutine and GC
+// sequence numbers keep counting, and each string is defined once. So
+// a snapshot that starts at generation k rewrites sequence numbers to
+// be relative to generation k's prologue, drops the string definitions
+// among the events and writes the whole string table instead, and
+// dumps the stack table without resetting it. Otherwise the events are
+// copied as they are, so a snapshot is in the same format as a trace
+// read with ReadTrace.
+
+package runtime
+
+import (
+	"runtime/internal/atomic"
+	"runtime/internal/sys"
+	"unsafe"
+)
+
+// traceFlight is the state of the flight recorder.
+var traceFlight struct {
+	sema uint32 // serializes start, stop, advance and snapshots
+
+	enabled bool    // only changed with the world stopped
+	period  int64   // nanoseconds of trace to keep, at least
+	size    uintptr // bytes of trace to keep, at most
+	bytes   uintptr // bytes in full buffers of the current generation; protected by trace.lock
+
+	cur   *traceGen // the generation being recorded
+	first *traceGen // the oldest complete generation kept
+	last  *traceGen // the newest complete generation
+}
+
+// A traceGen is a generation of the trace in flight recorder mode.
+type traceGen struct {
+	next *traceGen
+
+	ticksStart, ticksEnd int64 // cputicks
+	timeStart, timeEnd   int64 // nanotime
+
+	prologue traceBufPtr // list of buffers holding the prologue
+	bufs     traceBufPtr // list of buffers holding the events
+	bytes    uintptr     // total size of prologue and bufs
+
+	// seqOff and gcSeqOff are subtracted from the goroutine and GC
+	// sequence numbers of events to make them follow the prologue.
+	// Goroutines created later have no entry in seqOff.
+	seqOff   map[int64]uint64
+	gcSeqOff uint64
+}
+
+// traceFlightStart starts tracing in flight recorder mode, keeping at
+// least period nanoseconds and at most size bytes of trace.
+func traceFlightStart(period int64, size uintptr) error {
+	semacquire(&traceFlight.sema)
+	traceFlight.period = period
+	traceFlight.size = size
+	err := traceStart(true)
+	semrelease(&traceFlight.sema)
+	return err
+}
+
+// traceFlightStop stops tracing in flight recorder mode and frees the
+// trace kept.
+func traceFlightStop() {
+	semacquire(&traceFlight.sema)
+	stopTheWorld("stop tracing")
+	lock(&trace.bufLock)
+	if !trace.enabled || !traceFlight.enabled {
+		unlock(&trace.bufLock)
+		startTheWorld()
+		semrelease(&traceFlight.sema)
+		return
+	}
+	traceFlushAll()
+	trace.enabled = false
+	traceFlight.enabled = false
+	unlock(&trace.bufLock)
+	startTheWorld()
+
+	lock(&trace.lock)
+	traceFreeList(trace.fullHead)
+	trace.fullHead, trace.fullTail = 0, 0
+	for gen := traceFlight.first; gen != nil; gen = gen.next {
+		traceFreeList(gen.prologue)
+		traceFreeList(gen.bufs)
+	}
+	traceFreeList(traceFlight.cur.prologue)
+	traceFlight.cur, traceFlight.first, traceFlight.last = nil, nil, nil
+	traceFlight.bytes = 0
+	for trace.empty != 0 {
+		buf := trace.empty
+		trace.empty = buf.ptr().link
+		sysFree(unsafe.Pointer(buf), unsafe.Sizeof(*buf.ptr()), &memstats.other_sys)
+	}
+	trace.strings = nil
+	trace.stackTab.mem.drop()
+	trace.stackTab = traceStackTable{}
+	unlock(&trace.lock)
+	semrelease(&traceFlight.sema)
+}
+
+// traceFlightAdvance ends the current generation if force is set, or
+// if it is half the period old or holds half the size, and then drops
+// the generations no longer needed.
+func traceFlightAdvance(force bool) {
+	semacquire(&traceFlight.sema)
+	if traceFlight.enabled {
+		cur := traceFlight.cur
+		if force || nanotime()-cur.timeStart >= traceFlight.period/2 || traceFlight.bytes >= traceFlight.size/2 {
+			traceFlightAdvanceLocked()
+		}
+	}
+	semrelease(&traceFlight.sema)
+}
+
+// traceFlightAdvanceLocked ends the current generation and starts a
+// new one. The caller must hold traceFlight.sema.
+func traceFlightAdvanceLocked() {
+	next := &traceGen{seqOff: make(map[int64]uint64)}
+	stkBuf := make([]uintptr, traceStackSize)
+
+	stopTheWorld("trace advance")
+	// As in StartTrace, hold bufLock so that exitsyscall can't
+	// emit events while the generations are switched.
+	lock(&trace.bufLock)
+	if !traceFlight.enabled {
+		unlock(&trace.bufLock)
+		startTheWorld()
+		return
+	}
+
+	// End the current generation.
+	traceFlushAll()
+	cur := traceFlight.cur
+	lock(&trace.lock)
+	cur.bufs = traceFullDetach()
+	unlock(&trace.lock)
+	cur.ticksEnd = cputicks()
+	cur.timeEnd = nanotime()
+	cur.bytes += traceListBytes(cur.bufs)
+
+	// Write the next generation's prologue. Unlike StartTrace, this
+	// leaves the goroutines' sequence numbers alone and records
+	// their offsets instead.
+	mp := acquirem()
+	stackID := traceStackID(mp, stkBuf, 2)
+	releasem(mp)
+	_g_ := getg()
+	for _, gp := range allgs {
+		status := readgstatus(gp)
+		if status == _Gdead {
+			continue
+		}
+		// Make the goroutine's next event carry a sequence
+		// number, so that it is ordered after the prologue.
+		gp.tracelastp = _g_.m.p
+		id := trace.stackTab.put([]uintptr{gp.startpc + sys.PCQuantum})
+		traceEvent(traceEvGoCreate, -1, uint64(gp.goid), uint64(id), stackID)
+		traceGoParent(gp)
+		switch {
+		case gp == _g_.m.curg:
+			next.seqOff[gp.goid] = gp.traceseq - 1
+		case status == _Gwaiting:
+			traceEvent(traceEvGoWaiting, -1, uint64(gp.goid))
+			next.seqOff[gp.goid] = gp.traceseq - 1
+		case status == _Gsyscall:
+			traceEvent(traceEvGoInSyscall, -1, uint64(gp.goid))
+			next.seqOff[gp.goid] = gp.traceseq - 1
+		default:
+			next.seqOff[gp.goid] = gp.traceseq
+		}
+	}
+	traceProcStart()
+	traceEvent(traceEvGoStart, -1, uint64(_g_.m.curg.goid), 1)
+	next.gcSeqOff = trace.seqGC
+	if gcphase != _GCoff {
+		// The GC started in an earlier generation.
+		traceEvent(traceEvGCStart, 0, 0)
+		next.gcSeqOff--
+	}
+	pp := _g_.m.p.ptr()
+	lock(&trace.lock)
+	if pp.tracebuf != 0 {
+		traceFullQueue(pp.tracebuf)
+		pp.tracebuf = 0
+	}
+	next.prologue = traceFullDetach()
+	unlock(&trace.lock)
+	next.bytes = traceListBytes(next.prologue)
+	next.ticksStart = cputicks()
+	next.timeStart = nanotime()
+	// Syscall exit times before now belong to an earlier
+	// generation; see traceGoSysExit.
+	trace.ticksStart = next.ticksStart
+
+	unlock(&trace.bufLock)
+	startTheWorld()
+
+	if traceFlight.last == nil {
+		traceFlight.first = cur
+	} else {
+		traceFlight.last.next = cur
+	}
+	traceFlight.last = cur
+	traceFlight.cur = next
+	traceFlightTrim()
+}
+
+// traceFlightTrim drops the oldest complete generations while the
+// rest still cover the period, or while they exceed the size. It
+// keeps at least the newest complete generation.
+func traceFlightTrim() {
+	var total uintptr
+	for gen := traceFlight.first; gen != nil; gen = gen.next {
+		total += gen.bytes
+	}
+	now := nanotime()
+	lock(&trace.lock)
+	for gen := traceFlight.first; gen != traceFlight.last; gen = traceFlight.first {
+		if total <= traceFlight.size && gen.next.timeStart > now-traceFlight.period {
+			break
+		}
+		traceFreeList(gen.prologue)
+		traceFreeList(gen.bufs)
+		total -= gen.bytes
+		traceFlight.first = gen.next
+	}
+	unlock(&trace.lock)
+}
+
+// traceFlightWrite ends the current generation and calls write with
+// successive parts of a trace of the generations kept. It stops at
+// the first error from write and returns it. write must not retain
+// its argument.
+func traceFlightWrite(write func([]byte) error) error {
+	semacquire(&traceFlight.sema)
+	defer semrelease(&traceFlight.sema)
+	if !traceFlight.enabled {
+		return errorString("trace: flight recorder is not running")
+	}
+	traceFlightAdvanceLocked()
+	if !traceFlight.enabled {
+		return errorString("trace: flight recorder is not running")
+	}
+
+	first := traceFlight.first
+	if err := write([]byte(traceHeader)); err != nil {
+		return err
+	}
+	// The prologue's sequence numbers are already right.
+	for buf := first.prologue; buf != 0; buf = buf.ptr().link {
+		if err := write(buf.ptr().arr[:buf.ptr().pos]); err != nil {
+			return err
+		}
+	}
+	var out []byte
+	for gen := first; gen != nil; gen = gen.next {
+		for buf := gen.bufs; buf != 0; buf = buf.ptr().link {
+			out = traceFlightRewrite(out[:0], buf.ptr().arr[:buf.ptr().pos], first)
+			if err := write(out); err != nil {
+				return err
+			}
+		}
+		if gen == traceFlight.last {
+			break
+		}
+	}
+
+	// Footer: the timer frequency, the stacks and the strings. The
+	// stacks come first, since dumping them defines more strings.
+	last := traceFlight.last
+	freq := float64(last.ticksEnd-first.ticksStart) * 1e9 / float64(last.timeEnd-first.timeStart) / traceTickDiv
+	out = append(out[:0], traceEvFrequency|0<<traceArgCountShift)
+	out = traceAppend(out, uint64(freq))
+	out = append(out, traceEvBatch|1<<traceArgCountShift)
+	out = traceAppend(out, 0)
+	out = traceAppend(out, uint64(cputicks())/traceTickDiv)
+	out = trace.stackTab.appendStacks(out)
+	out = traceAppendStrings(out)
+	return write(out)
+}
+
+// traceFlightRewrite appends the events in buf to out, adjusting them
+// to follow gen's prologue: it rewrites the sequence numbers of
+// goroutine and GC events, and drops string definitions.
+func traceFlightRewrite(out, buf []byte, gen *traceGen) []byte {
+	var args [8]uint64
+	for pos := 0; pos < len(buf); {
+		start := pos
+		ev := buf[pos] &^ (3 << traceArgCountShift)
+		narg := int(buf[pos] >> traceArgCountShift)
+		pos++
+		if ev == traceEvString {
+			// [ID, length, string]
+			_, pos = traceReadVarint(buf, pos)
+			n, p := traceReadVarint(buf, pos)
+			pos = p + int(n)
+			continue
+		}
+
+		// Read the arguments, including the timestamp.
+		nargs := 0
+		if narg == 3 {
+			n, p := traceReadVarint(buf, pos)
+			end := p + int(n)
+			for p < end && nargs < len(args) {
+				args[nargs], p = traceReadVarint(buf, p)
+				nargs++
+			}
+			pos = end
+		} else {
+			for i := 0; i <= narg; i++ {
+				args[nargs], pos = traceReadVarint(buf, pos)
+				nargs++
+			}
+		}
+		if ev == traceEvUserLog {
+			// The value string follows.
+			n, p := traceReadVarint(buf, pos)
+			pos = p + int(n)
+		}
+
+		seq := -1
+		switch ev {
+		case traceEvGoStart, traceEvGoStartLabel, traceEvGoUnblock, traceEvGoSysExit:
+			// [timestamp, goroutine id, seq, ...]
+			seq = 2
+			args[seq] -= gen.seqOff[int64(args[1])]
+		case traceEvGCStart:
+			// [timestamp, seq, stack id]
+			seq = 1
+			args[seq] -= gen.gcSeqOff
+		}
+		if seq < 0 || seq >= nargs {
+			out = append(out, buf[start:pos]...)
+			continue
+		}
+		out = append(out, buf[start])
+		if narg == 3 {
+			var tmp [len(args) * traceBytesPerNumber]byte
+			enc := tmp[:0]
+			for _, a := range args[:nargs] {
+				enc = traceAppend(enc, a)
+			}
+			out = traceAppend(out, uint64(len(enc)))
+			out = append(out, enc...)
+		} else {
+			for _, a := range args[:nargs] {
+				out = traceAppend(out, a)
+			}
+		}
+	}
+	return out
+}
+
+// traceReadVarint reads a little-endian-base-128 value from buf at
+// pos and returns it and the position after it.
+func traceReadVarint(buf []byte, pos int) (uint64, int) {
+	var v uint64
+	for shift := uint(0); pos < len(buf); shift += 7 {
+		b := buf[pos]
+		pos++
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			break
+		}
+	}
+	return v, pos
+}
+
+// appendStacks appends all stacks in tab to out, as dump writes them to
+// trace buffers, but leaves tab as it is. Strings are defined in the
+// string table rather than along with the stacks.
+func (tab *traceStackTable) appendStacks(out []byte) []byte {
+	var tmp [(2 + 4*traceStackSize) * traceBytesPerNumber]byte
+	for i := range tab.tab {
+		// put may add stacks concurrently.
+		stk := (*traceStack)(atomic.Loadp(unsafe.Pointer(&tab.tab[i])))
+		for ; stk != nil; stk = stk.link.ptr() {
+			tmpbuf := tmp[:0]
+			tmpbuf = traceAppend(tmpbuf, uint64(stk.id))
+			frames := allFrames(stk.stack())
+			tmpbuf = traceAppend(tmpbuf, uint64(len(frames)))
+			for _, f := range frames {
+				tmpbuf = traceAppend(tmpbuf, uint64(f.PC))
+				tmpbuf = traceAppend(tmpbuf, traceStringID(f.Function))
+				tmpbuf = traceAppend(tmpbuf, traceStringID(f.File))
+				tmpbuf = traceAppend(tmpbuf, uint64(f.Line))
+			}
+			out = append(out, traceEvStack|3<<traceArgCountShift)
+			out = traceAppend(out, uint64(len(tmpbuf)))
+			out = append(out, tmpbuf...)
+		}
+	}
+	return out
+}
+
+// traceStringID returns the id of s in trace.strings, adding it if
+// necessary, without defining it in a trace buffer. It is for the flight
+// recorder, which writes out the whole string table.
+func traceStringID(s string) uint64 {
+	const maxLen = 1 << 10 // as in traceFrameForPC
+	if len(s) > maxLen {
+		s = s[len(s)-maxLen:]
+	}
+	if s == "" {
+		return 0
+	}
+	lock(&trace.stringsLock)
+	id, ok := trace.strings[s]
+	if !ok {
+		trace.stringSeq++
+		id = trace.stringSeq
+		trace.strings[s] = id
+	}
+	unlock(&trace.stringsLock)
+	return id
+}
+
+// traceAppendStrings appends definitions of all strings in
+// trace.strings to out.
+func traceAppendStrings(out []byte) []byte {
+	lock(&trace.stringsLock)
+	for s, id := range trace.strings {
+		out = append(out, traceEvString)
+		out = traceAppend(out, id)
+		out = traceAppend(out, uint64(len(s)))
+		out = append(out, s...)
+	}
+	unlock(&trace.stringsLock)
+	return out
+}
+
+// traceFlushAll moves every P's buffer and the global buffer to the
+// queue of full buffers. The world must be stopped, and the caller
+// must hold trace.bufLock.
+func traceFlushAll() {
+	// Loop over all allocated Ps because dead Ps may still have
+	// trace buffers.
+	for _, p := range allp[:cap(allp)] {
+		buf := p.tracebuf
+		if buf != 0 {
+			lock(&trace.lock)
+			traceFullQueue(buf)
+			unlock(&trace.lock)
+			p.tracebuf = 0
+		}
+	}
+	if trace.buf != 0 {
+		buf := trace.buf
+		trace.buf = 0
+		if buf.ptr().pos != 0 {
+			lock(&trace.lock)
+			traceFullQueue(buf)
+			unlock(&trace.lock)
+		}
+	}
+}
+
+// traceFullDetach empties the queue of full buffers and returns it as
+// a list. The caller must hold trace.lock.
+func traceFullDetach() traceBufPtr {
+	list := trace.fullHead
+	trace.fullHead, trace.fullTail = 0, 0
+	traceFlight.bytes = 0
+	return list
+}
+
+// traceListBytes returns the total size of the events in the list of
+// buffers starting at list.
+func traceListBytes(list traceBufPtr) uintptr {
+	var n uintptr
+	for buf := list; buf != 0; buf = buf.ptr().link {
+		n += uintptr(buf.ptr().pos)
+	}
+	return n
+}
+
+// traceFreeList returns the list of buffers starting at list to
+// trace.empty. The caller must hold trace.lock.
+func traceFreeList(list traceBufPtr) {
+	for list != 0 {
+		buf := list
+		list = buf.ptr().link
+		buf.ptr().link = trace.empty
+		trace.empty = buf
+	}
+}
+
+// To access the flight recorder from runtime/trace.
+// See runtime/trace/flight.go
+
+//go:linkname trace_flightStart runtime/trace.flightStart
+func trace_flightStart(period int64, size uintptr) error {
+	return traceFlightStart(period, size)
+}
+
+//go:linkname trace_flightAdvance runtime/trace.flightAdvance
+func trace_flightAdvance(force bool) {
+	traceFlightAdvance(force)
+}
+
+//go:linkname trace_flightWrite runtime/trace.flightWrite
+func trace_flightWrite(write func([]byte) error) error {
+	return traceFlightWrite(write)
+}
+
+//go:linkname trace_flightStop runtime/trace.flightStop
+func trace_flightStop() {
+	traceFlightStop()
+}
@@ -10,4 +10,5 @@ import "unsafe"
 
 func writeErr(b []byte) {
 	write(2, unsafe.Pointer(&b[0]), int32(len(b)))
+	writeCrash(b)
 }